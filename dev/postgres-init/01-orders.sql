@@ -0,0 +1,2 @@
+-- Database for the stop-loss orders store, next to Temporal's own databases.
+CREATE DATABASE orders;
@@ -2,12 +2,13 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
+	"log/slog"
 	"math/rand"
 	"net/http"
 	"os"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -34,32 +35,93 @@ var (
 	}
 	clientsMu sync.Mutex
 	clients   = make(map[*websocket.Conn]bool)
+
+	lastPricesAt atomic.Int64 // unix nanoseconds of the last round of prices
 )
 
+// pricesMaxStaleness is how long the generator can go without a round of
+// prices, a second apart, before it's reported as failing.
+const pricesMaxStaleness = 5 * time.Second
+
 func main() {
+	// JSON logs at LOG_LEVEL, like the stop-loss service's
+	level := slog.LevelInfo
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			slog.Error("Invalid LOG_LEVEL, must be debug, info, warn or error", "value", v)
+			os.Exit(1)
+		}
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
 	r := mux.NewRouter()
 	r.HandleFunc("/prices", handlePriceStream)
+	// the simulator depends on nothing, so it's ready whenever it's alive
+	r.HandleFunc("/healthz", handleHealth).Methods("GET")
+	r.HandleFunc("/readyz", handleHealth).Methods("GET")
 
 	disruptionProbabilityStr := os.Getenv("DISRUPTION_PROBABILITY")
 	disruptionProbability := 0.0 // Default: no disruption
 	if prob, err := strconv.ParseFloat(disruptionProbabilityStr, 64); err == nil {
 		disruptionProbability = prob
 	}
-	fmt.Printf("Price simulator started with disruption probability: %.2f\n", disruptionProbability)
+	slog.Info("Price simulator started", "disruptionProbability", disruptionProbability)
 
 	go generatePrices()                          // Goroutine for price generation and broadcasting
 	go disruptConnections(disruptionProbability) // Separate goroutine for disruption simulation
 
-	fmt.Println("Starting price stream server on :8080")
+	slog.Info("Starting price stream server", "addr", ":8080")
 	if err := http.ListenAndServe(":8080", r); err != nil {
-		panic(err)
+		slog.Error("Price stream server failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// handleHealth reports on the generator and the connected clients in the
+// same shape as the stop-loss service's health checks.
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	type checkResult struct {
+		Status  string         `json:"status"`
+		Error   string         `json:"error,omitempty"`
+		Details map[string]any `json:"details,omitempty"`
+	}
+
+	generator := checkResult{Status: "ok", Details: map[string]any{}}
+	if nanos := lastPricesAt.Load(); nanos == 0 {
+		generator.Status, generator.Error = "failing", "no prices have been generated yet"
+	} else {
+		lastAt := time.Unix(0, nanos)
+		staleness := time.Since(lastAt)
+		generator.Details["lastPricesAt"] = lastAt
+		generator.Details["stalenessSeconds"] = staleness.Seconds()
+		if staleness > pricesMaxStaleness {
+			generator.Status, generator.Error = "failing", "no prices have been generated for "+staleness.Round(time.Second).String()
+		}
+	}
+
+	clientsMu.Lock()
+	connected := len(clients)
+	clientsMu.Unlock()
+
+	status, code := "ok", http.StatusOK
+	if generator.Status != "ok" {
+		status, code = "failing", http.StatusServiceUnavailable
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"status": status,
+		"checks": map[string]checkResult{
+			"generator": generator,
+			"clients":   {Status: "ok", Details: map[string]any{"connected": connected}},
+		},
+	})
 }
 
 func handlePriceStream(w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		fmt.Println("upgrade:", err)
+		slog.Error("Failed to upgrade connection", "error", err)
 		return
 	}
 	defer conn.Close()
@@ -74,24 +136,24 @@ func handlePriceStream(w http.ResponseWriter, r *http.Request) {
 		clientsMu.Unlock()
 	}()
 
-	fmt.Println("Client connected")
+	slog.Info("Client connected", "remoteAddr", r.RemoteAddr)
 
 	for {
 		messageType, _, err := conn.ReadMessage() // Discard read messages
 		if err != nil {
-			fmt.Println("read error:", err)
+			slog.Info("Client disconnected", "remoteAddr", r.RemoteAddr, "error", err)
 			break // Exit loop on read error (client disconnect)
 		}
 
 		if messageType == websocket.CloseMessage {
-			fmt.Println("Client initiated close")
+			slog.Info("Client closed the connection", "remoteAddr", r.RemoteAddr)
 			break // Client disconnected
 		}
 	}
 }
 
 func generatePrices() {
-	fmt.Println("Starting price generator...")
+	slog.Info("Starting price generator")
 	for {
 		for _, security := range securityPrices {
 			// Simulate price change (random walk)
@@ -109,18 +171,19 @@ func generatePrices() {
 			}
 
 			// Broadcast price update to all connected clients.
+			slog.Debug("Broadcasting price update", "security", priceUpdate.Security, "price", priceUpdate.Price)
 			broadcastPrice(priceUpdate)
 		}
 
+		lastPricesAt.Store(time.Now().UnixNano())
 		time.Sleep(1 * time.Second) // Update prices every second
 	}
 }
 
 // disruptConnections simulates random client disconnections based on probability.
 func disruptConnections(disruptionProbability float64) {
-	fmt.Println("Starting connection disruptor...")
 	if disruptionProbability <= 0 {
-		fmt.Println("Disruptions disabled (probability <= 0)")
+		slog.Info("Connection disruptions disabled")
 		return // Exit if disruption probability is not positive
 	}
 
@@ -151,7 +214,7 @@ func simulateDisruption() {
 		}
 
 		if clientToDisconnect != nil {
-			fmt.Println("Simulating disruption - closing connection to a client.")
+			slog.Info("Simulating disruption, closing connection to a client", "remoteAddr", clientToDisconnect.RemoteAddr().String())
 			clientToDisconnect.Close()          // Simulate abrupt closure
 			delete(clients, clientToDisconnect) // Remove client from active list
 		}
@@ -164,7 +227,7 @@ func broadcastPrice(update PriceUpdate) {
 	for client := range clients {
 		err := client.WriteMessage(websocket.TextMessage, message)
 		if err != nil {
-			fmt.Println("write error:", err)
+			slog.Warn("Failed to send price update, dropping client", "remoteAddr", client.RemoteAddr().String(), "error", err)
 			// Client likely disconnected or in bad state, remove them.
 			delete(clients, client)
 		}
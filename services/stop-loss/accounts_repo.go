@@ -0,0 +1,277 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AccountsRepoSQL keeps accounts and their users in the accounts and users
+// tables of either SQL backend.
+type AccountsRepoSQL struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func NewAccountsRepoSQL(db *sql.DB, d dialect) *AccountsRepoSQL {
+	return &AccountsRepoSQL{
+		db:      db,
+		dialect: d,
+	}
+}
+
+func (r *AccountsRepoSQL) CreateAccount(account Account) error {
+	result, err := r.db.Exec(r.dialect.rebind(`
+		INSERT INTO accounts (id, name, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (id) DO NOTHING
+	`), account.ID, account.Name, account.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to create account %s: %w", account.ID, err)
+	}
+	return affectedOr(result, ErrAccountExists)
+}
+
+func (r *AccountsRepoSQL) GetAccount(id string) (Account, error) {
+	var account Account
+	err := r.db.QueryRow(r.dialect.rebind(`SELECT id, name, created_at FROM accounts WHERE id = ?`), id).
+		Scan(&account.ID, &account.Name, &account.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Account{}, ErrAccountNotFound
+	}
+	if err != nil {
+		return Account{}, fmt.Errorf("failed to get account %s: %w", id, err)
+	}
+	return account, nil
+}
+
+func (r *AccountsRepoSQL) ListAccounts() ([]Account, error) {
+	rows, err := r.db.Query(`SELECT id, name, created_at FROM accounts ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list accounts: %w", err)
+	}
+	defer rows.Close()
+
+	var accounts []Account
+	for rows.Next() {
+		var account Account
+		if err := rows.Scan(&account.ID, &account.Name, &account.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan account: %w", err)
+		}
+		accounts = append(accounts, account)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating account rows: %w", err)
+	}
+	return accounts, nil
+}
+
+// CreateUser checks for the account itself, since SQLite doesn't enforce
+// foreign keys unless asked to.
+func (r *AccountsRepoSQL) CreateUser(user User) error {
+	var exists int
+	err := r.db.QueryRow(r.dialect.rebind(`SELECT 1 FROM accounts WHERE id = ?`), user.AccountID).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrAccountNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up account %s: %w", user.AccountID, err)
+	}
+
+	result, err := r.db.Exec(r.dialect.rebind(`
+		INSERT INTO users (username, account_id, role, password_hash, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (username) DO NOTHING
+	`), user.Username, user.AccountID, user.Role, user.PasswordHash, user.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to create user %s: %w", user.Username, err)
+	}
+	return affectedOr(result, ErrUserExists)
+}
+
+func (r *AccountsRepoSQL) GetUser(username string) (User, error) {
+	var user User
+	err := r.db.QueryRow(r.dialect.rebind(`SELECT username, account_id, role, password_hash, created_at FROM users WHERE username = ?`), username).
+		Scan(&user.Username, &user.AccountID, &user.Role, &user.PasswordHash, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return User{}, fmt.Errorf("failed to get user %s: %w", username, err)
+	}
+	return user, nil
+}
+
+func (r *AccountsRepoSQL) ListUsers() ([]User, error) {
+	rows, err := r.db.Query(`SELECT username, account_id, role, password_hash, created_at FROM users ORDER BY username`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.Username, &user.AccountID, &user.Role, &user.PasswordHash, &user.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating user rows: %w", err)
+	}
+	return users, nil
+}
+
+func (r *AccountsRepoSQL) SetPassword(username string, passwordHash string) error {
+	result, err := r.db.Exec(r.dialect.rebind(`UPDATE users SET password_hash = ? WHERE username = ?`), passwordHash, username)
+	if err != nil {
+		return fmt.Errorf("failed to set password for user %s: %w", username, err)
+	}
+	return affectedOr(result, ErrUserNotFound)
+}
+
+func (r *AccountsRepoSQL) SetRole(username string, role string) error {
+	result, err := r.db.Exec(r.dialect.rebind(`UPDATE users SET role = ? WHERE username = ?`), role, username)
+	if err != nil {
+		return fmt.Errorf("failed to set role of user %s: %w", username, err)
+	}
+	return affectedOr(result, ErrUserNotFound)
+}
+
+// affectedOr returns none when a statement changed no rows, e.g. an
+// INSERT ... ON CONFLICT DO NOTHING that inserted nothing.
+func affectedOr(result sql.Result, none error) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rows == 0 {
+		return none
+	}
+	return nil
+}
+
+// Ensure AccountsRepoSQL implements AccountsRepo
+var _ AccountsRepo = (*AccountsRepoSQL)(nil)
+
+// AccountsRepoMemory keeps accounts for the in-memory store. Like a
+// migrated database it starts with the default account and admin user.
+type AccountsRepoMemory struct {
+	mu       sync.RWMutex
+	accounts map[string]Account
+	users    map[string]User
+}
+
+func NewAccountsRepoMemory() *AccountsRepoMemory {
+	now := time.Now().UTC()
+	return &AccountsRepoMemory{
+		accounts: map[string]Account{
+			DefaultAccountID: {ID: DefaultAccountID, Name: "Default", CreatedAt: now},
+		},
+		users: map[string]User{
+			"admin": {Username: "admin", AccountID: DefaultAccountID, Role: RoleAdmin, CreatedAt: now},
+		},
+	}
+}
+
+func (m *AccountsRepoMemory) CreateAccount(account Account) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.accounts[account.ID]; ok {
+		return ErrAccountExists
+	}
+	m.accounts[account.ID] = account
+	return nil
+}
+
+func (m *AccountsRepoMemory) GetAccount(id string) (Account, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	account, ok := m.accounts[id]
+	if !ok {
+		return Account{}, ErrAccountNotFound
+	}
+	return account, nil
+}
+
+func (m *AccountsRepoMemory) ListAccounts() ([]Account, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var accounts []Account
+	for _, account := range m.accounts {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].ID < accounts[j].ID })
+	return accounts, nil
+}
+
+func (m *AccountsRepoMemory) CreateUser(user User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.accounts[user.AccountID]; !ok {
+		return ErrAccountNotFound
+	}
+	if _, ok := m.users[user.Username]; ok {
+		return ErrUserExists
+	}
+	m.users[user.Username] = user
+	return nil
+}
+
+func (m *AccountsRepoMemory) GetUser(username string) (User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[username]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (m *AccountsRepoMemory) ListUsers() ([]User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var users []User
+	for _, user := range m.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+func (m *AccountsRepoMemory) SetPassword(username string, passwordHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.PasswordHash = passwordHash
+	m.users[username] = user
+	return nil
+}
+
+func (m *AccountsRepoMemory) SetRole(username string, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.Role = role
+	m.users[username] = user
+	return nil
+}
+
+// Ensure AccountsRepoMemory implements AccountsRepo
+var _ AccountsRepo = (*AccountsRepoMemory)(nil)
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testAccountsRepoContract expects a repo holding only the default account
+// and admin user, as both backends start.
+func testAccountsRepoContract(t *testing.T, repo AccountsRepo) {
+	at := time.Date(2025, 2, 1, 14, 30, 0, 0, time.UTC)
+
+	admin, err := repo.GetUser("admin")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultAccountID, admin.AccountID)
+	assert.Equal(t, RoleAdmin, admin.Role)
+
+	require.NoError(t, repo.CreateAccount(Account{ID: "acct-1", Name: "Fund One", CreatedAt: at}))
+	assert.ErrorIs(t, repo.CreateAccount(Account{ID: "acct-1", Name: "Again", CreatedAt: at}), ErrAccountExists)
+	account, err := repo.GetAccount("acct-1")
+	require.NoError(t, err)
+	assert.Equal(t, "Fund One", account.Name)
+	_, err = repo.GetAccount("acct-9")
+	assert.ErrorIs(t, err, ErrAccountNotFound)
+	accounts, err := repo.ListAccounts()
+	require.NoError(t, err)
+	require.Len(t, accounts, 2)
+	assert.Equal(t, "acct-1", accounts[0].ID)
+	assert.Equal(t, "Fund One", accounts[0].Name)
+	assert.Equal(t, DefaultAccountID, accounts[1].ID)
+
+	require.NoError(t, repo.CreateUser(User{Username: "alice", AccountID: "acct-1", Role: RoleViewer, CreatedAt: at}))
+	assert.ErrorIs(t, repo.CreateUser(User{Username: "alice", AccountID: DefaultAccountID, CreatedAt: at}), ErrUserExists)
+	assert.ErrorIs(t, repo.CreateUser(User{Username: "bob", AccountID: "acct-9", CreatedAt: at}), ErrAccountNotFound)
+
+	alice, err := repo.GetUser("alice")
+	require.NoError(t, err)
+	assert.Equal(t, "acct-1", alice.AccountID)
+	assert.Equal(t, RoleViewer, alice.Role)
+	assert.True(t, at.Equal(alice.CreatedAt))
+	_, err = repo.GetUser("bob")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	require.NoError(t, repo.SetPassword("alice", "hash"))
+	alice, err = repo.GetUser("alice")
+	require.NoError(t, err)
+	assert.Equal(t, "hash", alice.PasswordHash)
+	assert.ErrorIs(t, repo.SetPassword("bob", "hash"), ErrUserNotFound)
+
+	require.NoError(t, repo.SetRole("alice", RoleTrader))
+	alice, err = repo.GetUser("alice")
+	require.NoError(t, err)
+	assert.Equal(t, RoleTrader, alice.Role)
+	assert.ErrorIs(t, repo.SetRole("bob", RoleTrader), ErrUserNotFound)
+
+	users, err := repo.ListUsers()
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	assert.Equal(t, "admin", users[0].Username)
+	assert.Equal(t, "alice", users[1].Username)
+}
+
+func TestAccountsRepoMemory(t *testing.T) {
+	testAccountsRepoContract(t, NewAccountsRepoMemory())
+}
+
+func TestAccountsRepoSQLite(t *testing.T) {
+	db := newTestSQLiteDB(t)
+	require.NoError(t, migrateDB(db, dialectSQLite))
+
+	testAccountsRepoContract(t, NewAccountsRepoSQL(db, dialectSQLite))
+}
+
+func TestUserOwns(t *testing.T) {
+	order := StopLossOrder{ID: "order-1", AccountID: "acct-1"}
+
+	assert.True(t, User{Username: "alice", AccountID: "acct-1", Role: RoleTrader}.Owns(order))
+	assert.False(t, User{Username: "bob", AccountID: "acct-2", Role: RoleTrader}.Owns(order))
+	assert.True(t, User{Username: "risk", AccountID: DefaultAccountID, Role: RoleRiskManager}.Owns(order))
+	assert.True(t, User{Username: "admin", AccountID: DefaultAccountID, Role: RoleAdmin}.Owns(order))
+}
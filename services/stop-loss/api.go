@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// IdempotencyKeyHeader lets API clients retry order creation safely: every
+// request carrying the same key gets back the order the first one created.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+type CreateOrderRequest struct {
+	Security  string  `json:"security"`
+	StopPrice float64 `json:"stopPrice"`
+	Quantity  int     `json:"quantity"`
+}
+
+type CancelOrderResponse struct {
+	OrderID string `json:"orderID"`
+	Result  string `json:"result"` // cancelled, too_late or already_executed
+}
+
+type RedriveOrderRequest struct {
+	Action string `json:"action"` // retry or rearm
+}
+
+type HaltRequest struct {
+	Security string `json:"security"` // empty for every security
+	Reason   string `json:"reason"`
+}
+
+type ResumeExecutionsRequest struct {
+	Security string `json:"security"` // empty lifts the halt on every security
+}
+
+type ResumeExecutionsResponse struct {
+	Security      string `json:"security,omitempty"`
+	ResumedOrders int    `json:"resumedOrders"` // open orders told the halt was lifted
+}
+
+type SetPositionRequest struct {
+	AccountID string `json:"accountID"` // the user's own account if empty
+	Quantity  int    `json:"quantity"`
+}
+
+type apiError struct {
+	Error   string   `json:"error"`
+	Reasons []string `json:"reasons,omitempty"` // every risk limit a rejected order breaks
+}
+
+func (s *WebServer) setupAPIRoutes(api *mux.Router) {
+	api.HandleFunc("/orders", s.allow(ActionPlaceOrder, s.handleAPICreateOrder)).Methods("POST")
+	api.HandleFunc("/orders", s.allow(ActionViewOrders, s.handleAPIListOrders)).Methods("GET")
+	api.HandleFunc("/orders/search", s.allow(ActionViewOrders, s.handleAPISearchOrders)).Methods("GET")
+	api.HandleFunc("/orders/{id}", s.allow(ActionViewOrders, s.handleAPIGetOrder)).Methods("GET")
+	api.HandleFunc("/orders/{id}/cancel", s.allow(ActionCancelOrder, s.handleAPICancelOrder)).Methods("POST")
+	api.HandleFunc("/orders/{id}/redrive", s.allow(ActionRedriveOrder, s.handleAPIRedriveOrder)).Methods("POST")
+	api.HandleFunc("/halts", s.allow(ActionViewOrders, s.handleAPIListHalts)).Methods("GET")
+	api.HandleFunc("/halts", s.allow(ActionHaltExecutions, s.handleAPIHaltExecutions)).Methods("POST")
+	api.HandleFunc("/halts/resume", s.allow(ActionHaltExecutions, s.handleAPIResumeExecutions)).Methods("POST")
+	api.HandleFunc("/positions", s.allow(ActionViewOrders, s.handleAPIListPositions)).Methods("GET")
+	api.HandleFunc("/positions/{security}", s.allow(ActionSetPositions, s.handleAPISetPosition)).Methods("PUT")
+	api.HandleFunc("/notifications", s.allow(ActionManageNotifications, s.handleAPIListNotifications)).Methods("GET")
+	api.HandleFunc("/notifications", s.allow(ActionManageNotifications, s.handleAPICreateNotification)).Methods("POST")
+	api.HandleFunc("/notifications/{id}", s.allow(ActionManageNotifications, s.handleAPIDeleteNotification)).Methods("DELETE")
+	s.setupAdminAPIRoutes(api)
+}
+
+func (s *WebServer) handleAPICreateOrder(w http.ResponseWriter, r *http.Request) {
+	var req CreateOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.Security == "" || req.StopPrice <= 0 || req.Quantity <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "security, a positive stopPrice and a positive quantity are required")
+		return
+	}
+
+	order := StopLossOrder{
+		Security:       req.Security,
+		StopPrice:      req.StopPrice,
+		Quantity:       req.Quantity,
+		IdempotencyKey: r.Header.Get(IdempotencyKeyHeader),
+		AccountID:      userFrom(r).AccountID,
+	}
+	saved, created, err := s.orderWorkflowService.CreateOrder(r.Context(), order, EventSourceAPI)
+	if errors.Is(err, ErrInvalidOrder) {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if reasons := riskReasons(err); reasons != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, apiError{Error: "order rejected by risk limits", Reasons: reasons})
+		return
+	}
+	if errors.Is(err, ErrDuplicateIdempotencyKey) {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to create order", "security", req.Security, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to create order")
+		return
+	}
+
+	status := http.StatusCreated
+	if !created {
+		status = http.StatusOK // a replay, the original order is returned unchanged
+	}
+	writeJSON(w, status, saved)
+}
+
+func (s *WebServer) handleAPIListOrders(w http.ResponseWriter, r *http.Request) {
+	orders, err := s.ordersFor(userFrom(r))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list orders", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to list orders")
+		return
+	}
+	if orders == nil {
+		orders = []StopLossOrder{}
+	}
+	writeJSON(w, http.StatusOK, orders)
+}
+
+// handleAPISearchOrders lists orders from Temporal's visibility store, by
+// the search attributes StopLossWorkflow sets. Unlike GET /orders it works
+// without the orders table, but only knows what the attributes hold.
+func (s *WebServer) handleAPISearchOrders(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	search := OrderSearch{
+		Security:  params.Get("security"),
+		Status:    params.Get("status"),
+		AccountID: params.Get("account"),
+	}
+	switch search.Status {
+	case "", OrderStatusPending, OrderStatusExecuting, OrderStatusHalted, OrderStatusExecuted, OrderStatusCancelled, OrderStatusFailed:
+	default:
+		writeJSONError(w, http.StatusBadRequest, "unknown status "+search.Status)
+		return
+	}
+	// only risk managers and admins search beyond their own account
+	if user := userFrom(r); !user.Can(ActionViewAllAccounts) {
+		search.AccountID = user.AccountID
+	}
+	for name, price := range map[string]*float64{"minStopPrice": &search.MinStopPrice, "maxStopPrice": &search.MaxStopPrice} {
+		if v := params.Get(name); v != "" {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil || parsed < 0 {
+				writeJSONError(w, http.StatusBadRequest, name+" must be a non-negative number")
+				return
+			}
+			*price = parsed
+		}
+	}
+
+	results, err := s.orderWorkflowService.SearchOrders(r.Context(), search)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to search orders", "error", err)
+		writeJSONError(w, http.StatusBadGateway, "failed to search Temporal for orders")
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+func (s *WebServer) handleAPIGetOrder(w http.ResponseWriter, r *http.Request) {
+	order, err := s.orderFor(r, ActionViewOrders, mux.Vars(r)["id"])
+	if errors.Is(err, ErrOrderNotFound) {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get order", "orderID", mux.Vars(r)["id"], "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to get order")
+		return
+	}
+	writeJSON(w, http.StatusOK, order)
+}
+
+// handleAPICancelOrder answers 200 only when the order really was cancelled,
+// and 409 with the reason when it couldn't be.
+func (s *WebServer) handleAPICancelOrder(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+	err := s.checkOwner(r, ActionCancelOrder, orderID)
+	var result string
+	if err == nil {
+		result, err = s.orderWorkflowService.CancelOrder(r.Context(), orderID, EventSourceAPI)
+	}
+	if errors.Is(err, ErrOrderNotFound) {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to cancel order", "orderID", orderID, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to cancel order")
+		return
+	}
+
+	status := http.StatusOK
+	if result != CancelResultCancelled {
+		status = http.StatusConflict
+	}
+	writeJSON(w, status, CancelOrderResponse{OrderID: orderID, Result: result})
+}
+
+// handleAPIRedriveOrder is the admin action for a FAILED order. The workflow
+// does the work after answering, so success is 202.
+func (s *WebServer) handleAPIRedriveOrder(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+	var req RedriveOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.Action != FailureActionRetry && req.Action != FailureActionRearm {
+		writeJSONError(w, http.StatusBadRequest, "action must be retry or rearm")
+		return
+	}
+
+	err := s.checkOwner(r, ActionRedriveOrder, orderID)
+	if err == nil {
+		err = s.orderWorkflowService.RedriveOrder(r.Context(), orderID, req.Action, EventSourceAdmin)
+	}
+	switch {
+	case errors.Is(err, ErrOrderNotFound):
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrOrderNotFailed):
+		writeJSONError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Failed to re-drive order", "orderID", orderID, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to re-drive order")
+	default:
+		writeJSON(w, http.StatusAccepted, req)
+	}
+}
+
+func (s *WebServer) handleAPIListHalts(w http.ResponseWriter, r *http.Request) {
+	halts, err := s.orderWorkflowService.ListHalts(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list halts", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to list halts")
+		return
+	}
+	if halts == nil {
+		halts = []Halt{}
+	}
+	writeJSON(w, http.StatusOK, halts)
+}
+
+// handleAPIHaltExecutions is the kill switch. Halting a security that's
+// already halted replaces the halt.
+func (s *WebServer) handleAPIHaltExecutions(w http.ResponseWriter, r *http.Request) {
+	var req HaltRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	halt, err := s.orderWorkflowService.HaltExecutions(r.Context(), req.Security, req.Reason, EventSourceAdmin)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to halt executions", "security", haltScope(req.Security), "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to halt executions")
+		return
+	}
+	writeJSON(w, http.StatusCreated, halt)
+}
+
+func (s *WebServer) handleAPIResumeExecutions(w http.ResponseWriter, r *http.Request) {
+	var req ResumeExecutionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	resumed, err := s.orderWorkflowService.ResumeExecutions(r.Context(), req.Security, EventSourceAdmin)
+	switch {
+	case errors.Is(err, ErrNotHalted):
+		writeJSONError(w, http.StatusNotFound, haltScope(req.Security)+" is not halted")
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Failed to resume executions", "security", haltScope(req.Security), "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to resume executions")
+	default:
+		writeJSON(w, http.StatusOK, ResumeExecutionsResponse{Security: req.Security, ResumedOrders: resumed})
+	}
+}
+
+// handleAPIListPositions lists the positions of the user's account, or of
+// every account for risk managers and admins.
+func (s *WebServer) handleAPIListPositions(w http.ResponseWriter, r *http.Request) {
+	all, err := s.positionsRepo.ListPositions()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list positions", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to list positions")
+		return
+	}
+	user := userFrom(r)
+	positions := []Position{}
+	for _, position := range all {
+		if user.Can(ActionViewAllAccounts) || position.AccountID == user.AccountID {
+			positions = append(positions, position)
+		}
+	}
+	writeJSON(w, http.StatusOK, positions)
+}
+
+// handleAPISetPosition replaces what the user's account holds of a security,
+// e.g. after a trade made outside this service. Executions here reduce it on
+// their own. Risk managers and admins can set another account's.
+func (s *WebServer) handleAPISetPosition(w http.ResponseWriter, r *http.Request) {
+	var req SetPositionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.Quantity < 0 {
+		writeJSONError(w, http.StatusBadRequest, "quantity can't be negative")
+		return
+	}
+	user := userFrom(r)
+	if req.AccountID == "" {
+		req.AccountID = user.AccountID
+	}
+	if req.AccountID != user.AccountID && !user.Can(ActionViewAllAccounts) {
+		writeJSONError(w, http.StatusForbidden, "you can only set your own account's positions")
+		return
+	}
+
+	position := Position{AccountID: req.AccountID, Security: mux.Vars(r)["security"], Quantity: req.Quantity, UpdatedAt: time.Now().UTC()}
+	if err := s.positionsRepo.SetPosition(position); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to set position", "accountID", position.AccountID, "security", position.Security, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to set position")
+		return
+	}
+	writeJSON(w, http.StatusOK, position)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to write response", "error", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
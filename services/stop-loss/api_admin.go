@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type CreateUserRequest struct {
+	Username  string `json:"username"`
+	AccountID string `json:"accountID"`
+	Role      string `json:"role"`               // trader if empty
+	Password  string `json:"password,omitempty"` // API keys only if empty
+}
+
+type SetRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type CreateAccountRequest struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type CreateWebhookRequest struct {
+	AccountID string   `json:"accountID"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"` // every event if empty
+}
+
+// CreateWebhookResponse is the only time the secret is shown.
+type CreateWebhookResponse struct {
+	WebhookSubscription
+	Secret string `json:"secret"`
+}
+
+type ReconcileRequest struct {
+	Repair bool `json:"repair"` // fix mismatches instead of only reporting them
+}
+
+// setupAdminAPIRoutes adds what only admins may do: manage accounts, users
+// and webhooks, reconcile the orders table with Temporal, and read the audit
+// log.
+func (s *WebServer) setupAdminAPIRoutes(api *mux.Router) {
+	api.HandleFunc("/accounts", s.allow(ActionManageUsers, s.handleAPIListAccounts)).Methods("GET")
+	api.HandleFunc("/accounts", s.allow(ActionManageUsers, s.handleAPICreateAccount)).Methods("POST")
+	api.HandleFunc("/users", s.allow(ActionManageUsers, s.handleAPIListUsers)).Methods("GET")
+	api.HandleFunc("/users", s.allow(ActionManageUsers, s.handleAPICreateUser)).Methods("POST")
+	api.HandleFunc("/users/{username}/role", s.allow(ActionManageUsers, s.handleAPISetRole)).Methods("PUT")
+	api.HandleFunc("/webhooks", s.allow(ActionManageWebhooks, s.handleAPIListWebhooks)).Methods("GET")
+	api.HandleFunc("/webhooks", s.allow(ActionManageWebhooks, s.handleAPICreateWebhook)).Methods("POST")
+	api.HandleFunc("/webhooks/dead-letters", s.allow(ActionManageWebhooks, s.handleAPIListWebhookDeadLetters)).Methods("GET")
+	api.HandleFunc("/webhooks/{id}", s.allow(ActionManageWebhooks, s.handleAPIDeleteWebhook)).Methods("DELETE")
+	api.HandleFunc("/reconcile", s.allow(ActionReconcile, s.handleAPIReconcile)).Methods("POST")
+	api.HandleFunc("/audit", s.allow(ActionViewAuditLog, s.handleAPIListAuditEntries)).Methods("GET")
+}
+
+func (s *WebServer) handleAPIListAccounts(w http.ResponseWriter, r *http.Request) {
+	accounts, err := s.accountsRepo.ListAccounts()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list accounts", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to list accounts")
+		return
+	}
+	writeJSON(w, http.StatusOK, accounts)
+}
+
+func (s *WebServer) handleAPICreateAccount(w http.ResponseWriter, r *http.Request) {
+	var req CreateAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.ID == "" || req.Name == "" {
+		writeJSONError(w, http.StatusBadRequest, "id and name are required")
+		return
+	}
+
+	account := Account{ID: req.ID, Name: req.Name, CreatedAt: time.Now().UTC()}
+	err := s.accountsRepo.CreateAccount(account)
+	if errors.Is(err, ErrAccountExists) {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to create account", "accountID", req.ID, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to create account")
+		return
+	}
+	writeJSON(w, http.StatusCreated, account)
+}
+
+func (s *WebServer) handleAPIListUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := s.accountsRepo.ListUsers()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list users", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to list users")
+		return
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+func (s *WebServer) handleAPICreateUser(w http.ResponseWriter, r *http.Request) {
+	var req CreateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.Role == "" {
+		req.Role = RoleTrader
+	}
+	if req.Username == "" || req.AccountID == "" {
+		writeJSONError(w, http.StatusBadRequest, "username and accountID are required")
+		return
+	}
+	if !validRole(req.Role) {
+		writeJSONError(w, http.StatusBadRequest, "unknown role "+req.Role)
+		return
+	}
+
+	user := User{Username: req.Username, AccountID: req.AccountID, Role: req.Role, CreatedAt: time.Now().UTC()}
+	if req.Password != "" {
+		hash, err := hashPassword(req.Password)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to hash password", "username", req.Username, "error", err)
+			writeJSONError(w, http.StatusInternalServerError, "failed to create user")
+			return
+		}
+		user.PasswordHash = hash
+	}
+	err := s.accountsRepo.CreateUser(user)
+	switch {
+	case errors.Is(err, ErrUserExists):
+		writeJSONError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, ErrAccountNotFound):
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Failed to create user", "username", req.Username, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to create user")
+	default:
+		slog.InfoContext(r.Context(), "Added user", "by", userFrom(r).Username, "username", user.Username, "role", user.Role, "accountID", user.AccountID)
+		writeJSON(w, http.StatusCreated, user)
+	}
+}
+
+func (s *WebServer) handleAPISetRole(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+	var req SetRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if !validRole(req.Role) {
+		writeJSONError(w, http.StatusBadRequest, "unknown role "+req.Role)
+		return
+	}
+
+	err := s.accountsRepo.SetRole(username, req.Role)
+	if errors.Is(err, ErrUserNotFound) {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to set role", "username", username, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to set role")
+		return
+	}
+	slog.InfoContext(r.Context(), "Set role", "by", userFrom(r).Username, "username", username, "role", req.Role)
+	user, err := s.accountsRepo.GetUser(username)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get user", "username", username, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to get user")
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+// handleAPIListWebhooks lists every account's webhooks, or one account's
+// with ?account=.
+func (s *WebServer) handleAPIListWebhooks(w http.ResponseWriter, r *http.Request) {
+	subscriptions, err := s.webhooksRepo.ListSubscriptions(r.URL.Query().Get("account"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list webhooks", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to list webhooks")
+		return
+	}
+	if subscriptions == nil {
+		subscriptions = []WebhookSubscription{}
+	}
+	writeJSON(w, http.StatusOK, subscriptions)
+}
+
+func (s *WebServer) handleAPICreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.AccountID == "" {
+		writeJSONError(w, http.StatusBadRequest, "accountID is required")
+		return
+	}
+	if !absoluteHTTPURL(req.URL) {
+		writeJSONError(w, http.StatusBadRequest, "url must be an absolute http or https URL")
+		return
+	}
+	if len(req.Events) == 0 {
+		req.Events = webhookEvents
+	}
+	for _, event := range req.Events {
+		if !validWebhookEvent(event) {
+			writeJSONError(w, http.StatusBadRequest, "unknown event "+event)
+			return
+		}
+	}
+	if _, err := s.accountsRepo.GetAccount(req.AccountID); err != nil {
+		if errors.Is(err, ErrAccountNotFound) {
+			writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		slog.ErrorContext(r.Context(), "Failed to get account", "accountID", req.AccountID, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to create webhook")
+		return
+	}
+
+	subscription, err := newWebhookSubscription(req.AccountID, req.URL, req.Events, time.Now().UTC())
+	if err == nil {
+		err = s.webhooksRepo.CreateSubscription(subscription)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to create webhook", "accountID", req.AccountID, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to create webhook")
+		return
+	}
+	slog.InfoContext(r.Context(), "Added webhook", "by", userFrom(r).Username, "webhookID", subscription.ID, "accountID", subscription.AccountID, "url", subscription.URL)
+	writeJSON(w, http.StatusCreated, CreateWebhookResponse{WebhookSubscription: subscription, Secret: subscription.Secret})
+}
+
+func (s *WebServer) handleAPIDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	err := s.webhooksRepo.DeleteSubscription(id)
+	if errors.Is(err, ErrWebhookNotFound) {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to delete webhook", "webhookID", id, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to delete webhook")
+		return
+	}
+	slog.InfoContext(r.Context(), "Deleted webhook", "by", userFrom(r).Username, "webhookID", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPIListWebhookDeadLetters lists the latest deliveries that gave up,
+// newest first: 100 of them unless ?limit= says otherwise.
+func (s *WebServer) handleAPIListWebhookDeadLetters(w http.ResponseWriter, r *http.Request) {
+	limit, ok := limitParam(w, r)
+	if !ok {
+		return
+	}
+	deadLetters, err := s.webhooksRepo.ListDeadLetters(limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list webhook dead letters", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to list webhook dead letters")
+		return
+	}
+	if deadLetters == nil {
+		deadLetters = []WebhookDeadLetter{}
+	}
+	writeJSON(w, http.StatusOK, deadLetters)
+}
+
+// handleAPIReconcile runs the same comparison as `stop-loss reconcile` and
+// the reconcile schedule, and answers with its report.
+func (s *WebServer) handleAPIReconcile(w http.ResponseWriter, r *http.Request) {
+	var req ReconcileRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	}
+
+	report, err := s.orderWorkflowService.Reconcile(r.Context(), req.Repair)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to reconcile", "error", err)
+		writeJSONError(w, http.StatusBadGateway, "failed to reconcile with Temporal")
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleAPIListAuditEntries lists the latest audit entries, newest first:
+// 100 of them unless ?limit= says otherwise.
+func (s *WebServer) handleAPIListAuditEntries(w http.ResponseWriter, r *http.Request) {
+	limit, ok := limitParam(w, r)
+	if !ok {
+		return
+	}
+	entries, err := s.auditRepo.ListEntries(limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list audit entries", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to list audit entries")
+		return
+	}
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// limitParam reads ?limit=, 100 if it's left out. A bad one has been
+// answered when ok is false.
+func limitParam(w http.ResponseWriter, r *http.Request) (limit int, ok bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return 100, true
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "limit must be a positive number")
+		return 0, false
+	}
+	return limit, true
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type CreateNotificationRequest struct {
+	AccountID string   `json:"accountID"` // the user's own account if empty
+	Channel   string   `json:"channel"`   // email or chat
+	Target    string   `json:"target"`    // the email address or chat webhook URL
+	Events    []string `json:"events"`    // executed and failed if empty
+}
+
+// handleAPIListNotifications lists the notification preferences of the
+// user's account. Risk managers and admins see every account's, or one
+// account's with ?account=.
+func (s *WebServer) handleAPIListNotifications(w http.ResponseWriter, r *http.Request) {
+	user := userFrom(r)
+	accountID := user.AccountID
+	if user.Can(ActionViewAllAccounts) {
+		accountID = r.URL.Query().Get("account")
+	}
+	preferences, err := s.notificationsRepo.ListPreferences(accountID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list notification preferences", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to list notification preferences")
+		return
+	}
+	if preferences == nil {
+		preferences = []NotificationPreference{}
+	}
+	writeJSON(w, http.StatusOK, preferences)
+}
+
+func (s *WebServer) handleAPICreateNotification(w http.ResponseWriter, r *http.Request) {
+	var req CreateNotificationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	user := userFrom(r)
+	if req.AccountID == "" {
+		req.AccountID = user.AccountID
+	}
+	if req.AccountID != user.AccountID && !user.Can(ActionViewAllAccounts) {
+		writeJSONError(w, http.StatusForbidden, "you can only set up your own account's notifications")
+		return
+	}
+	if err := validNotificationTarget(req.Channel, req.Target); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.Events) == 0 {
+		req.Events = notificationEvents
+	}
+	for _, event := range req.Events {
+		if !validNotificationEvent(event) {
+			writeJSONError(w, http.StatusBadRequest, "unknown event "+event)
+			return
+		}
+	}
+	if _, err := s.accountsRepo.GetAccount(req.AccountID); err != nil {
+		if errors.Is(err, ErrAccountNotFound) {
+			writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		slog.ErrorContext(r.Context(), "Failed to get account", "accountID", req.AccountID, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to create notification preference")
+		return
+	}
+
+	preference, err := newNotificationPreference(req.AccountID, req.Channel, req.Target, req.Events, time.Now().UTC())
+	if err == nil {
+		err = s.notificationsRepo.CreatePreference(preference)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to create notification preference", "accountID", req.AccountID, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to create notification preference")
+		return
+	}
+	slog.InfoContext(r.Context(), "Added notification preference", "by", user.Username, "preferenceID", preference.ID, "channel", preference.Channel, "accountID", preference.AccountID)
+	writeJSON(w, http.StatusCreated, preference)
+}
+
+// handleAPIDeleteNotification answers 404 for another account's preference,
+// as if it didn't exist.
+func (s *WebServer) handleAPIDeleteNotification(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	user := userFrom(r)
+	preference, err := s.notificationsRepo.GetPreference(id)
+	if err == nil && preference.AccountID != user.AccountID && !user.Can(ActionViewAllAccounts) {
+		err = ErrNotificationNotFound
+	}
+	if err == nil {
+		err = s.notificationsRepo.DeletePreference(id)
+	}
+	if errors.Is(err, ErrNotificationNotFound) {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to delete notification preference", "preferenceID", id, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to delete notification preference")
+		return
+	}
+	slog.InfoContext(r.Context(), "Deleted notification preference", "by", user.Username, "preferenceID", id)
+	w.WriteHeader(http.StatusNoContent)
+}
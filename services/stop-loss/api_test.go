@@ -0,0 +1,468 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func apiCreateOrder(ts *testWebServer, body, idempotencyKey string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/api/orders", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if idempotencyKey != "" {
+		req.Header.Set(IdempotencyKeyHeader, idempotencyKey)
+	}
+	return ts.do(req)
+}
+
+func TestAPICreateOrder(t *testing.T) {
+	ts := newTestWebServer(t)
+
+	rec := apiCreateOrder(ts, `{"security": "AAPL", "stopPrice": 145.5, "quantity": 10}`, "key-1")
+
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var order StopLossOrder
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &order))
+	assert.Equal(t, "order-1", order.ID)
+	assert.Equal(t, "AAPL", order.Security)
+	assert.Equal(t, 145.5, order.StopPrice)
+	assert.Equal(t, 10, order.Quantity)
+	assert.Equal(t, "key-1", order.IdempotencyKey)
+	assert.Equal(t, []string{EventSourceAPI}, ts.service.sources)
+}
+
+func TestAPICreateOrderReplaysIdempotencyKey(t *testing.T) {
+	ts := newTestWebServer(t)
+	body := `{"security": "AAPL", "stopPrice": 145.5, "quantity": 10}`
+
+	first := apiCreateOrder(ts, body, "key-1")
+	require.Equal(t, http.StatusCreated, first.Code)
+	retry := apiCreateOrder(ts, body, "key-1")
+	require.Equal(t, http.StatusOK, retry.Code)
+	assert.JSONEq(t, first.Body.String(), retry.Body.String())
+
+	// a different key is a different order
+	other := apiCreateOrder(ts, body, "key-2")
+	require.Equal(t, http.StatusCreated, other.Code)
+	assert.Len(t, ts.service.created, 2)
+}
+
+func TestAPICreateOrderValidation(t *testing.T) {
+	ts := newTestWebServer(t)
+	for _, body := range []string{
+		`not json`,
+		`{"stopPrice": 145.5, "quantity": 10}`,
+		`{"security": "AAPL", "stopPrice": 0, "quantity": 10}`,
+		`{"security": "AAPL", "stopPrice": 145.5, "quantity": -1}`,
+	} {
+		rec := apiCreateOrder(ts, body, "")
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+		assert.Contains(t, rec.Body.String(), `"error"`)
+	}
+	assert.Empty(t, ts.service.created)
+}
+
+func TestAPICreateOrderRejectedByRiskLimits(t *testing.T) {
+	ts := newTestWebServer(t)
+	ts.service.riskReasons = []string{"stops on AAPL would sell 20 shares but 15 are held"}
+
+	rec := apiCreateOrder(ts, `{"security": "AAPL", "stopPrice": 145.5, "quantity": 10}`, "")
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.JSONEq(t, `{"error": "order rejected by risk limits", "reasons": ["stops on AAPL would sell 20 shares but 15 are held"]}`, rec.Body.String())
+}
+
+func TestAPIPositions(t *testing.T) {
+	ts := newTestWebServer(t)
+
+	rec := ts.do(httptest.NewRequest("GET", "/api/positions", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[]`, rec.Body.String())
+
+	rec = ts.do(httptest.NewRequest("PUT", "/api/positions/AAPL", strings.NewReader(`{"quantity": 100}`)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	position, err := ts.positions.GetPosition(DefaultAccountID, "AAPL")
+	require.NoError(t, err)
+	assert.Equal(t, 100, position.Quantity)
+
+	rec = ts.do(httptest.NewRequest("GET", "/api/positions", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var positions []Position
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &positions))
+	require.Len(t, positions, 1)
+	assert.Equal(t, "AAPL", positions[0].Security)
+
+	for _, body := range []string{`{"quantity": -1}`, `not json`} {
+		rec := ts.do(httptest.NewRequest("PUT", "/api/positions/AAPL", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+}
+
+func TestAPIGetAndListOrders(t *testing.T) {
+	ts := newTestWebServer(t)
+
+	rec := ts.do(httptest.NewRequest("GET", "/api/orders", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[]`, rec.Body.String())
+
+	placedAt := time.Date(2025, 2, 1, 14, 30, 0, 0, time.UTC)
+	_, err := ts.orders.CreateOrder(StopLossOrder{ID: "order-1", Security: "AAPL", StopPrice: 145, Quantity: 10, Status: OrderStatusPending, PlacedAt: placedAt})
+	require.NoError(t, err)
+
+	rec = ts.do(httptest.NewRequest("GET", "/api/orders", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var orders []StopLossOrder
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &orders))
+	assert.Equal(t, []string{"order-1"}, orderIDs(orders))
+
+	rec = ts.do(httptest.NewRequest("GET", "/api/orders/order-1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"security":"AAPL"`)
+
+	rec = ts.do(httptest.NewRequest("GET", "/api/orders/nope", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAPICancelOrder(t *testing.T) {
+	for result, status := range map[string]int{
+		CancelResultCancelled:       http.StatusOK,
+		CancelResultTooLate:         http.StatusConflict,
+		CancelResultAlreadyExecuted: http.StatusConflict,
+	} {
+		ts := newTestWebServer(t)
+		ts.service.cancelResult = result
+
+		rec := ts.do(httptest.NewRequest("POST", "/api/orders/order-1/cancel", nil))
+
+		assert.Equal(t, status, rec.Code, result)
+		assert.JSONEq(t, `{"orderID": "order-1", "result": "`+result+`"}`, rec.Body.String())
+	}
+
+	ts := newTestWebServer(t)
+	rec := ts.do(httptest.NewRequest("POST", "/api/orders/nope/cancel", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAPISearchOrders(t *testing.T) {
+	ts := newTestWebServer(t)
+	ts.service.searchResults = []OrderSearchResult{{OrderID: "order-1", Security: "AAPL", StopPrice: 145, Status: OrderStatusPending, WorkflowID: "wf-1", RunID: "run-1"}}
+
+	rec := ts.do(httptest.NewRequest("GET", "/api/orders/search?security=AAPL&status=PENDING&account=acct-1&minStopPrice=100&maxStopPrice=150.5", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[{"orderID": "order-1", "security": "AAPL", "stopPrice": 145, "status": "PENDING", "workflowID": "wf-1", "runID": "run-1"}]`, rec.Body.String())
+	assert.Equal(t, []OrderSearch{{Security: "AAPL", Status: OrderStatusPending, AccountID: "acct-1", MinStopPrice: 100, MaxStopPrice: 150.5}}, ts.service.searches)
+
+	// orders with a trigger queued behind a halt
+	rec = ts.do(httptest.NewRequest("GET", "/api/orders/search?status=HALTED", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, OrderStatusHalted, ts.service.searches[1].Status)
+
+	for _, query := range []string{"status=pending", "minStopPrice=cheap", "maxStopPrice=-1"} {
+		rec := ts.do(httptest.NewRequest("GET", "/api/orders/search?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+	}
+	assert.Len(t, ts.service.searches, 2)
+}
+
+func TestAPIRedriveOrder(t *testing.T) {
+	ts := newTestWebServer(t)
+
+	rec := ts.do(httptest.NewRequest("POST", "/api/orders/order-1/redrive", strings.NewReader(`{"action": "rearm"}`)))
+
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	assert.JSONEq(t, `{"action": "rearm"}`, rec.Body.String())
+	assert.Equal(t, []string{"order-1:rearm"}, ts.service.redriven)
+
+	for path, status := range map[string]int{
+		"/api/orders/nope/redrive":     http.StatusNotFound,
+		"/api/orders/order-ok/redrive": http.StatusConflict,
+	} {
+		rec := ts.do(httptest.NewRequest("POST", path, strings.NewReader(`{"action": "retry"}`)))
+		assert.Equal(t, status, rec.Code, path)
+	}
+	for _, body := range []string{`{"action": "escalate"}`, `{}`, `not json`} {
+		rec := ts.do(httptest.NewRequest("POST", "/api/orders/order-1/redrive", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+	assert.Len(t, ts.service.redriven, 1)
+}
+
+func TestAPIHalts(t *testing.T) {
+	ts := newTestWebServer(t)
+
+	rec := ts.do(httptest.NewRequest("GET", "/api/halts", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[]`, rec.Body.String())
+
+	rec = ts.do(httptest.NewRequest("POST", "/api/halts", strings.NewReader(`{"security": "AAPL", "reason": "bad feed"}`)))
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.JSONEq(t, `{"security": "AAPL", "reason": "bad feed", "source": "admin", "haltedAt": "2025-02-01T14:30:00Z"}`, rec.Body.String())
+
+	// no security halts every one
+	rec = ts.do(httptest.NewRequest("POST", "/api/halts", strings.NewReader(`{"reason": "market event"}`)))
+	require.Equal(t, http.StatusCreated, rec.Code)
+	rec = ts.do(httptest.NewRequest("GET", "/api/halts", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[
+		{"security": "AAPL", "reason": "bad feed", "source": "admin", "haltedAt": "2025-02-01T14:30:00Z"},
+		{"reason": "market event", "source": "admin", "haltedAt": "2025-02-01T14:30:00Z"}
+	]`, rec.Body.String())
+
+	rec = ts.do(httptest.NewRequest("POST", "/api/halts", strings.NewReader(`not json`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestAPIResumeExecutions(t *testing.T) {
+	ts := newTestWebServer(t)
+	ts.service.halts = []Halt{{Security: "AAPL", Reason: "bad feed"}}
+
+	rec := ts.do(httptest.NewRequest("POST", "/api/halts/resume", strings.NewReader(`{"security": "AAPL"}`)))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"security": "AAPL", "resumedOrders": 2}`, rec.Body.String())
+	assert.Empty(t, ts.service.halts)
+
+	rec = ts.do(httptest.NewRequest("POST", "/api/halts/resume", strings.NewReader(`{}`)))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Contains(t, rec.Body.String(), "all securities is not halted")
+	rec = ts.do(httptest.NewRequest("POST", "/api/halts/resume", strings.NewReader(`not json`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestAPIScopesOrdersToAccount(t *testing.T) {
+	ts := newTestWebServer(t)
+	ts.addUser(t, "alice", "acct-1")
+	for _, order := range []StopLossOrder{
+		{ID: "order-1", AccountID: "acct-1", Security: "AAPL", StopPrice: 145, Quantity: 10, Status: OrderStatusPending},
+		{ID: "order-2", AccountID: "acct-2", Security: "GOOG", StopPrice: 95, Quantity: 5, Status: OrderStatusPending},
+	} {
+		_, err := ts.orders.CreateOrder(order)
+		require.NoError(t, err)
+	}
+
+	rec := ts.doAs("alice", httptest.NewRequest("GET", "/api/orders", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var orders []StopLossOrder
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &orders))
+	assert.Equal(t, []string{"order-1"}, orderIDs(orders))
+
+	assert.Equal(t, http.StatusOK, ts.doAs("alice", httptest.NewRequest("GET", "/api/orders/order-1", nil)).Code)
+	assert.Equal(t, http.StatusNotFound, ts.doAs("alice", httptest.NewRequest("GET", "/api/orders/order-2", nil)).Code)
+	assert.Equal(t, http.StatusNotFound, ts.doAs("alice", httptest.NewRequest("POST", "/api/orders/order-2/cancel", nil)).Code)
+	assert.Empty(t, ts.service.cancelled)
+
+	// the 404s don't give the order away, but the audit log has them
+	entries, err := ts.audit.ListEntries(10)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, AuditEntry{ID: 2, Username: "alice", Role: RoleTrader, Action: ActionCancelOrder, Target: "POST /api/orders/order-2/cancel", Outcome: AuditOutcomeDenied, OccurredAt: entries[0].OccurredAt}, entries[0])
+	assert.Equal(t, ActionViewOrders, entries[1].Action)
+	assert.Equal(t, "GET /api/orders/order-2", entries[1].Target)
+
+	// a search can't reach past the user's account
+	ts.doAs("alice", httptest.NewRequest("GET", "/api/orders/search?account=acct-2", nil))
+	require.Len(t, ts.service.searches, 1)
+	assert.Equal(t, "acct-1", ts.service.searches[0].AccountID)
+
+	// the admin sees every account's orders
+	rec = ts.do(httptest.NewRequest("GET", "/api/orders", nil))
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &orders))
+	assert.ElementsMatch(t, []string{"order-1", "order-2"}, orderIDs(orders))
+
+	req := httptest.NewRequest("POST", "/api/orders", strings.NewReader(`{"security": "AAPL", "stopPrice": 140, "quantity": 5}`))
+	rec = ts.doAs("alice", req)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"accountID":"acct-1"`)
+}
+
+func TestAPIPositionsScopedToAccount(t *testing.T) {
+	ts := newTestWebServer(t)
+	ts.addUser(t, "alice", "acct-1")
+	ts.addUserWithRole(t, "rita", "acct-3", RoleRiskManager)
+	require.NoError(t, ts.positions.SetPosition(Position{AccountID: "acct-1", Security: "AAPL", Quantity: 100}))
+	require.NoError(t, ts.positions.SetPosition(Position{AccountID: "acct-2", Security: "AAPL", Quantity: 50}))
+
+	rec := ts.doAs("alice", httptest.NewRequest("GET", "/api/positions", nil))
+	var positions []Position
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &positions))
+	require.Len(t, positions, 1)
+	assert.Equal(t, "acct-1", positions[0].AccountID)
+	assert.Equal(t, 100, positions[0].Quantity)
+
+	// traders can't set positions, not even their own
+	rec = ts.doAs("alice", httptest.NewRequest("PUT", "/api/positions/AAPL", strings.NewReader(`{"quantity": 1000}`)))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// risk managers see and set any account's
+	rec = ts.doAs("rita", httptest.NewRequest("GET", "/api/positions", nil))
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &positions))
+	assert.Len(t, positions, 2)
+	rec = ts.doAs("rita", httptest.NewRequest("PUT", "/api/positions/AAPL", strings.NewReader(`{"accountID": "acct-2", "quantity": 0}`)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	position, err := ts.positions.GetPosition("acct-2", "AAPL")
+	require.NoError(t, err)
+	assert.Equal(t, 0, position.Quantity)
+}
+
+func TestAPIManageUsers(t *testing.T) {
+	ts := newTestWebServer(t)
+
+	rec := ts.do(httptest.NewRequest("POST", "/api/accounts", strings.NewReader(`{"id": "acct-1", "name": "Fund One"}`)))
+	require.Equal(t, http.StatusCreated, rec.Code)
+	rec = ts.do(httptest.NewRequest("POST", "/api/accounts", strings.NewReader(`{"id": "acct-1", "name": "Again"}`)))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = ts.do(httptest.NewRequest("POST", "/api/users", strings.NewReader(`{"username": "alice", "accountID": "acct-1", "password": "hunter2"}`)))
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "hunter2")
+	alice, err := ts.accounts.GetUser("alice")
+	require.NoError(t, err)
+	assert.Equal(t, RoleTrader, alice.Role)
+	assert.True(t, checkPassword(alice.PasswordHash, "hunter2"))
+
+	for body, status := range map[string]int{
+		`{"username": "alice", "accountID": "acct-1"}`:                    http.StatusConflict,
+		`{"username": "bob", "accountID": "acct-9"}`:                      http.StatusUnprocessableEntity,
+		`{"username": "bob", "accountID": "acct-1", "role": "superuser"}`: http.StatusBadRequest,
+		`{"accountID": "acct-1"}`:                                         http.StatusBadRequest,
+		`not json`:                                                        http.StatusBadRequest,
+	} {
+		rec = ts.do(httptest.NewRequest("POST", "/api/users", strings.NewReader(body)))
+		assert.Equal(t, status, rec.Code, body)
+	}
+
+	rec = ts.do(httptest.NewRequest("PUT", "/api/users/alice/role", strings.NewReader(`{"role": "risk-manager"}`)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"role":"risk-manager"`)
+	assert.Equal(t, http.StatusNotFound, ts.do(httptest.NewRequest("PUT", "/api/users/bob/role", strings.NewReader(`{"role": "viewer"}`))).Code)
+	assert.Equal(t, http.StatusBadRequest, ts.do(httptest.NewRequest("PUT", "/api/users/alice/role", strings.NewReader(`{"role": ""}`))).Code)
+
+	rec = ts.do(httptest.NewRequest("GET", "/api/users", nil))
+	var users []User
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &users))
+	require.Len(t, users, 2)
+	assert.Equal(t, RoleRiskManager, users[1].Role)
+
+	// a risk manager still isn't an admin
+	assert.Equal(t, http.StatusForbidden, ts.doAs("alice", httptest.NewRequest("PUT", "/api/users/alice/role", strings.NewReader(`{"role": "admin"}`))).Code)
+}
+
+func TestAPIReconcile(t *testing.T) {
+	ts := newTestWebServer(t)
+
+	rec := ts.do(httptest.NewRequest("POST", "/api/reconcile", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"mismatches":[]`)
+	rec = ts.do(httptest.NewRequest("POST", "/api/reconcile", strings.NewReader(`{"repair": true}`)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []bool{false, true}, ts.service.reconciles)
+}
+
+func TestAPIManageWebhooks(t *testing.T) {
+	ts := newTestWebServer(t)
+	ts.addUser(t, "alice", "acct-1")
+
+	rec := ts.do(httptest.NewRequest("POST", "/api/webhooks", strings.NewReader(`{"accountID": "acct-1", "url": "https://books.example/hook", "events": ["executed", "failed"]}`)))
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var created CreateWebhookResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.Regexp(t, `^whsec_[0-9a-f]{48}$`, created.Secret)
+	assert.Equal(t, []string{WebhookEventExecuted, WebhookEventFailed}, created.Events)
+	stored, err := ts.webhooks.GetSubscription(created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, created.Secret, stored.Secret)
+
+	// without events it gets them all
+	rec = ts.do(httptest.NewRequest("POST", "/api/webhooks", strings.NewReader(`{"accountID": "default", "url": "http://localhost:9000/"}`)))
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"events":["created","triggered","executed","cancelled","failed","expired"]`)
+
+	for body, status := range map[string]int{
+		`{"accountID": "acct-9", "url": "https://books.example/hook"}`:                         http.StatusUnprocessableEntity,
+		`{"accountID": "acct-1", "url": "books.example/hook"}`:                                 http.StatusBadRequest,
+		`{"accountID": "acct-1", "url": "ftp://books.example/hook"}`:                           http.StatusBadRequest,
+		`{"accountID": "default", "url": "https://books.example/hook", "events": ["expired"]}`: http.StatusCreated,
+		`{"accountID": "acct-1", "url": "https://books.example/hook", "events": ["amended"]}`:  http.StatusBadRequest,
+		`{"url": "https://books.example/hook"}`:                                                http.StatusBadRequest,
+	} {
+		rec = ts.do(httptest.NewRequest("POST", "/api/webhooks", strings.NewReader(body)))
+		assert.Equal(t, status, rec.Code, body)
+	}
+
+	// secrets aren't listed
+	rec = ts.do(httptest.NewRequest("GET", "/api/webhooks?account=acct-1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), created.Secret)
+	var subscriptions []WebhookSubscription
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &subscriptions))
+	require.Len(t, subscriptions, 1)
+	assert.Equal(t, created.ID, subscriptions[0].ID)
+
+	require.NoError(t, ts.webhooks.RecordDeadLetter(WebhookDeadLetter{DeliveryID: "delivery-1", SubscriptionID: created.ID, Payload: json.RawMessage(`{}`)}))
+	rec = ts.do(httptest.NewRequest("GET", "/api/webhooks/dead-letters", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"deliveryID":"delivery-1"`)
+
+	assert.Equal(t, http.StatusForbidden, ts.doAs("alice", httptest.NewRequest("GET", "/api/webhooks", nil)).Code)
+	assert.Equal(t, http.StatusNoContent, ts.do(httptest.NewRequest("DELETE", "/api/webhooks/"+created.ID, nil)).Code)
+	assert.Equal(t, http.StatusNotFound, ts.do(httptest.NewRequest("DELETE", "/api/webhooks/"+created.ID, nil)).Code)
+}
+
+func TestAPIManageNotifications(t *testing.T) {
+	ts := newTestWebServer(t)
+	ts.addUser(t, "alice", "acct-1")
+	ts.addUser(t, "bob", "acct-2")
+	ts.addUserWithRole(t, "vera", "acct-1", RoleViewer)
+
+	// a trader's go to their own account
+	rec := ts.doAs("alice", httptest.NewRequest("POST", "/api/notifications", strings.NewReader(`{"channel": "email", "target": "alice@books.example"}`)))
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var created NotificationPreference
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.Regexp(t, `^ntf-[0-9a-f]{12}$`, created.ID)
+	assert.Equal(t, "acct-1", created.AccountID)
+	assert.Equal(t, []string{OrderEventExecuted, OrderEventFailed}, created.Events)
+
+	rec = ts.doAs("bob", httptest.NewRequest("POST", "/api/notifications", strings.NewReader(`{"channel": "chat", "target": "https://chat.example/hook", "events": ["failed"]}`)))
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	for body, status := range map[string]int{
+		`{"channel": "email", "target": "not an address"}`:                               http.StatusBadRequest,
+		`{"channel": "chat", "target": "chat.example/hook"}`:                             http.StatusBadRequest,
+		`{"channel": "sms", "target": "+15550100"}`:                                      http.StatusBadRequest,
+		`{"channel": "email", "target": "alice@books.example", "events": ["cancelled"]}`: http.StatusBadRequest,
+		`{"accountID": "acct-2", "channel": "email", "target": "alice@books.example"}`:   http.StatusForbidden,
+	} {
+		rec = ts.doAs("alice", httptest.NewRequest("POST", "/api/notifications", strings.NewReader(body)))
+		assert.Equal(t, status, rec.Code, body)
+	}
+	assert.Equal(t, http.StatusForbidden, ts.doAs("vera", httptest.NewRequest("GET", "/api/notifications", nil)).Code)
+
+	// each trader sees their own account's
+	rec = ts.doAs("bob", httptest.NewRequest("GET", "/api/notifications", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var preferences []NotificationPreference
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &preferences))
+	require.Len(t, preferences, 1)
+	assert.Equal(t, "acct-2", preferences[0].AccountID)
+
+	// and admins see everyone's
+	rec = ts.do(httptest.NewRequest("GET", "/api/notifications?account=acct-1", nil))
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &preferences))
+	require.Len(t, preferences, 1)
+	assert.Equal(t, created.ID, preferences[0].ID)
+	rec = ts.do(httptest.NewRequest("GET", "/api/notifications", nil))
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &preferences))
+	assert.Len(t, preferences, 2)
+
+	assert.Equal(t, http.StatusNotFound, ts.doAs("bob", httptest.NewRequest("DELETE", "/api/notifications/"+created.ID, nil)).Code)
+	assert.Equal(t, http.StatusNoContent, ts.doAs("alice", httptest.NewRequest("DELETE", "/api/notifications/"+created.ID, nil)).Code)
+	assert.Equal(t, http.StatusNotFound, ts.doAs("alice", httptest.NewRequest("DELETE", "/api/notifications/"+created.ID, nil)).Code)
+}
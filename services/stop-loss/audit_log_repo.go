@@ -0,0 +1,94 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// AuditLogRepoSQL keeps the audit log in the audit_log table of either SQL
+// backend.
+type AuditLogRepoSQL struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func NewAuditLogRepoSQL(db *sql.DB, d dialect) *AuditLogRepoSQL {
+	return &AuditLogRepoSQL{
+		db:      db,
+		dialect: d,
+	}
+}
+
+func (r *AuditLogRepoSQL) AppendEntry(entry AuditEntry) error {
+	_, err := r.db.Exec(r.dialect.rebind(`
+		INSERT INTO audit_log (username, role, action, target, outcome, occurred_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`), entry.Username, entry.Role, entry.Action, entry.Target, entry.Outcome, entry.OccurredAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to append audit entry for %s: %w", entry.Username, err)
+	}
+	return nil
+}
+
+func (r *AuditLogRepoSQL) ListEntries(limit int) ([]AuditEntry, error) {
+	rows, err := r.db.Query(r.dialect.rebind(`
+		SELECT id, username, role, action, target, outcome, occurred_at
+		FROM audit_log ORDER BY id DESC LIMIT ?
+	`), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.Username, &entry.Role, &entry.Action, &entry.Target, &entry.Outcome, &entry.OccurredAt); err != nil {
+			slog.Error("Failed to scan audit entry row", "error", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating audit entry rows: %w", err)
+	}
+	return entries, nil
+}
+
+// Ensure AuditLogRepoSQL implements AuditLogRepo
+var _ AuditLogRepo = (*AuditLogRepoSQL)(nil)
+
+// AuditLogRepoMemory is the audit log for the in-memory store.
+type AuditLogRepoMemory struct {
+	mu      sync.RWMutex
+	entries []AuditEntry
+}
+
+func NewAuditLogRepoMemory() *AuditLogRepoMemory {
+	return &AuditLogRepoMemory{}
+}
+
+func (m *AuditLogRepoMemory) AppendEntry(entry AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry.ID = int64(len(m.entries) + 1)
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+func (m *AuditLogRepoMemory) ListEntries(limit int) ([]AuditEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var entries []AuditEntry
+	for i := len(m.entries) - 1; i >= 0 && len(entries) < limit; i-- {
+		entries = append(entries, m.entries[i])
+	}
+	return entries, nil
+}
+
+// Ensure AuditLogRepoMemory implements AuditLogRepo
+var _ AuditLogRepo = (*AuditLogRepoMemory)(nil)
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testAuditLogRepoContract(t *testing.T, repo AuditLogRepo) {
+	at := time.Date(2025, 2, 1, 14, 30, 0, 0, time.UTC)
+
+	entries, err := repo.ListEntries(10)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	for i, action := range []string{ActionPlaceOrder, ActionHaltExecutions, ActionReconcile} {
+		require.NoError(t, repo.AppendEntry(AuditEntry{
+			Username:   "alice",
+			Role:       RoleViewer,
+			Action:     action,
+			Target:     "POST /api/orders",
+			Outcome:    AuditOutcomeDenied,
+			OccurredAt: at.Add(time.Duration(i) * time.Minute),
+		}))
+	}
+
+	// newest first, up to the limit
+	entries, err = repo.ListEntries(2)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, ActionReconcile, entries[0].Action)
+	assert.Equal(t, ActionHaltExecutions, entries[1].Action)
+	assert.Greater(t, entries[0].ID, entries[1].ID)
+	assert.Equal(t, "alice", entries[0].Username)
+	assert.Equal(t, RoleViewer, entries[0].Role)
+	assert.Equal(t, "POST /api/orders", entries[0].Target)
+	assert.Equal(t, AuditOutcomeDenied, entries[0].Outcome)
+	assert.True(t, at.Add(2*time.Minute).Equal(entries[0].OccurredAt))
+}
+
+func TestAuditLogRepoMemory(t *testing.T) {
+	testAuditLogRepoContract(t, NewAuditLogRepoMemory())
+}
+
+func TestAuditLogRepoSQLite(t *testing.T) {
+	db := newTestSQLiteDB(t)
+	require.NoError(t, migrateDB(db, dialectSQLite))
+
+	testAuditLogRepoContract(t, NewAuditLogRepoSQL(db, dialectSQLite))
+
+	// the table is append-only
+	_, err := db.Exec(`DELETE FROM audit_log`)
+	assert.Error(t, err)
+}
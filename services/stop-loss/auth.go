@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Browsers log in for a session cookie and send its CSRF token back on every
+// unsafe request; API clients send an API key as a bearer token instead.
+const (
+	sessionCookieName = "stop_loss_session"
+	CSRFHeader        = "X-CSRF-Token"
+	csrfFormField     = "csrf_token"
+	apiKeyPrefix      = "slk_"
+)
+
+// sessionTTL is how long a login lasts, set from SESSION_TTL at startup.
+var sessionTTL = 12 * time.Hour
+
+// secureCookies marks the session cookie Secure, for deployments served over
+// HTTPS. Set from SESSION_COOKIE_SECURE at startup.
+var secureCookies = false
+
+// errUnauthenticated means the request carried no credentials we accept.
+var errUnauthenticated = errors.New("authentication required")
+
+type sessionContextKey struct{}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// dummyPasswordHash is compared against when there's no user or password,
+// so a login takes as long whether or not the username exists.
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// checkPassword says whether password matches hash. An empty hash matches
+// nothing.
+func checkPassword(hash string, password string) bool {
+	if hash == "" {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// hashToken is how session tokens and API keys are stored. They're random
+// and long, so a fast hash is enough.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAPIKey makes a key for username, returning it along with the secret to
+// hand over, which isn't kept.
+func newAPIKey(username string, name string, now time.Time) (APIKey, string, error) {
+	id, err := randomHex(6)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	secret = apiKeyPrefix + secret
+	return APIKey{ID: id, Username: username, Name: name, KeyHash: hashToken(secret), CreatedAt: now}, secret, nil
+}
+
+// setInitialPassword gives username a password, unless it already has one,
+// so a fresh install's admin can log in.
+func setInitialPassword(accounts AccountsRepo, username string, password string) error {
+	user, err := accounts.GetUser(username)
+	if err != nil {
+		return err
+	}
+	if user.PasswordHash != "" {
+		return nil
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := accounts.SetPassword(username, hash); err != nil {
+		return err
+	}
+	slog.Info("Set the initial password", "username", username)
+	return nil
+}
+
+// publicPaths are served to anyone: the login page, the metrics Prometheus
+// scrapes and the health checks probes make.
+var publicPaths = map[string]bool{"/login": true, "/metrics": true, "/healthz": true, "/readyz": true}
+
+// authenticate finds the user behind a request's session cookie or API key
+// and puts it in the request's context for the handlers. Everything but the
+// public paths needs one.
+func (s *WebServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, session, err := s.credentialsFor(r)
+		if errors.Is(err, errUnauthenticated) {
+			challenge(w, r)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to authenticate request", "error", err)
+			writeAuthError(w, r, http.StatusInternalServerError, "failed to authenticate")
+			return
+		}
+		// a cookie rides along on cross-site requests too; a bearer key doesn't
+		if session != nil && !safeMethod(r.Method) && !validCSRFToken(r, *session) {
+			writeAuthError(w, r, http.StatusForbidden, "missing or invalid CSRF token")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userContextKey{}, user)
+		if session != nil {
+			ctx = context.WithValue(ctx, sessionContextKey{}, *session)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// credentialsFor returns the user behind the request's API key or, without
+// an Authorization header, its session, along with the session.
+func (s *WebServer) credentialsFor(r *http.Request) (User, *Session, error) {
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		token, ok := strings.CutPrefix(authorization, "Bearer ")
+		if !ok {
+			return User{}, nil, errUnauthenticated
+		}
+		key, err := s.credentialsRepo.GetAPIKey(hashToken(token))
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			return User{}, nil, errUnauthenticated
+		}
+		if err != nil {
+			return User{}, nil, err
+		}
+		user, err := s.userNamed(key.Username)
+		return user, nil, err
+	}
+
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return User{}, nil, errUnauthenticated
+	}
+	session, err := s.credentialsRepo.GetSession(hashToken(cookie.Value))
+	if errors.Is(err, ErrSessionNotFound) {
+		return User{}, nil, errUnauthenticated
+	}
+	if err != nil {
+		return User{}, nil, err
+	}
+	if time.Now().After(session.ExpiresAt) {
+		if err := s.credentialsRepo.DeleteSession(session.TokenHash); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to delete expired session", "username", session.Username, "error", err)
+		}
+		return User{}, nil, errUnauthenticated
+	}
+	user, err := s.userNamed(session.Username)
+	return user, &session, err
+}
+
+// userNamed looks up the user credentials belong to.
+func (s *WebServer) userNamed(username string) (User, error) {
+	user, err := s.accountsRepo.GetUser(username)
+	if errors.Is(err, ErrUserNotFound) {
+		return User{}, errUnauthenticated
+	}
+	return user, err
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// sameOriginRequest says whether a browser sent r from one of our own pages,
+// going by Sec-Fetch-Site or, from older browsers, Origin. Requests with
+// neither don't come from a browser page, so they can't be forged by one.
+func sameOriginRequest(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin" || site == "none"
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// validCSRFToken checks the token htmx sends as a header, or a plain form
+// sends as a field, against the session's.
+func validCSRFToken(r *http.Request, session Session) bool {
+	token := r.Header.Get(CSRFHeader)
+	if token == "" {
+		token = r.PostFormValue(csrfFormField)
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) == 1
+}
+
+// challenge turns away a request without credentials: the API with a 401,
+// and browsers to the login page.
+func challenge(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/"):
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSONError(w, http.StatusUnauthorized, errUnauthenticated.Error())
+	case r.Header.Get("HX-Request") != "":
+		// htmx would swap a redirect's page into the fragment it asked for
+		w.Header().Set("HX-Redirect", "/login")
+		w.WriteHeader(http.StatusUnauthorized)
+	case r.Method == http.MethodGet:
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	default:
+		http.Error(w, errUnauthenticated.Error(), http.StatusUnauthorized)
+	}
+}
+
+// writeAuthError answers in JSON on the API and in plain text elsewhere.
+func writeAuthError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeJSONError(w, status, message)
+		return
+	}
+	http.Error(w, message, status)
+}
+
+// sessionFrom returns the session authenticate found for the request, if it
+// came with one.
+func sessionFrom(r *http.Request) (Session, bool) {
+	session, ok := r.Context().Value(sessionContextKey{}).(Session)
+	return session, ok
+}
+
+func (s *WebServer) handleLoginPage(w http.ResponseWriter, r *http.Request) {
+	s.renderLogin(w, http.StatusOK, "")
+}
+
+// handleLogin starts a session for a user whose password checks out. There's
+// no session for a CSRF token yet, so a login posted from another site is
+// turned away by its origin instead; otherwise that site could sign the
+// browser into an account of its choosing.
+func (s *WebServer) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if !sameOriginRequest(r) {
+		slog.WarnContext(r.Context(), "Cross-site login refused", "origin", r.Header.Get("Origin"), "fetchSite", r.Header.Get("Sec-Fetch-Site"))
+		http.Error(w, "cross-site login refused", http.StatusForbidden)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+	username := r.PostFormValue("username")
+
+	user, err := s.accountsRepo.GetUser(username)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		slog.ErrorContext(r.Context(), "Failed to look up user", "username", username, "error", err)
+		http.Error(w, "Failed to log in.", http.StatusInternalServerError)
+		return
+	}
+	if !checkPassword(user.PasswordHash, r.PostFormValue("password")) {
+		slog.WarnContext(r.Context(), "Failed login", "username", username)
+		s.renderLogin(w, http.StatusUnauthorized, "Invalid username or password.")
+		return
+	}
+
+	token, err := randomHex(32)
+	if err != nil {
+		http.Error(w, "Failed to log in.", http.StatusInternalServerError)
+		return
+	}
+	csrfToken, err := randomHex(32)
+	if err != nil {
+		http.Error(w, "Failed to log in.", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now().UTC()
+	session := Session{TokenHash: hashToken(token), Username: user.Username, CSRFToken: csrfToken, CreatedAt: now, ExpiresAt: now.Add(sessionTTL)}
+	if err := s.credentialsRepo.CreateSession(session); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to create session", "username", user.Username, "error", err)
+		http.Error(w, "Failed to log in.", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Logged in", "username", user.Username)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handleLogout ends the request's session, if it has one.
+func (s *WebServer) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if session, ok := sessionFrom(r); ok {
+		if err := s.credentialsRepo.DeleteSession(session.TokenHash); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to delete session", "username", session.Username, "error", err)
+			http.Error(w, "Failed to log out.", http.StatusInternalServerError)
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Value: "", Path: "/", MaxAge: -1, HttpOnly: true, Secure: secureCookies, SameSite: http.SameSiteLaxMode})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func (s *WebServer) renderLogin(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := s.loginTemplate.ExecuteTemplate(w, "layout.html", LoginPageData{Error: message}); err != nil {
+		slog.Error("Failed to render login page", "error", err)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var csrfTokenPattern = regexp.MustCompile(`"X-CSRF-Token": "([0-9a-f]+)"`)
+
+// login posts the login form and returns the response.
+func login(ts *testWebServer, username, password string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(url.Values{"username": {username}, "password": {password}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return ts.send(req)
+}
+
+func TestWebLoginSession(t *testing.T) {
+	ts := newTestWebServer(t)
+	require.NoError(t, setInitialPassword(ts.accounts, "admin", "s3cret"))
+
+	rec := ts.send(httptest.NewRequest("GET", "/login", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `action="/login"`)
+
+	for _, bad := range [][2]string{{"admin", "wrong"}, {"nobody", "s3cret"}, {"admin", ""}} {
+		rec := login(ts, bad[0], bad[1])
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, bad[0])
+		assert.Contains(t, rec.Body.String(), "Invalid username or password.")
+		assert.Empty(t, rec.Result().Cookies())
+	}
+
+	rec = login(ts, "admin", "s3cret")
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/", rec.Header().Get("Location"))
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	cookie := cookies[0]
+	assert.Equal(t, sessionCookieName, cookie.Name)
+	assert.True(t, cookie.HttpOnly)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+	rec = ts.send(req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Signed in as admin")
+	match := csrfTokenPattern.FindStringSubmatch(rec.Body.String())
+	require.NotNil(t, match)
+	csrfToken := match[1]
+
+	// an unsafe request needs the CSRF token along with the cookie
+	halt := func(token string) int {
+		req := httptest.NewRequest("POST", "/halts", strings.NewReader("security=AAPL"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(cookie)
+		if token != "" {
+			req.Header.Set(CSRFHeader, token)
+		}
+		return ts.send(req).Code
+	}
+	assert.Equal(t, http.StatusForbidden, halt(""))
+	assert.Equal(t, http.StatusForbidden, halt("0123"))
+	assert.Empty(t, ts.service.halts)
+	assert.Equal(t, http.StatusOK, halt(csrfToken))
+	assert.Len(t, ts.service.halts, 1)
+
+	req = httptest.NewRequest("POST", "/logout", strings.NewReader(url.Values{csrfFormField: {csrfToken}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+	rec = ts.send(req)
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/login", rec.Header().Get("Location"))
+
+	// the session is gone, whatever the browser still holds
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+	rec = ts.send(req)
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+}
+
+func TestWebLoginRefusesOtherSites(t *testing.T) {
+	ts := newTestWebServer(t)
+	require.NoError(t, setInitialPassword(ts.accounts, "admin", "s3cret"))
+	loginWith := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(url.Values{"username": {"admin"}, "password": {"s3cret"}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set(header, value)
+		return ts.send(req)
+	}
+
+	for _, forged := range [][2]string{{"Sec-Fetch-Site", "cross-site"}, {"Sec-Fetch-Site", "same-site"}, {"Origin", "https://evil.example"}, {"Origin", "null"}} {
+		rec := loginWith(forged[0], forged[1])
+		assert.Equal(t, http.StatusForbidden, rec.Code, forged)
+		assert.Empty(t, rec.Result().Cookies(), forged)
+	}
+
+	// httptest requests are for example.com
+	for _, own := range [][2]string{{"Sec-Fetch-Site", "same-origin"}, {"Origin", "http://example.com"}} {
+		rec := loginWith(own[0], own[1])
+		assert.Equal(t, http.StatusSeeOther, rec.Code, own)
+	}
+}
+
+func TestWebExpiredSession(t *testing.T) {
+	ts := newTestWebServer(t)
+	now := time.Now()
+	require.NoError(t, ts.credentials.CreateSession(Session{TokenHash: hashToken("token"), Username: "admin", CSRFToken: "csrf", CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)}))
+
+	req := httptest.NewRequest("GET", "/api/orders", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "token"})
+	assert.Equal(t, http.StatusUnauthorized, ts.send(req).Code)
+
+	_, err := ts.credentials.GetSession(hashToken("token"))
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+}
+
+func TestWebRequiresCredentials(t *testing.T) {
+	ts := newTestWebServer(t)
+
+	rec := ts.send(httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/login", rec.Header().Get("Location"))
+
+	req := httptest.NewRequest("GET", "/orders", nil)
+	req.Header.Set("HX-Request", "true")
+	rec = ts.send(req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "/login", rec.Header().Get("HX-Redirect"))
+
+	rec = ts.send(httptest.NewRequest("POST", "/orders", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Empty(t, ts.service.created)
+
+	for _, authorization := range []string{"", "Bearer slk_nope", "Basic YWRtaW46YWRtaW4="} {
+		req := httptest.NewRequest("GET", "/api/orders", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := ts.send(req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, authorization)
+		assert.Equal(t, "Bearer", rec.Header().Get("WWW-Authenticate"))
+		assert.JSONEq(t, `{"error": "authentication required"}`, rec.Body.String())
+	}
+}
+
+func TestSetInitialPassword(t *testing.T) {
+	accounts := NewAccountsRepoMemory()
+
+	require.NoError(t, setInitialPassword(accounts, "admin", "first"))
+	// a password already set isn't replaced
+	require.NoError(t, setInitialPassword(accounts, "admin", "second"))
+	admin, err := accounts.GetUser("admin")
+	require.NoError(t, err)
+	assert.True(t, checkPassword(admin.PasswordHash, "first"))
+	assert.False(t, checkPassword(admin.PasswordHash, "second"))
+	assert.False(t, checkPassword("", ""))
+
+	assert.ErrorIs(t, setInitialPassword(accounts, "nobody", "first"), ErrUserNotFound)
+}
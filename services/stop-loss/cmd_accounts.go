@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+const accountsUsage = "usage: stop-loss accounts [list | add ID NAME | users | add-user [-role ROLE] USERNAME ACCOUNT_ID | set-role USERNAME ROLE | set-password USERNAME | keys | add-key USERNAME NAME | revoke-key ID]"
+
+// runAccountsCommand implements `stop-loss accounts`, managing the accounts
+// orders belong to, the users acting for them and their credentials.
+// set-password reads the password from the first line of stdin.
+func runAccountsCommand(args []string) error {
+	action := "list"
+	if len(args) > 0 {
+		action = args[0]
+		args = args[1:]
+	}
+
+	repos, err := openStores(loadStoreConfig())
+	if err != nil {
+		return err
+	}
+	defer repos.Close()
+
+	switch action {
+	case "list":
+		accounts, err := repos.accounts.ListAccounts()
+		if err != nil {
+			return err
+		}
+		return printJSON(accounts)
+	case "add":
+		if len(args) != 2 {
+			return fmt.Errorf("%s", accountsUsage)
+		}
+		if err := repos.accounts.CreateAccount(Account{ID: args[0], Name: args[1], CreatedAt: time.Now().UTC()}); err != nil {
+			return err
+		}
+		slog.Info("Added account", "accountID", args[0])
+		return nil
+	case "users":
+		users, err := repos.accounts.ListUsers()
+		if err != nil {
+			return err
+		}
+		return printJSON(users)
+	case "add-user":
+		flags := flag.NewFlagSet("add-user", flag.ContinueOnError)
+		role := flags.String("role", RoleTrader, "viewer, trader, risk-manager or admin")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		if flags.NArg() != 2 {
+			return fmt.Errorf("%s", accountsUsage)
+		}
+		if !validRole(*role) {
+			return fmt.Errorf("unknown role %q", *role)
+		}
+		user := User{Username: flags.Arg(0), AccountID: flags.Arg(1), Role: *role, CreatedAt: time.Now().UTC()}
+		if err := repos.accounts.CreateUser(user); err != nil {
+			return err
+		}
+		slog.Info("Added user", "username", user.Username, "role", user.Role, "accountID", user.AccountID)
+		return nil
+	case "set-role":
+		if len(args) != 2 {
+			return fmt.Errorf("%s", accountsUsage)
+		}
+		if !validRole(args[1]) {
+			return fmt.Errorf("unknown role %q", args[1])
+		}
+		if err := repos.accounts.SetRole(args[0], args[1]); err != nil {
+			return err
+		}
+		slog.Info("Changed role", "username", args[0], "role", args[1])
+		return nil
+	case "set-password":
+		if len(args) != 1 {
+			return fmt.Errorf("%s", accountsUsage)
+		}
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read password: %w", err)
+		}
+		password = strings.TrimRight(password, "\r\n")
+		if password == "" {
+			return errors.New("password can't be empty")
+		}
+		hash, err := hashPassword(password)
+		if err != nil {
+			return err
+		}
+		if err := repos.accounts.SetPassword(args[0], hash); err != nil {
+			return err
+		}
+		slog.Info("Set password", "username", args[0])
+		return nil
+	case "keys":
+		keys, err := repos.credentials.ListAPIKeys()
+		if err != nil {
+			return err
+		}
+		return printJSON(keys)
+	case "add-key":
+		if len(args) != 2 {
+			return fmt.Errorf("%s", accountsUsage)
+		}
+		if _, err := repos.accounts.GetUser(args[0]); err != nil {
+			return err
+		}
+		key, secret, err := newAPIKey(args[0], args[1], time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		if err := repos.credentials.CreateAPIKey(key); err != nil {
+			return err
+		}
+		slog.Info("Added API key, it won't be shown again", "keyID", key.ID, "username", key.Username)
+		fmt.Println(secret)
+		return nil
+	case "revoke-key":
+		if len(args) != 1 {
+			return fmt.Errorf("%s", accountsUsage)
+		}
+		if err := repos.credentials.DeleteAPIKey(args[0]); err != nil {
+			return err
+		}
+		slog.Info("Revoked API key", "keyID", args[0])
+		return nil
+	default:
+		return fmt.Errorf("unknown action %q: %s", action, accountsUsage)
+	}
+}
+
+func printJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+)
+
+const migrateUsage = "usage: stop-loss migrate [up | down [steps] | version]"
+
+// runMigrateCommand implements `stop-loss migrate`, working on whichever
+// store the usual environment variables point at.
+func runMigrateCommand(args []string) error {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	cfg := loadStoreConfig()
+	db, d, err := openStoreDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := NewMigrator(db, d)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "up":
+		applied, err := migrator.Up()
+		if err != nil {
+			return err
+		}
+		slog.Info("Applied migrations", "applied", applied, "driver", d, "version", migrator.Latest())
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q: %s", args[1], migrateUsage)
+			}
+		}
+		reverted, err := migrator.Down(steps)
+		if err != nil {
+			return err
+		}
+		version, err := migrator.Version()
+		if err != nil {
+			return err
+		}
+		slog.Info("Reverted migrations", "reverted", reverted, "driver", d, "version", version)
+	case "version":
+		version, err := migrator.Version()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s schema version %d (latest %d)\n", d, version, migrator.Latest())
+	default:
+		return fmt.Errorf("unknown migrate action %q: %s", action, migrateUsage)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const positionsUsage = "usage: stop-loss positions [list | import FILE]"
+
+// runPositionsCommand implements `stop-loss positions`, seeding the held
+// positions the risk limits check against. FILE is a CSV of account,
+// security and quantity, or - for stdin.
+func runPositionsCommand(args []string) error {
+	action := "list"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	repos, err := openStores(loadStoreConfig())
+	if err != nil {
+		return err
+	}
+	defer repos.Close()
+
+	switch action {
+	case "list":
+		positions, err := repos.positions.ListPositions()
+		if err != nil {
+			return err
+		}
+		return printJSON(positions)
+	case "import":
+		if len(args) != 2 {
+			return fmt.Errorf("%s", positionsUsage)
+		}
+		in := os.Stdin
+		if args[1] != "-" {
+			in, err = os.Open(args[1])
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+		}
+		positions, err := readPositionsCSV(in, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		for _, position := range positions {
+			if err := repos.positions.SetPosition(position); err != nil {
+				return err
+			}
+		}
+		slog.Info("Imported positions", "count", len(positions))
+		return nil
+	default:
+		return fmt.Errorf("unknown action %q: %s", action, positionsUsage)
+	}
+}
+
+// readPositionsCSV reads account,security,quantity rows, with or without a
+// header. Rows of just security,quantity belong to the default account.
+func readPositionsCSV(r io.Reader, at time.Time) ([]Position, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read positions: %w", err)
+	}
+	if len(records) > 0 && (strings.EqualFold(records[0][0], "account") || strings.EqualFold(records[0][0], "security")) {
+		records = records[1:]
+	}
+
+	var positions []Position
+	for i, record := range records {
+		fields := record
+		switch len(fields) {
+		case 2:
+			fields = append([]string{DefaultAccountID}, fields...)
+		case 3:
+		default:
+			return nil, fmt.Errorf("invalid position %q on row %d", strings.Join(record, ","), i+1)
+		}
+		quantity, err := strconv.Atoi(fields[2])
+		if err != nil || quantity < 0 || fields[0] == "" || fields[1] == "" {
+			return nil, fmt.Errorf("invalid position %q on row %d", strings.Join(record, ","), i+1)
+		}
+		positions = append(positions, Position{AccountID: fields[0], Security: fields[1], Quantity: quantity, UpdatedAt: at})
+	}
+	return positions, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// runReconcileCommand implements `stop-loss reconcile`, a one-off comparison
+// of the orders table with Temporal.
+func runReconcileCommand(args []string) error {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "fix mismatches instead of only reporting them")
+	timeout := flags.Duration("timeout", 5*time.Minute, "give up after this long")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	temporalAddress := os.Getenv("TEMPORAL_ADDRESS")
+	if temporalAddress == "" {
+		return fmt.Errorf("TEMPORAL_ADDRESS environment variable is not set")
+	}
+	temporalClient, err := WaitDialTemporal(temporalAddress, 3)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Temporal server at %s: %w", temporalAddress, err)
+	}
+	defer temporalClient.Close()
+
+	repos, err := openStores(loadStoreConfig())
+	if err != nil {
+		return err
+	}
+	defer repos.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	report, err := NewReconciler(temporalClient, repos.orders, repos.events, NewWebhookPublisher(temporalClient, repos.orders, repos.webhooks)).Reconcile(ctx, *repair)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// CredentialsRepoSQL keeps web sessions and API keys in the sessions and
+// api_keys tables of either SQL backend.
+type CredentialsRepoSQL struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func NewCredentialsRepoSQL(db *sql.DB, d dialect) *CredentialsRepoSQL {
+	return &CredentialsRepoSQL{
+		db:      db,
+		dialect: d,
+	}
+}
+
+func (r *CredentialsRepoSQL) CreateSession(session Session) error {
+	_, err := r.db.Exec(r.dialect.rebind(`
+		INSERT INTO sessions (token_hash, username, csrf_token, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+	`), session.TokenHash, session.Username, session.CSRFToken, session.CreatedAt.UTC(), session.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to create session for user %s: %w", session.Username, err)
+	}
+	return nil
+}
+
+func (r *CredentialsRepoSQL) GetSession(tokenHash string) (Session, error) {
+	var session Session
+	err := r.db.QueryRow(r.dialect.rebind(`SELECT token_hash, username, csrf_token, created_at, expires_at FROM sessions WHERE token_hash = ?`), tokenHash).
+		Scan(&session.TokenHash, &session.Username, &session.CSRFToken, &session.CreatedAt, &session.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, ErrSessionNotFound
+	}
+	if err != nil {
+		return Session{}, fmt.Errorf("failed to get session: %w", err)
+	}
+	return session, nil
+}
+
+func (r *CredentialsRepoSQL) DeleteSession(tokenHash string) error {
+	if _, err := r.db.Exec(r.dialect.rebind(`DELETE FROM sessions WHERE token_hash = ?`), tokenHash); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+func (r *CredentialsRepoSQL) CreateAPIKey(key APIKey) error {
+	_, err := r.db.Exec(r.dialect.rebind(`
+		INSERT INTO api_keys (id, username, name, key_hash, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`), key.ID, key.Username, key.Name, key.KeyHash, key.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to create API key for user %s: %w", key.Username, err)
+	}
+	return nil
+}
+
+func (r *CredentialsRepoSQL) GetAPIKey(keyHash string) (APIKey, error) {
+	var key APIKey
+	err := r.db.QueryRow(r.dialect.rebind(`SELECT id, username, name, key_hash, created_at FROM api_keys WHERE key_hash = ?`), keyHash).
+		Scan(&key.ID, &key.Username, &key.Name, &key.KeyHash, &key.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return APIKey{}, fmt.Errorf("failed to get API key: %w", err)
+	}
+	return key, nil
+}
+
+func (r *CredentialsRepoSQL) ListAPIKeys() ([]APIKey, error) {
+	rows, err := r.db.Query(`SELECT id, username, name, key_hash, created_at FROM api_keys ORDER BY username, created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		var key APIKey
+		if err := rows.Scan(&key.ID, &key.Username, &key.Name, &key.KeyHash, &key.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating API key rows: %w", err)
+	}
+	return keys, nil
+}
+
+func (r *CredentialsRepoSQL) DeleteAPIKey(id string) error {
+	result, err := r.db.Exec(r.dialect.rebind(`DELETE FROM api_keys WHERE id = ?`), id)
+	if err != nil {
+		return fmt.Errorf("failed to delete API key %s: %w", id, err)
+	}
+	return affectedOr(result, ErrAPIKeyNotFound)
+}
+
+// Ensure CredentialsRepoSQL implements CredentialsRepo
+var _ CredentialsRepo = (*CredentialsRepoSQL)(nil)
+
+// CredentialsRepoMemory keeps sessions and API keys for the in-memory store.
+type CredentialsRepoMemory struct {
+	mu       sync.RWMutex
+	sessions map[string]Session // by token hash
+	apiKeys  map[string]APIKey  // by key hash
+}
+
+func NewCredentialsRepoMemory() *CredentialsRepoMemory {
+	return &CredentialsRepoMemory{
+		sessions: make(map[string]Session),
+		apiKeys:  make(map[string]APIKey),
+	}
+}
+
+func (m *CredentialsRepoMemory) CreateSession(session Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[session.TokenHash] = session
+	return nil
+}
+
+func (m *CredentialsRepoMemory) GetSession(tokenHash string) (Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	session, ok := m.sessions[tokenHash]
+	if !ok {
+		return Session{}, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+func (m *CredentialsRepoMemory) DeleteSession(tokenHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, tokenHash)
+	return nil
+}
+
+func (m *CredentialsRepoMemory) CreateAPIKey(key APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.apiKeys[key.KeyHash] = key
+	return nil
+}
+
+func (m *CredentialsRepoMemory) GetAPIKey(keyHash string) (APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key, ok := m.apiKeys[keyHash]
+	if !ok {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+	return key, nil
+}
+
+func (m *CredentialsRepoMemory) ListAPIKeys() ([]APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var keys []APIKey
+	for _, key := range m.apiKeys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Username != keys[j].Username {
+			return keys[i].Username < keys[j].Username
+		}
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+func (m *CredentialsRepoMemory) DeleteAPIKey(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for hash, key := range m.apiKeys {
+		if key.ID == id {
+			delete(m.apiKeys, hash)
+			return nil
+		}
+	}
+	return ErrAPIKeyNotFound
+}
+
+// Ensure CredentialsRepoMemory implements CredentialsRepo
+var _ CredentialsRepo = (*CredentialsRepoMemory)(nil)
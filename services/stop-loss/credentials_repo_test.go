@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testCredentialsRepoContract(t *testing.T, repo CredentialsRepo) {
+	at := time.Date(2025, 2, 1, 14, 30, 0, 0, time.UTC)
+
+	_, err := repo.GetSession("hash-1")
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+	require.NoError(t, repo.CreateSession(Session{TokenHash: "hash-1", Username: "admin", CSRFToken: "csrf-1", CreatedAt: at, ExpiresAt: at.Add(time.Hour)}))
+	session, err := repo.GetSession("hash-1")
+	require.NoError(t, err)
+	assert.Equal(t, "admin", session.Username)
+	assert.Equal(t, "csrf-1", session.CSRFToken)
+	assert.True(t, at.Add(time.Hour).Equal(session.ExpiresAt))
+	require.NoError(t, repo.DeleteSession("hash-1"))
+	_, err = repo.GetSession("hash-1")
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	_, err = repo.GetAPIKey("key-hash-1")
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+	require.NoError(t, repo.CreateAPIKey(APIKey{ID: "key-1", Username: "admin", Name: "ci", KeyHash: "key-hash-1", CreatedAt: at}))
+	require.NoError(t, repo.CreateAPIKey(APIKey{ID: "key-2", Username: "admin", Name: "scripts", KeyHash: "key-hash-2", CreatedAt: at.Add(time.Minute)}))
+	key, err := repo.GetAPIKey("key-hash-1")
+	require.NoError(t, err)
+	assert.Equal(t, "key-1", key.ID)
+	assert.Equal(t, "ci", key.Name)
+
+	keys, err := repo.ListAPIKeys()
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, "key-1", keys[0].ID)
+	assert.Equal(t, "key-2", keys[1].ID)
+
+	require.NoError(t, repo.DeleteAPIKey("key-1"))
+	assert.ErrorIs(t, repo.DeleteAPIKey("key-1"), ErrAPIKeyNotFound)
+	_, err = repo.GetAPIKey("key-hash-1")
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+}
+
+func TestCredentialsRepoMemory(t *testing.T) {
+	testCredentialsRepoContract(t, NewCredentialsRepoMemory())
+}
+
+func TestCredentialsRepoSQLite(t *testing.T) {
+	db := newTestSQLiteDB(t)
+	require.NoError(t, migrateDB(db, dialectSQLite))
+
+	testCredentialsRepoContract(t, NewCredentialsRepoSQL(db, dialectSQLite))
+}
+
+func TestNewAPIKey(t *testing.T) {
+	key, secret, err := newAPIKey("admin", "ci", time.Now())
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(secret, apiKeyPrefix))
+	// only the hash is kept
+	assert.Equal(t, hashToken(secret), key.KeyHash)
+	assert.NotEmpty(t, key.ID)
+}
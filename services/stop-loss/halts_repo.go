@@ -0,0 +1,158 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// HaltsRepoSQL keeps execution halts in the execution_halts table of either
+// SQL backend.
+type HaltsRepoSQL struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func NewHaltsRepoSQL(db *sql.DB, d dialect) *HaltsRepoSQL {
+	return &HaltsRepoSQL{
+		db:      db,
+		dialect: d,
+	}
+}
+
+func (r *HaltsRepoSQL) SetHalt(halt Halt) error {
+	_, err := r.db.Exec(r.dialect.rebind(`
+		INSERT INTO execution_halts (security, reason, source, halted_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (security) DO UPDATE
+		SET reason = excluded.reason, source = excluded.source, halted_at = excluded.halted_at
+	`), halt.Security, halt.Reason, halt.Source, halt.HaltedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to halt %s: %w", haltScope(halt.Security), err)
+	}
+	return nil
+}
+
+func (r *HaltsRepoSQL) ClearHalt(security string) error {
+	result, err := r.db.Exec(r.dialect.rebind(`DELETE FROM execution_halts WHERE security = ?`), security)
+	if err != nil {
+		return fmt.Errorf("failed to lift halt on %s: %w", haltScope(security), err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to lift halt on %s: %w", haltScope(security), err)
+	}
+	if rows == 0 {
+		return ErrNotHalted
+	}
+	return nil
+}
+
+func (r *HaltsRepoSQL) ListHalts() ([]Halt, error) {
+	rows, err := r.db.Query(`SELECT security, reason, source, halted_at FROM execution_halts ORDER BY security`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list halts: %w", err)
+	}
+	defer rows.Close()
+
+	var halts []Halt
+	for rows.Next() {
+		var halt Halt
+		if err := rows.Scan(&halt.Security, &halt.Reason, &halt.Source, &halt.HaltedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan halt: %w", err)
+		}
+		halts = append(halts, halt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating halt rows: %w", err)
+	}
+	return halts, nil
+}
+
+func (r *HaltsRepoSQL) HaltFor(security string) (Halt, error) {
+	// the security's own halt sorts before the empty one on every security
+	var halt Halt
+	err := r.db.QueryRow(r.dialect.rebind(`
+		SELECT security, reason, source, halted_at FROM execution_halts
+		WHERE security IN (?, '') ORDER BY security DESC LIMIT 1
+	`), security).Scan(&halt.Security, &halt.Reason, &halt.Source, &halt.HaltedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Halt{}, ErrNotHalted
+	}
+	if err != nil {
+		return Halt{}, fmt.Errorf("failed to look up halt on %s: %w", security, err)
+	}
+	return halt, nil
+}
+
+// Ensure HaltsRepoSQL implements HaltsRepo
+var _ HaltsRepo = (*HaltsRepoSQL)(nil)
+
+// HaltsRepoMemory keeps execution halts for the in-memory store.
+type HaltsRepoMemory struct {
+	mu    sync.RWMutex
+	halts map[string]Halt
+}
+
+func NewHaltsRepoMemory() *HaltsRepoMemory {
+	return &HaltsRepoMemory{
+		halts: make(map[string]Halt),
+	}
+}
+
+func (m *HaltsRepoMemory) SetHalt(halt Halt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.halts[halt.Security] = halt
+	return nil
+}
+
+func (m *HaltsRepoMemory) ClearHalt(security string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.halts[security]; !ok {
+		return ErrNotHalted
+	}
+	delete(m.halts, security)
+	return nil
+}
+
+func (m *HaltsRepoMemory) ListHalts() ([]Halt, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var halts []Halt
+	for _, halt := range m.halts {
+		halts = append(halts, halt)
+	}
+	sort.Slice(halts, func(i, j int) bool { return halts[i].Security < halts[j].Security })
+	return halts, nil
+}
+
+func (m *HaltsRepoMemory) HaltFor(security string) (Halt, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if halt, ok := m.halts[security]; ok {
+		return halt, nil
+	}
+	if halt, ok := m.halts[""]; ok {
+		return halt, nil
+	}
+	return Halt{}, ErrNotHalted
+}
+
+// Ensure HaltsRepoMemory implements HaltsRepo
+var _ HaltsRepo = (*HaltsRepoMemory)(nil)
+
+// haltScope names what a halt covers, for messages.
+func haltScope(security string) string {
+	if security == "" {
+		return "all securities"
+	}
+	return security
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testHaltsRepoContract(t *testing.T, repo HaltsRepo) {
+	at := time.Date(2025, 2, 1, 14, 30, 0, 0, time.UTC)
+
+	_, err := repo.HaltFor("AAPL")
+	assert.ErrorIs(t, err, ErrNotHalted)
+	halts, err := repo.ListHalts()
+	require.NoError(t, err)
+	assert.Empty(t, halts)
+
+	require.NoError(t, repo.SetHalt(Halt{Security: "AAPL", Reason: "bad feed", Source: EventSourceAdmin, HaltedAt: at}))
+	halt, err := repo.HaltFor("AAPL")
+	require.NoError(t, err)
+	assert.Equal(t, "AAPL", halt.Security)
+	assert.Equal(t, "bad feed", halt.Reason)
+	assert.Equal(t, EventSourceAdmin, halt.Source)
+	assert.True(t, at.Equal(halt.HaltedAt))
+	_, err = repo.HaltFor("GOOG")
+	assert.ErrorIs(t, err, ErrNotHalted)
+
+	// halting everything covers every security, but a security's own halt wins
+	require.NoError(t, repo.SetHalt(Halt{Reason: "market event", Source: EventSourceAPI, HaltedAt: at}))
+	halt, err = repo.HaltFor("GOOG")
+	require.NoError(t, err)
+	assert.Equal(t, "", halt.Security)
+	assert.Equal(t, "market event", halt.Reason)
+	halt, err = repo.HaltFor("AAPL")
+	require.NoError(t, err)
+	assert.Equal(t, "AAPL", halt.Security)
+
+	// halting again replaces the halt
+	require.NoError(t, repo.SetHalt(Halt{Security: "AAPL", Reason: "still bad", Source: EventSourceWeb, HaltedAt: at.Add(time.Minute)}))
+	halts, err = repo.ListHalts()
+	require.NoError(t, err)
+	require.Len(t, halts, 2)
+	assert.Equal(t, "", halts[0].Security)
+	assert.Equal(t, "AAPL", halts[1].Security)
+	assert.Equal(t, "still bad", halts[1].Reason)
+
+	require.NoError(t, repo.ClearHalt(""))
+	assert.ErrorIs(t, repo.ClearHalt(""), ErrNotHalted)
+	_, err = repo.HaltFor("GOOG")
+	assert.ErrorIs(t, err, ErrNotHalted)
+	require.NoError(t, repo.ClearHalt("AAPL"))
+	_, err = repo.HaltFor("AAPL")
+	assert.ErrorIs(t, err, ErrNotHalted)
+}
+
+func TestHaltsRepoMemory(t *testing.T) {
+	testHaltsRepoContract(t, NewHaltsRepoMemory())
+}
+
+func TestHaltsRepoSQLite(t *testing.T) {
+	db := newTestSQLiteDB(t)
+	require.NoError(t, migrateDB(db, dialectSQLite))
+
+	testHaltsRepoContract(t, NewHaltsRepoSQL(db, dialectSQLite))
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.temporal.io/sdk/client"
+)
+
+// /healthz says whether the process is alive: the worker is polling and the
+// dispatcher keeps up, which only a restart would fix if not. /readyz adds
+// the dependencies the service can't work without but which come back on
+// their own: Temporal, the store and the price feed. Both answer 200 or 503
+// with each check's status and what it found.
+
+const (
+	healthOK      = "ok"
+	healthFailing = "failing"
+
+	// healthCheckTimeout bounds each check, so a hung dependency fails its
+	// check rather than the probe.
+	healthCheckTimeout = 2 * time.Second
+)
+
+// healthCheck reports on one dependency, returning what it found even when
+// it fails.
+type healthCheck func(ctx context.Context) (details map[string]any, err error)
+
+type namedCheck struct {
+	name  string
+	check healthCheck
+}
+
+type checkResult struct {
+	Status  string         `json:"status"`
+	Error   string         `json:"error,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+// priceFeedStatus is what the health checks need from the price ingestion.
+type priceFeedStatus interface {
+	Status() (connected bool, lastTickAt time.Time)
+}
+
+type Health struct {
+	liveness  []namedCheck
+	readiness []namedCheck
+}
+
+// NewHealth checks the service's parts. The price feed is stale once no tick
+// has come for maxStaleness.
+func NewHealth(temporalClient client.Client, db *sql.DB, priceFeed priceFeedStatus, pricesChannel chan PriceUpdate, maxStaleness time.Duration) *Health {
+	liveness := []namedCheck{
+		{"worker", checkWorker},
+		{"dispatcher", checkDispatcher(pricesChannel)},
+	}
+	return &Health{
+		liveness: liveness,
+		readiness: append([]namedCheck{
+			{"temporal", checkTemporal(temporalClient)},
+			{"store", checkStore(db)},
+			{"priceFeed", checkPriceFeed(priceFeed, maxStaleness, time.Now)},
+		}, liveness...),
+	}
+}
+
+func (h *Health) SetupRoutes(r *mux.Router) {
+	r.Handle("/healthz", serveHealth(h.liveness)).Methods("GET")
+	r.Handle("/readyz", serveHealth(h.readiness)).Methods("GET")
+}
+
+func serveHealth(checks []namedCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := runHealthChecks(r.Context(), checks)
+		status := http.StatusOK
+		if report.Status != healthOK {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, report)
+	}
+}
+
+// runHealthChecks runs the checks side by side; the report fails if any of
+// them does.
+func runHealthChecks(ctx context.Context, checks []namedCheck) healthReport {
+	report := healthReport{Status: healthOK, Checks: make(map[string]checkResult, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+			details, err := c.check(ctx)
+
+			result := checkResult{Status: healthOK, Details: details}
+			if err != nil {
+				result.Status, result.Error = healthFailing, err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[c.name] = result
+			if err != nil {
+				report.Status = healthFailing
+			}
+		}()
+	}
+	wg.Wait()
+	return report
+}
+
+func checkTemporal(temporalClient client.Client) healthCheck {
+	return func(ctx context.Context) (map[string]any, error) {
+		if _, err := temporalClient.CheckHealth(ctx, &client.CheckHealthRequest{}); err != nil {
+			return nil, fmt.Errorf("temporal is unreachable: %w", err)
+		}
+		return nil, nil
+	}
+}
+
+func checkWorker(ctx context.Context) (map[string]any, error) {
+	running := workerRunning.Load()
+	details := map[string]any{"running": running, "taskQueue": "stop-loss-task-queue"}
+	if !running {
+		return details, errors.New("the worker isn't running")
+	}
+	return details, nil
+}
+
+// checkStore pings the database, if orders are kept in one.
+func checkStore(db *sql.DB) healthCheck {
+	return func(ctx context.Context) (map[string]any, error) {
+		if db == nil {
+			return map[string]any{"backend": ordersRepoMemory}, nil
+		}
+		stats := db.Stats()
+		details := map[string]any{"openConnections": stats.OpenConnections, "inUse": stats.InUse}
+		if err := db.PingContext(ctx); err != nil {
+			return details, fmt.Errorf("the database is unreachable: %w", err)
+		}
+		return details, nil
+	}
+}
+
+// checkPriceFeed goes by the last tick rather than the connection, as the
+// feed drops connections now and then and ingestion reconnects in a second.
+func checkPriceFeed(feed priceFeedStatus, maxStaleness time.Duration, now func() time.Time) healthCheck {
+	return func(ctx context.Context) (map[string]any, error) {
+		connected, lastTickAt := feed.Status()
+		details := map[string]any{"connected": connected}
+		if lastTickAt.IsZero() {
+			return details, errors.New("no price has been received yet")
+		}
+		staleness := now().Sub(lastTickAt)
+		details["lastTickAt"] = lastTickAt
+		details["stalenessSeconds"] = staleness.Seconds()
+		if staleness > maxStaleness {
+			return details, fmt.Errorf("no price has been received for %s", staleness.Round(time.Second))
+		}
+		return details, nil
+	}
+}
+
+// checkDispatcher fails once three quarters of the prices channel is waiting
+// for the dispatcher, before ingestion has to block on it.
+func checkDispatcher(pricesChannel chan PriceUpdate) healthCheck {
+	return func(ctx context.Context) (map[string]any, error) {
+		backlog, capacity := len(pricesChannel), cap(pricesChannel)
+		details := map[string]any{"backlog": backlog, "capacity": capacity}
+		if backlog >= capacity*3/4 {
+			return details, fmt.Errorf("the dispatcher is behind by %d price updates", backlog)
+		}
+		return details, nil
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeHealthReportsEachCheck(t *testing.T) {
+	ok := func(ctx context.Context) (map[string]any, error) { return map[string]any{"backlog": 0}, nil }
+	failing := func(ctx context.Context) (map[string]any, error) { return nil, errors.New("temporal is unreachable") }
+
+	rec := httptest.NewRecorder()
+	serveHealth([]namedCheck{{"dispatcher", ok}})(rec, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, 200, rec.Code)
+
+	rec = httptest.NewRecorder()
+	serveHealth([]namedCheck{{"dispatcher", ok}, {"temporal", failing}})(rec, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, 503, rec.Code)
+	var report healthReport
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.Equal(t, healthFailing, report.Status)
+	assert.Equal(t, checkResult{Status: healthOK, Details: map[string]any{"backlog": float64(0)}}, report.Checks["dispatcher"])
+	assert.Equal(t, checkResult{Status: healthFailing, Error: "temporal is unreachable"}, report.Checks["temporal"])
+}
+
+func TestHealthCheckTimesOut(t *testing.T) {
+	hung := func(ctx context.Context) (map[string]any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	report := runHealthChecks(context.Background(), []namedCheck{{"store", hung}})
+	assert.Equal(t, healthFailing, report.Status)
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks["store"].Error)
+}
+
+// fakePriceFeed reports a fixed status.
+type fakePriceFeed struct {
+	connected  bool
+	lastTickAt time.Time
+}
+
+func (f fakePriceFeed) Status() (bool, time.Time) {
+	return f.connected, f.lastTickAt
+}
+
+func TestCheckPriceFeed(t *testing.T) {
+	now := time.Date(2025, 2, 1, 14, 30, 0, 0, time.UTC)
+	check := func(feed fakePriceFeed) (map[string]any, error) {
+		return checkPriceFeed(feed, 30*time.Second, func() time.Time { return now })(context.Background())
+	}
+
+	_, err := check(fakePriceFeed{connected: true})
+	assert.ErrorContains(t, err, "no price has been received yet")
+
+	// a dropped connection doesn't matter while prices are fresh
+	details, err := check(fakePriceFeed{connected: false, lastTickAt: now.Add(-2 * time.Second)})
+	assert.NoError(t, err)
+	assert.Equal(t, false, details["connected"])
+	assert.Equal(t, 2.0, details["stalenessSeconds"])
+
+	_, err = check(fakePriceFeed{connected: true, lastTickAt: now.Add(-time.Minute)})
+	assert.ErrorContains(t, err, "no price has been received for 1m0s")
+}
+
+func TestCheckDispatcher(t *testing.T) {
+	pricesChannel := make(chan PriceUpdate, 4)
+	check := checkDispatcher(pricesChannel)
+
+	pricesChannel <- PriceUpdate{Security: "AAPL", Price: 150}
+	details, err := check(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{"backlog": 1, "capacity": 4}, details)
+
+	pricesChannel <- PriceUpdate{Security: "AAPL", Price: 149}
+	pricesChannel <- PriceUpdate{Security: "AAPL", Price: 148}
+	_, err = check(context.Background())
+	assert.ErrorContains(t, err, "behind by 3 price updates")
+}
+
+func TestCheckStore(t *testing.T) {
+	details, err := checkStore(nil)(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, ordersRepoMemory, details["backend"])
+
+	db, err := openSQLiteDB(filepath.Join(t.TempDir(), "orders.db"))
+	require.NoError(t, err)
+	_, err = checkStore(db)(context.Background())
+	assert.NoError(t, err)
+
+	require.NoError(t, db.Close())
+	_, err = checkStore(db)(context.Background())
+	assert.ErrorContains(t, err, "the database is unreachable")
+}
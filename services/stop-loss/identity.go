@@ -0,0 +1,46 @@
+package main
+
+import "net/http"
+
+type userContextKey struct{}
+
+// userFrom returns the user authenticate found for the request.
+func userFrom(r *http.Request) User {
+	user, _ := r.Context().Value(userContextKey{}).(User)
+	return user
+}
+
+// ordersFor lists the orders user can see: every order for risk managers
+// and admins, their account's for anyone else.
+func (s *WebServer) ordersFor(user User) ([]StopLossOrder, error) {
+	if user.Can(ActionViewAllAccounts) {
+		return s.ordersRepo.ListOrders()
+	}
+	return s.ordersRepo.ListOrdersForAccount(user.AccountID)
+}
+
+// orderFor gets an order the request's user owns, for action. Other
+// accounts' orders are ErrOrderNotFound too, so their IDs can't be probed,
+// but the attempt goes in the audit log like any other refusal.
+func (s *WebServer) orderFor(r *http.Request, action, orderID string) (StopLossOrder, error) {
+	order, err := s.ordersRepo.GetOrder(orderID)
+	if err != nil {
+		return StopLossOrder{}, err
+	}
+	if user := userFrom(r); !user.Owns(order) {
+		s.auditDenial(r, user, action)
+		return StopLossOrder{}, ErrOrderNotFound
+	}
+	return order, nil
+}
+
+// checkOwner is orderFor for handlers that only act on the order, where the
+// service finds it. Nothing is looked up for users who can see every
+// account's orders.
+func (s *WebServer) checkOwner(r *http.Request, action, orderID string) error {
+	if userFrom(r).Can(ActionViewAllAccounts) {
+		return nil
+	}
+	_, err := s.orderFor(r, action, orderID)
+	return err
+}
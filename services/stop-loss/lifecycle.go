@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Lifecycle stops what main started, in the reverse of the order it was
+// registered in, like defers: main starts the consumers before the producers
+// that feed them, so the producers stop first and the consumers can drain.
+type Lifecycle struct {
+	steps []shutdownStep
+}
+
+// shutdownStep is one thing to stop, given at most timeout to do it in.
+type shutdownStep struct {
+	name    string
+	timeout time.Duration
+	stop    func(ctx context.Context) error
+}
+
+// OnShutdown registers stop to run on shutdown, before everything registered
+// ahead of it.
+func (l *Lifecycle) OnShutdown(name string, timeout time.Duration, stop func(ctx context.Context) error) {
+	l.steps = append(l.steps, shutdownStep{name: name, timeout: timeout, stop: stop})
+}
+
+// Shutdown runs every step, each under its own timeout. A step that fails or
+// times out doesn't hold up the ones after it; Shutdown returns all their
+// errors once the last step is done.
+func (l *Lifecycle) Shutdown() error {
+	var errs []error
+	for i := len(l.steps) - 1; i >= 0; i-- {
+		step := l.steps[i]
+		start := time.Now()
+		if err := runShutdownStep(step); err != nil {
+			slog.Error("Failed to shut down", "step", step.name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", step.name, err))
+			continue
+		}
+		slog.Info("Shut down", "step", step.name, "took", time.Since(start).String())
+	}
+	return errors.Join(errs...)
+}
+
+// runShutdownStep gives up on a step that ignores its context once its
+// timeout has passed, leaving it to finish, or not, as the process exits.
+func runShutdownStep(step shutdownStep) error {
+	ctx, cancel := context.WithTimeout(context.Background(), step.timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- step.stop(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out after %s", step.timeout)
+	}
+}
+
+// waitFor is a shutdown step that waits for done to close.
+func waitFor(done <-chan struct{}) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
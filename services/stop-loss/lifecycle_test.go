@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLifecycleStopsInReverseOrder(t *testing.T) {
+	var stopped []string
+	lifecycle := &Lifecycle{}
+	for _, name := range []string{"store", "worker", "dispatcher", "http server"} {
+		lifecycle.OnShutdown(name, time.Second, func(context.Context) error {
+			stopped = append(stopped, name)
+			return nil
+		})
+	}
+
+	require.NoError(t, lifecycle.Shutdown())
+	assert.Equal(t, []string{"http server", "dispatcher", "worker", "store"}, stopped)
+}
+
+func TestLifecycleCarriesOnPastFailedSteps(t *testing.T) {
+	var stopped []string
+	lifecycle := &Lifecycle{}
+	lifecycle.OnShutdown("store", time.Second, func(context.Context) error {
+		stopped = append(stopped, "store")
+		return nil
+	})
+	// ignores its context, so only the step's timeout ends it
+	lifecycle.OnShutdown("worker", 10*time.Millisecond, func(context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	lifecycle.OnShutdown("http server", time.Second, func(context.Context) error {
+		return errors.New("listener already closed")
+	})
+
+	err := lifecycle.Shutdown()
+	assert.ErrorContains(t, err, "http server: listener already closed")
+	assert.ErrorContains(t, err, "worker: timed out after 10ms")
+	assert.Equal(t, []string{"store"}, stopped)
+}
+
+func TestPriceIngestionStopsAndClosesTheChannel(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.NoError(t, conn.WriteJSON(PriceUpdate{Security: "AAPL", Price: 150}))
+		// then keep the connection open and quiet until the client leaves
+		conn.ReadMessage()
+	}))
+	defer feed.Close()
+
+	pricesChannel := make(chan PriceUpdate, 8)
+	ingestion := NewPriceIngestionService("ws"+strings.TrimPrefix(feed.URL, "http"), pricesChannel)
+	ctx, cancel := context.WithCancel(context.Background())
+	ingestion.Start(ctx)
+
+	select {
+	case update := <-pricesChannel:
+		assert.Equal(t, "AAPL", update.Security)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no price update received")
+	}
+
+	cancel()
+	select {
+	case <-ingestion.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("ingestion didn't stop")
+	}
+	_, open := <-pricesChannel
+	assert.False(t, open)
+	connected, _ := ingestion.Status()
+	assert.False(t, connected)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"go.temporal.io/sdk/activity"
+	tlog "go.temporal.io/sdk/log"
+)
+
+// Logs are JSON, one object per line. Everything about an order carries its
+// orderID, and workflowID and security where there are ones; everything done
+// for an HTTP request carries its requestID. Temporal's own logs go through
+// the same handler, with their keys renamed to match ours.
+
+// temporalLogKeys are the keys the Temporal SDK logs with that we'd spell
+// differently.
+var temporalLogKeys = map[string]string{
+	"WorkflowID":   "workflowID",
+	"RunID":        "runID",
+	"WorkflowType": "workflowType",
+	"ActivityID":   "activityID",
+	"ActivityType": "activityType",
+	"TaskQueue":    "taskQueue",
+	"Namespace":    "namespace",
+	"Attempt":      "attempt",
+}
+
+// newLogger logs to w at level and above.
+func newLogger(w io.Writer, level slog.Level) *slog.Logger {
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if key, ok := temporalLogKeys[a.Key]; ok && len(groups) == 0 {
+				a.Key = key
+			}
+			return a
+		},
+	})
+	return slog.New(contextHandler{Handler: handler})
+}
+
+// setupLogging makes every logger, including the log package's, write JSON
+// to stderr at LOG_LEVEL (debug, info, warn or error; info by default).
+func setupLogging() error {
+	level := slog.LevelInfo
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("invalid LOG_LEVEL %q: must be debug, info, warn or error", v)
+		}
+	}
+	slog.SetDefault(newLogger(os.Stderr, level))
+	return nil
+}
+
+// activityLogger is the activity's own logger, tagged with its workflow and
+// attempt, or the default one when the activity is called directly.
+func activityLogger(ctx context.Context) tlog.Logger {
+	if activity.IsActivity(ctx) {
+		return activity.GetLogger(ctx)
+	}
+	return tlog.NewStructuredLogger(slog.Default())
+}
+
+// fatal logs msg as an error and exits, like log.Fatal.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+type requestIDKey struct{}
+
+// withRequestID tags each request with the caller's X-Request-ID, or a new
+// one, and sends it back so the caller can quote it.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > 128 {
+			id, _ = randomHex(8)
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// contextHandler adds the request ID of the context a record is logged
+// with, e.g. by slog.InfoContext(r.Context(), ...).
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		r.AddAttrs(slog.String("requestID", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tlog "go.temporal.io/sdk/log"
+)
+
+// logLines decodes each JSON line written to buf.
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestLoggerSpellsTemporalKeysLikeOurs(t *testing.T) {
+	var buf bytes.Buffer
+	logger := tlog.With(tlog.NewStructuredLogger(newLogger(&buf, slog.LevelInfo)), "WorkflowID", "stop-loss-workflow-order-1", "RunID", "run-1")
+
+	logger.Info("Stop-loss price reached", "orderID", "order-1", "security", "AAPL")
+	logger.Debug("Received price update")
+
+	lines := logLines(t, &buf)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "INFO", lines[0]["level"])
+	assert.Equal(t, "Stop-loss price reached", lines[0]["msg"])
+	assert.Equal(t, "stop-loss-workflow-order-1", lines[0]["workflowID"])
+	assert.Equal(t, "run-1", lines[0]["runID"])
+	assert.Equal(t, "order-1", lines[0]["orderID"])
+	assert.Equal(t, "AAPL", lines[0]["security"])
+	assert.NotContains(t, lines[0], "WorkflowID")
+}
+
+func TestRequestIDIsLoggedAndEchoed(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, slog.LevelInfo)
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.InfoContext(r.Context(), "Handled", "orderID", "order-1")
+	}))
+
+	req := httptest.NewRequest("GET", "/api/orders/order-1", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, "req-123", rec.Header().Get("X-Request-ID"))
+
+	// without one, the request gets its own
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/orders/order-1", nil))
+	generated := rec.Header().Get("X-Request-ID")
+	assert.NotEmpty(t, generated)
+
+	lines := logLines(t, &buf)
+	require.Len(t, lines, 2)
+	assert.Equal(t, "req-123", lines[0]["requestID"])
+	assert.Equal(t, generated, lines[1]["requestID"])
+}
+
+func TestSetupLoggingRejectsUnknownLevels(t *testing.T) {
+	previous := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	t.Setenv("LOG_LEVEL", "debug")
+	require.NoError(t, setupLogging())
+	assert.True(t, slog.Default().Enabled(context.Background(), slog.LevelDebug))
+
+	t.Setenv("LOG_LEVEL", "chatty")
+	assert.ErrorContains(t, setupLogging(), "LOG_LEVEL")
+}
@@ -1,88 +1,302 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.temporal.io/sdk/client"
 )
 
-const dbFileName = "/app/data/orders.db"
-
 func main() {
+	if err := setupLogging(); err != nil {
+		fatal("Failed to set up logging", "error", err)
+	}
+
+	// --- Subcommands ---
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			if err := runMigrateCommand(os.Args[2:]); err != nil {
+				fatal("Migration failed", "error", err)
+			}
+			return
+		case "reconcile":
+			if err := runReconcileCommand(os.Args[2:]); err != nil {
+				fatal("Reconciliation failed", "error", err)
+			}
+			return
+		case "accounts":
+			if err := runAccountsCommand(os.Args[2:]); err != nil {
+				fatal("Accounts command failed", "error", err)
+			}
+			return
+		case "positions":
+			if err := runPositionsCommand(os.Args[2:]); err != nil {
+				fatal("Positions command failed", "error", err)
+			}
+			return
+		default:
+			fatal("Unknown command", "command", os.Args[1])
+		}
+	}
+
 	// --- Environment Variable Loading and Validation ---
 	temporalAddress := os.Getenv("TEMPORAL_ADDRESS")
 	if temporalAddress == "" {
-		log.Fatal("TEMPORAL_ADDRESS environment variable is not set")
+		fatal("TEMPORAL_ADDRESS environment variable is not set")
 	}
 
 	priceFeedWsURL := os.Getenv("PRICE_WS_URL")
 	if priceFeedWsURL == "" {
-		log.Fatal("PRICE_WS_URL environment variable is not set")
+		fatal("PRICE_WS_URL environment variable is not set")
+	}
+
+	// --- Lifecycle ---
+	// everything started from here on is stopped on SIGINT or SIGTERM, in
+	// the reverse of the order it's registered in
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	lifecycle := &Lifecycle{}
+
+	// --- Tracing ---
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		fatal("Failed to set up tracing", "error", err)
 	}
+	lifecycle.OnShutdown("tracing", 5*time.Second, shutdownTracing)
 
 	// --- Temporal Client ---
 	temporalClient, err := WaitDialTemporal(temporalAddress, 10)
 	if err != nil {
-		log.Fatalf("Failed to connect to Temporal server at %s: %v", temporalAddress, err)
+		fatal("Failed to connect to Temporal server", "address", temporalAddress, "error", err)
+	}
+	lifecycle.OnShutdown("temporal client", 5*time.Second, func(context.Context) error {
+		temporalClient.Close()
+		return nil
+	})
+	slog.Info("Connected to Temporal server", "address", temporalAddress)
+
+	// --- Search Attributes ---
+	if err := RegisterSearchAttributes(context.Background(), temporalClient, client.DefaultNamespace); err != nil {
+		fatal("Failed to register search attributes", "error", err)
 	}
-	defer temporalClient.Close()
-	log.Println("Connected to Temporal server")
 
 	// --- Order Repo ---
-	db, err := openSQLiteDB(dbFileName)
+	repos, err := openStores(loadStoreConfig())
 	if err != nil {
-		log.Fatalf("Failed to initialize SQLite database: %v", err)
+		fatal("Failed to open order store", "error", err)
 	}
-	defer db.Close()
+	lifecycle.OnShutdown("store", 5*time.Second, func(context.Context) error {
+		return repos.Close()
+	})
+	orderRepo, eventsRepo, haltsRepo, positionsRepo := repos.orders, repos.events, repos.halts, repos.positions
 
-	err = createOrdersTable(db)
-	if err != nil {
-		log.Fatalf("Failed to create tables: %v", err)
+	// --- Authentication ---
+	if v := os.Getenv("SESSION_TTL"); v != "" {
+		sessionTTL, err = time.ParseDuration(v)
+		if err != nil || sessionTTL <= 0 {
+			fatal("Invalid SESSION_TTL", "value", v)
+		}
+	}
+	if v := os.Getenv("SESSION_COOKIE_SECURE"); v != "" {
+		secureCookies, err = strconv.ParseBool(v)
+		if err != nil {
+			fatal("Invalid SESSION_COOKIE_SECURE", "value", v)
+		}
+	}
+	slog.Info("Web sessions configured", "ttl", sessionTTL.String(), "secureCookie", secureCookies)
+	if v := os.Getenv("ADMIN_PASSWORD"); v != "" {
+		if err := setInitialPassword(repos.accounts, "admin", v); err != nil {
+			fatal("Failed to set the admin password", "error", err)
+		}
+	}
+
+	// --- Continue-As-New Policy ---
+	for env, limit := range map[string]*int{
+		"CONTINUE_AS_NEW_AFTER_SIGNALS": &continueAsNewPolicy.AfterSignals,
+		"CONTINUE_AS_NEW_AFTER_EVENTS":  &continueAsNewPolicy.AfterHistoryEvents,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*limit, err = strconv.Atoi(v)
+			if err != nil || *limit < 0 {
+				fatal("Invalid "+env, "value", v)
+			}
+		}
+	}
+	slog.Info("StopLossWorkflow continue-as-new policy", "afterSignals", continueAsNewPolicy.AfterSignals, "afterHistoryEvents", continueAsNewPolicy.AfterHistoryEvents)
+
+	// --- Execution Failure Policy ---
+	if v := os.Getenv("EXECUTION_FAILURE_POLICY"); v != "" {
+		if v != FailureActionRetry && v != FailureActionRearm && v != FailureActionEscalate {
+			fatal("Invalid EXECUTION_FAILURE_POLICY: must be retry, rearm or escalate", "value", v)
+		}
+		executionFailurePolicy.Action = v
+	}
+	if v := os.Getenv("EXECUTION_RETRY_DELAY"); v != "" {
+		executionFailurePolicy.RetryDelay, err = time.ParseDuration(v)
+		if err != nil || executionFailurePolicy.RetryDelay <= 0 {
+			fatal("Invalid EXECUTION_RETRY_DELAY", "value", v)
+		}
+	}
+	if v := os.Getenv("EXECUTION_MAX_RETRIES"); v != "" {
+		executionFailurePolicy.MaxRetries, err = strconv.Atoi(v)
+		if err != nil || executionFailurePolicy.MaxRetries < 0 {
+			fatal("Invalid EXECUTION_MAX_RETRIES", "value", v)
+		}
+	}
+	slog.Info("Execution failure policy", "action", executionFailurePolicy.Action, "retryDelay", executionFailurePolicy.RetryDelay.String(), "maxRetries", executionFailurePolicy.MaxRetries)
+
+	// --- Halt Resume Policy ---
+	if v := os.Getenv("HALT_RESUME_POLICY"); v != "" {
+		if v != ResumeActionExecute && v != ResumeActionReevaluate {
+			fatal("Invalid HALT_RESUME_POLICY: must be execute or reevaluate", "value", v)
+		}
+		haltResumeAction = v
+	}
+	slog.Info("Halt resume policy", "action", haltResumeAction)
+
+	// --- Risk Limits ---
+	if v := os.Getenv("RISK_REQUIRE_POSITION"); v != "" {
+		riskLimits.RequirePosition, err = strconv.ParseBool(v)
+		if err != nil {
+			fatal("Invalid RISK_REQUIRE_POSITION", "value", v)
+		}
 	}
-	log.Println("SQLite database initialized")
+	if v := os.Getenv("RISK_MAX_NOTIONAL"); v != "" {
+		riskLimits.MaxNotional, err = parseMaxNotional(v)
+		if err != nil {
+			fatal("Invalid RISK_MAX_NOTIONAL", "error", err)
+		}
+	}
+	slog.Info("Risk limits", "positionRequired", riskLimits.RequirePosition, "maxNotional", describeMaxNotional(riskLimits.MaxNotional))
+
+	// --- Webhooks ---
+	if v := os.Getenv("WEBHOOK_MAX_ATTEMPTS"); v != "" {
+		webhookDeliveryAttempts, err = strconv.Atoi(v)
+		if err != nil || webhookDeliveryAttempts < 1 {
+			fatal("Invalid WEBHOOK_MAX_ATTEMPTS", "value", v)
+		}
+	}
+	slog.Info("Webhook deliveries are dead lettered after their last attempt", "maxAttempts", webhookDeliveryAttempts)
+	webhookPublisher := NewWebhookPublisher(temporalClient, orderRepo, repos.webhooks)
 
-	orderRepo := NewOrdersRepoSQLite(db) // Use SQLite OrdersRepo
-	log.Println("Order repository initialized (SQLite)")
+	// --- Metrics ---
+	eventsRepo = measureExecutionLatency(eventsRepo, triggerToExecution)
+	prometheus.MustRegister(newPendingOrdersCollector(orderRepo))
+
+	// --- Notifications ---
+	notifiers := map[string]Notifier{NotificationChannelChat: NewChatNotifier()}
+	if smtpAddr := os.Getenv("SMTP_ADDR"); smtpAddr != "" {
+		smtpFrom := os.Getenv("SMTP_FROM")
+		if smtpFrom == "" {
+			fatal("SMTP_FROM environment variable is not set, but SMTP_ADDR is")
+		}
+		notifiers[NotificationChannelEmail] = NewSMTPNotifier(smtpAddr, smtpFrom, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+		slog.Info("Email notifications are on", "smtpAddr", smtpAddr, "from", smtpFrom)
+	} else {
+		slog.Info("SMTP_ADDR is not set, so email notifications aren't sent")
+	}
 
 	// --- Orders Workflow Service ---
-	ordersWorkflowService := NewOrdersService(temporalClient, orderRepo)
-	log.Println("Order service created")
+	ordersWorkflowService := NewOrdersService(temporalClient, orderRepo, eventsRepo, haltsRepo, positionsRepo, webhookPublisher)
+	slog.Info("Order service created")
 
 	// --- Price Update Channel ---
 	pricesChannel := make(chan PriceUpdate, 1024)
-	log.Println("Price update channel created")
-
-	// --- Start Price Ingestion Service ---
-	priceIngestionService := NewPriceIngestionService(priceFeedWsURL, pricesChannel)
-	priceIngestionService.Start()
-	log.Println("Price ingestion service started")
+	registerPricesChannelDepth(pricesChannel)
+	slog.Info("Price update channel created", "capacity", cap(pricesChannel))
 
 	// --- Start Temporal Worker ---
-	go StartLossOrderWorker(temporalClient, orderRepo)
-	log.Println("Loss Order Temporal worker started")
+	reconciler := NewReconciler(temporalClient, orderRepo, eventsRepo, webhookPublisher)
+	stopWorker, err := StartLossOrderWorker(temporalClient, orderRepo, eventsRepo, haltsRepo, positionsRepo, repos.webhooks, repos.notifications, notifiers, reconciler)
+	if err != nil {
+		fatal("Unable to start worker", "error", err)
+	}
+	lifecycle.OnShutdown("worker", workerStopTimeout+5*time.Second, func(context.Context) error {
+		stopWorker()
+		return nil
+	})
+	slog.Info("Loss Order Temporal worker started")
+
+	// --- Reconcile Schedule ---
+	reconcileInterval := 10 * time.Minute
+	if v := os.Getenv("RECONCILE_INTERVAL"); v != "" {
+		reconcileInterval, err = time.ParseDuration(v)
+		if err != nil {
+			fatal("Invalid RECONCILE_INTERVAL", "value", v, "error", err)
+		}
+	}
+	reconcileRepair := os.Getenv("RECONCILE_REPAIR") == "true"
+	err = ensureReconcileSchedule(context.Background(), temporalClient, reconcileInterval, reconcileRepair)
+	if err != nil {
+		fatal("Failed to create reconcile schedule", "error", err)
+	}
+	slog.Info("Reconcile schedule", "interval", reconcileInterval.String(), "repair", reconcileRepair)
+
+	// --- Start Price Change Dispatcher ---
+	slog.Info("Starting price change dispatcher")
+	dispatcherDone := make(chan struct{})
+	go func() {
+		defer close(dispatcherDone)
+		StartPriceChangeDispatcher(temporalClient, orderRepo, pricesChannel)
+	}()
+	lifecycle.OnShutdown("dispatcher", 15*time.Second, func(ctx context.Context) error {
+		if err := waitFor(dispatcherDone)(ctx); err != nil {
+			return fmt.Errorf("%d price updates weren't dispatched: %w", len(pricesChannel), err)
+		}
+		return nil
+	})
 
-	log.Println("Starting price change dispatcher")
-	go StartPriceChangeDispatcher(temporalClient, orderRepo, pricesChannel)
+	// --- Start Price Ingestion Service ---
+	// it closes the prices channel once stopped, which lets the dispatcher
+	// finish once it has sent what's buffered
+	ingestionCtx, stopIngestion := context.WithCancel(context.Background())
+	priceIngestionService := NewPriceIngestionService(priceFeedWsURL, pricesChannel)
+	priceIngestionService.Start(ingestionCtx)
+	lifecycle.OnShutdown("price ingestion", 5*time.Second, func(ctx context.Context) error {
+		stopIngestion()
+		return waitFor(priceIngestionService.Done())(ctx)
+	})
+	slog.Info("Price ingestion service started")
 
 	// --- Compile HTML Templates ---
 	tpl, err := compileTemplates()
 	if err != nil {
-		log.Fatalf("Failed to compile templates: %v", err)
+		fatal("Failed to compile templates", "error", err)
 	}
-	log.Println("Templates compiled successfully")
+	slog.Info("Templates compiled successfully")
 
 	// --- Web Server Setup ---
-	webServer := NewWebServer(tpl, temporalClient, orderRepo, ordersWorkflowService)
+	webServer, err := NewWebServer(tpl, temporalClient, orderRepo, eventsRepo, positionsRepo, repos.accounts, repos.credentials, repos.audit, repos.webhooks, repos.notifications, ordersWorkflowService)
+	if err != nil {
+		fatal("Failed to compile page templates", "error", err)
+	}
 	r := mux.NewRouter()
 	webServer.SetupRoutes(r)
 
+	// --- Health Checks ---
+	priceFeedMaxStaleness := 30 * time.Second
+	if v := os.Getenv("PRICE_FEED_MAX_STALENESS"); v != "" {
+		priceFeedMaxStaleness, err = time.ParseDuration(v)
+		if err != nil || priceFeedMaxStaleness <= 0 {
+			fatal("Invalid PRICE_FEED_MAX_STALENESS", "value", v)
+		}
+	}
+	NewHealth(temporalClient, repos.db, priceIngestionService, pricesChannel, priceFeedMaxStaleness).SetupRoutes(r)
+	slog.Info("Health checks at /healthz and /readyz", "priceFeedMaxStaleness", priceFeedMaxStaleness.String())
+
 	port := "8080"
 	serverAddr := fmt.Sprintf(":%s", port)
-	log.Printf("Starting server on %s", serverAddr)
+	slog.Info("Starting server", "addr", serverAddr)
 
 	server := &http.Server{
 		Addr:         serverAddr,
@@ -92,9 +306,25 @@ func main() {
 		IdleTimeout:  30 * time.Second,
 	}
 
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("Server failed to start: %v", err)
-	}
+	// Shutdown stops accepting connections, so no more orders, and waits for
+	// the requests in flight
+	serverErr := make(chan error, 1)
+	go func() { serverErr <- server.ListenAndServe() }()
+	lifecycle.OnShutdown("http server", 10*time.Second, server.Shutdown)
 
-	log.Println("Server stopped.")
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+		slog.Info("Shutting down")
+	case err := <-serverErr:
+		slog.Error("Server failed", "error", err)
+		exitCode = 1
+	}
+	// a second signal kills the process rather than waiting for the shutdown
+	stopSignals()
+	if err := lifecycle.Shutdown(); err != nil {
+		exitCode = 1
+	}
+	slog.Info("Stopped", "exitCode", exitCode)
+	os.Exit(exitCode)
 }
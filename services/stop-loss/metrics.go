@@ -0,0 +1,163 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics served at /metrics, alongside the Go runtime and process ones the
+// default registry comes with.
+var (
+	priceTicks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "stop_loss_price_ticks_total",
+		Help: "Price updates received from the price feed, by security.",
+	}, []string{"security"})
+
+	priceFeedReconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "stop_loss_price_feed_reconnects_total",
+		Help: "Attempts to connect to the price feed after the first.",
+	})
+
+	priceFeedConnected = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "stop_loss_price_feed_connected",
+		Help: "1 while the price feed's WebSocket is connected, 0 otherwise.",
+	})
+
+	dispatcherSignalDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "stop_loss_dispatcher_signal_duration_seconds",
+		Help:    "How long signalling an order's workflow with a price update took.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	dispatcherSignalErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "stop_loss_dispatcher_signal_errors_total",
+		Help: "Price update signals that failed to reach an order's workflow.",
+	})
+
+	triggerToExecution = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "stop_loss_trigger_to_execution_seconds",
+		Help: "Time from an order's stop triggering, or its last re-drive, to its execution.",
+		// from half a second up to about half an hour, for retries and halts
+		Buckets: prometheus.ExponentialBuckets(0.5, 2, 13),
+	})
+
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "stop_loss_http_requests_total",
+		Help: "HTTP requests served, by method, route and status code.",
+	}, []string{"method", "route", "code"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "stop_loss_http_request_duration_seconds",
+		Help:    "How long HTTP requests took to serve, by method and route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+)
+
+// registerPricesChannelDepth reports how many price updates are waiting for
+// the dispatcher.
+func registerPricesChannelDepth(pricesChannel chan PriceUpdate) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "stop_loss_prices_channel_depth",
+		Help: "Price updates waiting for the dispatcher.",
+	}, func() float64 { return float64(len(pricesChannel)) }))
+}
+
+// pendingOrdersCollector counts PENDING orders by security from the orders
+// table each time it's scraped, so it can't drift from what's stored.
+type pendingOrdersCollector struct {
+	ordersRepo OrdersRepo
+	desc       *prometheus.Desc
+}
+
+func newPendingOrdersCollector(ordersRepo OrdersRepo) *pendingOrdersCollector {
+	return &pendingOrdersCollector{
+		ordersRepo: ordersRepo,
+		desc:       prometheus.NewDesc("stop_loss_pending_orders", "Orders waiting for their stop to trigger, by security.", []string{"security"}, nil),
+	}
+}
+
+func (c *pendingOrdersCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *pendingOrdersCollector) Collect(ch chan<- prometheus.Metric) {
+	counts, err := c.ordersRepo.CountPendingOrdersBySecurity()
+	if err != nil {
+		slog.Error("Failed to count pending orders for metrics", "error", err)
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+	for security, count := range counts {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count), security)
+	}
+}
+
+// latencyEventsRepo observes how long each executed order took from its
+// trigger as the execution is recorded.
+type latencyEventsRepo struct {
+	OrderEventsRepo
+	latency prometheus.Observer
+}
+
+func measureExecutionLatency(events OrderEventsRepo, latency prometheus.Observer) OrderEventsRepo {
+	return &latencyEventsRepo{OrderEventsRepo: events, latency: latency}
+}
+
+func (r *latencyEventsRepo) AppendEvent(event OrderEvent) error {
+	if err := r.OrderEventsRepo.AppendEvent(event); err != nil {
+		return err
+	}
+	if event.Type != OrderEventExecuted {
+		return nil
+	}
+	events, err := r.ListEventsForOrder(event.OrderID)
+	if err != nil {
+		slog.Error("Failed to load order events for metrics", "orderID", event.OrderID, "error", err)
+		return nil
+	}
+	// the latest trigger, or re-drive of a failed execution, is what led here
+	var triggeredAt time.Time
+	for _, e := range events {
+		if e.Type == OrderEventPriceTriggered || e.Type == OrderEventRedriven {
+			triggeredAt = e.OccurredAt
+		}
+	}
+	if !triggeredAt.IsZero() {
+		r.latency.Observe(event.OccurredAt.Sub(triggeredAt).Seconds())
+	}
+	return nil
+}
+
+// instrumentHTTP counts and times requests by their route's path template,
+// e.g. /orders/{id}, so order IDs don't each get their own series.
+func instrumentHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		httpRequestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+		httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(rec.status)).Inc()
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPendingOrdersCollector(t *testing.T) {
+	orders := NewOrdersRepoMemory()
+	for _, order := range []StopLossOrder{
+		{ID: "order-1", Security: "AAPL", Status: OrderStatusPending},
+		{ID: "order-2", Security: "AAPL", Status: OrderStatusPending},
+		{ID: "order-3", Security: "GOOG", Status: OrderStatusPending},
+	} {
+		_, err := orders.CreateOrder(order)
+		require.NoError(t, err)
+	}
+	require.NoError(t, orders.CancelOrder("order-3"))
+
+	err := testutil.CollectAndCompare(newPendingOrdersCollector(orders), strings.NewReader(`
+# HELP stop_loss_pending_orders Orders waiting for their stop to trigger, by security.
+# TYPE stop_loss_pending_orders gauge
+stop_loss_pending_orders{security="AAPL"} 2
+`))
+	assert.NoError(t, err)
+}
+
+// observations records what it's asked to observe.
+type observations []float64
+
+func (o *observations) Observe(v float64) {
+	*o = append(*o, v)
+}
+
+func TestMeasureExecutionLatency(t *testing.T) {
+	var latencies observations
+	events := measureExecutionLatency(NewOrderEventsRepoMemory(), &latencies)
+	at := time.Date(2025, 2, 1, 14, 30, 0, 0, time.UTC)
+	record := func(orderID, eventType string, after time.Duration) {
+		require.NoError(t, events.AppendEvent(OrderEvent{OrderID: orderID, Type: eventType, Source: EventSourceWorkflow, OccurredAt: at.Add(after)}))
+	}
+
+	record("order-1", OrderEventCreated, 0)
+	record("order-1", OrderEventPriceTriggered, time.Minute)
+	record("order-1", OrderEventExecutionAttempted, time.Minute+time.Second)
+	record("order-1", OrderEventExecuted, time.Minute+3*time.Second)
+	assert.Equal(t, observations{3}, latencies)
+
+	// a re-driven order is timed from its re-drive, not its long-gone trigger
+	record("order-2", OrderEventPriceTriggered, 0)
+	record("order-2", OrderEventFailed, time.Second)
+	record("order-2", OrderEventRedriven, time.Hour)
+	record("order-2", OrderEventExecuted, time.Hour+2*time.Second)
+	assert.Equal(t, observations{3, 2}, latencies)
+}
+
+func TestInstrumentHTTP(t *testing.T) {
+	ts := newTestWebServer(t)
+	ts.addUser(t, "alice", "acct-1")
+	requests := func(code string) float64 {
+		return testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/api/orders/{id}", code))
+	}
+	found, notFound := requests("200"), requests("404")
+
+	_, err := ts.orders.CreateOrder(StopLossOrder{ID: "order-1", AccountID: "acct-1", Security: "AAPL", Status: OrderStatusPending})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, ts.doAs("alice", httptest.NewRequest("GET", "/api/orders/order-1", nil)).Code)
+	assert.Equal(t, http.StatusNotFound, ts.doAs("alice", httptest.NewRequest("GET", "/api/orders/order-2", nil)).Code)
+
+	assert.Equal(t, found+1, requests("200"))
+	assert.Equal(t, notFound+1, requests("404"))
+}
+
+func TestMetricsNeedNoCredentials(t *testing.T) {
+	ts := newTestWebServer(t)
+	priceTicks.WithLabelValues("AAPL").Inc()
+
+	rec := ts.send(httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `stop_loss_price_ticks_total{security="AAPL"}`)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations live in migrations/<dialect>/NNNN_name.{up,down}.sql and are
+// compiled into the binary.
+//
+//go:embed migrations
+var migrationsFS embed.FS
+
+// dialect names a SQL backend, and picks its migrations directory
+type dialect string
+
+const (
+	dialectSQLite   dialect = "sqlite"
+	dialectPostgres dialect = "postgres"
+)
+
+// rebind turns ? placeholders into the dialect's own style.
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+func loadMigrations(d dialect) ([]migration, error) {
+	dir := path.Join("migrations", string(d))
+	entries, err := fs.ReadDir(migrationsFS, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for dialect %s: %w", d, err)
+	}
+
+	byVersion := map[int]*migration{}
+	for _, entry := range entries {
+		fileName := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(fileName, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		versionStr, name, ok := strings.Cut(strings.TrimSuffix(fileName, "."+direction+".sql"), "_")
+		if !ok {
+			return nil, fmt.Errorf("malformed migration file name %s", fileName)
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("malformed migration version in %s: %w", fileName, err)
+		}
+
+		body, err := fs.ReadFile(migrationsFS, path.Join(dir, fileName))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", fileName, err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	var migrations []migration
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migrations for %s are not numbered consecutively: expected %04d, found %04d", d, i+1, m.Version)
+		}
+	}
+	return migrations, nil
+}
+
+// Migrator applies the embedded migrations and records each applied version
+// in the schema_version table.
+type Migrator struct {
+	db         *sql.DB
+	dialect    dialect
+	migrations []migration
+}
+
+func NewMigrator(db *sql.DB, d dialect) (*Migrator, error) {
+	migrations, err := loadMigrations(d)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		db:         db,
+		dialect:    d,
+		migrations: migrations,
+	}, nil
+}
+
+func (m *Migrator) ensureVersionTable() error {
+	_, err := m.db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_version table: %w", err)
+	}
+	return nil
+}
+
+// Version returns the highest applied migration, 0 for an empty database.
+func (m *Migrator) Version() (int, error) {
+	if err := m.ensureVersionTable(); err != nil {
+		return 0, err
+	}
+	var version int
+	err := m.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// Latest is the version Up migrates to.
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Up applies every pending migration and returns how many were applied.
+func (m *Migrator) Up() (int, error) {
+	current, err := m.Version()
+	if err != nil {
+		return 0, err
+	}
+	if current > m.Latest() {
+		return 0, fmt.Errorf("database is at schema version %d but this binary only knows up to %d", current, m.Latest())
+	}
+
+	applied := 0
+	for _, mig := range m.migrations[current:] {
+		slog.Info("Applying migration", "version", mig.Version, "name", mig.Name)
+		err := m.inTx(mig.Up, func(tx *sql.Tx) error {
+			_, err := tx.Exec(m.dialect.rebind(`INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)`), mig.Version, mig.Name, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return applied, fmt.Errorf("migration %04d_%s failed: %w", mig.Version, mig.Name, err)
+		}
+		applied++
+	}
+	return applied, nil
+}
+
+// Down reverts the most recent steps migrations.
+func (m *Migrator) Down(steps int) (int, error) {
+	current, err := m.Version()
+	if err != nil {
+		return 0, err
+	}
+	if current > m.Latest() {
+		return 0, fmt.Errorf("database is at schema version %d but this binary only knows up to %d", current, m.Latest())
+	}
+
+	reverted := 0
+	for v := current; v > 0 && reverted < steps; v-- {
+		mig := m.migrations[v-1]
+		slog.Info("Reverting migration", "version", mig.Version, "name", mig.Name)
+		err := m.inTx(mig.Down, func(tx *sql.Tx) error {
+			_, err := tx.Exec(m.dialect.rebind(`DELETE FROM schema_version WHERE version = ?`), mig.Version)
+			return err
+		})
+		if err != nil {
+			return reverted, fmt.Errorf("reverting migration %04d_%s failed: %w", mig.Version, mig.Name, err)
+		}
+		reverted++
+	}
+	return reverted, nil
+}
+
+// inTx runs a migration script and its bookkeeping atomically.
+func (m *Migrator) inTx(script string, record func(tx *sql.Tx) error) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(script); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrateDB brings a database up to the latest schema, used at startup.
+func migrateDB(db *sql.DB, d dialect) error {
+	migrator, err := NewMigrator(db, d)
+	if err != nil {
+		return err
+	}
+	applied, err := migrator.Up()
+	if err != nil {
+		return err
+	}
+	slog.Info("Database schema is up to date", "version", migrator.Latest(), "applied", applied)
+	return nil
+}
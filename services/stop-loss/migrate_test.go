@@ -0,0 +1,108 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSQLiteDB(t *testing.T) *sql.DB {
+	db, err := openSQLiteDB(filepath.Join(t.TempDir(), "orders.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestLoadMigrations(t *testing.T) {
+	for _, d := range []dialect{dialectSQLite, dialectPostgres} {
+		migrations, err := loadMigrations(d)
+		require.NoError(t, err, d)
+		require.NotEmpty(t, migrations, d)
+		for i, m := range migrations {
+			assert.Equal(t, i+1, m.Version, d)
+			assert.NotEmpty(t, m.Up, d)
+			assert.NotEmpty(t, m.Down, d)
+		}
+	}
+
+	// every dialect has to offer the same schema versions
+	sqlite, _ := loadMigrations(dialectSQLite)
+	postgres, _ := loadMigrations(dialectPostgres)
+	require.Equal(t, len(sqlite), len(postgres))
+	for i := range sqlite {
+		assert.Equal(t, sqlite[i].Name, postgres[i].Name)
+	}
+}
+
+func TestMigratorUpDown(t *testing.T) {
+	db := newTestSQLiteDB(t)
+	migrator, err := NewMigrator(db, dialectSQLite)
+	require.NoError(t, err)
+
+	version, err := migrator.Version()
+	require.NoError(t, err)
+	assert.Equal(t, 0, version)
+
+	applied, err := migrator.Up()
+	require.NoError(t, err)
+	assert.Equal(t, migrator.Latest(), applied)
+
+	// running again is a no-op
+	applied, err = migrator.Up()
+	require.NoError(t, err)
+	assert.Equal(t, 0, applied)
+
+	version, err = migrator.Version()
+	require.NoError(t, err)
+	assert.Equal(t, migrator.Latest(), version)
+
+	reverted, err := migrator.Down(migrator.Latest())
+	require.NoError(t, err)
+	assert.Equal(t, migrator.Latest(), reverted)
+
+	version, err = migrator.Version()
+	require.NoError(t, err)
+	assert.Equal(t, 0, version)
+
+	var tables int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'orders'`).Scan(&tables))
+	assert.Equal(t, 0, tables)
+
+	// and back up again after a full teardown
+	_, err = migrator.Up()
+	require.NoError(t, err)
+}
+
+func TestMigratorAdoptsLegacyDatabase(t *testing.T) {
+	db := newTestSQLiteDB(t)
+
+	// what createOrdersTable used to build, before there was a schema_version
+	_, err := db.Exec(`
+		CREATE TABLE orders (
+			id TEXT PRIMARY KEY,
+			security TEXT NOT NULL,
+			stop_price REAL NOT NULL,
+			quantity INTEGER NOT NULL,
+			status TEXT NOT NULL,
+			placed_at DATETIME NOT NULL,
+			workflow_id TEXT
+		);
+		INSERT INTO orders VALUES ('order-1', 'AAPL', 145.0, 10, 'PENDING', '2025-02-01T14:30:00Z', 'stop-loss-workflow-order-1');
+	`)
+	require.NoError(t, err)
+
+	require.NoError(t, migrateDB(db, dialectSQLite))
+
+	order, err := NewOrdersRepoSQLite(db).GetOrder("order-1")
+	require.NoError(t, err)
+	assert.Equal(t, "AAPL", order.Security)
+}
+
+func TestDialectRebind(t *testing.T) {
+	query := `SELECT * FROM orders WHERE id = ? AND status = ?`
+	assert.Equal(t, query, dialectSQLite.rebind(query))
+	assert.Equal(t, `SELECT * FROM orders WHERE id = $1 AND status = $2`, dialectPostgres.rebind(query))
+}
@@ -0,0 +1,12 @@
+-- IF NOT EXISTS adopts databases created before migrations existed.
+CREATE TABLE IF NOT EXISTS orders (
+	id TEXT PRIMARY KEY,
+	security TEXT NOT NULL,
+	stop_price NUMERIC(18, 6) NOT NULL,
+	quantity INTEGER NOT NULL,
+	status TEXT NOT NULL,
+	placed_at TIMESTAMPTZ NOT NULL,
+	workflow_id TEXT
+);
+
+CREATE INDEX IF NOT EXISTS orders_security_status_idx ON orders (security, status);
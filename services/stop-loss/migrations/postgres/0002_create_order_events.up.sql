@@ -0,0 +1,21 @@
+CREATE TABLE order_events (
+	id BIGSERIAL PRIMARY KEY,
+	order_id TEXT NOT NULL,
+	event_type TEXT NOT NULL,
+	source TEXT NOT NULL,
+	payload JSONB NOT NULL DEFAULT '{}',
+	occurred_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX order_events_order_id_idx ON order_events (order_id, id);
+
+-- the audit log is append-only
+CREATE FUNCTION order_events_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'order_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER order_events_append_only
+	BEFORE UPDATE OR DELETE ON order_events
+	FOR EACH ROW EXECUTE FUNCTION order_events_append_only();
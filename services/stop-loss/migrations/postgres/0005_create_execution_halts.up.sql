@@ -0,0 +1,7 @@
+-- one row per halted security; the empty security halts them all
+CREATE TABLE execution_halts (
+	security TEXT PRIMARY KEY,
+	reason TEXT NOT NULL DEFAULT '',
+	source TEXT NOT NULL,
+	halted_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,14 @@
+-- shares held per security, which stop-losses can't sell more than
+CREATE TABLE positions (
+	security TEXT PRIMARY KEY,
+	quantity INTEGER NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+
+-- executions already taken off a position, so none is taken off twice
+CREATE TABLE position_executions (
+	order_id TEXT PRIMARY KEY,
+	security TEXT NOT NULL,
+	quantity INTEGER NOT NULL,
+	executed_at TIMESTAMPTZ NOT NULL
+);
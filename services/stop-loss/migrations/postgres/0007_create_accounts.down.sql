@@ -0,0 +1,11 @@
+-- only the default account's positions fit the old table
+DELETE FROM positions WHERE account_id <> 'default';
+ALTER TABLE positions DROP CONSTRAINT positions_pkey;
+ALTER TABLE positions DROP COLUMN account_id;
+ALTER TABLE positions ADD PRIMARY KEY (security);
+
+DROP INDEX IF EXISTS orders_account_id_idx;
+ALTER TABLE orders DROP COLUMN account_id;
+
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS accounts;
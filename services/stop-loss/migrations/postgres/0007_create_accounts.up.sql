@@ -0,0 +1,26 @@
+CREATE TABLE accounts (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE users (
+	username TEXT PRIMARY KEY,
+	account_id TEXT NOT NULL REFERENCES accounts (id),
+	admin BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+-- everything from before accounts belongs to the default account, which the
+-- built-in admin user acts for
+INSERT INTO accounts (id, name, created_at) VALUES ('default', 'Default', CURRENT_TIMESTAMP);
+INSERT INTO users (username, account_id, admin, created_at) VALUES ('admin', 'default', TRUE, CURRENT_TIMESTAMP);
+
+ALTER TABLE orders ADD COLUMN account_id TEXT NOT NULL DEFAULT 'default';
+CREATE INDEX orders_account_id_idx ON orders (account_id);
+
+-- positions are held per account
+ALTER TABLE positions ADD COLUMN account_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE positions ALTER COLUMN account_id DROP DEFAULT;
+ALTER TABLE positions DROP CONSTRAINT positions_pkey;
+ALTER TABLE positions ADD PRIMARY KEY (account_id, security);
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS audit_log;
+DROP FUNCTION IF EXISTS audit_log_append_only();
+
+ALTER TABLE users ADD COLUMN admin BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE users SET admin = TRUE WHERE role = 'admin';
+ALTER TABLE users DROP COLUMN role;
@@ -0,0 +1,25 @@
+-- roles replace the admin flag; everyone else could place and cancel orders
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'trader';
+UPDATE users SET role = 'admin' WHERE admin;
+ALTER TABLE users DROP COLUMN admin;
+
+-- actions users were refused, alongside order_events
+CREATE TABLE audit_log (
+	id BIGSERIAL PRIMARY KEY,
+	username TEXT NOT NULL,
+	role TEXT NOT NULL,
+	action TEXT NOT NULL,
+	target TEXT NOT NULL,
+	outcome TEXT NOT NULL,
+	occurred_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_append_only
+	BEFORE UPDATE OR DELETE ON audit_log
+	FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
@@ -0,0 +1,10 @@
+-- IF NOT EXISTS adopts databases created before migrations existed.
+CREATE TABLE IF NOT EXISTS orders (
+	id TEXT PRIMARY KEY,
+	security TEXT NOT NULL,
+	stop_price REAL NOT NULL,
+	quantity INTEGER NOT NULL,
+	status TEXT NOT NULL,
+	placed_at DATETIME NOT NULL,
+	workflow_id TEXT
+);
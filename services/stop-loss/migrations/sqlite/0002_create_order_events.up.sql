@@ -0,0 +1,21 @@
+CREATE TABLE order_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	order_id TEXT NOT NULL,
+	event_type TEXT NOT NULL,
+	source TEXT NOT NULL,
+	payload TEXT NOT NULL DEFAULT '{}',
+	occurred_at DATETIME NOT NULL
+);
+
+CREATE INDEX order_events_order_id_idx ON order_events (order_id, id);
+
+-- the audit log is append-only
+CREATE TRIGGER order_events_no_update BEFORE UPDATE ON order_events
+BEGIN
+	SELECT RAISE(ABORT, 'order_events is append-only');
+END;
+
+CREATE TRIGGER order_events_no_delete BEFORE DELETE ON order_events
+BEGIN
+	SELECT RAISE(ABORT, 'order_events is append-only');
+END;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS orders_idempotency_key_idx;
+
+ALTER TABLE orders DROP COLUMN idempotency_key;
@@ -0,0 +1,4 @@
+ALTER TABLE orders ADD COLUMN idempotency_key TEXT;
+
+-- orders placed without a key are stored with NULL and never collide
+CREATE UNIQUE INDEX orders_idempotency_key_idx ON orders (idempotency_key) WHERE idempotency_key IS NOT NULL;
@@ -0,0 +1,2 @@
+-- why the last execution attempt failed; only set while the order is FAILED
+ALTER TABLE orders ADD COLUMN failure_reason TEXT;
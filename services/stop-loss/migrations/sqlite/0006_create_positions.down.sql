@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS position_executions;
+DROP TABLE IF EXISTS positions;
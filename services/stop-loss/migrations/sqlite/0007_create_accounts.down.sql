@@ -0,0 +1,16 @@
+-- only the default account's positions fit the old table
+CREATE TABLE positions_by_security (
+	security TEXT PRIMARY KEY,
+	quantity INTEGER NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+INSERT INTO positions_by_security (security, quantity, updated_at)
+SELECT security, quantity, updated_at FROM positions WHERE account_id = 'default';
+DROP TABLE positions;
+ALTER TABLE positions_by_security RENAME TO positions;
+
+DROP INDEX IF EXISTS orders_account_id_idx;
+ALTER TABLE orders DROP COLUMN account_id;
+
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS accounts;
@@ -0,0 +1,33 @@
+CREATE TABLE accounts (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	created_at DATETIME NOT NULL
+);
+
+CREATE TABLE users (
+	username TEXT PRIMARY KEY,
+	account_id TEXT NOT NULL REFERENCES accounts (id),
+	admin BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at DATETIME NOT NULL
+);
+
+-- everything from before accounts belongs to the default account, which the
+-- built-in admin user acts for
+INSERT INTO accounts (id, name, created_at) VALUES ('default', 'Default', CURRENT_TIMESTAMP);
+INSERT INTO users (username, account_id, admin, created_at) VALUES ('admin', 'default', TRUE, CURRENT_TIMESTAMP);
+
+ALTER TABLE orders ADD COLUMN account_id TEXT NOT NULL DEFAULT 'default';
+CREATE INDEX orders_account_id_idx ON orders (account_id);
+
+-- positions are held per account; SQLite can't change a primary key in place
+CREATE TABLE positions_by_account (
+	account_id TEXT NOT NULL,
+	security TEXT NOT NULL,
+	quantity INTEGER NOT NULL,
+	updated_at DATETIME NOT NULL,
+	PRIMARY KEY (account_id, security)
+);
+INSERT INTO positions_by_account (account_id, security, quantity, updated_at)
+SELECT 'default', security, quantity, updated_at FROM positions;
+DROP TABLE positions;
+ALTER TABLE positions_by_account RENAME TO positions;
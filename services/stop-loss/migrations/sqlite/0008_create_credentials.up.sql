@@ -0,0 +1,18 @@
+-- users without a password can't log in until one is set
+ALTER TABLE users ADD COLUMN password_hash TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE sessions (
+	token_hash TEXT PRIMARY KEY,
+	username TEXT NOT NULL REFERENCES users (username),
+	csrf_token TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL
+);
+
+CREATE TABLE api_keys (
+	id TEXT PRIMARY KEY,
+	username TEXT NOT NULL REFERENCES users (username),
+	name TEXT NOT NULL,
+	key_hash TEXT NOT NULL UNIQUE,
+	created_at DATETIME NOT NULL
+);
@@ -0,0 +1,25 @@
+-- roles replace the admin flag; everyone else could place and cancel orders
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'trader';
+UPDATE users SET role = 'admin' WHERE admin;
+ALTER TABLE users DROP COLUMN admin;
+
+-- actions users were refused, alongside order_events
+CREATE TABLE audit_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT NOT NULL,
+	role TEXT NOT NULL,
+	action TEXT NOT NULL,
+	target TEXT NOT NULL,
+	outcome TEXT NOT NULL,
+	occurred_at DATETIME NOT NULL
+);
+
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
@@ -0,0 +1,25 @@
+-- secret is kept as is: it signs every delivery
+CREATE TABLE webhook_subscriptions (
+	id TEXT PRIMARY KEY,
+	account_id TEXT NOT NULL REFERENCES accounts (id),
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	events TEXT NOT NULL, -- comma-separated
+	created_at DATETIME NOT NULL
+);
+
+CREATE INDEX webhook_subscriptions_account_id_idx ON webhook_subscriptions (account_id);
+
+-- deliveries that ran out of retries, with the body that was sent
+CREATE TABLE webhook_dead_letters (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	delivery_id TEXT NOT NULL UNIQUE,
+	subscription_id TEXT NOT NULL,
+	account_id TEXT NOT NULL,
+	order_id TEXT NOT NULL,
+	event TEXT NOT NULL,
+	url TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	error TEXT NOT NULL,
+	failed_at DATETIME NOT NULL
+);
@@ -0,0 +1,12 @@
+-- where an account's traders are told about their orders; target is an
+-- email address or a chat incoming-webhook URL, depending on channel
+CREATE TABLE notification_preferences (
+	id TEXT PRIMARY KEY,
+	account_id TEXT NOT NULL REFERENCES accounts (id),
+	channel TEXT NOT NULL,
+	target TEXT NOT NULL,
+	events TEXT NOT NULL, -- comma-separated
+	created_at DATETIME NOT NULL
+);
+
+CREATE INDEX notification_preferences_account_id_idx ON notification_preferences (account_id);
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+)
+
+// Notification channels a preference can name.
+const (
+	NotificationChannelEmail = "email"
+	NotificationChannelChat  = "chat"
+)
+
+// notificationEvents are the order events traders can be told about: the
+// ones that leave the order's status persisted as EXECUTED or FAILED.
+var notificationEvents = []string{OrderEventExecuted, OrderEventFailed}
+
+// notificationTimeout bounds a single send.
+const notificationTimeout = 30 * time.Second
+
+// notificationAttempts is how often the workflow tries to send an order's
+// notifications before giving up on the ones that haven't gone out.
+const notificationAttempts = 5
+
+// Notification is one message about one order, whatever it's sent through.
+type Notification struct {
+	Subject string
+	Body    string
+}
+
+// Notifier sends a notification to a target, in whatever form its channel
+// takes: an email address, or a chat webhook URL.
+type Notifier interface {
+	Notify(ctx context.Context, target string, notification Notification) error
+}
+
+func newNotificationPreference(accountID, channel, target string, events []string, now time.Time) (NotificationPreference, error) {
+	id, err := randomHex(6)
+	if err != nil {
+		return NotificationPreference{}, err
+	}
+	return NotificationPreference{ID: "ntf-" + id, AccountID: accountID, Channel: channel, Target: target, Events: events, CreatedAt: now}, nil
+}
+
+func (p NotificationPreference) wants(event string) bool {
+	for _, e := range p.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func validNotificationEvent(event string) bool {
+	for _, e := range notificationEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// validNotificationTarget checks a target makes sense for its channel.
+func validNotificationTarget(channel, target string) error {
+	switch channel {
+	case NotificationChannelEmail:
+		address, err := mail.ParseAddress(target)
+		if err != nil || address.Address != target {
+			return errors.New("target must be a plain email address")
+		}
+	case NotificationChannelChat:
+		if !absoluteHTTPURL(target) {
+			return errors.New("target must be an absolute http or https URL")
+		}
+	default:
+		return fmt.Errorf("unknown channel %q: must be %s or %s", channel, NotificationChannelEmail, NotificationChannelChat)
+	}
+	return nil
+}
+
+// orderNotification says what happened to the order, as it's stored now.
+func orderNotification(order StopLossOrder, event string) Notification {
+	var n Notification
+	switch event {
+	case OrderEventExecuted:
+		n.Subject = fmt.Sprintf("Stop-loss executed: %d %s", order.Quantity, order.Security)
+		n.Body = fmt.Sprintf("Your stop-loss order %s for %d %s at a stop of %.2f has executed.", order.ID, order.Quantity, order.Security, order.StopPrice)
+	case OrderEventFailed:
+		n.Subject = fmt.Sprintf("Stop-loss failed: %d %s", order.Quantity, order.Security)
+		n.Body = fmt.Sprintf("Your stop-loss order %s for %d %s at a stop of %.2f failed to execute: %s\n\nIt won't trigger again until it's re-driven.",
+			order.ID, order.Quantity, order.Security, order.StopPrice, order.FailureReason)
+	}
+	return n
+}
+
+// SMTPNotifier sends notifications as plain-text email, upgrading to TLS
+// when the server offers it.
+type SMTPNotifier struct {
+	addr string // host:port
+	from string
+	auth smtp.Auth // nil to send without logging in
+}
+
+// NewSMTPNotifier logs in with username and password if username is set.
+func NewSMTPNotifier(addr, from, username, password string) *SMTPNotifier {
+	n := &SMTPNotifier{addr: addr, from: from}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		n.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return n
+}
+
+// Notify does what smtp.SendMail does, but gives up when ctx does.
+func (n *SMTPNotifier) Notify(ctx context.Context, to string, notification Notification) error {
+	dialer := net.Dialer{Timeout: notificationTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server %s: %w", n.addr, err)
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(notificationTimeout)
+	}
+	_ = conn.SetDeadline(deadline)
+
+	host, _, _ := net.SplitHostPort(n.addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to greet SMTP server %s: %w", n.addr, err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return fmt.Errorf("failed to start TLS with SMTP server %s: %w", n.addr, err)
+		}
+	}
+	if n.auth != nil {
+		if err := c.Auth(n.auth); err != nil {
+			return fmt.Errorf("failed to log in to SMTP server %s: %w", n.addr, err)
+		}
+	}
+	if err := c.Mail(n.from); err != nil {
+		return fmt.Errorf("SMTP server %s refused sender %s: %w", n.addr, n.from, err)
+	}
+	if err := c.Rcpt(to); err != nil {
+		return fmt.Errorf("SMTP server %s refused recipient %s: %w", n.addr, to, err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send email to %s: %w", to, err)
+	}
+	if _, err := w.Write(n.message(to, notification, time.Now())); err != nil {
+		return fmt.Errorf("failed to send email to %s: %w", to, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send email to %s: %w", to, err)
+	}
+	return c.Quit()
+}
+
+func (n *SMTPNotifier) message(to string, notification Notification, now time.Time) []byte {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", oneLine(notification.Subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(notification.Body, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+	return msg.Bytes()
+}
+
+// oneLine keeps a header value from starting headers of its own.
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+// ChatNotifier posts notifications to a chat incoming webhook as
+// {"text": "..."}, which Slack, Mattermost and Rocket.Chat all take.
+type ChatNotifier struct {
+	httpClient *http.Client
+}
+
+func NewChatNotifier() *ChatNotifier {
+	return &ChatNotifier{httpClient: &http.Client{Timeout: notificationTimeout}}
+}
+
+type chatMessage struct {
+	Text string `json:"text"`
+}
+
+func (n *ChatNotifier) Notify(ctx context.Context, url string, notification Notification) error {
+	body, err := json.Marshal(chatMessage{Text: "*" + notification.Subject + "*\n" + notification.Body})
+	if err != nil {
+		return fmt.Errorf("failed to marshal chat message: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid chat webhook URL %s: %w", url, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post to chat webhook %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("chat webhook %s answered %s", url, resp.Status)
+	}
+	return nil
+}
+
+// NotificationActivities sends an order's notifications through the
+// notifier for each preference's channel. A channel without one, e.g. email
+// with no SMTP server configured, is skipped.
+type NotificationActivities struct {
+	ordersRepo        OrdersRepo
+	notificationsRepo NotificationsRepo
+	notifiers         map[string]Notifier
+}
+
+func NewNotificationActivities(ordersRepo OrdersRepo, notificationsRepo NotificationsRepo, notifiers map[string]Notifier) *NotificationActivities {
+	return &NotificationActivities{
+		ordersRepo:        ordersRepo,
+		notificationsRepo: notificationsRepo,
+		notifiers:         notifiers,
+	}
+}
+
+// NotifyOrderActivity tells every preference of the order's account that
+// wants it about event. The preferences already sent to are kept in the
+// heartbeat, so a retry only sends to the ones that failed.
+func (a *NotificationActivities) NotifyOrderActivity(ctx context.Context, orderID string, event string) error {
+	order, err := a.ordersRepo.GetOrder(orderID)
+	if err != nil {
+		return fmt.Errorf("failed to load order %s: %w", orderID, err)
+	}
+	preferences, err := a.notificationsRepo.ListPreferences(order.AccountID)
+	if err != nil {
+		return err
+	}
+
+	sent := map[string]bool{}
+	if activity.HasHeartbeatDetails(ctx) {
+		var done []string
+		if err := activity.GetHeartbeatDetails(ctx, &done); err == nil {
+			for _, id := range done {
+				sent[id] = true
+			}
+		}
+	}
+
+	notification := orderNotification(order, event)
+	var done []string
+	var errs []error
+	for _, preference := range preferences {
+		if !preference.wants(event) {
+			continue
+		}
+		if sent[preference.ID] {
+			done = append(done, preference.ID)
+			continue
+		}
+		notifier, ok := a.notifiers[preference.Channel]
+		if !ok {
+			activityLogger(ctx).Warn("Skipping notification, no notifier is configured for its channel", "orderID", orderID, "event", event, "preferenceID", preference.ID, "channel", preference.Channel)
+			continue
+		}
+		if err := notifier.Notify(ctx, preference.Target, notification); err != nil {
+			errs = append(errs, fmt.Errorf("%s notification %s: %w", preference.Channel, preference.ID, err))
+			continue
+		}
+		activityLogger(ctx).Info("Sent notification", "orderID", orderID, "event", event, "channel", preference.Channel, "target", preference.Target)
+		done = append(done, preference.ID)
+		activity.RecordHeartbeat(ctx, done)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to send %d %s notifications for order %s: %w", len(errs), event, orderID, errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// NotificationsRepoSQL keeps notification preferences in the
+// notification_preferences table of either SQL backend.
+type NotificationsRepoSQL struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func NewNotificationsRepoSQL(db *sql.DB, d dialect) *NotificationsRepoSQL {
+	return &NotificationsRepoSQL{
+		db:      db,
+		dialect: d,
+	}
+}
+
+func (r *NotificationsRepoSQL) CreatePreference(preference NotificationPreference) error {
+	_, err := r.db.Exec(r.dialect.rebind(`
+		INSERT INTO notification_preferences (id, account_id, channel, target, events, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`), preference.ID, preference.AccountID, preference.Channel, preference.Target, strings.Join(preference.Events, ","), preference.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to create notification preference for account %s: %w", preference.AccountID, err)
+	}
+	return nil
+}
+
+const notificationPreferenceColumns = `id, account_id, channel, target, events, created_at`
+
+func scanNotificationPreference(row interface{ Scan(...any) error }) (NotificationPreference, error) {
+	var preference NotificationPreference
+	var events string
+	err := row.Scan(&preference.ID, &preference.AccountID, &preference.Channel, &preference.Target, &events, &preference.CreatedAt)
+	if events != "" {
+		preference.Events = strings.Split(events, ",")
+	}
+	return preference, err
+}
+
+func (r *NotificationsRepoSQL) GetPreference(id string) (NotificationPreference, error) {
+	row := r.db.QueryRow(r.dialect.rebind(`SELECT `+notificationPreferenceColumns+` FROM notification_preferences WHERE id = ?`), id)
+	preference, err := scanNotificationPreference(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return NotificationPreference{}, ErrNotificationNotFound
+	}
+	if err != nil {
+		return NotificationPreference{}, fmt.Errorf("failed to get notification preference %s: %w", id, err)
+	}
+	return preference, nil
+}
+
+func (r *NotificationsRepoSQL) ListPreferences(accountID string) ([]NotificationPreference, error) {
+	query, args := `SELECT `+notificationPreferenceColumns+` FROM notification_preferences ORDER BY account_id, created_at, id`, []any{}
+	if accountID != "" {
+		query, args = `SELECT `+notificationPreferenceColumns+` FROM notification_preferences WHERE account_id = ? ORDER BY created_at, id`, []any{accountID}
+	}
+	rows, err := r.db.Query(r.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list notification preferences: %w", err)
+	}
+	defer rows.Close()
+
+	var preferences []NotificationPreference
+	for rows.Next() {
+		preference, err := scanNotificationPreference(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan notification preference: %w", err)
+		}
+		preferences = append(preferences, preference)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating notification preference rows: %w", err)
+	}
+	return preferences, nil
+}
+
+func (r *NotificationsRepoSQL) DeletePreference(id string) error {
+	result, err := r.db.Exec(r.dialect.rebind(`DELETE FROM notification_preferences WHERE id = ?`), id)
+	if err != nil {
+		return fmt.Errorf("failed to delete notification preference %s: %w", id, err)
+	}
+	return affectedOr(result, ErrNotificationNotFound)
+}
+
+// Ensure NotificationsRepoSQL implements NotificationsRepo
+var _ NotificationsRepo = (*NotificationsRepoSQL)(nil)
+
+// NotificationsRepoMemory keeps notification preferences for the in-memory
+// store.
+type NotificationsRepoMemory struct {
+	mu          sync.RWMutex
+	preferences map[string]NotificationPreference
+}
+
+func NewNotificationsRepoMemory() *NotificationsRepoMemory {
+	return &NotificationsRepoMemory{
+		preferences: make(map[string]NotificationPreference),
+	}
+}
+
+func (m *NotificationsRepoMemory) CreatePreference(preference NotificationPreference) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.preferences[preference.ID] = preference
+	return nil
+}
+
+func (m *NotificationsRepoMemory) GetPreference(id string) (NotificationPreference, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	preference, ok := m.preferences[id]
+	if !ok {
+		return NotificationPreference{}, ErrNotificationNotFound
+	}
+	return preference, nil
+}
+
+func (m *NotificationsRepoMemory) ListPreferences(accountID string) ([]NotificationPreference, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var preferences []NotificationPreference
+	for _, preference := range m.preferences {
+		if accountID == "" || preference.AccountID == accountID {
+			preferences = append(preferences, preference)
+		}
+	}
+	sort.Slice(preferences, func(i, j int) bool {
+		a, b := preferences[i], preferences[j]
+		if a.AccountID != b.AccountID {
+			return a.AccountID < b.AccountID
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+	return preferences, nil
+}
+
+func (m *NotificationsRepoMemory) DeletePreference(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.preferences[id]; !ok {
+		return ErrNotificationNotFound
+	}
+	delete(m.preferences, id)
+	return nil
+}
+
+// Ensure NotificationsRepoMemory implements NotificationsRepo
+var _ NotificationsRepo = (*NotificationsRepoMemory)(nil)
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testNotificationsRepoContract(t *testing.T, repo NotificationsRepo) {
+	at := time.Date(2025, 2, 1, 14, 30, 0, 0, time.UTC)
+
+	_, err := repo.GetPreference("ntf-1")
+	assert.ErrorIs(t, err, ErrNotificationNotFound)
+
+	require.NoError(t, repo.CreatePreference(NotificationPreference{ID: "ntf-1", AccountID: "acct-1", Channel: NotificationChannelEmail, Target: "alice@books.example", Events: notificationEvents, CreatedAt: at}))
+	require.NoError(t, repo.CreatePreference(NotificationPreference{ID: "ntf-2", AccountID: "acct-2", Channel: NotificationChannelChat, Target: "https://chat.example/hook", Events: []string{OrderEventFailed}, CreatedAt: at}))
+	require.NoError(t, repo.CreatePreference(NotificationPreference{ID: "ntf-3", AccountID: "acct-1", Channel: NotificationChannelChat, Target: "https://chat.example/desk", Events: []string{OrderEventExecuted}, CreatedAt: at.Add(time.Minute)}))
+
+	preference, err := repo.GetPreference("ntf-1")
+	require.NoError(t, err)
+	assert.Equal(t, NotificationChannelEmail, preference.Channel)
+	assert.Equal(t, "alice@books.example", preference.Target)
+	assert.Equal(t, []string{OrderEventExecuted, OrderEventFailed}, preference.Events)
+	assert.True(t, at.Equal(preference.CreatedAt))
+
+	preferences, err := repo.ListPreferences("acct-1")
+	require.NoError(t, err)
+	require.Len(t, preferences, 2)
+	assert.Equal(t, "ntf-1", preferences[0].ID)
+	assert.Equal(t, "ntf-3", preferences[1].ID)
+	preferences, err = repo.ListPreferences("")
+	require.NoError(t, err)
+	assert.Len(t, preferences, 3)
+
+	require.NoError(t, repo.DeletePreference("ntf-3"))
+	assert.ErrorIs(t, repo.DeletePreference("ntf-3"), ErrNotificationNotFound)
+	preferences, err = repo.ListPreferences("acct-1")
+	require.NoError(t, err)
+	assert.Len(t, preferences, 1)
+}
+
+func TestNotificationsRepoMemory(t *testing.T) {
+	testNotificationsRepoContract(t, NewNotificationsRepoMemory())
+}
+
+func TestNotificationsRepoSQLite(t *testing.T) {
+	db := newTestSQLiteDB(t)
+	require.NoError(t, migrateDB(db, dialectSQLite))
+
+	testNotificationsRepoContract(t, NewNotificationsRepoSQL(db, dialectSQLite))
+}
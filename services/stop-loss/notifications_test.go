@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/testsuite"
+)
+
+type smtpMessage struct {
+	From, To, Data string
+}
+
+// startFakeSMTPServer accepts mail on a local port, without TLS or logins,
+// and hands over every message it's given. Recipients at reject.example are
+// refused.
+func startFakeSMTPServer(t *testing.T) (string, <-chan smtpMessage) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	messages := make(chan smtpMessage, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeSMTP(conn, messages)
+		}
+	}()
+	return ln.Addr().String(), messages
+}
+
+func serveFakeSMTP(conn net.Conn, messages chan<- smtpMessage) {
+	defer conn.Close()
+	c := textproto.NewConn(conn)
+	_ = c.PrintfLine("220 localhost fake SMTP")
+
+	var msg smtpMessage
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			_ = c.PrintfLine("250 localhost")
+		case "MAIL":
+			msg.From = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			_ = c.PrintfLine("250 OK")
+		case "RCPT":
+			msg.To = strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>")
+			if strings.HasSuffix(msg.To, "@reject.example") {
+				_ = c.PrintfLine("550 no such user")
+				continue
+			}
+			_ = c.PrintfLine("250 OK")
+		case "DATA":
+			_ = c.PrintfLine("354 go ahead")
+			data, err := c.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.Data = string(data)
+			messages <- msg
+			_ = c.PrintfLine("250 OK")
+		case "RSET":
+			msg = smtpMessage{}
+			_ = c.PrintfLine("250 OK")
+		case "QUIT":
+			_ = c.PrintfLine("221 bye")
+			return
+		default:
+			_ = c.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	addr, messages := startFakeSMTPServer(t)
+	notifier := NewSMTPNotifier(addr, "stop-loss@books.example", "", "")
+	notification := orderNotification(StopLossOrder{ID: "order-1", Security: "AAPL", Quantity: 10, StopPrice: 145}, OrderEventExecuted)
+
+	require.NoError(t, notifier.Notify(context.Background(), "alice@books.example", notification))
+	msg := <-messages
+	assert.Equal(t, "stop-loss@books.example", msg.From)
+	assert.Equal(t, "alice@books.example", msg.To)
+	assert.Contains(t, msg.Data, "To: alice@books.example\n")
+	assert.Contains(t, msg.Data, "Subject: Stop-loss executed: 10 AAPL\n")
+	assert.Contains(t, msg.Data, "Your stop-loss order order-1 for 10 AAPL at a stop of 145.00 has executed.")
+
+	assert.ErrorContains(t, notifier.Notify(context.Background(), "bob@reject.example", notification), "refused recipient bob@reject.example")
+
+	// a server that isn't there fails rather than hangs
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.ErrorContains(t, NewSMTPNotifier("127.0.0.1:1", "stop-loss@books.example", "", "").Notify(ctx, "alice@books.example", notification), "failed to connect")
+}
+
+func TestSMTPNotifierKeepsSubjectToOneLine(t *testing.T) {
+	notifier := NewSMTPNotifier("localhost:25", "stop-loss@books.example", "", "")
+	msg := string(notifier.message("alice@books.example", Notification{Subject: "AAPL\r\nBcc: mallory@evil.example", Body: "one\ntwo"}, time.Now()))
+	assert.Contains(t, msg, "Subject: AAPL  Bcc: mallory@evil.example\r\n")
+	assert.NotContains(t, msg, "\r\nBcc:")
+	assert.True(t, strings.HasSuffix(msg, "\r\n\r\none\r\ntwo\r\n"))
+}
+
+func TestChatNotifier(t *testing.T) {
+	status := http.StatusOK
+	var message chatMessage
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &message)
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+	notifier := NewChatNotifier()
+	notification := orderNotification(StopLossOrder{ID: "order-1", Security: "AAPL", Quantity: 10, StopPrice: 145, FailureReason: "broker unavailable"}, OrderEventFailed)
+
+	require.NoError(t, notifier.Notify(context.Background(), receiver.URL, notification))
+	assert.True(t, strings.HasPrefix(message.Text, "*Stop-loss failed: 10 AAPL*\n"))
+	assert.Contains(t, message.Text, "failed to execute: broker unavailable")
+
+	status = http.StatusInternalServerError
+	assert.ErrorContains(t, notifier.Notify(context.Background(), receiver.URL, notification), "500")
+}
+
+// recordingNotifier keeps the targets it's asked to notify, and fails for
+// the ones in fail.
+type recordingNotifier struct {
+	sent []string
+	fail map[string]bool
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, target string, notification Notification) error {
+	if n.fail[target] {
+		return errors.New("unreachable")
+	}
+	n.sent = append(n.sent, target)
+	return nil
+}
+
+func TestNotifyOrderActivity(t *testing.T) {
+	orders := NewOrdersRepoMemory()
+	_, err := orders.CreateOrder(StopLossOrder{ID: "order-1", AccountID: "acct-1", Security: "AAPL", Quantity: 10, StopPrice: 145, Status: OrderStatusExecuted})
+	require.NoError(t, err)
+	notifications := NewNotificationsRepoMemory()
+	at := time.Date(2025, 2, 1, 14, 30, 0, 0, time.UTC)
+	for _, preference := range []NotificationPreference{
+		{ID: "ntf-1", AccountID: "acct-1", Channel: NotificationChannelEmail, Target: "alice@books.example", Events: notificationEvents},
+		{ID: "ntf-2", AccountID: "acct-1", Channel: NotificationChannelChat, Target: "https://chat.example/hook", Events: notificationEvents},
+		{ID: "ntf-3", AccountID: "acct-1", Channel: NotificationChannelEmail, Target: "risk@books.example", Events: []string{OrderEventFailed}},
+		{ID: "ntf-4", AccountID: "acct-2", Channel: NotificationChannelEmail, Target: "carol@other.example", Events: notificationEvents},
+	} {
+		preference.CreatedAt = at
+		require.NoError(t, notifications.CreatePreference(preference))
+	}
+	email, chat := &recordingNotifier{}, &recordingNotifier{fail: map[string]bool{"https://chat.example/hook": true}}
+	activities := NewNotificationActivities(orders, notifications, map[string]Notifier{NotificationChannelEmail: email, NotificationChannelChat: chat})
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	// the chat webhook fails, so the activity does and is retried...
+	_, err = env.ExecuteActivity(activities.NotifyOrderActivity, "order-1", OrderEventExecuted)
+	assert.ErrorContains(t, err, "failed to send 1 executed notifications for order order-1")
+	assert.Equal(t, []string{"alice@books.example"}, email.sent)
+
+	// ...with the email it already sent in its heartbeat, so it isn't sent twice
+	chat.fail = nil
+	env.SetHeartbeatDetails([]string{"ntf-1"})
+	_, err = env.ExecuteActivity(activities.NotifyOrderActivity, "order-1", OrderEventExecuted)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice@books.example"}, email.sent)
+	assert.Equal(t, []string{"https://chat.example/hook"}, chat.sent)
+
+	// a channel nobody configured is skipped
+	activities = NewNotificationActivities(orders, notifications, map[string]Notifier{NotificationChannelChat: chat})
+	env = suite.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+	_, err = env.ExecuteActivity(activities.NotifyOrderActivity, "order-1", OrderEventFailed)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://chat.example/hook", "https://chat.example/hook"}, chat.sent)
+}
+
+func TestValidNotificationTarget(t *testing.T) {
+	assert.NoError(t, validNotificationTarget(NotificationChannelEmail, "alice@books.example"))
+	assert.NoError(t, validNotificationTarget(NotificationChannelChat, "https://hooks.slack.com/services/T0/B0/x"))
+	assert.Error(t, validNotificationTarget(NotificationChannelEmail, "Alice <alice@books.example>"))
+	assert.Error(t, validNotificationTarget(NotificationChannelEmail, "alice"))
+	assert.Error(t, validNotificationTarget(NotificationChannelChat, "alice@books.example"))
+	assert.Error(t, validNotificationTarget("sms", "+15550100"))
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// newOrderEvent builds an event, marshalling payload to JSON. A payload that
+// can't be marshalled is recorded as its error rather than losing the event.
+func newOrderEvent(orderID, eventType, source string, payload any, occurredAt time.Time) OrderEvent {
+	raw := json.RawMessage(`{}`)
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			b, _ = json.Marshal(map[string]string{"marshalError": err.Error()})
+		}
+		raw = b
+	}
+	return OrderEvent{
+		OrderID:    orderID,
+		Type:       eventType,
+		Source:     source,
+		Payload:    raw,
+		OccurredAt: occurredAt,
+	}
+}
+
+// OrderEventsRepoSQL keeps the audit log in the order_events table of either
+// SQL backend.
+type OrderEventsRepoSQL struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func NewOrderEventsRepoSQL(db *sql.DB, d dialect) *OrderEventsRepoSQL {
+	return &OrderEventsRepoSQL{
+		db:      db,
+		dialect: d,
+	}
+}
+
+func (r *OrderEventsRepoSQL) AppendEvent(event OrderEvent) error {
+	payload := event.Payload
+	if len(payload) == 0 {
+		payload = json.RawMessage(`{}`)
+	}
+	_, err := r.db.Exec(r.dialect.rebind(`
+		INSERT INTO order_events (order_id, event_type, source, payload, occurred_at)
+		VALUES (?, ?, ?, ?, ?)
+	`), event.OrderID, event.Type, event.Source, string(payload), event.OccurredAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to append %s event for order %s: %w", event.Type, event.OrderID, err)
+	}
+	return nil
+}
+
+func (r *OrderEventsRepoSQL) ListEventsForOrder(orderID string) ([]OrderEvent, error) {
+	rows, err := r.db.Query(r.dialect.rebind(`
+		SELECT id, order_id, event_type, source, payload, occurred_at
+		FROM order_events WHERE order_id = ? ORDER BY id
+	`), orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events for order %s: %w", orderID, err)
+	}
+	defer rows.Close()
+
+	var events []OrderEvent
+	for rows.Next() {
+		var event OrderEvent
+		var payload []byte
+		if err := rows.Scan(&event.ID, &event.OrderID, &event.Type, &event.Source, &payload, &event.OccurredAt); err != nil {
+			slog.Error("Failed to scan order event row", "error", err)
+			continue
+		}
+		event.Payload = json.RawMessage(payload)
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating order event rows: %w", err)
+	}
+	return events, nil
+}
+
+// Ensure OrderEventsRepoSQL implements OrderEventsRepo
+var _ OrderEventsRepo = (*OrderEventsRepoSQL)(nil)
+
+// OrderEventsRepoMemory is the audit log for the in-memory store.
+type OrderEventsRepoMemory struct {
+	mu     sync.RWMutex
+	events []OrderEvent
+}
+
+func NewOrderEventsRepoMemory() *OrderEventsRepoMemory {
+	return &OrderEventsRepoMemory{}
+}
+
+func (m *OrderEventsRepoMemory) AppendEvent(event OrderEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event.ID = int64(len(m.events) + 1)
+	if len(event.Payload) == 0 {
+		event.Payload = json.RawMessage(`{}`)
+	}
+	m.events = append(m.events, event)
+	return nil
+}
+
+func (m *OrderEventsRepoMemory) ListEventsForOrder(orderID string) ([]OrderEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var events []OrderEvent
+	for _, event := range m.events {
+		if event.OrderID == orderID {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// Ensure OrderEventsRepoMemory implements OrderEventsRepo
+var _ OrderEventsRepo = (*OrderEventsRepoMemory)(nil)
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testOrderEventsRepoContract(t *testing.T, repo OrderEventsRepo) {
+	at := time.Date(2025, 2, 1, 14, 30, 0, 0, time.UTC)
+
+	require.NoError(t, repo.AppendEvent(newOrderEvent("order-1", OrderEventCreated, EventSourceWeb, map[string]any{"stopPrice": 145.5}, at)))
+	require.NoError(t, repo.AppendEvent(newOrderEvent("order-2", OrderEventCreated, EventSourceWeb, nil, at)))
+	require.NoError(t, repo.AppendEvent(newOrderEvent("order-1", OrderEventCancelled, EventSourceAdmin, nil, at.Add(time.Minute))))
+
+	events, err := repo.ListEventsForOrder("order-1")
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+
+	assert.Equal(t, OrderEventCreated, events[0].Type)
+	assert.Equal(t, EventSourceWeb, events[0].Source)
+	assert.JSONEq(t, `{"stopPrice": 145.5}`, string(events[0].Payload))
+	assert.True(t, at.Equal(events[0].OccurredAt))
+
+	assert.Equal(t, OrderEventCancelled, events[1].Type)
+	assert.Equal(t, EventSourceAdmin, events[1].Source)
+	assert.JSONEq(t, `{}`, string(events[1].Payload))
+	assert.Less(t, events[0].ID, events[1].ID)
+
+	events, err = repo.ListEventsForOrder("nope")
+	require.NoError(t, err)
+	assert.Empty(t, events)
+}
+
+func TestOrderEventsRepoMemory(t *testing.T) {
+	testOrderEventsRepoContract(t, NewOrderEventsRepoMemory())
+}
+
+func TestOrderEventsRepoSQLite(t *testing.T) {
+	db := newTestSQLiteDB(t)
+	require.NoError(t, migrateDB(db, dialectSQLite))
+	repo := NewOrderEventsRepoSQL(db, dialectSQLite)
+
+	testOrderEventsRepoContract(t, repo)
+
+	// history can't be rewritten
+	_, err := db.Exec(`UPDATE order_events SET source = 'web'`)
+	assert.ErrorContains(t, err, "append-only")
+	_, err = db.Exec(`DELETE FROM order_events`)
+	assert.ErrorContains(t, err, "append-only")
+}
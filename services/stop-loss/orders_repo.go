@@ -4,10 +4,11 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"strings"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 type OrdersRepoSQLite struct {
@@ -20,25 +21,73 @@ func NewOrdersRepoSQLite(db *sql.DB) *OrdersRepoSQLite {
 	}
 }
 
+const sqliteOrderColumns = `id, security, stop_price, quantity, status, placed_at, workflow_id, COALESCE(idempotency_key, ''), COALESCE(failure_reason, ''), account_id`
+
 func (s *OrdersRepoSQLite) CreateOrder(order StopLossOrder) (StopLossOrder, error) {
-	_, err := s.db.Exec(`
-		INSERT INTO orders (id, security, stop_price, quantity, status, placed_at, workflow_id)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`, order.ID, order.Security, order.StopPrice, order.Quantity, order.Status, order.PlacedAt, order.WorkflowID)
+	return insertSQLiteOrder(s.db, order)
+}
+
+// AdmitOrder runs check and the insert in one transaction, whose first
+// statement takes SQLite's write lock: a concurrent admission waits for it
+// rather than reading the same stops.
+func (s *OrdersRepoSQLite) AdmitOrder(order StopLossOrder, check riskCheck) (StopLossOrder, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return StopLossOrder{}, fmt.Errorf("failed to admit order: %w", err)
+	}
+	defer tx.Rollback()
+
+	position, err := lockPosition(tx, dialectSQLite, order.AccountID, order.Security)
+	if err != nil {
+		return StopLossOrder{}, err
+	}
+	stops, err := querySQLiteOrders(tx, `SELECT `+sqliteOrderColumns+` FROM orders WHERE account_id = ? AND security = ?`, order.AccountID, order.Security)
+	if err != nil {
+		return StopLossOrder{}, err
+	}
+	if err := check(stops, position); err != nil {
+		return StopLossOrder{}, err
+	}
+	if _, err := insertSQLiteOrder(tx, order); err != nil {
+		return StopLossOrder{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return StopLossOrder{}, fmt.Errorf("failed to admit order: %w", err)
+	}
+	return order, nil
+}
+
+func insertSQLiteOrder(db sqlRunner, order StopLossOrder) (StopLossOrder, error) {
+	_, err := db.Exec(`
+		INSERT INTO orders (id, security, stop_price, quantity, status, placed_at, workflow_id, idempotency_key, account_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, order.ID, order.Security, order.StopPrice, order.Quantity, order.Status, order.PlacedAt, order.WorkflowID, nullString(order.IdempotencyKey), order.AccountID)
 	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique && strings.Contains(sqliteErr.Error(), "idempotency_key") {
+			return StopLossOrder{}, ErrDuplicateIdempotencyKey
+		}
 		return StopLossOrder{}, fmt.Errorf("failed to create order in database: %w", err)
 	}
 	return order, nil
 }
 
 func (s *OrdersRepoSQLite) GetOrder(orderID string) (StopLossOrder, error) {
-	row := s.db.QueryRow(`SELECT id, security, stop_price, quantity, status, placed_at, workflow_id FROM orders WHERE id = ?`, orderID)
+	return s.getOrderWhere(`id = ?`, orderID)
+}
+
+func (s *OrdersRepoSQLite) GetOrderByIdempotencyKey(key string) (StopLossOrder, error) {
+	return s.getOrderWhere(`idempotency_key = ?`, key)
+}
+
+func (s *OrdersRepoSQLite) getOrderWhere(where string, arg any) (StopLossOrder, error) {
+	row := s.db.QueryRow(`SELECT `+sqliteOrderColumns+` FROM orders WHERE `+where, arg)
 	var order StopLossOrder
 	var placedAt string // SQLite stores DATETIME as TEXT
-	err := row.Scan(&order.ID, &order.Security, &order.StopPrice, &order.Quantity, &order.Status, &placedAt, &order.WorkflowID)
+	err := row.Scan(&order.ID, &order.Security, &order.StopPrice, &order.Quantity, &order.Status, &placedAt, &order.WorkflowID, &order.IdempotencyKey, &order.FailureReason, &order.AccountID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return StopLossOrder{}, errors.New("order not found")
+			return StopLossOrder{}, ErrOrderNotFound
 		}
 		return StopLossOrder{}, fmt.Errorf("failed to get order from database: %w", err)
 	}
@@ -46,7 +95,7 @@ func (s *OrdersRepoSQLite) GetOrder(orderID string) (StopLossOrder, error) {
 	// Parse PlacedAt from string to time.Time
 	parseTime, err := time.Parse(time.RFC3339, placedAt)
 	if err != nil {
-		log.Printf("Error parsing placed_at from database: %v", err)
+		slog.Error("Failed to parse placed_at from database", "error", err)
 		return StopLossOrder{}, fmt.Errorf("error parsing placed_at: %w", err) // Or handle more gracefully if needed
 	}
 	order.PlacedAt = parseTime
@@ -67,51 +116,37 @@ func (s *OrdersRepoSQLite) CancelOrder(orderID string) error {
 		// Check if order exists, if not return "not found", otherwise "not pending"
 		_, err := s.GetOrder(orderID)
 		if err != nil {
-			return errors.New("order not found") // Or original error if you want to be more specific
+			return ErrOrderNotFound // Or original error if you want to be more specific
 		}
-		return errors.New("order is not pending and cannot be cancelled") // Order exists but not pending
+		return ErrOrderNotPending // Order exists but not pending
 	}
 	return nil
 }
 
 func (s *OrdersRepoSQLite) ListOrders() ([]StopLossOrder, error) {
-	rows, err := s.db.Query(`SELECT id, security, stop_price, quantity, status, placed_at, workflow_id FROM orders`)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list orders from database: %w", err)
-	}
-	defer rows.Close()
+	return s.queryOrders(`SELECT ` + sqliteOrderColumns + ` FROM orders`)
+}
 
-	var orders []StopLossOrder
-	for rows.Next() {
-		var order StopLossOrder
-		var placedAt string
-		err := rows.Scan(&order.ID, &order.Security, &order.StopPrice, &order.Quantity, &order.Status, &placedAt, &order.WorkflowID)
-		if err != nil {
-			return nil, fmt.Errorf("error scanning order row: %w", err)
-		}
-		// Parse PlacedAt from string to time.Time
-		parseTime, err := time.Parse(time.RFC3339, placedAt)
-		if err != nil {
-			log.Printf("Error parsing placed_at from database: %v", err) // Log and continue, or return error?
-			continue                                                     // Let's continue and log, for now
-		}
-		order.PlacedAt = parseTime
-		orders = append(orders, order)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating order rows: %w", err)
-	}
-	return orders, nil
+func (s *OrdersRepoSQLite) ListOrdersForAccount(accountID string) ([]StopLossOrder, error) {
+	return s.queryOrders(`SELECT `+sqliteOrderColumns+` FROM orders WHERE account_id = ?`, accountID)
 }
 
 func (s *OrdersRepoSQLite) UpdateOrderStatus(orderID string, status string) error {
-	_, err := s.db.Exec(`UPDATE orders SET status = ? WHERE id = ?`, status, orderID)
+	_, err := s.db.Exec(`UPDATE orders SET status = ?, failure_reason = NULL WHERE id = ?`, status, orderID)
 	if err != nil {
 		return fmt.Errorf("failed to update order status in database: %w", err)
 	}
 	return nil
 }
 
+func (s *OrdersRepoSQLite) MarkOrderFailed(orderID string, reason string) error {
+	_, err := s.db.Exec(`UPDATE orders SET status = ?, failure_reason = ? WHERE id = ?`, OrderStatusFailed, reason, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to mark order failed in database: %w", err)
+	}
+	return nil
+}
+
 func (s *OrdersRepoSQLite) AssociateWorkflowID(orderID string, workflowID string) error {
 	_, err := s.db.Exec(`UPDATE orders SET workflow_id = ? WHERE id = ?`, workflowID, orderID)
 	if err != nil {
@@ -121,7 +156,7 @@ func (s *OrdersRepoSQLite) AssociateWorkflowID(orderID string, workflowID string
 }
 
 func (s *OrdersRepoSQLite) GetPendingWorkflowIDsForSecurity(security string) ([]string, error) {
-	rows, err := s.db.Query(`SELECT workflow_id FROM orders WHERE security = ? AND workflow_id IS NOT NULL AND status is ?`, security, OrderStatusPending)
+	rows, err := s.db.Query(`SELECT workflow_id FROM orders WHERE security = ? AND workflow_id IS NOT NULL AND workflow_id != '' AND status is ?`, security, OrderStatusPending)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get workflow IDs for security from database: %w", err)
 	}
@@ -132,7 +167,7 @@ func (s *OrdersRepoSQLite) GetPendingWorkflowIDsForSecurity(security string) ([]
 		var workflowID string
 		err := rows.Scan(&workflowID)
 		if err != nil {
-			log.Printf("Error scanning workflow ID row: %v", err)
+			slog.Error("Failed to scan workflow ID row", "error", err)
 			continue // Log and continue, or return error?
 		}
 		workflowIDs = append(workflowIDs, workflowID)
@@ -143,10 +178,46 @@ func (s *OrdersRepoSQLite) GetPendingWorkflowIDsForSecurity(security string) ([]
 	return workflowIDs, nil
 }
 
-func (s *OrdersRepoSQLite) GetOrdersForSecurity(security string) ([]StopLossOrder, error) { // Added error return
-	rows, err := s.db.Query(`SELECT id, security, stop_price, quantity, status, placed_at, workflow_id FROM orders WHERE security = ?`, security)
+func (s *OrdersRepoSQLite) CountPendingOrdersBySecurity() (map[string]int, error) {
+	return countPendingOrdersBySecurity(s.db, `SELECT security, COUNT(*) FROM orders WHERE status = ? GROUP BY security`)
+}
+
+// countPendingOrdersBySecurity runs either backend's query for
+// CountPendingOrdersBySecurity.
+func countPendingOrdersBySecurity(db *sql.DB, query string) (map[string]int, error) {
+	rows, err := db.Query(query, OrderStatusPending)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get orders for security from database: %w", err)
+		return nil, fmt.Errorf("failed to count pending orders: %w", err)
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var security string
+		var count int
+		if err := rows.Scan(&security, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan pending order count: %w", err)
+		}
+		counts[security] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating pending order counts: %w", err)
+	}
+	return counts, nil
+}
+
+func (s *OrdersRepoSQLite) GetOrdersForSecurity(security string) ([]StopLossOrder, error) {
+	return s.queryOrders(`SELECT `+sqliteOrderColumns+` FROM orders WHERE security = ?`, security)
+}
+
+func (s *OrdersRepoSQLite) queryOrders(query string, args ...any) ([]StopLossOrder, error) {
+	return querySQLiteOrders(s.db, query, args...)
+}
+
+func querySQLiteOrders(db sqlRunner, query string, args ...any) ([]StopLossOrder, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list orders from database: %w", err)
 	}
 	defer rows.Close()
 
@@ -154,15 +225,15 @@ func (s *OrdersRepoSQLite) GetOrdersForSecurity(security string) ([]StopLossOrde
 	for rows.Next() {
 		var order StopLossOrder
 		var placedAt string
-		err := rows.Scan(&order.ID, &order.Security, &order.StopPrice, &order.Quantity, &order.Status, &placedAt, &order.WorkflowID)
+		err := rows.Scan(&order.ID, &order.Security, &order.StopPrice, &order.Quantity, &order.Status, &placedAt, &order.WorkflowID, &order.IdempotencyKey, &order.FailureReason, &order.AccountID)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning order row: %w", err)
 		}
 		// Parse PlacedAt from string to time.Time
 		parseTime, err := time.Parse(time.RFC3339, placedAt)
 		if err != nil {
-			log.Printf("Error parsing placed_at from database: %v", err)
-			continue // Log and continue
+			slog.Error("Failed to parse placed_at from database", "error", err)
+			continue // Let's continue and log, for now
 		}
 		order.PlacedAt = parseTime
 		orders = append(orders, order)
@@ -173,11 +244,28 @@ func (s *OrdersRepoSQLite) GetOrdersForSecurity(security string) ([]StopLossOrde
 	return orders, nil
 }
 
-// Ensure OrdersRepoSQLite implements OrdersRepo
-var _ OrdersRepo = (*OrdersRepoSQLite)(nil)
+// Ensure OrdersRepoSQLite implements OrdersRepo and OrderAdmitter
+var (
+	_ OrdersRepo    = (*OrdersRepoSQLite)(nil)
+	_ OrderAdmitter = (*OrdersRepoSQLite)(nil)
+)
+
+// sqlRunner is what *sql.DB and *sql.Tx have in common, so a query can run
+// inside a transaction or outside one.
+type sqlRunner interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
 
 // --- Utility Functions for SQLite ---
 
+// nullString stores an empty string as NULL, so optional unique columns only
+// collide when actually set.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
 func openSQLiteDB(dbFilePath string) (*sql.DB, error) {
 	db, err := sql.Open("sqlite3", dbFilePath)
 	if err != nil {
@@ -191,21 +279,3 @@ func openSQLiteDB(dbFilePath string) (*sql.DB, error) {
 
 	return db, nil
 }
-
-func createOrdersTable(db *sql.DB) error {
-	_, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS orders (
-			id TEXT PRIMARY KEY,
-			security TEXT NOT NULL,
-			stop_price REAL NOT NULL,
-			quantity INTEGER NOT NULL,
-			status TEXT NOT NULL,
-			placed_at DATETIME NOT NULL,
-			workflow_id TEXT
-		);
-	`)
-	if err != nil {
-		return fmt.Errorf("failed to create orders table: %w", err)
-	}
-	return nil
-}
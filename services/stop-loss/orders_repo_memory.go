@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// OrdersRepoMemory keeps orders in process memory. Everything is lost on
+// restart, so it's only meant for tests, demos and CI.
+type OrdersRepoMemory struct {
+	mu     sync.RWMutex
+	orders map[string]StopLossOrder
+	ids    []string // insertion order, so listings are stable
+}
+
+func NewOrdersRepoMemory() *OrdersRepoMemory {
+	return &OrdersRepoMemory{
+		orders: make(map[string]StopLossOrder),
+	}
+}
+
+func (m *OrdersRepoMemory) CreateOrder(order StopLossOrder) (StopLossOrder, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.orders[order.ID]; exists {
+		return StopLossOrder{}, fmt.Errorf("failed to create order in memory: order %s already exists", order.ID)
+	}
+	if order.IdempotencyKey != "" {
+		for _, existing := range m.orders {
+			if existing.IdempotencyKey == order.IdempotencyKey {
+				return StopLossOrder{}, ErrDuplicateIdempotencyKey
+			}
+		}
+	}
+	m.orders[order.ID] = order
+	m.ids = append(m.ids, order.ID)
+	return order, nil
+}
+
+func (m *OrdersRepoMemory) GetOrder(orderID string) (StopLossOrder, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	order, ok := m.orders[orderID]
+	if !ok {
+		return StopLossOrder{}, ErrOrderNotFound
+	}
+	return order, nil
+}
+
+func (m *OrdersRepoMemory) GetOrderByIdempotencyKey(key string) (StopLossOrder, error) {
+	orders := m.filter(func(o StopLossOrder) bool { return key != "" && o.IdempotencyKey == key })
+	if len(orders) == 0 {
+		return StopLossOrder{}, ErrOrderNotFound
+	}
+	return orders[0], nil
+}
+
+func (m *OrdersRepoMemory) CancelOrder(orderID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	order, ok := m.orders[orderID]
+	if !ok {
+		return ErrOrderNotFound
+	}
+	if order.Status != OrderStatusPending {
+		return ErrOrderNotPending
+	}
+	order.Status = OrderStatusCancelled
+	m.orders[orderID] = order
+	return nil
+}
+
+func (m *OrdersRepoMemory) ListOrders() ([]StopLossOrder, error) {
+	return m.filter(func(StopLossOrder) bool { return true }), nil
+}
+
+func (m *OrdersRepoMemory) ListOrdersForAccount(accountID string) ([]StopLossOrder, error) {
+	return m.filter(func(o StopLossOrder) bool { return o.AccountID == accountID }), nil
+}
+
+// UpdateOrderStatus is a no-op for unknown orders, matching the SQL UPDATE.
+func (m *OrdersRepoMemory) UpdateOrderStatus(orderID string, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if order, ok := m.orders[orderID]; ok {
+		order.Status = status
+		order.FailureReason = ""
+		m.orders[orderID] = order
+	}
+	return nil
+}
+
+func (m *OrdersRepoMemory) MarkOrderFailed(orderID string, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if order, ok := m.orders[orderID]; ok {
+		order.Status = OrderStatusFailed
+		order.FailureReason = reason
+		m.orders[orderID] = order
+	}
+	return nil
+}
+
+func (m *OrdersRepoMemory) AssociateWorkflowID(orderID string, workflowID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if order, ok := m.orders[orderID]; ok {
+		order.WorkflowID = workflowID
+		m.orders[orderID] = order
+	}
+	return nil
+}
+
+func (m *OrdersRepoMemory) GetPendingWorkflowIDsForSecurity(security string) ([]string, error) {
+	orders := m.filter(func(o StopLossOrder) bool {
+		return o.Security == security && o.Status == OrderStatusPending && o.WorkflowID != ""
+	})
+
+	var workflowIDs []string
+	for _, order := range orders {
+		workflowIDs = append(workflowIDs, order.WorkflowID)
+	}
+	return workflowIDs, nil
+}
+
+func (m *OrdersRepoMemory) CountPendingOrdersBySecurity() (map[string]int, error) {
+	counts := map[string]int{}
+	for _, order := range m.filter(func(o StopLossOrder) bool { return o.Status == OrderStatusPending }) {
+		counts[order.Security]++
+	}
+	return counts, nil
+}
+
+func (m *OrdersRepoMemory) GetOrdersForSecurity(security string) ([]StopLossOrder, error) {
+	return m.filter(func(o StopLossOrder) bool { return o.Security == security }), nil
+}
+
+func (m *OrdersRepoMemory) filter(keep func(StopLossOrder) bool) []StopLossOrder {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var orders []StopLossOrder
+	for _, id := range m.ids {
+		if order := m.orders[id]; keep(order) {
+			orders = append(orders, order)
+		}
+	}
+	return orders
+}
+
+// Ensure OrdersRepoMemory implements OrdersRepo
+var _ OrdersRepo = (*OrdersRepoMemory)(nil)
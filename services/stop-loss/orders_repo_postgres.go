@@ -0,0 +1,228 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// OrdersRepoPostgres stores orders in PostgreSQL so several stop-loss
+// replicas can share one order store.
+type OrdersRepoPostgres struct {
+	db *sql.DB
+}
+
+func NewOrdersRepoPostgres(db *sql.DB) *OrdersRepoPostgres {
+	return &OrdersRepoPostgres{
+		db: db,
+	}
+}
+
+const postgresOrderColumns = `id, security, stop_price, quantity, status, placed_at, COALESCE(workflow_id, ''), COALESCE(idempotency_key, ''), COALESCE(failure_reason, ''), account_id`
+
+func (p *OrdersRepoPostgres) CreateOrder(order StopLossOrder) (StopLossOrder, error) {
+	return insertPostgresOrder(p.db, order)
+}
+
+// AdmitOrder runs check and the insert in one transaction holding the lock
+// on the account's position in the order's security, so replicas admitting
+// orders against the same position take turns.
+func (p *OrdersRepoPostgres) AdmitOrder(order StopLossOrder, check riskCheck) (StopLossOrder, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return StopLossOrder{}, fmt.Errorf("failed to admit order: %w", err)
+	}
+	defer tx.Rollback()
+
+	position, err := lockPosition(tx, dialectPostgres, order.AccountID, order.Security)
+	if err != nil {
+		return StopLossOrder{}, err
+	}
+	stops, err := queryPostgresOrders(tx, `SELECT `+postgresOrderColumns+` FROM orders WHERE account_id = $1 AND security = $2 ORDER BY placed_at, id`, order.AccountID, order.Security)
+	if err != nil {
+		return StopLossOrder{}, err
+	}
+	if err := check(stops, position); err != nil {
+		return StopLossOrder{}, err
+	}
+	if _, err := insertPostgresOrder(tx, order); err != nil {
+		return StopLossOrder{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return StopLossOrder{}, fmt.Errorf("failed to admit order: %w", err)
+	}
+	return order, nil
+}
+
+func insertPostgresOrder(db sqlRunner, order StopLossOrder) (StopLossOrder, error) {
+	_, err := db.Exec(`
+		INSERT INTO orders (id, security, stop_price, quantity, status, placed_at, workflow_id, idempotency_key, account_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, order.ID, order.Security, order.StopPrice, order.Quantity, order.Status, order.PlacedAt, order.WorkflowID, nullString(order.IdempotencyKey), order.AccountID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "orders_idempotency_key_idx" {
+			return StopLossOrder{}, ErrDuplicateIdempotencyKey
+		}
+		return StopLossOrder{}, fmt.Errorf("failed to create order in database: %w", err)
+	}
+	return order, nil
+}
+
+func (p *OrdersRepoPostgres) GetOrder(orderID string) (StopLossOrder, error) {
+	return p.getOrderWhere(`id = $1`, orderID)
+}
+
+func (p *OrdersRepoPostgres) GetOrderByIdempotencyKey(key string) (StopLossOrder, error) {
+	return p.getOrderWhere(`idempotency_key = $1`, key)
+}
+
+func (p *OrdersRepoPostgres) getOrderWhere(where string, arg any) (StopLossOrder, error) {
+	row := p.db.QueryRow(`SELECT `+postgresOrderColumns+` FROM orders WHERE `+where, arg)
+	var order StopLossOrder
+	err := row.Scan(&order.ID, &order.Security, &order.StopPrice, &order.Quantity, &order.Status, &order.PlacedAt, &order.WorkflowID, &order.IdempotencyKey, &order.FailureReason, &order.AccountID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return StopLossOrder{}, ErrOrderNotFound
+		}
+		return StopLossOrder{}, fmt.Errorf("failed to get order from database: %w", err)
+	}
+	return order, nil
+}
+
+func (p *OrdersRepoPostgres) CancelOrder(orderID string) error {
+	res, err := p.db.Exec(`UPDATE orders SET status = $1 WHERE id = $2 AND status = $3`, OrderStatusCancelled, orderID, OrderStatusPending)
+	if err != nil {
+		return fmt.Errorf("failed to cancel order in database: %w", err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows on cancel: %w", err)
+	}
+	if rowsAffected == 0 {
+		if _, err := p.GetOrder(orderID); err != nil {
+			return ErrOrderNotFound
+		}
+		return ErrOrderNotPending
+	}
+	return nil
+}
+
+func (p *OrdersRepoPostgres) ListOrders() ([]StopLossOrder, error) {
+	return p.queryOrders(`SELECT ` + postgresOrderColumns + ` FROM orders ORDER BY placed_at, id`)
+}
+
+func (p *OrdersRepoPostgres) ListOrdersForAccount(accountID string) ([]StopLossOrder, error) {
+	return p.queryOrders(`SELECT `+postgresOrderColumns+` FROM orders WHERE account_id = $1 ORDER BY placed_at, id`, accountID)
+}
+
+func (p *OrdersRepoPostgres) UpdateOrderStatus(orderID string, status string) error {
+	_, err := p.db.Exec(`UPDATE orders SET status = $1, failure_reason = NULL WHERE id = $2`, status, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to update order status in database: %w", err)
+	}
+	return nil
+}
+
+func (p *OrdersRepoPostgres) MarkOrderFailed(orderID string, reason string) error {
+	_, err := p.db.Exec(`UPDATE orders SET status = $1, failure_reason = $2 WHERE id = $3`, OrderStatusFailed, reason, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to mark order failed in database: %w", err)
+	}
+	return nil
+}
+
+func (p *OrdersRepoPostgres) AssociateWorkflowID(orderID string, workflowID string) error {
+	_, err := p.db.Exec(`UPDATE orders SET workflow_id = $1 WHERE id = $2`, workflowID, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to associate workflow ID in database: %w", err)
+	}
+	return nil
+}
+
+func (p *OrdersRepoPostgres) GetPendingWorkflowIDsForSecurity(security string) ([]string, error) {
+	rows, err := p.db.Query(`SELECT workflow_id FROM orders WHERE security = $1 AND status = $2 AND workflow_id IS NOT NULL AND workflow_id <> ''`, security, OrderStatusPending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workflow IDs for security from database: %w", err)
+	}
+	defer rows.Close()
+
+	var workflowIDs []string
+	for rows.Next() {
+		var workflowID string
+		if err := rows.Scan(&workflowID); err != nil {
+			slog.Error("Failed to scan workflow ID row", "error", err)
+			continue
+		}
+		workflowIDs = append(workflowIDs, workflowID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating workflow ID rows: %w", err)
+	}
+	return workflowIDs, nil
+}
+
+func (p *OrdersRepoPostgres) CountPendingOrdersBySecurity() (map[string]int, error) {
+	return countPendingOrdersBySecurity(p.db, `SELECT security, COUNT(*) FROM orders WHERE status = $1 GROUP BY security`)
+}
+
+func (p *OrdersRepoPostgres) GetOrdersForSecurity(security string) ([]StopLossOrder, error) {
+	return p.queryOrders(`SELECT `+postgresOrderColumns+` FROM orders WHERE security = $1 ORDER BY placed_at, id`, security)
+}
+
+func (p *OrdersRepoPostgres) queryOrders(query string, args ...any) ([]StopLossOrder, error) {
+	return queryPostgresOrders(p.db, query, args...)
+}
+
+func queryPostgresOrders(db sqlRunner, query string, args ...any) ([]StopLossOrder, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list orders from database: %w", err)
+	}
+	defer rows.Close()
+
+	var orders []StopLossOrder
+	for rows.Next() {
+		var order StopLossOrder
+		err := rows.Scan(&order.ID, &order.Security, &order.StopPrice, &order.Quantity, &order.Status, &order.PlacedAt, &order.WorkflowID, &order.IdempotencyKey, &order.FailureReason, &order.AccountID)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning order row: %w", err)
+		}
+		orders = append(orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating order rows: %w", err)
+	}
+	return orders, nil
+}
+
+// Ensure OrdersRepoPostgres implements OrdersRepo and OrderAdmitter
+var (
+	_ OrdersRepo    = (*OrdersRepoPostgres)(nil)
+	_ OrderAdmitter = (*OrdersRepoPostgres)(nil)
+)
+
+// --- Utility Functions for PostgreSQL ---
+
+func openPostgresDB(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	db.SetMaxOpenConns(20)
+	db.SetMaxIdleConns(5)
+	db.SetConnMaxLifetime(time.Hour)
+
+	// sql.Open is lazy, make sure the DSN actually works before carrying on
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	return db, nil
+}
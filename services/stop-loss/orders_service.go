@@ -2,47 +2,429 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"sync"
+	"time"
 
 	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/api/workflowservice/v1"
 	"go.temporal.io/sdk/client"
 )
 
 type ordersService struct {
 	temporalClient client.Client
 	repo           OrdersRepo
+	eventsRepo     OrderEventsRepo
+	haltsRepo      HaltsRepo
+	positionsRepo  PositionsRepo
+	webhooks       *WebhookPublisher
+	admitter       OrderAdmitter
 }
 
-func NewOrdersService(client client.Client, repo OrdersRepo) OrderWorkflowService {
+func NewOrdersService(client client.Client, repo OrdersRepo, eventsRepo OrderEventsRepo, haltsRepo HaltsRepo, positionsRepo PositionsRepo, webhooks *WebhookPublisher) OrderWorkflowService {
 	return &ordersService{
 		temporalClient: client,
 		repo:           repo,
+		eventsRepo:     eventsRepo,
+		haltsRepo:      haltsRepo,
+		positionsRepo:  positionsRepo,
+		webhooks:       webhooks,
+		admitter:       admitterFor(repo, positionsRepo),
 	}
 }
 
-func (os *ordersService) CreateOrder(ctx context.Context, order StopLossOrder) error {
-	workflowOptions := client.StartWorkflowOptions{
-		ID:                    fmt.Sprintf("stop-loss-workflow-%s", order.ID),
-		TaskQueue:             "stop-loss-task-queue",
-		WorkflowIDReusePolicy: enums.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE,
+// admitterFor admits orders through repo if it can do it in a transaction,
+// and otherwise one at a time within this process, which is enough for the
+// memory store: nothing else shares it.
+func admitterFor(repo OrdersRepo, positionsRepo PositionsRepo) OrderAdmitter {
+	if admitter, ok := repo.(OrderAdmitter); ok {
+		return admitter
 	}
+	return &lockedOrderAdmitter{orders: repo, positions: positionsRepo}
+}
+
+type lockedOrderAdmitter struct {
+	mu        sync.Mutex // held from the risk check until the order is stored
+	orders    OrdersRepo
+	positions PositionsRepo
+}
+
+func (l *lockedOrderAdmitter) AdmitOrder(order StopLossOrder, check riskCheck) (StopLossOrder, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	workflowRun, err := os.temporalClient.ExecuteWorkflow(ctx, workflowOptions, StopLossWorkflow, order)
+	existing, err := l.orders.GetOrdersForSecurity(order.Security)
 	if err != nil {
-		log.Printf("Failed to start StopLossWorkflow for order %s: %v", order.ID, err)
-		return err
+		return StopLossOrder{}, fmt.Errorf("failed to load open stops on %s: %w", order.Security, err)
+	}
+	var stops []StopLossOrder
+	for _, o := range existing {
+		if o.AccountID == order.AccountID {
+			stops = append(stops, o)
+		}
+	}
+	var held *Position
+	position, err := l.positions.GetPosition(order.AccountID, order.Security)
+	if err == nil {
+		held = &position
+	} else if !errors.Is(err, ErrNoPosition) {
+		return StopLossOrder{}, err
+	}
+	if err := check(stops, held); err != nil {
+		return StopLossOrder{}, err
+	}
+	return l.orders.CreateOrder(order)
+}
+
+// CreateOrder writes the order row first and only then starts its workflow,
+// so by the time the caller hears back the order is visible everywhere. A
+// repeated idempotency key returns the order it first created, without
+// checking it against the risk limits again. Keys are unique across
+// accounts, so one another account used is ErrDuplicateIdempotencyKey.
+// Orders placed without an account go to the default one. Every entry point
+// comes through here, so this is where an order that would throw off the
+// risk limits, such as one with a negative quantity, is turned away.
+func (os *ordersService) CreateOrder(ctx context.Context, order StopLossOrder, source string) (StopLossOrder, bool, error) {
+	if order.Security == "" || order.StopPrice <= 0 || order.Quantity <= 0 {
+		return StopLossOrder{}, false, ErrInvalidOrder
+	}
+	if order.AccountID == "" {
+		order.AccountID = DefaultAccountID
+	}
+	if order.IdempotencyKey != "" {
+		existing, err := os.repo.GetOrderByIdempotencyKey(order.IdempotencyKey)
+		if err == nil {
+			return os.replayOrder(ctx, existing, order.AccountID)
+		}
+		if !errors.Is(err, ErrOrderNotFound) {
+			return StopLossOrder{}, false, fmt.Errorf("failed to look up idempotency key: %w", err)
+		}
+	}
+
+	orderID, err := newOrderID()
+	if err != nil {
+		return StopLossOrder{}, false, err
+	}
+	order.ID = orderID
+	order.WorkflowID = stopLossWorkflowID(orderID)
+	order.Status = OrderStatusPending
+	order.PlacedAt = time.Now().UTC()
+
+	saved, err := os.acceptOrder(ctx, order)
+	if errors.Is(err, ErrDuplicateIdempotencyKey) {
+		// a concurrent submission with the same key got there first
+		existing, err := os.repo.GetOrderByIdempotencyKey(order.IdempotencyKey)
+		if err != nil {
+			return StopLossOrder{}, false, fmt.Errorf("failed to look up idempotency key: %w", err)
+		}
+		return os.replayOrder(ctx, existing, order.AccountID)
+	}
+	if err != nil {
+		return StopLossOrder{}, false, err
+	}
+
+	event := newOrderEvent(saved.ID, OrderEventCreated, source, saved, saved.PlacedAt)
+	if err := os.eventsRepo.AppendEvent(event); err != nil {
+		slog.ErrorContext(ctx, "Failed to record created event", "orderID", saved.ID, "error", err)
+	}
+	webhookErr := os.webhooks.PublishOrDeadLetter(ctx, event)
+
+	// if this fails the row stays PENDING without a workflow; retrying with
+	// the same key, or the reconciler, starts it later
+	if err := os.startWorkflow(ctx, saved); err != nil {
+		return saved, true, err
+	}
+	// the order is watched either way, but the caller hears that its
+	// webhooks weren't sent
+	if webhookErr != nil {
+		return saved, true, fmt.Errorf("order %s was placed, but its created webhooks couldn't be published: %w", saved.ID, webhookErr)
+	}
+	return saved, true, nil
+}
+
+// acceptOrder stores the order if the risk limits allow it, or returns a
+// *RiskRejection. Only the account's own stops draw on its position.
+func (os *ordersService) acceptOrder(ctx context.Context, order StopLossOrder) (StopLossOrder, error) {
+	return os.admitter.AdmitOrder(order, func(stops []StopLossOrder, position *Position) error {
+		if err := checkRisk(riskLimits, order, stops, position); err != nil {
+			slog.InfoContext(ctx, "Rejected stop-loss", "accountID", order.AccountID, "security", order.Security, "quantity", order.Quantity, "stopPrice", order.StopPrice, "reason", err)
+			return err
+		}
+		return nil
+	})
+}
+
+// replayOrder answers a repeated idempotency key with the order it created,
+// if the same account is asking.
+func (os *ordersService) replayOrder(ctx context.Context, existing StopLossOrder, accountID string) (StopLossOrder, bool, error) {
+	if existing.AccountID != accountID {
+		return StopLossOrder{}, false, ErrDuplicateIdempotencyKey
 	}
-	log.Printf("Started workflow for order ID: %s, WorkflowID: %s, RunID: %s", order.ID, workflowRun.GetID(), workflowRun.GetRunID())
+	return existing, false, os.resumeOrder(ctx, existing)
+}
+
+// resumeOrder makes sure a replayed order's workflow was started, in case the
+// first attempt stored the row but failed before starting it.
+func (os *ordersService) resumeOrder(ctx context.Context, order StopLossOrder) error {
+	if order.Status != OrderStatusPending {
+		return nil
+	}
+	return os.startWorkflow(ctx, order)
+}
 
+func (os *ordersService) startWorkflow(ctx context.Context, order StopLossOrder) error {
+	err := startStopLossWorkflow(ctx, os.temporalClient, order)
+	if isWorkflowAlreadyStarted(err) {
+		return nil
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to start StopLossWorkflow", "orderID", order.ID, "workflowID", order.WorkflowID, "error", err)
+		return err
+	}
 	return nil
 }
 
-func (os *ordersService) CancelOrder(ctx context.Context, workflowID string) error {
-	err := os.temporalClient.SignalWorkflow(ctx, workflowID, "", CancelOrderSignalName, nil)
+// CancelOrder leaves the decision to the order's workflow, the only thing
+// that knows whether execution has already begun.
+func (os *ordersService) CancelOrder(ctx context.Context, orderID string, source string) (string, error) {
+	order, err := os.repo.GetOrder(orderID)
+	if err != nil {
+		return "", err
+	}
+	if result, closed := cancelResultForClosedOrder(order); closed {
+		return result, nil
+	}
+
+	handle, err := os.temporalClient.UpdateWorkflow(ctx, client.UpdateWorkflowOptions{
+		WorkflowID:   order.WorkflowID,
+		UpdateName:   CancelOrderUpdateName,
+		Args:         []interface{}{CancelOrderRequest{Source: source}},
+		WaitForStage: client.WorkflowUpdateStageCompleted,
+	})
+	var result string
+	if err == nil {
+		err = handle.Get(ctx, &result)
+	}
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		// the workflow finished after the row was read, the row has caught up by now
+		if order, getErr := os.repo.GetOrder(orderID); getErr == nil {
+			if result, closed := cancelResultForClosedOrder(order); closed {
+				return result, nil
+			}
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to cancel order %s: %w", orderID, err)
+	}
+	return result, nil
+}
+
+// RedriveOrder uses signal-with-start: a FAILED order's workflow is normally
+// still running and just gets the signal, but if it's gone a new run picks the
+// order up from its row and handles the signal first.
+func (os *ordersService) RedriveOrder(ctx context.Context, orderID string, action string, source string) error {
+	if action != FailureActionRetry && action != FailureActionRearm {
+		return fmt.Errorf("unknown re-drive action %q", action)
+	}
+	order, err := os.repo.GetOrder(orderID)
 	if err != nil {
 		return err
 	}
+	if order.Status != OrderStatusFailed {
+		return ErrOrderNotFailed
+	}
+
+	workflowOptions := stopLossWorkflowOptions(order)
+	// the order's ID has been used, starting it again is the point here
+	workflowOptions.WorkflowIDReusePolicy = enums.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE
+	workflowOptions.WorkflowIDConflictPolicy = enums.WORKFLOW_ID_CONFLICT_POLICY_USE_EXISTING
+	workflowOptions.WorkflowExecutionErrorWhenAlreadyStarted = false
+
+	workflowRun, err := os.temporalClient.SignalWithStartWorkflow(ctx, order.WorkflowID, RedriveSignalName, RedriveRequest{Action: action, Source: source},
+		workflowOptions, StopLossWorkflow, order, newStopLossRun())
+	if err != nil {
+		return fmt.Errorf("failed to re-drive order %s: %w", orderID, err)
+	}
+	slog.InfoContext(ctx, "Re-drove order", "orderID", orderID, "action", action, "workflowID", workflowRun.GetID(), "runID", workflowRun.GetRunID())
+	return nil
+}
+
+func (os *ordersService) LiveState(ctx context.Context, orderID string) (OrderLiveState, error) {
+	order, err := os.repo.GetOrder(orderID)
+	if err != nil {
+		return OrderLiveState{}, err
+	}
+
+	value, err := os.temporalClient.QueryWorkflow(ctx, order.WorkflowID, "", OrderStateQueryName)
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		return OrderLiveState{}, ErrOrderNotLive
+	}
+	if err != nil {
+		return OrderLiveState{}, fmt.Errorf("failed to query workflow for order %s: %w", orderID, err)
+	}
+	var state OrderLiveState
+	if err := value.Get(&state); err != nil {
+		return OrderLiveState{}, fmt.Errorf("failed to decode state of order %s: %w", orderID, err)
+	}
+	return state, nil
+}
+
+func (os *ordersService) SearchOrders(ctx context.Context, search OrderSearch) ([]OrderSearchResult, error) {
+	request := &workflowservice.ListWorkflowExecutionsRequest{Query: search.visibilityQuery()}
+	results := []OrderSearchResult{}
+	for {
+		response, err := os.temporalClient.ListWorkflow(ctx, request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search orders: %w", err)
+		}
+		for _, execution := range response.GetExecutions() {
+			result, err := orderSearchResult(execution)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, result)
+		}
+		if len(response.GetNextPageToken()) == 0 {
+			return results, nil
+		}
+		request.NextPageToken = response.GetNextPageToken()
+	}
+}
+
+// HaltExecutions only records the halt. Each order's workflow looks for one
+// before it executes, so orders already executing finish.
+func (os *ordersService) HaltExecutions(ctx context.Context, security string, reason string, source string) (Halt, error) {
+	halt := Halt{Security: security, Reason: reason, Source: source, HaltedAt: time.Now().UTC()}
+	if err := os.haltsRepo.SetHalt(halt); err != nil {
+		return Halt{}, err
+	}
+	slog.InfoContext(ctx, "Halted executions", "scope", haltScope(security), "source", source, "reason", reason)
+	return halt, nil
+}
+
+// ResumeExecutions lifts the halt first, so an order told to resume finds
+// it gone; an order still covered by another halt queues again.
+func (os *ordersService) ResumeExecutions(ctx context.Context, security string, source string) (int, error) {
+	if err := os.haltsRepo.ClearHalt(security); err != nil {
+		return 0, err
+	}
+	slog.InfoContext(ctx, "Resumed executions", "scope", haltScope(security), "source", source)
+
+	orders, err := os.repo.ListOrders()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list orders to resume: %w", err)
+	}
+	resumed := 0
+	for _, order := range orders {
+		// only these have a workflow that can be holding a trigger
+		open := order.Status == OrderStatusPending || order.Status == OrderStatusFailed
+		if !open || (security != "" && order.Security != security) {
+			continue
+		}
+		err := os.temporalClient.SignalWorkflow(ctx, order.WorkflowID, "", ResumeSignalName, ResumeRequest{Action: haltResumeAction, Source: source})
+		var notFound *serviceerror.NotFound
+		if errors.As(err, &notFound) {
+			continue // finished since the orders were listed
+		}
+		if err != nil {
+			return resumed, fmt.Errorf("failed to resume order %s: %w", order.ID, err)
+		}
+		resumed++
+	}
+	return resumed, nil
+}
+
+func (os *ordersService) ListHalts(ctx context.Context) ([]Halt, error) {
+	return os.haltsRepo.ListHalts()
+}
+
+func (os *ordersService) Reconcile(ctx context.Context, repair bool) (ReconcileReport, error) {
+	return NewReconciler(os.temporalClient, os.repo, os.eventsRepo, os.webhooks).Reconcile(ctx, repair)
+}
+
+func cancelResultForClosedOrder(order StopLossOrder) (string, bool) {
+	switch order.Status {
+	case OrderStatusExecuted:
+		return CancelResultAlreadyExecuted, true
+	case OrderStatusCancelled:
+		return CancelResultCancelled, true
+	}
+	return "", false
+}
+
+// continueAsNewPolicy and executionFailurePolicy are given to every
+// StopLossWorkflow started from now on, and haltResumeAction to every order
+// resumed; main overrides them from the environment.
+var (
+	continueAsNewPolicy = ContinueAsNewPolicy{
+		AfterSignals:       2000,
+		AfterHistoryEvents: 10000, // Temporal starts warning at 10k events, and fails runs at 50k
+	}
+	executionFailurePolicy = ExecutionFailurePolicy{
+		Action:     FailureActionEscalate,
+		RetryDelay: time.Minute,
+		MaxRetries: 3,
+	}
+	// a bad feed is the usual reason to halt, and its prices may be what
+	// triggered the queued orders
+	haltResumeAction = ResumeActionReevaluate
+)
+
+func newStopLossRun() StopLossRun {
+	return StopLossRun{ContinueAsNew: continueAsNewPolicy, OnFailure: executionFailurePolicy}
+}
 
+// startStopLossWorkflow starts the order's workflow under its fixed ID. An
+// ID can only ever be used once, so a retried request can't start a second
+// workflow for the same order; that case comes back as an error
+// isWorkflowAlreadyStarted recognises.
+func startStopLossWorkflow(ctx context.Context, temporalClient client.Client, order StopLossOrder) error {
+	workflowRun, err := temporalClient.ExecuteWorkflow(ctx, stopLossWorkflowOptions(order), StopLossWorkflow, order, newStopLossRun())
+	if err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Started workflow", "orderID", order.ID, "security", order.Security, "workflowID", workflowRun.GetID(), "runID", workflowRun.GetRunID())
 	return nil
 }
+
+func stopLossWorkflowOptions(order StopLossOrder) client.StartWorkflowOptions {
+	return client.StartWorkflowOptions{
+		ID:                                       order.WorkflowID,
+		TaskQueue:                                "stop-loss-task-queue",
+		WorkflowIDReusePolicy:                    enums.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE,
+		WorkflowIDConflictPolicy:                 enums.WORKFLOW_ID_CONFLICT_POLICY_FAIL,
+		WorkflowExecutionErrorWhenAlreadyStarted: true,
+	}
+}
+
+func isWorkflowAlreadyStarted(err error) bool {
+	var alreadyStarted *serviceerror.WorkflowExecutionAlreadyStarted
+	return errors.As(err, &alreadyStarted)
+}
+
+func stopLossWorkflowID(orderID string) string {
+	return fmt.Sprintf("stop-loss-workflow-%s", orderID)
+}
+
+func newOrderID() (string, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate order ID: %w", err)
+	}
+	return "order-" + id, nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/api/common/v1"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/mocks"
+)
+
+func newTestOrdersService(t *testing.T) (OrderWorkflowService, *mocks.Client, *OrdersRepoMemory, *OrderEventsRepoMemory) {
+	temporalClient := mocks.NewClient(t)
+	orders := NewOrdersRepoMemory()
+	events := NewOrderEventsRepoMemory()
+	// enough AAPL held that the risk limits never get in the way
+	positions := NewPositionsRepoMemory()
+	require.NoError(t, positions.SetPosition(Position{AccountID: DefaultAccountID, Security: "AAPL", Quantity: 1000}))
+	return NewOrdersService(temporalClient, orders, events, NewHaltsRepoMemory(), positions, NewWebhookPublisher(temporalClient, orders, NewWebhooksRepoMemory())), temporalClient, orders, events
+}
+
+func mockWorkflowRun(t *testing.T) *mocks.WorkflowRun {
+	run := mocks.NewWorkflowRun(t)
+	run.On("GetID").Return("wf").Maybe()
+	run.On("GetRunID").Return("run").Maybe()
+	return run
+}
+
+func TestOrdersServiceCreateOrder(t *testing.T) {
+	service, temporalClient, orders, events := newTestOrdersService(t)
+
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			options := args.Get(1).(client.StartWorkflowOptions)
+			order := args.Get(3).(StopLossOrder)
+			assert.Equal(t, stopLossWorkflowID(order.ID), options.ID)
+			assert.Equal(t, enums.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE, options.WorkflowIDReusePolicy)
+			assert.True(t, options.WorkflowExecutionErrorWhenAlreadyStarted)
+			assert.Equal(t, newStopLossRun(), args.Get(4))
+
+			// the row is in place before the workflow exists
+			stored, err := orders.GetOrder(order.ID)
+			require.NoError(t, err)
+			assert.Equal(t, options.ID, stored.WorkflowID)
+		}).
+		Return(mockWorkflowRun(t), nil).Once()
+
+	saved, created, err := service.CreateOrder(context.Background(), StopLossOrder{Security: "AAPL", StopPrice: 145, Quantity: 10}, EventSourceAPI)
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Regexp(t, `^order-[0-9a-f]{16}$`, saved.ID)
+	assert.Equal(t, OrderStatusPending, saved.Status)
+	assert.False(t, saved.PlacedAt.IsZero())
+
+	recorded, err := events.ListEventsForOrder(saved.ID)
+	require.NoError(t, err)
+	require.Len(t, recorded, 1)
+	assert.Equal(t, OrderEventCreated, recorded[0].Type)
+	assert.Equal(t, EventSourceAPI, recorded[0].Source)
+}
+
+func TestOrdersServiceCreateOrderReplaysIdempotencyKey(t *testing.T) {
+	service, temporalClient, orders, events := newTestOrdersService(t)
+
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(mockWorkflowRun(t), nil).Once()
+	// the retry checks the workflow is running; Temporal refuses to start a second one
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil, serviceerror.NewWorkflowExecutionAlreadyStarted("already started", "", "run")).Once()
+
+	order := StopLossOrder{Security: "AAPL", StopPrice: 145, Quantity: 10, IdempotencyKey: "key-1"}
+	first, created, err := service.CreateOrder(context.Background(), order, EventSourceWeb)
+	require.NoError(t, err)
+	require.True(t, created)
+
+	second, created, err := service.CreateOrder(context.Background(), order, EventSourceWeb)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, first.ID, second.ID)
+
+	all, err := orders.ListOrders()
+	require.NoError(t, err)
+	assert.Len(t, all, 1)
+	recorded, err := events.ListEventsForOrder(first.ID)
+	require.NoError(t, err)
+	assert.Len(t, recorded, 1)
+
+	// once the order is closed a replay doesn't go near Temporal
+	require.NoError(t, orders.UpdateOrderStatus(first.ID, OrderStatusExecuted))
+	third, created, err := service.CreateOrder(context.Background(), order, EventSourceWeb)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, first.ID, third.ID)
+	assert.Equal(t, DefaultAccountID, third.AccountID)
+
+	// keys are unique across accounts, and another's order isn't given away
+	order.AccountID = "acct-2"
+	_, _, err = service.CreateOrder(context.Background(), order, EventSourceWeb)
+	assert.ErrorIs(t, err, ErrDuplicateIdempotencyKey)
+}
+
+func TestOrdersServiceCreateOrderChecksRiskLimits(t *testing.T) {
+	temporalClient := mocks.NewClient(t)
+	orders := NewOrdersRepoMemory()
+	positions := NewPositionsRepoMemory()
+	service := NewOrdersService(temporalClient, orders, NewOrderEventsRepoMemory(), NewHaltsRepoMemory(), positions, NewWebhookPublisher(temporalClient, orders, NewWebhooksRepoMemory()))
+	require.NoError(t, positions.SetPosition(Position{AccountID: DefaultAccountID, Security: "AAPL", Quantity: 15}))
+	require.NoError(t, positions.SetPosition(Position{AccountID: "acct-2", Security: "AAPL", Quantity: 10}))
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(mockWorkflowRun(t), nil).Once()
+
+	first, _, err := service.CreateOrder(context.Background(), StopLossOrder{Security: "AAPL", StopPrice: 145, Quantity: 10}, EventSourceAPI)
+	require.NoError(t, err)
+
+	// together with the first the stops would sell more than is held
+	_, _, err = service.CreateOrder(context.Background(), StopLossOrder{Security: "AAPL", StopPrice: 140, Quantity: 10}, EventSourceAPI)
+	assert.Equal(t, []string{"stops on AAPL would sell 20 shares but 15 are held"}, riskReasons(err))
+	_, _, err = service.CreateOrder(context.Background(), StopLossOrder{Security: "GOOG", StopPrice: 140, Quantity: 1}, EventSourceAPI)
+	assert.Equal(t, []string{"stops on GOOG would sell 1 shares but 0 are held"}, riskReasons(err))
+
+	// another account's stops draw on its own position only
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(mockWorkflowRun(t), nil).Once()
+	_, _, err = service.CreateOrder(context.Background(), StopLossOrder{AccountID: "acct-2", Security: "AAPL", StopPrice: 140, Quantity: 10}, EventSourceAPI)
+	require.NoError(t, err)
+	_, _, err = service.CreateOrder(context.Background(), StopLossOrder{AccountID: "acct-2", Security: "AAPL", StopPrice: 140, Quantity: 1}, EventSourceAPI)
+	assert.Equal(t, []string{"stops on AAPL would sell 11 shares but 10 are held"}, riskReasons(err))
+
+	// a cancelled stop frees its shares again
+	require.NoError(t, orders.CancelOrder(first.ID))
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(mockWorkflowRun(t), nil).Once()
+	_, _, err = service.CreateOrder(context.Background(), StopLossOrder{Security: "AAPL", StopPrice: 140, Quantity: 10}, EventSourceAPI)
+	require.NoError(t, err)
+
+	all, err := orders.ListOrders()
+	require.NoError(t, err)
+	assert.Len(t, all, 3)
+}
+
+func TestOrdersServiceCreateOrderRejectsInvalidOrders(t *testing.T) {
+	service, _, orders, _ := newTestOrdersService(t)
+
+	for _, order := range []StopLossOrder{
+		// a negative quantity would lower the open stops the limits add up
+		{Security: "AAPL", StopPrice: 145, Quantity: -100},
+		{Security: "AAPL", StopPrice: 145, Quantity: 0},
+		{Security: "AAPL", StopPrice: -1, Quantity: 10},
+		{StopPrice: 145, Quantity: 10},
+	} {
+		_, _, err := service.CreateOrder(context.Background(), order, EventSourceWeb)
+		assert.ErrorIs(t, err, ErrInvalidOrder, "%+v", order)
+	}
+
+	all, err := orders.ListOrders()
+	require.NoError(t, err)
+	assert.Empty(t, all)
+}
+
+func TestOrdersServiceCreateOrderRetriesFailedStart(t *testing.T) {
+	service, temporalClient, orders, _ := newTestOrdersService(t)
+
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("temporal unavailable")).Once()
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(mockWorkflowRun(t), nil).Once()
+
+	order := StopLossOrder{Security: "AAPL", StopPrice: 145, Quantity: 10, IdempotencyKey: "key-1"}
+	first, _, err := service.CreateOrder(context.Background(), order, EventSourceAPI)
+	require.Error(t, err)
+
+	// the retry reuses the stored row and starts its workflow this time
+	second, created, err := service.CreateOrder(context.Background(), order, EventSourceAPI)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, first.ID, second.ID)
+
+	all, err := orders.ListOrders()
+	require.NoError(t, err)
+	assert.Len(t, all, 1)
+}
+
+func mockCancelUpdate(t *testing.T, temporalClient *mocks.Client, result string) {
+	handle := mocks.NewWorkflowUpdateHandle(t)
+	handle.On("Get", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(1).(*string) = result
+	}).Return(nil).Once()
+	temporalClient.On("UpdateWorkflow", mock.Anything, mock.MatchedBy(func(options client.UpdateWorkflowOptions) bool {
+		return options.UpdateName == CancelOrderUpdateName && options.WorkflowID == "wf-1"
+	})).Return(handle, nil).Once()
+}
+
+func TestOrdersServiceCancelOrder(t *testing.T) {
+	service, temporalClient, orders, _ := newTestOrdersService(t)
+	_, err := orders.CreateOrder(StopLossOrder{ID: "order-1", Status: OrderStatusPending, WorkflowID: "wf-1"})
+	require.NoError(t, err)
+
+	// whatever the workflow decides is the answer
+	mockCancelUpdate(t, temporalClient, CancelResultTooLate)
+	result, err := service.CancelOrder(context.Background(), "order-1", EventSourceWeb)
+	require.NoError(t, err)
+	assert.Equal(t, CancelResultTooLate, result)
+
+	_, err = service.CancelOrder(context.Background(), "nope", EventSourceWeb)
+	assert.ErrorIs(t, err, ErrOrderNotFound)
+}
+
+func TestOrdersServiceCancelClosedOrder(t *testing.T) {
+	service, temporalClient, orders, _ := newTestOrdersService(t)
+	_, err := orders.CreateOrder(StopLossOrder{ID: "order-1", Status: OrderStatusExecuted, WorkflowID: "wf-1"})
+	require.NoError(t, err)
+
+	// closed orders are answered without asking Temporal
+	result, err := service.CancelOrder(context.Background(), "order-1", EventSourceWeb)
+	require.NoError(t, err)
+	assert.Equal(t, CancelResultAlreadyExecuted, result)
+	temporalClient.AssertNotCalled(t, "UpdateWorkflow", mock.Anything, mock.Anything)
+}
+
+func TestOrdersServiceCancelOrderWorkflowJustFinished(t *testing.T) {
+	service, temporalClient, orders, _ := newTestOrdersService(t)
+	_, err := orders.CreateOrder(StopLossOrder{ID: "order-1", Status: OrderStatusPending, WorkflowID: "wf-1"})
+	require.NoError(t, err)
+
+	// the workflow executed the order and completed between reading the row and the update
+	temporalClient.On("UpdateWorkflow", mock.Anything, mock.Anything).
+		Run(func(mock.Arguments) { require.NoError(t, orders.UpdateOrderStatus("order-1", OrderStatusExecuted)) }).
+		Return(nil, serviceerror.NewNotFound("workflow execution already completed")).Once()
+
+	result, err := service.CancelOrder(context.Background(), "order-1", EventSourceWeb)
+	require.NoError(t, err)
+	assert.Equal(t, CancelResultAlreadyExecuted, result)
+}
+
+func TestOrdersServiceRedriveOrder(t *testing.T) {
+	service, temporalClient, orders, _ := newTestOrdersService(t)
+	_, err := orders.CreateOrder(StopLossOrder{ID: "order-1", Status: OrderStatusPending, WorkflowID: "wf-1"})
+	require.NoError(t, err)
+	require.NoError(t, orders.MarkOrderFailed("order-1", "broker unavailable"))
+	_, err = orders.CreateOrder(StopLossOrder{ID: "order-2", Status: OrderStatusPending, WorkflowID: "wf-2"})
+	require.NoError(t, err)
+
+	temporalClient.On("SignalWithStartWorkflow", mock.Anything, "wf-1", RedriveSignalName, RedriveRequest{Action: FailureActionRetry, Source: EventSourceAdmin},
+		mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			// whether or not the workflow is still running, the signal reaches it
+			options := args.Get(4).(client.StartWorkflowOptions)
+			assert.Equal(t, "wf-1", options.ID)
+			assert.Equal(t, enums.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE, options.WorkflowIDReusePolicy)
+			assert.Equal(t, enums.WORKFLOW_ID_CONFLICT_POLICY_USE_EXISTING, options.WorkflowIDConflictPolicy)
+			assert.Equal(t, OrderStatusFailed, args.Get(6).(StopLossOrder).Status)
+			assert.Equal(t, newStopLossRun(), args.Get(7))
+		}).
+		Return(mockWorkflowRun(t), nil).Once()
+
+	require.NoError(t, service.RedriveOrder(context.Background(), "order-1", FailureActionRetry, EventSourceAdmin))
+
+	assert.ErrorIs(t, service.RedriveOrder(context.Background(), "order-2", FailureActionRetry, EventSourceAdmin), ErrOrderNotFailed)
+	assert.ErrorIs(t, service.RedriveOrder(context.Background(), "nope", FailureActionRetry, EventSourceAdmin), ErrOrderNotFound)
+	assert.Error(t, service.RedriveOrder(context.Background(), "order-1", FailureActionEscalate, EventSourceAdmin))
+}
+
+func TestOrdersServiceHaltAndResumeExecutions(t *testing.T) {
+	service, temporalClient, orders, _ := newTestOrdersService(t)
+	for _, order := range []StopLossOrder{
+		{ID: "order-1", Security: "AAPL", Status: OrderStatusPending, WorkflowID: "wf-1"},
+		{ID: "order-2", Security: "AAPL", Status: OrderStatusPending, WorkflowID: "wf-2"},
+		{ID: "order-3", Security: "AAPL", Status: OrderStatusPending, WorkflowID: "wf-3"},
+		{ID: "order-4", Security: "AAPL", Status: OrderStatusPending, WorkflowID: "wf-4"},
+		{ID: "order-5", Security: "GOOG", Status: OrderStatusPending, WorkflowID: "wf-5"},
+	} {
+		_, err := orders.CreateOrder(order)
+		require.NoError(t, err)
+	}
+	require.NoError(t, orders.MarkOrderFailed("order-2", "broker unavailable"))
+	require.NoError(t, orders.UpdateOrderStatus("order-3", OrderStatusExecuted))
+
+	halt, err := service.HaltExecutions(context.Background(), "AAPL", "bad feed", EventSourceAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, "AAPL", halt.Security)
+	assert.False(t, halt.HaltedAt.IsZero())
+	halts, err := service.ListHalts(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []Halt{halt}, halts)
+
+	// pending and failed orders on AAPL are told, the executed one and GOOG are not
+	resume := ResumeRequest{Action: ResumeActionReevaluate, Source: EventSourceAdmin}
+	temporalClient.On("SignalWorkflow", mock.Anything, "wf-1", "", ResumeSignalName, resume).Return(nil).Once()
+	temporalClient.On("SignalWorkflow", mock.Anything, "wf-2", "", ResumeSignalName, resume).Return(nil).Once()
+	temporalClient.On("SignalWorkflow", mock.Anything, "wf-4", "", ResumeSignalName, resume).
+		Return(serviceerror.NewNotFound("workflow execution already completed")).Once()
+
+	resumed, err := service.ResumeExecutions(context.Background(), "AAPL", EventSourceAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, 2, resumed)
+	halts, err = service.ListHalts(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, halts)
+
+	_, err = service.ResumeExecutions(context.Background(), "AAPL", EventSourceAdmin)
+	assert.ErrorIs(t, err, ErrNotHalted)
+}
+
+func TestOrdersServiceLiveState(t *testing.T) {
+	service, temporalClient, orders, _ := newTestOrdersService(t)
+	_, err := orders.CreateOrder(StopLossOrder{ID: "order-1", Status: OrderStatusPending, WorkflowID: "wf-1"})
+	require.NoError(t, err)
+	_, err = orders.CreateOrder(StopLossOrder{ID: "order-2", Status: OrderStatusPending, WorkflowID: "wf-2"})
+	require.NoError(t, err)
+
+	value := mocks.NewEncodedValue(t)
+	value.On("Get", mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(0).(*OrderLiveState) = OrderLiveState{OrderID: "order-1", LastPrice: 150, PriceUpdates: 2}
+	}).Return(nil).Once()
+	temporalClient.On("QueryWorkflow", mock.Anything, "wf-1", "", OrderStateQueryName).Return(value, nil).Once()
+	temporalClient.On("QueryWorkflow", mock.Anything, "wf-2", "", OrderStateQueryName).Return(nil, serviceerror.NewNotFound("workflow not found")).Once()
+
+	state, err := service.LiveState(context.Background(), "order-1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, state.PriceUpdates)
+
+	_, err = service.LiveState(context.Background(), "order-2")
+	assert.ErrorIs(t, err, ErrOrderNotLive)
+
+	_, err = service.LiveState(context.Background(), "nope")
+	assert.ErrorIs(t, err, ErrOrderNotFound)
+}
+
+func TestOrdersServiceSearchOrders(t *testing.T) {
+	service, temporalClient, _, _ := newTestOrdersService(t)
+
+	attributes := func(fields map[string]any) *common.SearchAttributes {
+		indexed := map[string]*common.Payload{}
+		for name, value := range fields {
+			payload, err := converter.GetDefaultDataConverter().ToPayload(value)
+			require.NoError(t, err)
+			indexed[name] = payload
+		}
+		return &common.SearchAttributes{IndexedFields: indexed}
+	}
+	execution := func(workflowID string, fields map[string]any) *workflowpb.WorkflowExecutionInfo {
+		return &workflowpb.WorkflowExecutionInfo{
+			Execution:        &common.WorkflowExecution{WorkflowId: workflowID, RunId: "run"},
+			SearchAttributes: attributes(fields),
+		}
+	}
+
+	var queries []string
+	temporalClient.On("ListWorkflow", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			queries = append(queries, args.Get(1).(*workflowservice.ListWorkflowExecutionsRequest).Query)
+		}).
+		Return(&workflowservice.ListWorkflowExecutionsResponse{
+			Executions:    []*workflowpb.WorkflowExecutionInfo{execution("wf-1", map[string]any{"OrderID": "order-1", "Security": "AAPL", "StopPrice": 145.5, "OrderStatus": OrderStatusPending})},
+			NextPageToken: []byte("page-2"),
+		}, nil).Once()
+	temporalClient.On("ListWorkflow", mock.Anything, mock.MatchedBy(func(request *workflowservice.ListWorkflowExecutionsRequest) bool {
+		return string(request.NextPageToken) == "page-2"
+	})).Return(&workflowservice.ListWorkflowExecutionsResponse{
+		// started before the workflow set search attributes
+		Executions: []*workflowpb.WorkflowExecutionInfo{execution("wf-0", nil)},
+	}, nil).Once()
+
+	results, err := service.SearchOrders(context.Background(), OrderSearch{Security: "AAPL", Status: OrderStatusPending, AccountID: "o'brien", MinStopPrice: 100})
+	require.NoError(t, err)
+	assert.Equal(t, []OrderSearchResult{
+		{OrderID: "order-1", Security: "AAPL", StopPrice: 145.5, Status: OrderStatusPending, WorkflowID: "wf-1", RunID: "run"},
+		{WorkflowID: "wf-0", RunID: "run"},
+	}, results)
+	require.NotEmpty(t, queries)
+	assert.Equal(t, `WorkflowType = 'StopLossWorkflow' AND ExecutionStatus != 'ContinuedAsNew' AND Security = 'AAPL' AND OrderStatus = 'PENDING' AND AccountID = 'o\'brien' AND StopPrice >= 100`, queries[0])
+}
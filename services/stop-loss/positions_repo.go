@@ -0,0 +1,221 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PositionsRepoSQL keeps positions, and the executions taken off them, in
+// the positions and position_executions tables of either SQL backend.
+type PositionsRepoSQL struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func NewPositionsRepoSQL(db *sql.DB, d dialect) *PositionsRepoSQL {
+	return &PositionsRepoSQL{
+		db:      db,
+		dialect: d,
+	}
+}
+
+func (r *PositionsRepoSQL) SetPosition(position Position) error {
+	_, err := r.db.Exec(r.dialect.rebind(`
+		INSERT INTO positions (account_id, security, quantity, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (account_id, security) DO UPDATE
+		SET quantity = excluded.quantity, updated_at = excluded.updated_at
+	`), position.AccountID, position.Security, position.Quantity, position.UpdatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to set account %s's position in %s: %w", position.AccountID, position.Security, err)
+	}
+	return nil
+}
+
+func (r *PositionsRepoSQL) GetPosition(accountID string, security string) (Position, error) {
+	var position Position
+	err := r.db.QueryRow(r.dialect.rebind(`SELECT account_id, security, quantity, updated_at FROM positions WHERE account_id = ? AND security = ?`), accountID, security).
+		Scan(&position.AccountID, &position.Security, &position.Quantity, &position.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Position{}, ErrNoPosition
+	}
+	if err != nil {
+		return Position{}, fmt.Errorf("failed to get account %s's position in %s: %w", accountID, security, err)
+	}
+	return position, nil
+}
+
+func (r *PositionsRepoSQL) ListPositions() ([]Position, error) {
+	rows, err := r.db.Query(`SELECT account_id, security, quantity, updated_at FROM positions ORDER BY account_id, security`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list positions: %w", err)
+	}
+	defer rows.Close()
+
+	var positions []Position
+	for rows.Next() {
+		var position Position
+		if err := rows.Scan(&position.AccountID, &position.Security, &position.Quantity, &position.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan position: %w", err)
+		}
+		positions = append(positions, position)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating position rows: %w", err)
+	}
+	return positions, nil
+}
+
+// ApplyExecution records the order and reduces the position in one
+// transaction, so a retried call finds the order recorded and changes
+// nothing. A position never goes below zero.
+func (r *PositionsRepoSQL) ApplyExecution(order StopLossOrder, executedAt time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to apply execution of order %s: %w", order.ID, err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(r.dialect.rebind(`
+		INSERT INTO position_executions (order_id, security, quantity, executed_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (order_id) DO NOTHING
+	`), order.ID, order.Security, order.Quantity, executedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record execution of order %s: %w", order.ID, err)
+	}
+	recorded, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to record execution of order %s: %w", order.ID, err)
+	}
+	if recorded == 0 {
+		return nil // already applied
+	}
+
+	_, err = tx.Exec(r.dialect.rebind(`
+		UPDATE positions
+		SET quantity = CASE WHEN quantity > ? THEN quantity - ? ELSE 0 END, updated_at = ?
+		WHERE account_id = ? AND security = ?
+	`), order.Quantity, order.Quantity, executedAt.UTC(), order.AccountID, order.Security)
+	if err != nil {
+		return fmt.Errorf("failed to reduce account %s's position in %s: %w", order.AccountID, order.Security, err)
+	}
+	return tx.Commit()
+}
+
+// lockPosition reads the account's position in security inside tx, nil if
+// it holds none, and keeps anyone else from admitting an order against it
+// until tx ends. Postgres locks the row, and an advisory lock on the pair
+// stands in for a row that doesn't exist yet; SQLite has only the one
+// write lock, which the no-op update takes.
+func lockPosition(tx sqlRunner, d dialect, accountID string, security string) (*Position, error) {
+	var err error
+	if d == dialectPostgres {
+		_, err = tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1 || '/' || $2))`, accountID, security)
+	} else {
+		_, err = tx.Exec(`UPDATE positions SET quantity = quantity WHERE account_id = ? AND security = ?`, accountID, security)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock account %s's position in %s: %w", accountID, security, err)
+	}
+
+	query := `SELECT account_id, security, quantity, updated_at FROM positions WHERE account_id = ? AND security = ?`
+	if d == dialectPostgres {
+		query += ` FOR UPDATE`
+	}
+	var position Position
+	err = tx.QueryRow(d.rebind(query), accountID, security).
+		Scan(&position.AccountID, &position.Security, &position.Quantity, &position.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account %s's position in %s: %w", accountID, security, err)
+	}
+	return &position, nil
+}
+
+// Ensure PositionsRepoSQL implements PositionsRepo
+var _ PositionsRepo = (*PositionsRepoSQL)(nil)
+
+// PositionsRepoMemory keeps positions for the in-memory store.
+type PositionsRepoMemory struct {
+	mu        sync.RWMutex
+	positions map[positionKey]Position
+	applied   map[string]bool // order IDs already taken off a position
+}
+
+func NewPositionsRepoMemory() *PositionsRepoMemory {
+	return &PositionsRepoMemory{
+		positions: make(map[positionKey]Position),
+		applied:   make(map[string]bool),
+	}
+}
+
+func (m *PositionsRepoMemory) SetPosition(position Position) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.positions[positionKey{position.AccountID, position.Security}] = position
+	return nil
+}
+
+func (m *PositionsRepoMemory) GetPosition(accountID string, security string) (Position, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	position, ok := m.positions[positionKey{accountID, security}]
+	if !ok {
+		return Position{}, ErrNoPosition
+	}
+	return position, nil
+}
+
+func (m *PositionsRepoMemory) ListPositions() ([]Position, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var positions []Position
+	for _, position := range m.positions {
+		positions = append(positions, position)
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		if positions[i].AccountID != positions[j].AccountID {
+			return positions[i].AccountID < positions[j].AccountID
+		}
+		return positions[i].Security < positions[j].Security
+	})
+	return positions, nil
+}
+
+func (m *PositionsRepoMemory) ApplyExecution(order StopLossOrder, executedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.applied[order.ID] {
+		return nil
+	}
+	m.applied[order.ID] = true
+
+	key := positionKey{order.AccountID, order.Security}
+	position, ok := m.positions[key]
+	if !ok {
+		return nil
+	}
+	position.Quantity = max(position.Quantity-order.Quantity, 0)
+	position.UpdatedAt = executedAt
+	m.positions[key] = position
+	return nil
+}
+
+type positionKey struct {
+	accountID string
+	security  string
+}
+
+// Ensure PositionsRepoMemory implements PositionsRepo
+var _ PositionsRepo = (*PositionsRepoMemory)(nil)
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPositionsRepoContract(t *testing.T, repo PositionsRepo) {
+	at := time.Date(2025, 2, 1, 14, 30, 0, 0, time.UTC)
+
+	_, err := repo.GetPosition("acct-1", "AAPL")
+	assert.ErrorIs(t, err, ErrNoPosition)
+
+	require.NoError(t, repo.SetPosition(Position{AccountID: "acct-1", Security: "AAPL", Quantity: 100, UpdatedAt: at}))
+	require.NoError(t, repo.SetPosition(Position{AccountID: "acct-1", Security: "GOOG", Quantity: 5, UpdatedAt: at}))
+	require.NoError(t, repo.SetPosition(Position{AccountID: "acct-2", Security: "AAPL", Quantity: 40, UpdatedAt: at}))
+	position, err := repo.GetPosition("acct-1", "AAPL")
+	require.NoError(t, err)
+	assert.Equal(t, 100, position.Quantity)
+	assert.True(t, at.Equal(position.UpdatedAt))
+
+	// an execution comes off its own account's position once, however often
+	// it's applied
+	executed := StopLossOrder{ID: "order-1", AccountID: "acct-1", Security: "AAPL", Quantity: 30}
+	require.NoError(t, repo.ApplyExecution(executed, at.Add(time.Minute)))
+	require.NoError(t, repo.ApplyExecution(executed, at.Add(2*time.Minute)))
+	position, err = repo.GetPosition("acct-1", "AAPL")
+	require.NoError(t, err)
+	assert.Equal(t, 70, position.Quantity)
+	assert.True(t, at.Add(time.Minute).Equal(position.UpdatedAt))
+	position, err = repo.GetPosition("acct-2", "AAPL")
+	require.NoError(t, err)
+	assert.Equal(t, 40, position.Quantity)
+
+	// never below nothing, and nothing to take off a security with no position
+	require.NoError(t, repo.ApplyExecution(StopLossOrder{ID: "order-2", AccountID: "acct-1", Security: "GOOG", Quantity: 10}, at))
+	require.NoError(t, repo.ApplyExecution(StopLossOrder{ID: "order-3", AccountID: "acct-1", Security: "MSFT", Quantity: 10}, at))
+	_, err = repo.GetPosition("acct-1", "MSFT")
+	assert.ErrorIs(t, err, ErrNoPosition)
+
+	require.NoError(t, repo.SetPosition(Position{AccountID: "acct-1", Security: "AAPL", Quantity: 200, UpdatedAt: at}))
+	positions, err := repo.ListPositions()
+	require.NoError(t, err)
+	require.Len(t, positions, 3)
+	assert.Equal(t, "AAPL", positions[0].Security)
+	assert.Equal(t, 200, positions[0].Quantity)
+	assert.Equal(t, "GOOG", positions[1].Security)
+	assert.Equal(t, 0, positions[1].Quantity)
+	assert.Equal(t, "acct-2", positions[2].AccountID)
+}
+
+func TestPositionsRepoMemory(t *testing.T) {
+	testPositionsRepoContract(t, NewPositionsRepoMemory())
+}
+
+func TestPositionsRepoSQLite(t *testing.T) {
+	db := newTestSQLiteDB(t)
+	require.NoError(t, migrateDB(db, dialectSQLite))
+
+	testPositionsRepoContract(t, NewPositionsRepoSQL(db, dialectSQLite))
+}
+
+func TestReadPositionsCSV(t *testing.T) {
+	at := time.Date(2025, 2, 1, 14, 30, 0, 0, time.UTC)
+
+	positions, err := readPositionsCSV(strings.NewReader("account,security,quantity\nacct-1,AAPL, 100\nacct-2,GOOG,0\n"), at)
+	require.NoError(t, err)
+	assert.Equal(t, []Position{{AccountID: "acct-1", Security: "AAPL", Quantity: 100, UpdatedAt: at}, {AccountID: "acct-2", Security: "GOOG", Quantity: 0, UpdatedAt: at}}, positions)
+
+	// without an account column, rows belong to the default account
+	positions, err = readPositionsCSV(strings.NewReader("security,quantity\nAAPL,100\n"), at)
+	require.NoError(t, err)
+	assert.Equal(t, []Position{{AccountID: DefaultAccountID, Security: "AAPL", Quantity: 100, UpdatedAt: at}}, positions)
+
+	for _, in := range []string{"AAPL,-1\n", "AAPL,lots\n", ",100\n", ",AAPL,100\n", "AAPL\n", "AAPL,100,extra\n", "acct-1,AAPL,100,extra\n"} {
+		_, err := readPositionsCSV(strings.NewReader(in), at)
+		assert.Error(t, err, in)
+	}
+}
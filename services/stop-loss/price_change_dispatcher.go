@@ -2,20 +2,31 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
+	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.temporal.io/sdk/client"
 )
 
+// StartPriceChangeDispatcher signals each price update to the workflows of
+// the orders waiting on its security, until ingestion closes the channel and
+// everything in it has been sent.
 func StartPriceChangeDispatcher(temporalClient client.Client, ordersRepo OrdersRepo, pricesChannel <-chan PriceUpdate) {
 	for priceUpdate := range pricesChannel {
+		// the tick's trace carries on through the signals' headers
+		ctx, span := tracer().Start(trace.ContextWithSpanContext(context.Background(), priceUpdate.spanContext), "DispatchPriceUpdate",
+			trace.WithAttributes(attribute.String("security", priceUpdate.Security)),
+		)
 
 		// find pending orders for this secuirty:
 		workflowIDs, err := ordersRepo.GetPendingWorkflowIDsForSecurity(priceUpdate.Security)
 		if err != nil {
-			log.Fatal("failed to fetch workflowids from repo", err)
+			fatal("Failed to fetch pending workflow IDs", "security", priceUpdate.Security, "error", err)
 			// TODO: recover from here
 		}
+		span.SetAttributes(attribute.Int("orders", len(workflowIDs)))
 
 		// send workflows the signal that their price has been updated:
 		for _, workflowID := range workflowIDs {
@@ -24,13 +35,18 @@ func StartPriceChangeDispatcher(temporalClient client.Client, ordersRepo OrdersR
 				Price:    priceUpdate.Price,
 			}
 
-			err := temporalClient.SignalWorkflow(context.Background(), workflowID, "", PriceUpdateSignalName, signalData)
+			start := time.Now()
+			err := temporalClient.SignalWorkflow(ctx, workflowID, "", PriceUpdateSignalName, signalData)
+			dispatcherSignalDuration.Observe(time.Since(start).Seconds())
 			if err != nil {
-				log.Printf("Disaptcher: error signaling workflow %s for security %s: %v", workflowID, priceUpdate.Security, err)
+				dispatcherSignalErrors.Inc()
+				span.RecordError(err)
+				slog.Error("Failed to signal workflow with price update", "workflowID", workflowID, "security", priceUpdate.Security, "error", err)
 			} else {
-				log.Printf("Signaled workflow %s for security %s with price %.2f (via Channel -> Signal)", workflowID, priceUpdate.Security, priceUpdate.Price)
+				slog.Debug("Signalled workflow with price update", "workflowID", workflowID, "security", priceUpdate.Security, "price", priceUpdate.Price)
 			}
 		}
+		span.End()
 	}
-	log.Println("Unexepected price channel closed!")
+	slog.Info("Price change dispatcher drained")
 }
@@ -1,81 +1,134 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func NewPriceIngestionService(wsURL string, pricesChannel chan PriceUpdate) *PriceIngestionService {
 	return &PriceIngestionService{
 		wsURL:         wsURL,
 		pricesChannel: pricesChannel,
+		done:          make(chan struct{}),
 	}
 }
 
-func (pis *PriceIngestionService) Start() {
-	log.Println("Starting Price Ingestion Service...")
-	go pis.run()
+// Start ingests prices until ctx is done, then closes the prices channel so
+// the dispatcher can drain what's left in it.
+func (pis *PriceIngestionService) Start(ctx context.Context) {
+	slog.Info("Starting price ingestion service", "url", pis.wsURL)
+	go pis.run(ctx)
 }
 
-func (pis *PriceIngestionService) run() {
+// Done is closed once ingestion has stopped and closed the prices channel.
+func (pis *PriceIngestionService) Done() <-chan struct{} {
+	return pis.done
+}
+
+func (pis *PriceIngestionService) run(ctx context.Context) {
+	defer close(pis.done)
+	defer close(pis.pricesChannel)
 	var reconnectInterval = time.Second // Initial reconnect interval
 
-	for {
-		log.Println("Attempting to connect to WebSocket...")
-		conn, _, err := websocket.DefaultDialer.Dial(pis.wsURL, nil)
+	for attempt := 0; ctx.Err() == nil; attempt++ {
+		if attempt > 0 {
+			priceFeedReconnects.Inc()
+		}
+		slog.Debug("Connecting to the price feed", "attempt", attempt+1)
+		conn, _, err := websocket.DefaultDialer.DialContext(ctx, pis.wsURL, nil)
 		if err != nil {
-			log.Printf("Failed to connect to WebSocket: %v. Retrying in %s...", err, reconnectInterval)
-			time.Sleep(reconnectInterval)
+			if ctx.Err() != nil {
+				break
+			}
+			slog.Warn("Failed to connect to the price feed, retrying", "retryIn", reconnectInterval.String(), "error", err)
+			select {
+			case <-time.After(reconnectInterval):
+			case <-ctx.Done():
+			}
 			reconnectInterval = minDuration(reconnectInterval*2, 10*time.Second) // Exponential backoff, max 10s
 			continue
 		}
 
-		log.Println("WebSocket connected.")
+		slog.Info("Connected to the price feed")
 		pis.conn = conn
+		pis.connected.Store(true)
+		priceFeedConnected.Set(1)
 		reconnectInterval = time.Second // Reset reconnect interval on successful connection
 
-		log.Println("Successfully subscribed to securities after reconnection.")
-		pis.startReceivingPrices()
+		pis.startReceivingPrices(ctx)
 	}
+	slog.Info("Price ingestion service stopped")
 }
 
-func (pis *PriceIngestionService) startReceivingPrices() {
+func (pis *PriceIngestionService) startReceivingPrices(ctx context.Context) {
 	defer pis.closeConnection()
+	// a read deadline in the past wakes ReadMessage up when ctx is done
+	conn := pis.conn
+	defer context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })()
 
 	for {
 		_, message, err := pis.conn.ReadMessage()
 		if err != nil {
-			log.Println("WebSocket ReadMessage error:", err)
+			if ctx.Err() != nil {
+				return
+			}
 			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Println("WebSocket connection closed by remote side (expected close error).")
+				slog.Warn("Price feed closed the connection, reconnecting", "error", err)
 			} else {
-				log.Printf("WebSocket connection error (not close error): %v. Reconnecting...", err) // Specific log for non-close errors
+				slog.Error("Price feed connection failed, reconnecting", "error", err)
 			}
 			return // Exit to trigger reconnection attempt in run()
 		}
 
 		var priceUpdate PriceUpdate
 		if err := json.Unmarshal(message, &priceUpdate); err != nil {
-			log.Printf("Error unmarshalling price update: %v, message: %s", err, string(message))
+			slog.Error("Failed to unmarshal price update", "message", string(message), "error", err)
 			continue
 		}
 
-		log.Printf("Received price update: Security=%s, Price=%.2f", priceUpdate.Security, priceUpdate.Price)
-		pis.pricesChannel <- priceUpdate
+		slog.Debug("Received price update", "security", priceUpdate.Security, "price", priceUpdate.Price)
+		priceTicks.WithLabelValues(priceUpdate.Security).Inc()
+		pis.lastTickAt.Store(time.Now().UnixNano())
+		_, span := tracer().Start(context.Background(), "PriceTick",
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(attribute.String("security", priceUpdate.Security), attribute.Float64("price", priceUpdate.Price)),
+		)
+		priceUpdate.spanContext = span.SpanContext()
+		// a dispatcher too far behind to take this tick won't get it once
+		// ingestion is stopping; the buffered ones it still gets
+		select {
+		case pis.pricesChannel <- priceUpdate:
+		case <-ctx.Done():
+			slog.Warn("Dropped a price update while stopping", "security", priceUpdate.Security, "price", priceUpdate.Price)
+		}
+		span.End()
 	}
 }
 
 func (pis *PriceIngestionService) closeConnection() {
 	if pis.conn != nil {
-		log.Println("Closing WebSocket connection.")
 		if err := pis.conn.Close(); err != nil {
-			log.Printf("Error closing WebSocket connection: %v", err)
+			slog.Error("Failed to close the price feed connection", "error", err)
 		}
 		pis.conn = nil
 	}
+	pis.connected.Store(false)
+	priceFeedConnected.Set(0)
+}
+
+// Status says whether the price feed is connected and when its last tick
+// arrived, zero if none has.
+func (pis *PriceIngestionService) Status() (connected bool, lastTickAt time.Time) {
+	if nanos := pis.lastTickAt.Load(); nanos != 0 {
+		lastTickAt = time.Unix(0, nanos)
+	}
+	return pis.connected.Load(), lastTickAt
 }
 
 func minDuration(d1, d2 time.Duration) time.Duration {
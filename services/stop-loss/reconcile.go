@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// Kinds of drift between the orders table and Temporal
+const (
+	MismatchPendingWithoutWorkflow = "pending-without-workflow"  // PENDING row, nothing running
+	MismatchWorkflowForClosedOrder = "workflow-for-closed-order" // running workflow, row already executed/cancelled
+	MismatchOrphanWorkflow         = "orphan-workflow"           // running workflow, no row at all
+)
+
+const (
+	reconcileScheduleID = "stop-loss-reconcile"
+	// rows are written just before their workflow starts, and older
+	// workflows write their own row, so anything this young is left alone
+	// rather than mistaken for drift
+	reconcileGracePeriod = 2 * time.Minute
+	// a workflow writes EXECUTED or CANCELLED and then stays up while its
+	// notifications retry, up to about eight minutes, and its events flush,
+	// so it's only drift once its order has been closed for longer
+	closedOrderGracePeriod = 15 * time.Minute
+)
+
+type ReconcileMismatch struct {
+	Kind        string `json:"kind"`
+	OrderID     string `json:"orderID,omitempty"`
+	WorkflowID  string `json:"workflowID,omitempty"`
+	Detail      string `json:"detail"`
+	Repaired    bool   `json:"repaired"`
+	RepairError string `json:"repairError,omitempty"`
+}
+
+type ReconcileReport struct {
+	CheckedOrders    int                 `json:"checkedOrders"`
+	CheckedWorkflows int                 `json:"checkedWorkflows"`
+	Mismatches       []ReconcileMismatch `json:"mismatches"`
+}
+
+// openStopLossWorkflow is a running StopLossWorkflow as Temporal sees it.
+type openStopLossWorkflow struct {
+	WorkflowID string
+	StartTime  time.Time
+}
+
+// reconcileTemporal is the slice of Temporal the reconciler needs.
+type reconcileTemporal interface {
+	ListOpenStopLossWorkflows(ctx context.Context) ([]openStopLossWorkflow, error)
+	TerminateWorkflow(ctx context.Context, workflowID string, reason string) error
+	// StartStopLossWorkflow starts the order's workflow; alreadyRan reports
+	// that a run under its ID has already finished, so it can't be started.
+	StartStopLossWorkflow(ctx context.Context, order StopLossOrder) (alreadyRan bool, err error)
+}
+
+// Reconciler compares PENDING orders with running StopLossWorkflows and
+// optionally repairs the difference.
+type Reconciler struct {
+	temporal   reconcileTemporal
+	ordersRepo OrdersRepo
+	eventsRepo OrderEventsRepo
+	webhooks   *WebhookPublisher
+	now        func() time.Time
+}
+
+func NewReconciler(temporalClient client.Client, ordersRepo OrdersRepo, eventsRepo OrderEventsRepo, webhooks *WebhookPublisher) *Reconciler {
+	return &Reconciler{
+		temporal:   &temporalReconcileClient{client: temporalClient},
+		ordersRepo: ordersRepo,
+		eventsRepo: eventsRepo,
+		webhooks:   webhooks,
+		now:        time.Now,
+	}
+}
+
+func (r *Reconciler) Reconcile(ctx context.Context, repair bool) (ReconcileReport, error) {
+	var report ReconcileReport
+
+	orders, err := r.ordersRepo.ListOrders()
+	if err != nil {
+		return report, fmt.Errorf("failed to list orders: %w", err)
+	}
+	workflows, err := r.temporal.ListOpenStopLossWorkflows(ctx)
+	if err != nil {
+		return report, fmt.Errorf("failed to list open workflows: %w", err)
+	}
+	report.CheckedOrders = len(orders)
+	report.CheckedWorkflows = len(workflows)
+
+	ordersByWorkflowID := make(map[string]StopLossOrder, len(orders))
+	for _, order := range orders {
+		if order.WorkflowID != "" {
+			ordersByWorkflowID[order.WorkflowID] = order
+		}
+	}
+	running := make(map[string]bool, len(workflows))
+	for _, wf := range workflows {
+		running[wf.WorkflowID] = true
+	}
+
+	for _, order := range orders {
+		if order.Status != OrderStatusPending || running[order.WorkflowID] {
+			continue
+		}
+		if r.now().Sub(order.PlacedAt) < reconcileGracePeriod {
+			continue
+		}
+		m := ReconcileMismatch{
+			Kind:       MismatchPendingWithoutWorkflow,
+			OrderID:    order.ID,
+			WorkflowID: order.WorkflowID,
+			Detail:     "order is PENDING but no StopLossWorkflow is running for it",
+		}
+		if repair {
+			r.repair(&m, func() error { return r.restartStrandedOrder(ctx, order) })
+		}
+		report.Mismatches = append(report.Mismatches, m)
+	}
+
+	for _, wf := range workflows {
+		order, ok := ordersByWorkflowID[wf.WorkflowID]
+		switch {
+		case ok && (order.Status == OrderStatusPending || order.Status == OrderStatusFailed):
+			// a FAILED order's workflow stays up, waiting to be re-driven
+			continue
+		case ok:
+			closedAt, err := r.closedAt(order, wf)
+			if err != nil {
+				return report, err
+			}
+			if r.now().Sub(closedAt) < closedOrderGracePeriod {
+				continue
+			}
+			m := ReconcileMismatch{
+				Kind:       MismatchWorkflowForClosedOrder,
+				OrderID:    order.ID,
+				WorkflowID: wf.WorkflowID,
+				Detail:     fmt.Sprintf("workflow is still running but the order is %s", order.Status),
+			}
+			if repair {
+				r.repair(&m, func() error {
+					return r.temporal.TerminateWorkflow(ctx, wf.WorkflowID, "reconcile: order is "+order.Status)
+				})
+			}
+			report.Mismatches = append(report.Mismatches, m)
+		case r.now().Sub(wf.StartTime) < reconcileGracePeriod:
+			continue
+		default:
+			m := ReconcileMismatch{
+				Kind:       MismatchOrphanWorkflow,
+				WorkflowID: wf.WorkflowID,
+				Detail:     "workflow is running but has no order row",
+			}
+			if repair {
+				r.repair(&m, func() error {
+					return r.temporal.TerminateWorkflow(ctx, wf.WorkflowID, "reconcile: no order row")
+				})
+			}
+			report.Mismatches = append(report.Mismatches, m)
+		}
+	}
+
+	return report, nil
+}
+
+// closedAt is when the order's last event, normally its execution or
+// cancellation, was recorded, or when its workflow started if it has none.
+func (r *Reconciler) closedAt(order StopLossOrder, wf openStopLossWorkflow) (time.Time, error) {
+	events, err := r.eventsRepo.ListEventsForOrder(order.ID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to list events for order %s: %w", order.ID, err)
+	}
+	closedAt := wf.StartTime
+	for _, e := range events {
+		if e.OccurredAt.After(closedAt) {
+			closedAt = e.OccurredAt
+		}
+	}
+	return closedAt, nil
+}
+
+func (r *Reconciler) repair(m *ReconcileMismatch, fix func() error) {
+	if err := fix(); err != nil {
+		slog.Error("Reconcile failed to repair a mismatch", "kind", m.Kind, "orderID", m.OrderID, "workflowID", m.WorkflowID, "error", err)
+		m.RepairError = err.Error()
+		return
+	}
+	slog.Info("Reconcile repaired a mismatch", "kind", m.Kind, "orderID", m.OrderID, "workflowID", m.WorkflowID)
+	m.Repaired = true
+}
+
+// restartStrandedOrder starts the workflow for an order whose row was stored
+// but whose workflow never got going. If the workflow already ran to the end
+// the order can't be watched again, so it's cancelled instead.
+func (r *Reconciler) restartStrandedOrder(ctx context.Context, order StopLossOrder) error {
+	if order.WorkflowID == "" {
+		order.WorkflowID = stopLossWorkflowID(order.ID)
+		if err := r.ordersRepo.AssociateWorkflowID(order.ID, order.WorkflowID); err != nil {
+			return err
+		}
+	}
+	alreadyRan, err := r.temporal.StartStopLossWorkflow(ctx, order)
+	if err != nil {
+		return err
+	}
+	if alreadyRan {
+		return r.cancelStrandedOrder(ctx, order)
+	}
+	return nil
+}
+
+// cancelStrandedOrder closes an order nothing is watching any more, so it
+// stops showing as live. The repair fails if the cancellation's webhooks
+// could neither be sent nor dead lettered.
+func (r *Reconciler) cancelStrandedOrder(ctx context.Context, order StopLossOrder) error {
+	err := r.ordersRepo.CancelOrder(order.ID)
+	if errors.Is(err, ErrOrderNotPending) {
+		return nil // closed in the meantime, nothing to do
+	}
+	if err != nil {
+		return err
+	}
+	event := newOrderEvent(order.ID, OrderEventCancelled, EventSourceAdmin, map[string]string{"reason": "reconcile: no running workflow"}, r.now())
+	if err := r.eventsRepo.AppendEvent(event); err != nil {
+		slog.Error("Reconcile failed to record a cancellation", "orderID", order.ID, "error", err)
+	}
+	return r.webhooks.PublishOrDeadLetter(ctx, event)
+}
+
+// temporalReconcileClient answers the reconciler from Temporal visibility.
+type temporalReconcileClient struct {
+	client client.Client
+}
+
+func (c *temporalReconcileClient) ListOpenStopLossWorkflows(ctx context.Context) ([]openStopLossWorkflow, error) {
+	var workflows []openStopLossWorkflow
+	var nextPageToken []byte
+	for {
+		resp, err := c.client.ListWorkflow(ctx, &workflowservice.ListWorkflowExecutionsRequest{
+			Query:         "WorkflowType = 'StopLossWorkflow' AND ExecutionStatus = 'Running'",
+			NextPageToken: nextPageToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, execution := range resp.GetExecutions() {
+			workflows = append(workflows, openStopLossWorkflow{
+				WorkflowID: execution.GetExecution().GetWorkflowId(),
+				StartTime:  execution.GetStartTime().AsTime(),
+			})
+		}
+		nextPageToken = resp.GetNextPageToken()
+		if len(nextPageToken) == 0 {
+			return workflows, nil
+		}
+	}
+}
+
+func (c *temporalReconcileClient) TerminateWorkflow(ctx context.Context, workflowID string, reason string) error {
+	err := c.client.TerminateWorkflow(ctx, workflowID, "", reason)
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		return nil // finished on its own in the meantime
+	}
+	return err
+}
+
+func (c *temporalReconcileClient) StartStopLossWorkflow(ctx context.Context, order StopLossOrder) (bool, error) {
+	err := startStopLossWorkflow(ctx, c.client, order)
+	if !isWorkflowAlreadyStarted(err) {
+		return false, err
+	}
+	// either it finished, or it started since the workflows were listed
+	desc, err := c.client.DescribeWorkflowExecution(ctx, order.WorkflowID, "")
+	if err != nil {
+		return false, err
+	}
+	return desc.GetWorkflowExecutionInfo().GetStatus() != enums.WORKFLOW_EXECUTION_STATUS_RUNNING, nil
+}
+
+// --- Scheduled reconciliation ---
+
+// ReconcileActivities runs the reconciler inside the worker.
+type ReconcileActivities struct {
+	reconciler *Reconciler
+}
+
+func NewReconcileActivities(reconciler *Reconciler) *ReconcileActivities {
+	return &ReconcileActivities{
+		reconciler: reconciler,
+	}
+}
+
+func (a *ReconcileActivities) ReconcileActivity(ctx context.Context, repair bool) (ReconcileReport, error) {
+	return a.reconciler.Reconcile(ctx, repair)
+}
+
+// ReconcileWorkflow is started by the reconcile schedule.
+func ReconcileWorkflow(ctx workflow.Context, repair bool) (ReconcileReport, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 5 * time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 3,
+		},
+	})
+
+	var a *ReconcileActivities
+	var report ReconcileReport
+	err := workflow.ExecuteActivity(ctx, a.ReconcileActivity, repair).Get(ctx, &report)
+	if err != nil {
+		return report, err
+	}
+	workflow.GetLogger(ctx).Info("Reconciliation finished", "orders", report.CheckedOrders, "workflows", report.CheckedWorkflows, "mismatches", len(report.Mismatches))
+	return report, nil
+}
+
+// ensureReconcileSchedule creates the reconcile schedule unless an earlier
+// start (or another replica) already did.
+func ensureReconcileSchedule(ctx context.Context, temporalClient client.Client, interval time.Duration, repair bool) error {
+	_, err := temporalClient.ScheduleClient().Create(ctx, client.ScheduleOptions{
+		ID: reconcileScheduleID,
+		Spec: client.ScheduleSpec{
+			Intervals: []client.ScheduleIntervalSpec{{Every: interval}},
+		},
+		Action: &client.ScheduleWorkflowAction{
+			ID:        reconcileScheduleID + "-run",
+			Workflow:  ReconcileWorkflow,
+			Args:      []interface{}{repair},
+			TaskQueue: "stop-loss-task-queue",
+		},
+		Overlap: enums.SCHEDULE_OVERLAP_POLICY_SKIP,
+	})
+	if errors.Is(err, temporal.ErrScheduleAlreadyRunning) {
+		slog.Info("Reconcile schedule already exists", "scheduleID", reconcileScheduleID)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"go.temporal.io/sdk/mocks"
+	"go.temporal.io/sdk/testsuite"
+)
+
+type fakeReconcileTemporal struct {
+	open       []openStopLossWorkflow
+	finished   map[string]bool // workflow IDs whose run already completed
+	terminated []string
+	started    []string
+}
+
+func (f *fakeReconcileTemporal) ListOpenStopLossWorkflows(ctx context.Context) ([]openStopLossWorkflow, error) {
+	return f.open, nil
+}
+
+func (f *fakeReconcileTemporal) TerminateWorkflow(ctx context.Context, workflowID string, reason string) error {
+	f.terminated = append(f.terminated, workflowID)
+	return nil
+}
+
+func (f *fakeReconcileTemporal) StartStopLossWorkflow(ctx context.Context, order StopLossOrder) (bool, error) {
+	if f.finished[order.WorkflowID] {
+		return true, nil
+	}
+	f.started = append(f.started, order.WorkflowID)
+	f.open = append(f.open, openStopLossWorkflow{WorkflowID: order.WorkflowID, StartTime: time.Now()})
+	return false, nil
+}
+
+func newTestReconciler(t *testing.T, now time.Time) (*Reconciler, *fakeReconcileTemporal) {
+	orders := NewOrdersRepoMemory()
+	seed := []StopLossOrder{
+		{ID: "healthy", Status: OrderStatusPending, WorkflowID: "wf-healthy"},
+		{ID: "stranded", Status: OrderStatusPending, WorkflowID: "wf-stranded"},
+		{ID: "unstarted", Status: OrderStatusPending, WorkflowID: "wf-unstarted"},
+		{ID: "executed", Status: OrderStatusExecuted, WorkflowID: "wf-executed"},
+		{ID: "done", Status: OrderStatusCancelled, WorkflowID: "wf-done"},
+		// its workflow waits for an admin to re-drive it
+		{ID: "failed", Status: OrderStatusFailed, WorkflowID: "wf-failed"},
+		// just stored, its workflow is probably being started right now
+		{ID: "fresh", Status: OrderStatusPending, WorkflowID: "wf-fresh", PlacedAt: now.Add(-10 * time.Second)},
+	}
+	for _, o := range seed {
+		o.Security = "AAPL"
+		if o.PlacedAt.IsZero() {
+			o.PlacedAt = now.Add(-time.Hour)
+		}
+		_, err := orders.CreateOrder(o)
+		require.NoError(t, err)
+	}
+
+	fake := &fakeReconcileTemporal{
+		finished: map[string]bool{"wf-stranded": true},
+		open: []openStopLossWorkflow{
+			{WorkflowID: "wf-healthy", StartTime: now.Add(-time.Hour)},
+			{WorkflowID: "wf-executed", StartTime: now.Add(-time.Hour)},
+			{WorkflowID: "wf-failed", StartTime: now.Add(-time.Hour)},
+			{WorkflowID: "wf-orphan", StartTime: now.Add(-time.Hour)},
+			// too young to judge, its row may not be written yet
+			{WorkflowID: "wf-new", StartTime: now.Add(-10 * time.Second)},
+		},
+	}
+	return &Reconciler{
+		temporal:   fake,
+		ordersRepo: orders,
+		eventsRepo: NewOrderEventsRepoMemory(),
+		webhooks:   NewWebhookPublisher(mocks.NewClient(t), orders, NewWebhooksRepoMemory()),
+		now:        func() time.Time { return now },
+	}, fake
+}
+
+func mismatchKinds(report ReconcileReport) map[string]string {
+	kinds := map[string]string{}
+	for _, m := range report.Mismatches {
+		key := m.OrderID
+		if key == "" {
+			key = m.WorkflowID
+		}
+		kinds[key] = m.Kind
+	}
+	return kinds
+}
+
+func TestReconcileReportOnly(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	r, fake := newTestReconciler(t, now)
+
+	report, err := r.Reconcile(context.Background(), false)
+	require.NoError(t, err)
+
+	assert.Equal(t, 7, report.CheckedOrders)
+	assert.Equal(t, 5, report.CheckedWorkflows)
+	assert.Equal(t, map[string]string{
+		"stranded":  MismatchPendingWithoutWorkflow,
+		"unstarted": MismatchPendingWithoutWorkflow,
+		"executed":  MismatchWorkflowForClosedOrder,
+		"wf-orphan": MismatchOrphanWorkflow,
+	}, mismatchKinds(report))
+
+	for _, m := range report.Mismatches {
+		assert.False(t, m.Repaired)
+	}
+	assert.Empty(t, fake.terminated)
+	assert.Empty(t, fake.started)
+	order, err := r.ordersRepo.GetOrder("stranded")
+	require.NoError(t, err)
+	assert.Equal(t, OrderStatusPending, order.Status)
+}
+
+func TestReconcileRepair(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	r, fake := newTestReconciler(t, now)
+
+	report, err := r.Reconcile(context.Background(), true)
+	require.NoError(t, err)
+
+	require.Len(t, report.Mismatches, 4)
+	for _, m := range report.Mismatches {
+		assert.True(t, m.Repaired, m.Kind)
+	}
+	assert.ElementsMatch(t, []string{"wf-executed", "wf-orphan"}, fake.terminated)
+	// never started, so it gets its workflow now
+	assert.Equal(t, []string{"wf-unstarted"}, fake.started)
+	order, err := r.ordersRepo.GetOrder("unstarted")
+	require.NoError(t, err)
+	assert.Equal(t, OrderStatusPending, order.Status)
+
+	// its workflow already ran, so it can only be closed
+	order, err = r.ordersRepo.GetOrder("stranded")
+	require.NoError(t, err)
+	assert.Equal(t, OrderStatusCancelled, order.Status)
+
+	events, err := r.eventsRepo.ListEventsForOrder("stranded")
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, OrderEventCancelled, events[0].Type)
+	assert.Equal(t, EventSourceAdmin, events[0].Source)
+
+	// a second pass finds nothing left to do
+	fake.open = []openStopLossWorkflow{
+		{WorkflowID: "wf-healthy", StartTime: now.Add(-time.Hour)},
+		{WorkflowID: "wf-unstarted", StartTime: now.Add(-time.Minute)},
+		{WorkflowID: "wf-fresh", StartTime: now.Add(-time.Second)},
+	}
+	report, err = r.Reconcile(context.Background(), true)
+	require.NoError(t, err)
+	assert.Empty(t, report.Mismatches)
+}
+
+func TestReconcileLeavesRecentlyClosedOrdersAlone(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	r, fake := newTestReconciler(t, now)
+	// executed a minute ago; its workflow is still sending the notification
+	require.NoError(t, r.eventsRepo.AppendEvent(OrderEvent{OrderID: "executed", Type: OrderEventExecuted, Source: EventSourceWorkflow, OccurredAt: now.Add(-time.Minute)}))
+
+	report, err := r.Reconcile(context.Background(), true)
+	require.NoError(t, err)
+	assert.NotContains(t, mismatchKinds(report), "executed")
+	assert.NotContains(t, fake.terminated, "wf-executed")
+
+	// long after, the workflow still running is drift
+	r.now = func() time.Time { return now.Add(time.Hour) }
+	report, err = r.Reconcile(context.Background(), true)
+	require.NoError(t, err)
+	assert.Equal(t, MismatchWorkflowForClosedOrder, mismatchKinds(report)["executed"])
+	assert.Contains(t, fake.terminated, "wf-executed")
+}
+
+type ReconcileWorkflowTestSuite struct {
+	suite.Suite
+	testsuite.WorkflowTestSuite
+}
+
+func TestReconcileWorkflowTestSuite(t *testing.T) {
+	suite.Run(t, new(ReconcileWorkflowTestSuite))
+}
+
+func (s *ReconcileWorkflowTestSuite) Test_RunsReconcileActivity() {
+	env := s.NewTestWorkflowEnvironment()
+	var a *ReconcileActivities
+	env.RegisterActivity(a)
+	env.OnActivity(a.ReconcileActivity, mock.Anything, true).Return(ReconcileReport{CheckedOrders: 2}, nil).Once()
+
+	env.ExecuteWorkflow(ReconcileWorkflow, true)
+
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	var report ReconcileReport
+	s.NoError(env.GetWorkflowResult(&report))
+	s.Equal(2, report.CheckedOrders)
+	env.AssertExpectations(s.T())
+}
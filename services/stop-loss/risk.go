@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RiskLimits are checked before an order is accepted, against the order
+// plus every stop already open on its security.
+type RiskLimits struct {
+	// RequirePosition rejects stops that would sell more shares than are
+	// held, counting a security with no position as none held.
+	RequirePosition bool
+	// MaxNotional caps quantity × stop price per security. The "*" entry
+	// covers securities without one of their own; no entry, no cap.
+	MaxNotional map[string]float64
+}
+
+// riskLimits is set from the environment at startup.
+var riskLimits = RiskLimits{RequirePosition: true}
+
+// RiskRejection is returned by CreateOrder for an order the risk limits
+// don't allow, with every limit it breaks.
+type RiskRejection struct {
+	Reasons []string
+}
+
+func (e *RiskRejection) Error() string {
+	return "order rejected: " + strings.Join(e.Reasons, "; ")
+}
+
+// openStop says whether an order's stop can still sell. FAILED orders count,
+// since a re-drive can still execute them.
+func openStop(order StopLossOrder) bool {
+	return order.Status == OrderStatusPending || order.Status == OrderStatusFailed
+}
+
+// checkRisk returns a *RiskRejection if placing order would break limits,
+// given the orders already on its security and the shares held, nil if
+// there's no position.
+func checkRisk(limits RiskLimits, order StopLossOrder, existing []StopLossOrder, position *Position) error {
+	quantity, notional := order.Quantity, float64(order.Quantity)*order.StopPrice
+	for _, o := range existing {
+		if openStop(o) {
+			quantity += o.Quantity
+			notional += float64(o.Quantity) * o.StopPrice
+		}
+	}
+
+	var reasons []string
+	if limits.RequirePosition {
+		held := 0
+		if position != nil {
+			held = position.Quantity
+		}
+		if quantity > held {
+			reasons = append(reasons, fmt.Sprintf("stops on %s would sell %d shares but %d are held", order.Security, quantity, held))
+		}
+	}
+	maxNotional, capped := limits.MaxNotional[order.Security]
+	if !capped {
+		maxNotional, capped = limits.MaxNotional["*"]
+	}
+	if capped && notional > maxNotional {
+		reasons = append(reasons, fmt.Sprintf("stops on %s would total %.2f notional, over the %.2f limit", order.Security, notional, maxNotional))
+	}
+
+	if len(reasons) > 0 {
+		return &RiskRejection{Reasons: reasons}
+	}
+	return nil
+}
+
+// parseMaxNotional reads RISK_MAX_NOTIONAL, e.g. "AAPL=100000,*=50000".
+func parseMaxNotional(v string) (map[string]float64, error) {
+	limits := map[string]float64{}
+	for _, entry := range strings.Split(v, ",") {
+		security, limit, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || security == "" {
+			return nil, fmt.Errorf("expected SECURITY=LIMIT, got %q", entry)
+		}
+		parsed, err := strconv.ParseFloat(limit, 64)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("limit for %s must be a positive number, got %q", security, limit)
+		}
+		limits[security] = parsed
+	}
+	return limits, nil
+}
+
+// describeMaxNotional is parseMaxNotional in reverse, for the startup log.
+func describeMaxNotional(limits map[string]float64) string {
+	if len(limits) == 0 {
+		return "none"
+	}
+	var entries []string
+	for security, limit := range limits {
+		entries = append(entries, fmt.Sprintf("%s=%g", security, limit))
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+// riskReasons unwraps a RiskRejection's reasons, nil for any other error.
+func riskReasons(err error) []string {
+	var rejection *RiskRejection
+	if errors.As(err, &rejection) {
+		return rejection.Reasons
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckRisk(t *testing.T) {
+	existing := []StopLossOrder{
+		{Security: "AAPL", StopPrice: 140, Quantity: 50, Status: OrderStatusPending},
+		{Security: "AAPL", StopPrice: 130, Quantity: 20, Status: OrderStatusFailed},
+		{Security: "AAPL", StopPrice: 150, Quantity: 500, Status: OrderStatusExecuted},
+		{Security: "AAPL", StopPrice: 150, Quantity: 500, Status: OrderStatusCancelled},
+	}
+	order := StopLossOrder{Security: "AAPL", StopPrice: 145, Quantity: 30}
+
+	tests := []struct {
+		name     string
+		limits   RiskLimits
+		position *Position
+		reasons  []string
+	}{
+		{
+			name:     "within the position",
+			limits:   RiskLimits{RequirePosition: true},
+			position: &Position{Security: "AAPL", Quantity: 100},
+		},
+		{
+			name:     "more than the position",
+			limits:   RiskLimits{RequirePosition: true},
+			position: &Position{Security: "AAPL", Quantity: 99},
+			reasons:  []string{"stops on AAPL would sell 100 shares but 99 are held"},
+		},
+		{
+			name:    "no position",
+			limits:  RiskLimits{RequirePosition: true},
+			reasons: []string{"stops on AAPL would sell 100 shares but 0 are held"},
+		},
+		{
+			name:   "position not required",
+			limits: RiskLimits{},
+		},
+		{
+			// 50×140 + 20×130 + 30×145
+			name:   "at the security's notional limit",
+			limits: RiskLimits{MaxNotional: map[string]float64{"AAPL": 13950, "*": 1}},
+		},
+		{
+			name:    "over the security's notional limit",
+			limits:  RiskLimits{MaxNotional: map[string]float64{"AAPL": 13000, "*": 1e6}},
+			reasons: []string{"stops on AAPL would total 13950.00 notional, over the 13000.00 limit"},
+		},
+		{
+			name:    "over the default notional limit",
+			limits:  RiskLimits{MaxNotional: map[string]float64{"GOOG": 1e6, "*": 10000}},
+			reasons: []string{"stops on AAPL would total 13950.00 notional, over the 10000.00 limit"},
+		},
+		{
+			name:   "every limit broken",
+			limits: RiskLimits{RequirePosition: true, MaxNotional: map[string]float64{"*": 10000}},
+			reasons: []string{
+				"stops on AAPL would sell 100 shares but 0 are held",
+				"stops on AAPL would total 13950.00 notional, over the 10000.00 limit",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRisk(tt.limits, order, existing, tt.position)
+			if tt.reasons == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Equal(t, tt.reasons, riskReasons(err))
+		})
+	}
+}
+
+func TestParseMaxNotional(t *testing.T) {
+	limits, err := parseMaxNotional("AAPL=100000, *=50000.5")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"AAPL": 100000, "*": 50000.5}, limits)
+	assert.Equal(t, "*=50000.5,AAPL=100000", describeMaxNotional(limits))
+
+	for _, v := range []string{"AAPL", "=100", "AAPL=lots", "AAPL=0", "AAPL=100,"} {
+		_, err := parseMaxNotional(v)
+		assert.Error(t, err, v)
+	}
+}
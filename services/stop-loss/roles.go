@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Roles, from least to most trusted. Each may do everything the ones before
+// it may.
+const (
+	RoleViewer      = "viewer"
+	RoleTrader      = "trader"
+	RoleRiskManager = "risk-manager"
+	RoleAdmin       = "admin"
+)
+
+var roles = []string{RoleViewer, RoleTrader, RoleRiskManager, RoleAdmin}
+
+// Actions a role may be allowed. A refused one is recorded in the audit log
+// under its name.
+const (
+	ActionViewOrders = "view-orders"
+	ActionPlaceOrder = "place-order"
+	// ActionCancelOrder covers the user's own account's orders, or every
+	// account's with ActionViewAllAccounts.
+	ActionCancelOrder = "cancel-order"
+	// ActionManageNotifications is scoped the same way.
+	ActionManageNotifications = "manage-notifications"
+	ActionViewAllAccounts     = "view-all-accounts"
+	ActionHaltExecutions      = "halt-executions"
+	ActionRedriveOrder        = "redrive-order"
+	ActionSetPositions        = "set-positions"
+	ActionManageUsers         = "manage-users"
+	ActionManageWebhooks      = "manage-webhooks"
+	ActionReconcile           = "reconcile"
+	ActionViewAuditLog        = "view-audit-log"
+)
+
+// minimumRole is the least trusted role allowed each action.
+var minimumRole = map[string]string{
+	ActionViewOrders:          RoleViewer,
+	ActionPlaceOrder:          RoleTrader,
+	ActionCancelOrder:         RoleTrader,
+	ActionManageNotifications: RoleTrader,
+	ActionViewAllAccounts:     RoleRiskManager,
+	ActionHaltExecutions:      RoleRiskManager,
+	ActionRedriveOrder:        RoleAdmin,
+	ActionSetPositions:        RoleRiskManager,
+	ActionManageUsers:         RoleAdmin,
+	ActionManageWebhooks:      RoleAdmin,
+	ActionReconcile:           RoleAdmin,
+	ActionViewAuditLog:        RoleAdmin,
+}
+
+// roleRank orders roles by trust, -1 for one that doesn't exist.
+func roleRank(role string) int {
+	for i, r := range roles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+func validRole(role string) bool {
+	return roleRank(role) >= 0
+}
+
+// Can says whether the user's role allows action. Unknown roles and actions
+// allow nothing.
+func (u User) Can(action string) bool {
+	required, ok := minimumRole[action]
+	if !ok {
+		return false
+	}
+	rank := roleRank(u.Role)
+	return rank >= 0 && rank >= roleRank(required)
+}
+
+// allow serves the request only if the user's role allows action. Anyone
+// else is refused with a 403, and the refusal goes in the audit log.
+func (s *WebServer) allow(action string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := userFrom(r)
+		if !user.Can(action) {
+			s.deny(w, r, user, action)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *WebServer) deny(w http.ResponseWriter, r *http.Request, user User, action string) {
+	s.auditDenial(r, user, action)
+	writeAuthError(w, r, http.StatusForbidden, fmt.Sprintf("role %q doesn't allow %s", user.Role, action))
+}
+
+// auditDenial records that user was refused action on the request's target.
+func (s *WebServer) auditDenial(r *http.Request, user User, action string) {
+	entry := AuditEntry{
+		Username:   user.Username,
+		Role:       user.Role,
+		Action:     action,
+		Target:     r.Method + " " + r.URL.Path,
+		Outcome:    AuditOutcomeDenied,
+		OccurredAt: time.Now().UTC(),
+	}
+	slog.WarnContext(r.Context(), "Denied action", "action", action, "username", entry.Username, "role", entry.Role, "target", entry.Target)
+	if err := s.auditRepo.AppendEntry(entry); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to record denied action", "action", action, "username", entry.Username, "error", err)
+	}
+}
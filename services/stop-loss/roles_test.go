@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserCan(t *testing.T) {
+	viewer := User{Role: RoleViewer}
+	assert.True(t, viewer.Can(ActionViewOrders))
+	assert.False(t, viewer.Can(ActionPlaceOrder))
+	assert.False(t, viewer.Can(ActionCancelOrder))
+
+	trader := User{Role: RoleTrader}
+	assert.True(t, trader.Can(ActionPlaceOrder))
+	assert.True(t, trader.Can(ActionCancelOrder))
+	assert.False(t, trader.Can(ActionViewAllAccounts))
+	assert.False(t, trader.Can(ActionHaltExecutions))
+
+	riskManager := User{Role: RoleRiskManager}
+	assert.True(t, riskManager.Can(ActionHaltExecutions))
+	assert.True(t, riskManager.Can(ActionViewAllAccounts))
+	assert.False(t, riskManager.Can(ActionManageUsers))
+	assert.False(t, riskManager.Can(ActionReconcile))
+
+	admin := User{Role: RoleAdmin}
+	for action := range minimumRole {
+		assert.True(t, admin.Can(action), action)
+	}
+	assert.False(t, admin.Can("launch-missiles"))
+	assert.False(t, User{Role: "superuser"}.Can(ActionViewOrders))
+	assert.False(t, User{}.Can(ActionViewOrders))
+}
+
+func TestRolesEnforcedOnRoutes(t *testing.T) {
+	ts := newTestWebServer(t)
+	ts.addUserWithRole(t, "vic", "acct-1", RoleViewer)
+	ts.addUser(t, "alice", "acct-1")
+	ts.addUserWithRole(t, "rita", "acct-2", RoleRiskManager)
+	_, err := ts.orders.CreateOrder(StopLossOrder{ID: "order-1", AccountID: "acct-1", Security: "AAPL", StopPrice: 145, Quantity: 10, Status: OrderStatusPending})
+	require.NoError(t, err)
+
+	placeOrder := func() *http.Request {
+		return httptest.NewRequest("POST", "/api/orders", strings.NewReader(`{"security": "AAPL", "stopPrice": 140, "quantity": 5}`))
+	}
+	halt := func() *http.Request {
+		return httptest.NewRequest("POST", "/api/halts", strings.NewReader(`{"security": "AAPL"}`))
+	}
+
+	// viewers only look
+	assert.Equal(t, http.StatusOK, ts.doAs("vic", httptest.NewRequest("GET", "/api/orders", nil)).Code)
+	assert.Equal(t, http.StatusOK, ts.doAs("vic", httptest.NewRequest("GET", "/", nil)).Code)
+	rec := ts.doAs("vic", placeOrder())
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), ActionPlaceOrder)
+	assert.Equal(t, http.StatusForbidden, ts.doAs("vic", httptest.NewRequest("POST", "/api/orders/order-1/cancel", nil)).Code)
+	assert.Equal(t, http.StatusForbidden, ts.doAs("vic", httptest.NewRequest("POST", "/orders/order-1/cancel", nil)).Code)
+
+	// traders place and cancel their own account's orders, but don't halt
+	assert.Equal(t, http.StatusCreated, ts.doAs("alice", placeOrder()).Code)
+	assert.Equal(t, http.StatusOK, ts.doAs("alice", httptest.NewRequest("POST", "/api/orders/order-1/cancel", nil)).Code)
+	assert.Equal(t, http.StatusForbidden, ts.doAs("alice", halt()).Code)
+	assert.Equal(t, http.StatusForbidden, ts.doAs("alice", httptest.NewRequest("POST", "/api/orders/order-1/redrive", strings.NewReader(`{"action": "retry"}`))).Code)
+
+	// risk managers halt and cancel another account's orders, but don't
+	// re-drive them, manage users or reconcile
+	assert.Equal(t, http.StatusCreated, ts.doAs("rita", halt()).Code)
+	assert.Equal(t, http.StatusOK, ts.doAs("rita", httptest.NewRequest("POST", "/api/orders/order-1/cancel", nil)).Code)
+	assert.Equal(t, []string{"order-1", "order-1"}, ts.service.cancelled)
+	assert.Equal(t, http.StatusForbidden, ts.doAs("rita", httptest.NewRequest("POST", "/api/orders/order-1/redrive", strings.NewReader(`{"action": "retry"}`))).Code)
+	assert.Equal(t, http.StatusForbidden, ts.doAs("rita", httptest.NewRequest("GET", "/api/users", nil)).Code)
+	assert.Equal(t, http.StatusForbidden, ts.doAs("rita", httptest.NewRequest("POST", "/api/reconcile", nil)).Code)
+
+	// every refusal is in the audit log, which only admins read
+	assert.Equal(t, http.StatusForbidden, ts.doAs("rita", httptest.NewRequest("GET", "/api/audit", nil)).Code)
+	rec = ts.do(httptest.NewRequest("GET", "/api/audit", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var entries []AuditEntry
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &entries))
+	require.Len(t, entries, 9)
+	assert.Equal(t, AuditEntry{ID: 9, Username: "rita", Role: RoleRiskManager, Action: ActionViewAuditLog, Target: "GET /api/audit", Outcome: AuditOutcomeDenied, OccurredAt: entries[0].OccurredAt}, entries[0])
+	assert.Equal(t, "vic", entries[8].Username)
+	assert.Equal(t, ActionPlaceOrder, entries[8].Action)
+	assert.Equal(t, "POST /api/orders", entries[8].Target)
+
+	rec = ts.do(httptest.NewRequest("GET", "/api/audit?limit=1", nil))
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &entries))
+	assert.Len(t, entries, 1)
+	assert.Equal(t, http.StatusBadRequest, ts.do(httptest.NewRequest("GET", "/api/audit?limit=0", nil)).Code)
+}
+
+func TestWebHidesFormsTheRoleCantUse(t *testing.T) {
+	ts := newTestWebServer(t)
+	ts.addUserWithRole(t, "vic", "acct-1", RoleViewer)
+	ts.addUser(t, "alice", "acct-1")
+
+	body := ts.doAs("vic", httptest.NewRequest("GET", "/", nil)).Body.String()
+	assert.NotContains(t, body, `id="order-form"`)
+	assert.NotContains(t, body, `id="halt-form"`)
+
+	body = ts.doAs("alice", httptest.NewRequest("GET", "/", nil)).Body.String()
+	assert.Contains(t, body, `id="order-form"`)
+	assert.NotContains(t, body, `id="halt-form"`)
+
+	body = ts.do(httptest.NewRequest("GET", "/", nil)).Body.String()
+	assert.Contains(t, body, `id="order-form"`)
+	assert.Contains(t, body, `id="halt-form"`)
+}
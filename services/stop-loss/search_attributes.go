@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/operatorservice/v1"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/temporal"
+)
+
+// Custom search attributes StopLossWorkflow keeps up to date, so orders can
+// be found in the Temporal UI and through visibility queries.
+var (
+	SearchAttributeOrderID     = temporal.NewSearchAttributeKeyKeyword("OrderID")
+	SearchAttributeSecurity    = temporal.NewSearchAttributeKeyKeyword("Security")
+	SearchAttributeOrderStatus = temporal.NewSearchAttributeKeyKeyword("OrderStatus")
+	SearchAttributeStopPrice   = temporal.NewSearchAttributeKeyFloat64("StopPrice")
+	SearchAttributeAccountID   = temporal.NewSearchAttributeKeyKeyword("AccountID")
+)
+
+var orderSearchAttributeTypes = map[string]enums.IndexedValueType{
+	SearchAttributeOrderID.GetName():     enums.INDEXED_VALUE_TYPE_KEYWORD,
+	SearchAttributeSecurity.GetName():    enums.INDEXED_VALUE_TYPE_KEYWORD,
+	SearchAttributeOrderStatus.GetName(): enums.INDEXED_VALUE_TYPE_KEYWORD,
+	SearchAttributeStopPrice.GetName():   enums.INDEXED_VALUE_TYPE_DOUBLE,
+	SearchAttributeAccountID.GetName():   enums.INDEXED_VALUE_TYPE_KEYWORD,
+}
+
+// RegisterSearchAttributes adds the order search attributes to the namespace
+// if they aren't there yet. Workflows that set an unregistered attribute get
+// stuck, so the service refuses to start without them.
+func RegisterSearchAttributes(ctx context.Context, temporalClient client.Client, namespace string) error {
+	existing, err := temporalClient.OperatorService().ListSearchAttributes(ctx, &operatorservice.ListSearchAttributesRequest{Namespace: namespace})
+	if err != nil {
+		return fmt.Errorf("failed to list search attributes: %w", err)
+	}
+
+	missing := map[string]enums.IndexedValueType{}
+	for name, valueType := range orderSearchAttributeTypes {
+		registered, ok := existing.GetCustomAttributes()[name]
+		if !ok {
+			missing[name] = valueType
+			continue
+		}
+		if registered != valueType {
+			return fmt.Errorf("search attribute %s is registered as %s, expected %s", name, registered, valueType)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	_, err = temporalClient.OperatorService().AddSearchAttributes(ctx, &operatorservice.AddSearchAttributesRequest{
+		Namespace:        namespace,
+		SearchAttributes: missing,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add search attributes: %w", err)
+	}
+	slog.Info("Registered search attributes", "count", len(missing), "namespace", namespace)
+	return nil
+}
+
+// orderSearchAttributes are the attributes a StopLossWorkflow run sets when it
+// starts; afterwards only the status changes.
+func orderSearchAttributes(order StopLossOrder, status string) []temporal.SearchAttributeUpdate {
+	attributes := []temporal.SearchAttributeUpdate{
+		SearchAttributeOrderID.ValueSet(order.ID),
+		SearchAttributeSecurity.ValueSet(order.Security),
+		SearchAttributeOrderStatus.ValueSet(status),
+		SearchAttributeStopPrice.ValueSet(order.StopPrice),
+	}
+	// orders from before accounts leave it unset rather than empty
+	if order.AccountID != "" {
+		attributes = append(attributes, SearchAttributeAccountID.ValueSet(order.AccountID))
+	}
+	return attributes
+}
+
+// OrderSearch filters a visibility search for orders. Empty fields match
+// everything.
+type OrderSearch struct {
+	Security     string
+	Status       string
+	AccountID    string
+	MinStopPrice float64
+	MaxStopPrice float64
+}
+
+// visibilityQuery matches the current run of every StopLossWorkflow that
+// passes the filters; runs that continued as new are left out.
+func (s OrderSearch) visibilityQuery() string {
+	conditions := []string{
+		"WorkflowType = 'StopLossWorkflow'",
+		"ExecutionStatus != 'ContinuedAsNew'",
+	}
+	for _, keyword := range []struct {
+		key   temporal.SearchAttributeKeyKeyword
+		value string
+	}{
+		{SearchAttributeSecurity, s.Security},
+		{SearchAttributeOrderStatus, s.Status},
+		{SearchAttributeAccountID, s.AccountID},
+	} {
+		if keyword.value != "" {
+			conditions = append(conditions, fmt.Sprintf("%s = %s", keyword.key.GetName(), quoteQueryValue(keyword.value)))
+		}
+	}
+	if s.MinStopPrice > 0 {
+		conditions = append(conditions, fmt.Sprintf("%s >= %s", SearchAttributeStopPrice.GetName(), strconv.FormatFloat(s.MinStopPrice, 'f', -1, 64)))
+	}
+	if s.MaxStopPrice > 0 {
+		conditions = append(conditions, fmt.Sprintf("%s <= %s", SearchAttributeStopPrice.GetName(), strconv.FormatFloat(s.MaxStopPrice, 'f', -1, 64)))
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+func quoteQueryValue(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// OrderSearchResult is what Temporal's visibility store knows about an order,
+// which is less than the orders table does.
+type OrderSearchResult struct {
+	OrderID    string  `json:"orderID"`
+	Security   string  `json:"security"`
+	StopPrice  float64 `json:"stopPrice"`
+	Status     string  `json:"status"`
+	AccountID  string  `json:"accountID,omitempty"`
+	WorkflowID string  `json:"workflowID"`
+	RunID      string  `json:"runID"`
+}
+
+// orderSearchResult reads an order back out of a workflow's search
+// attributes. Runs started before the attributes existed have none, and come
+// back with only their workflow IDs.
+func orderSearchResult(execution *workflowpb.WorkflowExecutionInfo) (OrderSearchResult, error) {
+	result := OrderSearchResult{
+		WorkflowID: execution.GetExecution().GetWorkflowId(),
+		RunID:      execution.GetExecution().GetRunId(),
+	}
+	fields := execution.GetSearchAttributes().GetIndexedFields()
+	for name, value := range map[string]any{
+		SearchAttributeOrderID.GetName():     &result.OrderID,
+		SearchAttributeSecurity.GetName():    &result.Security,
+		SearchAttributeStopPrice.GetName():   &result.StopPrice,
+		SearchAttributeOrderStatus.GetName(): &result.Status,
+		SearchAttributeAccountID.GetName():   &result.AccountID,
+	} {
+		payload, ok := fields[name]
+		if !ok {
+			continue
+		}
+		if err := converter.GetDefaultDataConverter().FromPayload(payload, value); err != nil {
+			return OrderSearchResult{}, fmt.Errorf("failed to decode search attribute %s of workflow %s: %w", name, result.WorkflowID, err)
+		}
+	}
+	return result, nil
+}
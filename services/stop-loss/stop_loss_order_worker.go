@@ -2,46 +2,81 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"sync/atomic"
 	"time"
 
-	"go.temporal.io/sdk/activity"
 	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/interceptor"
+	tlog "go.temporal.io/sdk/log"
 	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/worker"
 	"go.temporal.io/sdk/workflow"
 )
 
-func StartLossOrderWorker(temporalClient client.Client, ordersRepo OrdersRepo) {
-	w := worker.New(temporalClient, "stop-loss-task-queue", worker.Options{})
+// StartLossOrderWorker starts polling the task queue. stop waits for the
+// activities in flight, up to workerStopTimeout, and then stops polling.
+func StartLossOrderWorker(temporalClient client.Client, ordersRepo OrdersRepo, eventsRepo OrderEventsRepo, haltsRepo HaltsRepo, positionsRepo PositionsRepo, webhooksRepo WebhooksRepo, notificationsRepo NotificationsRepo, notifiers map[string]Notifier, reconciler *Reconciler) (stop func(), err error) {
+	w := worker.New(temporalClient, "stop-loss-task-queue", worker.Options{
+		Interceptors:      []interceptor.WorkerInterceptor{&executionTracingInterceptor{}},
+		WorkerStopTimeout: workerStopTimeout,
+	})
 	w.RegisterWorkflow(StopLossWorkflow)
 	w.RegisterActivity(ExecuteOrderActivity)
 
-	// CreateOrderActivity as a closure, capturing orderRepo
-	createOrderActivity := func(ctx context.Context, order StopLossOrder) error {
-		return CreateOrderActivity(ctx, order, ordersRepo)
-	}
-	w.RegisterActivityWithOptions(createOrderActivity, activity.RegisterOptions{
-		Name: "CreateOrderActivity",
-	})
+	// repo-backed activities are registered as methods so they keep their
+	// plain names (CreateOrderActivity, UpdateOrderStatusActivity)
+	w.RegisterActivity(NewOrderActivities(ordersRepo, eventsRepo, haltsRepo, positionsRepo))
 
-	// UpdateOrderStatusActivity as a closure, capturing orderRepo
-	updateOrderStatusActivity := func(ctx context.Context, orderID string, status string) error {
-		return UpdateOrderStatusActivity(ctx, orderID, status, ordersRepo)
-	}
-	w.RegisterActivityWithOptions(updateOrderStatusActivity, activity.RegisterOptions{
-		Name: "UpdateOrderStatusActivity",
-	})
+	w.RegisterActivity(NewNotificationActivities(ordersRepo, notificationsRepo, notifiers))
 
-	log.Println("Starting Temporal worker...")
-	err := w.Run(worker.InterruptCh())
-	if err != nil {
-		log.Fatalf("Unable to start worker: %v", err)
+	w.RegisterWorkflow(WebhookDeliveryWorkflow)
+	w.RegisterActivity(NewWebhookActivities(webhooksRepo, NewWebhookPublisher(temporalClient, ordersRepo, webhooksRepo)))
+
+	w.RegisterWorkflow(ReconcileWorkflow)
+	w.RegisterActivity(NewReconcileActivities(reconciler))
+
+	slog.Info("Starting Temporal worker", "taskQueue", "stop-loss-task-queue")
+	if err := w.Start(); err != nil {
+		return nil, err
 	}
+	workerRunning.Store(true)
+	return func() {
+		workerRunning.Store(false)
+		w.Stop()
+	}, nil
 }
 
-func StopLossWorkflow(ctx workflow.Context, order StopLossOrder) error {
+// workerStopTimeout is how long a stopping worker lets its activities run on,
+// long enough for an order's execution to finish.
+const workerStopTimeout = 10 * time.Second
+
+// workerRunning is true while the worker polls its task queue, for the
+// health checks.
+var workerRunning atomic.Bool
+
+// Orders sit in StopLossWorkflow across deploys, and their histories are
+// replayed through whatever code is current. A change to the commands the
+// workflow issues, or their order, goes behind workflow.GetVersion under a
+// change ID of its own, with the old branch kept until no run that took it
+// can still be open. See "Changing StopLossWorkflow" in the README.
+const (
+	changeCreateOrderBeforeStart = "create-order-before-start"
+	changeContinueAsNew          = "continue-as-new"
+	changeSearchAttributes       = "search-attributes"
+	changeExecutionFailurePolicy = "execution-failure-policy"
+	changeExecutionHalts         = "execution-halts"
+	changeNotifications          = "notifications"
+	changeSignalsDuringHandover  = "signals-during-handover"
+	changePublishWebhooks        = "publish-webhooks"
+)
+
+// StopLossWorkflow watches one order until it executes or is cancelled. run
+// carries state across continue-as-new; a fresh order passes just the
+// policies.
+func StopLossWorkflow(ctx workflow.Context, order StopLossOrder, run StopLossRun) error {
 	options := workflow.ActivityOptions{
 		ScheduleToCloseTimeout: time.Hour * 24 * 365, // TODO: make this infinity
 		HeartbeatTimeout:       time.Second * 30,
@@ -53,8 +88,9 @@ func StopLossWorkflow(ctx workflow.Context, order StopLossOrder) error {
 		},
 	}
 	ctx = workflow.WithActivityOptions(ctx, options)
-	logger := workflow.GetLogger(ctx)
-	logger.Info("StopLossWorkflow started", "orderID", order.ID, "security", order.Security, "stopPrice", order.StopPrice, "quantity", order.Quantity)
+	// every line the workflow logs is about this order
+	logger := tlog.With(workflow.GetLogger(ctx), "orderID", order.ID, "security", order.Security)
+	logger.Info("StopLossWorkflow started", "stopPrice", order.StopPrice, "quantity", order.Quantity)
 
 	workflowInfo := workflow.GetInfo(ctx)
 	runID := workflowInfo.WorkflowExecution.RunID
@@ -63,99 +99,613 @@ func StopLossWorkflow(ctx workflow.Context, order StopLossOrder) error {
 	// set this here prior to creation so the disatcher can signal to the worker
 	order.WorkflowID = workflowID
 
-	err := workflow.ExecuteActivity(ctx, CreateOrderActivity, order).Get(ctx, nil)
-	if err != nil {
-		logger.Error("Failed to create order", err)
-		return fmt.Errorf("failed to create order: %v", err)
-	}
-
-	priceUpdateChannel := workflow.GetSignalChannel(ctx, PriceUpdateSignalName)
-	cancelOrderChannel := workflow.GetSignalChannel(ctx, CancelOrderSignalName)
+	var a *OrderActivities
+	var n *NotificationActivities
 
 	// TODO: are these ok being just in memory values? seems dicey
 	isOrderExecuted := order.Status == OrderStatusExecuted
 	isOrderCancelled := order.Status == OrderStatusCancelled
+	isExecuting := false   // between triggering and the order filling or failing
+	awaitingRetry := false // between failed attempts; unlike executing, cancellable
+	isFailed := order.Status == OrderStatusFailed
+	failureReason := order.FailureReason
+	halted := run.Halted // the halt a trigger is queued behind, if any
 
-	selector := workflow.NewSelector(ctx)
+	// only reported through the state query
+	lastPrice, lastPriceAt := run.LastPrice, run.LastPriceAt
+	priceUpdates := run.PriceUpdates
+	executionAttempts := run.ExecutionAttempts
 
-	for !isOrderExecuted && !isOrderCancelled {
-		selector = workflow.NewSelector(ctx)
+	currentStatus := func() string {
+		switch {
+		case isOrderCancelled:
+			return OrderStatusCancelled
+		case isExecuting || awaitingRetry:
+			return OrderStatusExecuting
+		case halted != nil:
+			return OrderStatusHalted
+		case isOrderExecuted:
+			return OrderStatusExecuted
+		case isFailed:
+			return OrderStatusFailed
+		}
+		return OrderStatusPending
+	}
 
-		selector.AddReceive(priceUpdateChannel, func(c workflow.ReceiveChannel, more bool) {
-			var signalData PriceUpdateSignalData
-			c.Receive(ctx, &signalData)
+	err := workflow.SetQueryHandler(ctx, OrderStateQueryName, func() (OrderLiveState, error) {
+		haltReason := ""
+		if halted != nil {
+			haltReason = halted.Reason
+		}
+		return OrderLiveState{
+			OrderID:           order.ID,
+			Security:          order.Security,
+			Status:            currentStatus(),
+			EffectiveStop:     order.StopPrice,
+			LastPrice:         lastPrice,
+			LastPriceAt:       lastPriceAt,
+			PriceUpdates:      priceUpdates,
+			ExecutionAttempts: executionAttempts,
+			FailureReason:     failureReason,
+			HaltReason:        haltReason,
+		}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register state query handler: %w", err)
+	}
+
+	events := startEventRecorder(ctx)
+	recordEvent := func(ctx workflow.Context, eventType, source string, payload any) {
+		events.record(ctx, newOrderEvent(order.ID, eventType, source, payload, workflow.Now(ctx)))
+	}
+
+	// runs started before the search attributes existed don't set them
+	searchable := false
+	setSearchStatus := func(status string) {
+		if !searchable {
+			return
+		}
+		if err := workflow.UpsertTypedSearchAttributes(ctx, SearchAttributeOrderStatus.ValueSet(status)); err != nil {
+			logger.Error("Failed to update OrderStatus search attribute", "status", status, "error", err)
+		}
+	}
+
+	// cancel is the one place cancellation is decided, so the answer can't
+	// race with execution
+	cancelled := workflow.NewBufferedChannel(ctx, 1) // wakes the main loop
+	cancel := func(ctx workflow.Context, source string) string {
+		switch {
+		case isOrderCancelled:
+			return CancelResultCancelled
+		case isExecuting:
+			return CancelResultTooLate
+		case isOrderExecuted:
+			return CancelResultAlreadyExecuted
+		}
+		if source == "" {
+			source = EventSourceWorkflow
+		}
+
+		logger.Info("Cancelling order", "runID", runID, "source", source)
+		isOrderCancelled = true
+		cancelled.SendAsync(true)
+		setSearchStatus(OrderStatusCancelled)
+		recordEvent(ctx, OrderEventCancelled, source, nil)
+		err := workflow.ExecuteActivity(ctx, a.UpdateOrderStatusActivity, order.ID, OrderStatusCancelled).Get(ctx, nil)
+		if err != nil {
+			logger.Error("Failed to update order status to CANCELLED", "error", err)
+		}
+		logger.Info("StopLossWorkflow cancelled for order", "runID", runID)
+		return CancelResultCancelled
+	}
+
+	err = workflow.SetUpdateHandler(ctx, CancelOrderUpdateName, func(ctx workflow.Context, req CancelOrderRequest) (string, error) {
+		// handlers get a fresh context, without the workflow's activity options
+		return cancel(workflow.WithActivityOptions(ctx, options), req.Source), nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register cancel update handler: %w", err)
+	}
+
+	// orders used to be written by the workflow itself; now the row exists
+	// before the workflow starts, and only runs started the old way still
+	// create it here
+	if workflow.GetVersion(ctx, changeCreateOrderBeforeStart, workflow.DefaultVersion, 1) == workflow.DefaultVersion {
+		err := workflow.ExecuteActivity(ctx, a.CreateOrderActivity, order).Get(ctx, nil)
+		if err != nil {
+			logger.Error("Failed to create order", "error", err)
+			return fmt.Errorf("failed to create order: %v", err)
+		}
+	}
+
+	// runs started before continue-as-new existed never do it, so their
+	// histories still replay
+	canContinueAsNew := workflow.GetVersion(ctx, changeContinueAsNew, workflow.DefaultVersion, 1) == 1
 
-			if signalData.Security != order.Security {
-				logger.Warn("Received price update for incorrect security", "expected", order.Security, "received", signalData.Security)
+	searchable = workflow.GetVersion(ctx, changeSearchAttributes, workflow.DefaultVersion, 1) == 1
+
+	// runs started before halts existed execute without looking for one
+	checksHalts := workflow.GetVersion(ctx, changeExecutionHalts, workflow.DefaultVersion, 1) == 1
+
+	// nor did runs started before notifications send any
+	notifies := workflow.GetVersion(ctx, changeNotifications, workflow.DefaultVersion, 1) == 1
+	if searchable {
+		if err := workflow.UpsertTypedSearchAttributes(ctx, orderSearchAttributes(order, currentStatus())...); err != nil {
+			logger.Error("Failed to set order search attributes", "error", err)
+		}
+	}
+
+	priceUpdateChannel := workflow.GetSignalChannel(ctx, PriceUpdateSignalName)
+	// cancellation used to be a signal; still honoured for runs that got one
+	cancelOrderChannel := workflow.GetSignalChannel(ctx, CancelOrderSignalName)
+	redriveChannel := workflow.GetSignalChannel(ctx, RedriveSignalName)
+	resumeChannel := workflow.GetSignalChannel(ctx, ResumeSignalName)
+	signalsThisRun := 0
+
+	// haltFor looks for a halt on the order's security. One that can't be
+	// read doesn't stop the order: the table being unreachable is far more
+	// likely than a halt.
+	haltFor := func() *Halt {
+		if !checksHalts {
+			return nil
+		}
+		var halt *Halt
+		if err := workflow.ExecuteActivity(ctx, a.ExecutionHaltActivity, order.Security).Get(ctx, &halt); err != nil {
+			logger.Error("Failed to check for an execution halt, executing anyway", "error", err)
+			return nil
+		}
+		return halt
+	}
+
+	// notify tells the account about an order's status once it's persisted.
+	// It sends from a coroutine of its own, so a slow mail server holds up
+	// nothing but the end of the run.
+	notifying := 0
+	notify := func(event string) {
+		if !notifies {
+			return
+		}
+		notifying++
+		workflow.Go(ctx, func(ctx workflow.Context) {
+			defer func() { notifying-- }()
+			notifyCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+				StartToCloseTimeout: 2 * notificationTimeout,
+				RetryPolicy: &temporal.RetryPolicy{
+					InitialInterval:    10 * time.Second,
+					BackoffCoefficient: 2.0,
+					MaximumInterval:    5 * time.Minute,
+					MaximumAttempts:    notificationAttempts,
+				},
+			})
+			if err := workflow.ExecuteActivity(notifyCtx, n.NotifyOrderActivity, order.ID, event).Get(ctx, nil); err != nil {
+				logger.Error("Failed to send order notifications", "event", event, "error", err)
+			}
+		})
+	}
+
+	// executeOrder tries to fill the order, as often as the failure policy
+	// allows, and leaves it executed, re-armed or FAILED, or queued behind a
+	// halt.
+	executeOrder := func() {
+		for retries := 0; ; retries++ {
+			if halt := haltFor(); halt != nil {
+				halted = halt
+				logger.Info("Executions halted, queueing the trigger", "halt", haltScope(halt.Security), "reason", halt.Reason)
+				setSearchStatus(OrderStatusHalted)
+				recordEvent(ctx, OrderEventHalted, EventSourceWorkflow, map[string]string{"scope": haltScope(halt.Security), "reason": halt.Reason})
 				return
 			}
 
-			currentPrice := signalData.Price
-			logger.Debug("Received price update", "security", signalData.Security, "price", currentPrice, "stopPrice", order.StopPrice, "isOrderExecuted", isOrderExecuted, "isOrderCancelled", isOrderCancelled)
+			isExecuting = true
+			setSearchStatus(OrderStatusExecuting)
+			recordEvent(ctx, OrderEventExecutionAttempted, EventSourceWorkflow, map[string]any{"security": order.Security, "quantity": order.Quantity})
 
-			if currentPrice <= order.StopPrice && !isOrderExecuted && !isOrderCancelled {
-				logger.Info("Stop-loss price reached 📉!", "security", order.Security, "currentPrice", currentPrice, "stopPrice", order.StopPrice)
+			var executionResult string
+			executionAttempts++
+			err := workflow.ExecuteActivity(ctx, ExecuteOrderActivity, order.Security, order.Quantity).Get(ctx, &executionResult)
+			if err == nil {
+				isExecuting = false
 				isOrderExecuted = true
-
-				var executionResult string
-				err := workflow.ExecuteActivity(ctx, ExecuteOrderActivity, order.Security, order.Quantity).Get(ctx, &executionResult)
-				if err != nil {
-					logger.Error("ExecuteOrderActivity failed", "error", err)
-					workflow.ExecuteActivity(ctx, UpdateOrderStatusActivity, order.ID, OrderStatusPending)
-					return
-				}
-
 				logger.Info("ExecuteOrderActivity completed", "result", executionResult)
+				setSearchStatus(OrderStatusExecuted)
+				recordEvent(ctx, OrderEventExecuted, EventSourceWorkflow, map[string]string{"result": executionResult})
 
-				err = workflow.ExecuteActivity(ctx, UpdateOrderStatusActivity, order.ID, OrderStatusExecuted).Get(ctx, nil)
+				err = workflow.ExecuteActivity(ctx, a.UpdateOrderStatusActivity, order.ID, OrderStatusExecuted).Get(ctx, nil)
 				if err != nil {
 					logger.Error("Failed to update order status to EXECUTED after execution", "error", err)
 					return // Log error but execution is already done.
 				}
+				notify(OrderEventExecuted)
+
+				logger.Info("StopLossWorkflow executed for order", "runID", runID)
+				return
+			}
+
+			logger.Error("ExecuteOrderActivity failed", "error", err)
+			if workflow.GetVersion(ctx, changeExecutionFailurePolicy, workflow.DefaultVersion, 1) == workflow.DefaultVersion {
+				// what runs from before the failure policy did: write PENDING
+				// without waiting, and stop watching the order
+				isExecuting = false
+				isOrderExecuted = true
+				setSearchStatus(OrderStatusPending)
+				recordEvent(ctx, OrderEventFailed, EventSourceWorkflow, map[string]string{"error": err.Error()})
+				workflow.ExecuteActivity(ctx, a.UpdateOrderStatusActivity, order.ID, OrderStatusPending)
+				return
+			}
+			recordEvent(ctx, OrderEventFailed, EventSourceWorkflow, map[string]any{"error": err.Error(), "attempt": retries + 1})
+
+			switch run.OnFailure.next(retries) {
+			case FailureActionRetry:
+				isExecuting, awaitingRetry = false, true
+				logger.Info("Retrying execution", "delay", run.OnFailure.RetryDelay)
+				_, _ = workflow.AwaitWithTimeout(ctx, run.OnFailure.RetryDelay, func() bool { return isOrderCancelled })
+				awaitingRetry = false
+				if isOrderCancelled {
+					return
+				}
+			case FailureActionRearm:
+				isExecuting = false
+				logger.Info("Re-arming order after failed execution")
+				setSearchStatus(OrderStatusPending)
+				if err := workflow.ExecuteActivity(ctx, a.UpdateOrderStatusActivity, order.ID, OrderStatusPending).Get(ctx, nil); err != nil {
+					logger.Error("Failed to update order status to PENDING after failed execution", "error", err)
+				}
+				return
+			default:
+				isExecuting = false
+				isFailed = true
+				failureReason = executionFailureReason(err)
+				logger.Warn("Order failed, waiting to be re-driven", "reason", failureReason)
+				setSearchStatus(OrderStatusFailed)
+				if err := workflow.ExecuteActivity(ctx, a.MarkOrderFailedActivity, order.ID, failureReason).Get(ctx, nil); err != nil {
+					logger.Error("Failed to mark order FAILED", "error", err)
+					return
+				}
+				notify(OrderEventFailed)
+				return
+			}
+		}
+	}
+
+	redrive := func(req RedriveRequest) {
+		if !isFailed {
+			logger.Warn("Ignoring re-drive of an order that hasn't failed", "status", currentStatus())
+			return
+		}
+		logger.Info("Re-driving failed order", "action", req.Action, "source", req.Source)
+		isFailed = false
+		failureReason = ""
+		recordEvent(ctx, OrderEventRedriven, req.Source, map[string]string{"action": req.Action})
+
+		if req.Action == FailureActionRearm {
+			setSearchStatus(OrderStatusPending)
+			if err := workflow.ExecuteActivity(ctx, a.UpdateOrderStatusActivity, order.ID, OrderStatusPending).Get(ctx, nil); err != nil {
+				logger.Error("Failed to update order status to PENDING after re-drive", "error", err)
+			}
+			return
+		}
+		executeOrder()
+	}
+
+	// resume runs a trigger that was queued behind a halt, or, if the policy
+	// says so and the price has recovered since, re-arms the order instead.
+	resume := func(req ResumeRequest) {
+		if halted == nil {
+			return // nothing queued
+		}
+		logger.Info("Resuming halted order", "action", req.Action, "lastPrice", lastPrice, "source", req.Source)
+		halted = nil
+		recordEvent(ctx, OrderEventResumed, req.Source, map[string]any{"action": req.Action, "price": lastPrice})
 
-				logger.Info("StopLossWorkflow executed for order", "orderID", order.ID, "runID", runID)
-			} else if currentPrice > order.StopPrice {
-				logger.Debug("Price above stop-loss, waiting for trigger", "security", order.Security, "currentPrice", currentPrice, "stopPrice", order.StopPrice)
+		if req.Action == ResumeActionReevaluate && lastPrice > order.StopPrice {
+			// the row can still be FAILED if a re-drive was what got halted
+			logger.Info("Price recovered while halted, re-arming", "lastPrice", lastPrice, "stopPrice", order.StopPrice)
+			setSearchStatus(OrderStatusPending)
+			if err := workflow.ExecuteActivity(ctx, a.UpdateOrderStatusActivity, order.ID, OrderStatusPending).Get(ctx, nil); err != nil {
+				logger.Error("Failed to update order status to PENDING after resuming", "error", err)
 			}
+			return
+		}
+		executeOrder()
+	}
+
+	handlePrice := func(signalData PriceUpdateSignalData) {
+		if signalData.Security != order.Security {
+			logger.Warn("Received price update for incorrect security", "expected", order.Security, "received", signalData.Security)
+			return
+		}
+
+		currentPrice := signalData.Price
+		lastPrice, lastPriceAt = currentPrice, workflow.Now(ctx)
+		priceUpdates++
+		logger.Debug("Received price update", "price", currentPrice, "stopPrice", order.StopPrice, "isOrderExecuted", isOrderExecuted, "isOrderCancelled", isOrderCancelled)
+
+		if currentPrice <= order.StopPrice && !isOrderExecuted && !isOrderCancelled && !isFailed && halted == nil {
+			logger.Info("Stop-loss price reached 📉!", "currentPrice", currentPrice, "stopPrice", order.StopPrice)
+			recordEvent(ctx, OrderEventPriceTriggered, EventSourceWorkflow, map[string]float64{"price": currentPrice, "stopPrice": order.StopPrice})
+			executeOrder()
+		} else if currentPrice > order.StopPrice {
+			logger.Debug("Price above stop-loss, waiting for trigger", "currentPrice", currentPrice, "stopPrice", order.StopPrice)
+		}
+	}
+
+	// prices the previous run received while handing over
+	for _, signalData := range run.PendingPrices {
+		if isOrderExecuted || isOrderCancelled {
+			break
+		}
+		handlePrice(signalData)
+	}
+
+	controlSignalsPending := func() bool {
+		return cancelOrderChannel.Len() > 0 || redriveChannel.Len() > 0 || resumeChannel.Len() > 0
+	}
+
+	selector := workflow.NewSelector(ctx)
+
+	for !isOrderExecuted && !isOrderCancelled {
+		selector = workflow.NewSelector(ctx)
+
+		selector.AddReceive(priceUpdateChannel, func(c workflow.ReceiveChannel, more bool) {
+			var signalData PriceUpdateSignalData
+			c.Receive(ctx, &signalData)
+			signalsThisRun++
+			handlePrice(signalData)
 		})
 
 		selector.AddReceive(cancelOrderChannel, func(c workflow.ReceiveChannel, more bool) {
-			logger.Info("Cancellation signal received for order", "orderID", order.ID, "runID", runID)
-			isOrderCancelled = true
-			err := workflow.ExecuteActivity(ctx, UpdateOrderStatusActivity, order.ID, OrderStatusCancelled).Get(ctx, nil)
-			if err != nil {
-				logger.Error("Failed to update order status to CANCELLED", "error", err)
-			}
-			logger.Info("StopLossWorkflow cancelled for order", "orderID", order.ID, "runID", runID)
+			var cancelSignal CancelOrderRequest
+			c.Receive(ctx, &cancelSignal)
+			logger.Info("Cancellation signal received for order", "runID", runID)
+			cancel(ctx, cancelSignal.Source)
+		})
+
+		selector.AddReceive(redriveChannel, func(c workflow.ReceiveChannel, more bool) {
+			var req RedriveRequest
+			c.Receive(ctx, &req)
+			redrive(req)
 		})
 
-		// Wait for either price signal OR cancellation signal within the selector:
+		selector.AddReceive(resumeChannel, func(c workflow.ReceiveChannel, more bool) {
+			var req ResumeRequest
+			c.Receive(ctx, &req)
+			resume(req)
+		})
+
+		// the cancel update has already done the work, this just wakes the loop
+		selector.AddReceive(cancelled, func(c workflow.ReceiveChannel, more bool) {
+			c.Receive(ctx, nil)
+		})
+
+		// Wait for a price signal or a cancellation within the selector:
 		selector.Select(ctx)
+
+		if canContinueAsNew && !controlSignalsPending() && run.ContinueAsNew.due(signalsThisRun, workflow.GetInfo(ctx)) {
+			// a cancel in progress answers from this run, and every event and
+			// notification is written before the history is left behind
+			_ = workflow.Await(ctx, func() bool { return workflow.AllHandlersFinished(ctx) && events.idle() && notifying == 0 })
+			// only prices go along to the next run, so a cancel, re-drive or
+			// resume signalled while waiting is handled here first
+			if controlSignalsPending() && workflow.GetVersion(ctx, changeSignalsDuringHandover, workflow.DefaultVersion, 1) == 1 {
+				continue
+			}
+			if !isOrderExecuted && !isOrderCancelled {
+				order.Status, order.FailureReason = currentStatus(), failureReason
+				return continueAsNew(ctx, order, StopLossRun{
+					ContinueAsNew:     run.ContinueAsNew,
+					OnFailure:         run.OnFailure,
+					LastPrice:         lastPrice,
+					LastPriceAt:       lastPriceAt,
+					PriceUpdates:      priceUpdates,
+					ExecutionAttempts: executionAttempts,
+					Halted:            halted,
+				}, priceUpdateChannel)
+			}
+		}
 	}
+
+	// let an in-flight cancel finish writing its status and answer its
+	// caller, and notifications finish sending
+	_ = workflow.Await(ctx, func() bool { return workflow.AllHandlersFinished(ctx) && notifying == 0 })
+	events.flush(ctx)
 	return nil
 }
 
+// continueAsNew hands the order over to a fresh run with an empty history.
+// Signals this run received but hasn't handled go along with it, so none are
+// lost. The caller must not block between deciding to hand over and this.
+func continueAsNew(ctx workflow.Context, order StopLossOrder, next StopLossRun, priceUpdateChannel workflow.ReceiveChannel) error {
+	for {
+		var signalData PriceUpdateSignalData
+		if !priceUpdateChannel.ReceiveAsync(&signalData) {
+			break
+		}
+		next.PendingPrices = append(next.PendingPrices, signalData)
+	}
+
+	workflow.GetLogger(ctx).Info("Continuing as new", "orderID", order.ID, "historyLength", workflow.GetInfo(ctx).GetCurrentHistoryLength(), "pendingPrices", len(next.PendingPrices))
+	return workflow.NewContinueAsNewError(ctx, StopLossWorkflow, order, next)
+}
+
+// executionFailureReason is what the broker said, without the activity
+// error Temporal wraps it in.
+func executionFailureReason(err error) string {
+	if cause := errors.Unwrap(err); cause != nil {
+		return cause.Error()
+	}
+	return err.Error()
+}
+
+// next is what to do after the failure of the execution attempt that followed
+// the given number of retries.
+func (p ExecutionFailurePolicy) next(retries int) string {
+	switch p.Action {
+	case FailureActionRetry:
+		if retries < p.MaxRetries {
+			return FailureActionRetry
+		}
+	case FailureActionRearm:
+		return FailureActionRearm
+	}
+	return FailureActionEscalate
+}
+
+// due reports whether a run has grown enough to hand over. The server's own
+// suggestion is honoured whatever the policy says.
+func (p ContinueAsNewPolicy) due(signals int, info *workflow.Info) bool {
+	return (p.AfterSignals > 0 && signals >= p.AfterSignals) ||
+		(p.AfterHistoryEvents > 0 && info.GetCurrentHistoryLength() >= p.AfterHistoryEvents) ||
+		info.GetContinueAsNewSuggested()
+}
+
+// eventRecorder writes audit events one at a time and in order, and
+// publishes each to webhooks once it's written, without holding up the
+// workflow while they're written.
+type eventRecorder struct {
+	ch      workflow.Channel
+	pending int // recorded but not yet written
+	done    bool
+}
+
+func startEventRecorder(ctx workflow.Context) *eventRecorder {
+	r := &eventRecorder{ch: workflow.NewBufferedChannel(ctx, 64)}
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		// publishing retries until every delivery has started; only an
+		// order that's gone stops it
+		publishCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+			StartToCloseTimeout: time.Minute,
+			RetryPolicy: &temporal.RetryPolicy{
+				InitialInterval:    time.Second,
+				BackoffCoefficient: 2.0,
+				MaximumInterval:    time.Minute,
+			},
+		})
+		var a *OrderActivities
+		var w *WebhookActivities
+		var event OrderEvent
+		for r.ch.Receive(ctx, &event) {
+			if err := workflow.ExecuteActivity(ctx, a.RecordOrderEventActivity, event).Get(ctx, nil); err != nil {
+				workflow.GetLogger(ctx).Error("Failed to record order event", "type", event.Type, "error", err)
+			} else if workflow.GetVersion(ctx, changePublishWebhooks, workflow.DefaultVersion, 1) == 1 {
+				// asked per event rather than per run, so runs already open
+				// publish from here as soon as they reach code that does;
+				// before, recording the event published it
+				if err := workflow.ExecuteActivity(publishCtx, w.PublishWebhooksActivity, event).Get(ctx, nil); err != nil {
+					workflow.GetLogger(ctx).Error("Failed to publish order event to webhooks", "type", event.Type, "error", err)
+				}
+			}
+			r.pending--
+		}
+		r.done = true
+	})
+	return r
+}
+
+// record only blocks if a backlog of events is still waiting to be written.
+func (r *eventRecorder) record(ctx workflow.Context, event OrderEvent) {
+	r.pending++
+	r.ch.Send(ctx, event)
+}
+
+// idle reports whether every recorded event has been written.
+func (r *eventRecorder) idle() bool {
+	return r.pending == 0
+}
+
+// flush waits for every recorded event to be written, so none are dropped
+// when the workflow completes.
+func (r *eventRecorder) flush(ctx workflow.Context) {
+	r.ch.Close()
+	_ = workflow.Await(ctx, func() bool { return r.done })
+}
+
 func ExecuteOrderActivity(ctx context.Context, security string, quantity int) (string, error) {
-	log.Printf("Executing order for %d shares of %s", quantity, security)
+	activityLogger(ctx).Info("Executing order", "security", security, "quantity", quantity)
 	time.Sleep(2 * time.Second) // Simulate order execution delay - this is a mock implementation
 	executionResult := fmt.Sprintf("Order for %d shares of %s executed successfully", quantity, security)
 	return executionResult, nil
 }
 
-func CreateOrderActivity(ctx context.Context, order StopLossOrder, ordersRepo OrdersRepo) error {
-	log.Printf("Creating order: ", order)
-	_, err := ordersRepo.CreateOrder(order)
+// OrderActivities groups the activities that need access to the repos.
+type OrderActivities struct {
+	ordersRepo    OrdersRepo
+	eventsRepo    OrderEventsRepo
+	haltsRepo     HaltsRepo
+	positionsRepo PositionsRepo
+}
+
+func NewOrderActivities(ordersRepo OrdersRepo, eventsRepo OrderEventsRepo, haltsRepo HaltsRepo, positionsRepo PositionsRepo) *OrderActivities {
+	return &OrderActivities{
+		ordersRepo:    ordersRepo,
+		eventsRepo:    eventsRepo,
+		haltsRepo:     haltsRepo,
+		positionsRepo: positionsRepo,
+	}
+}
+
+// CreateOrderActivity files orders from runs started before accounts existed
+// under the default account.
+func (a *OrderActivities) CreateOrderActivity(ctx context.Context, order StopLossOrder) error {
+	if order.AccountID == "" {
+		order.AccountID = DefaultAccountID
+	}
+	activityLogger(ctx).Info("Creating order", "orderID", order.ID, "accountID", order.AccountID, "security", order.Security, "stopPrice", order.StopPrice, "quantity", order.Quantity)
+	_, err := a.ordersRepo.CreateOrder(order)
 	if err != nil {
-		return fmt.Errorf("failed to create order %s %v", order.ID, err)
+		return fmt.Errorf("failed to create order %s: %w", order.ID, err)
 	}
 	return nil
 }
 
-func UpdateOrderStatusActivity(ctx context.Context, orderID string, status string, ordersRepo OrdersRepo) error {
-	log.Printf("Updating order %s status to: %s", orderID, status)
-	err := ordersRepo.UpdateOrderStatus(orderID, status)
+// UpdateOrderStatusActivity also takes an executed order off its security's
+// position. That happens here rather than in an activity of its own so the
+// workflow's commands stay as they were.
+func (a *OrderActivities) UpdateOrderStatusActivity(ctx context.Context, orderID string, status string) error {
+	activityLogger(ctx).Info("Updating order status", "orderID", orderID, "status", status)
+	err := a.ordersRepo.UpdateOrderStatus(orderID, status)
 	if err != nil {
 		return fmt.Errorf("failed to update order status for order %s to %s: %w", orderID, status, err)
 	}
+	if status != OrderStatusExecuted {
+		return nil
+	}
+
+	order, err := a.ordersRepo.GetOrder(orderID)
+	if err != nil {
+		return fmt.Errorf("failed to load executed order %s: %w", orderID, err)
+	}
+	if err := a.positionsRepo.ApplyExecution(order, time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to apply execution of order %s to its position: %w", orderID, err)
+	}
+	return nil
+}
+
+func (a *OrderActivities) MarkOrderFailedActivity(ctx context.Context, orderID string, reason string) error {
+	activityLogger(ctx).Warn("Marking order FAILED", "orderID", orderID, "reason", reason)
+	err := a.ordersRepo.MarkOrderFailed(orderID, reason)
+	if err != nil {
+		return fmt.Errorf("failed to mark order %s failed: %w", orderID, err)
+	}
 	return nil
 }
+
+func (a *OrderActivities) RecordOrderEventActivity(ctx context.Context, event OrderEvent) error {
+	err := a.eventsRepo.AppendEvent(event)
+	if err != nil {
+		return fmt.Errorf("failed to record %s event for order %s: %w", event.Type, event.OrderID, err)
+	}
+	return nil
+}
+
+// ExecutionHaltActivity returns the halt stopping security from executing,
+// or nil if there isn't one.
+func (a *OrderActivities) ExecutionHaltActivity(ctx context.Context, security string) (*Halt, error) {
+	halt, err := a.haltsRepo.HaltFor(security)
+	if errors.Is(err, ErrNotHalted) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for a halt on %s: %w", security, err)
+	}
+	return &halt, nil
+}
@@ -0,0 +1,925 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
+)
+
+type StopLossWorkflowTestSuite struct {
+	suite.Suite
+	testsuite.WorkflowTestSuite
+
+	env *testsuite.TestWorkflowEnvironment
+	a   *OrderActivities
+	n   *NotificationActivities
+	w   *WebhookActivities
+
+	events        []OrderEvent     // recorded through RecordOrderEventActivity
+	published     []OrderEvent     // published through PublishWebhooksActivity
+	publishErrors int              // how many publishes fail before they work
+	notifications []string         // events NotifyOrderActivity sent
+	notifyDelay   time.Duration    // how long NotifyOrderActivity takes
+	cancelResults []string         // answers to the cancel update
+	halts         map[string]*Halt // what ExecutionHaltActivity finds, by security
+}
+
+func TestStopLossWorkflowTestSuite(t *testing.T) {
+	suite.Run(t, new(StopLossWorkflowTestSuite))
+}
+
+func (s *StopLossWorkflowTestSuite) SetupTest() {
+	s.env = s.NewTestWorkflowEnvironment()
+	s.env.RegisterActivity(ExecuteOrderActivity)
+	s.env.RegisterActivity(s.a)
+	s.env.RegisterActivity(s.n)
+	s.env.RegisterActivity(s.w)
+
+	s.events = nil
+	s.published = nil
+	s.publishErrors = 0
+	s.notifications = nil
+	s.notifyDelay = 0
+	s.cancelResults = nil
+	s.halts = map[string]*Halt{}
+	s.env.OnActivity(s.a.RecordOrderEventActivity, mock.Anything, mock.Anything).Return(func(_ context.Context, event OrderEvent) error {
+		s.events = append(s.events, event)
+		return nil
+	}).Maybe()
+	s.env.OnActivity(s.w.PublishWebhooksActivity, mock.Anything, mock.Anything).Return(func(_ context.Context, event OrderEvent) error {
+		if s.publishErrors > 0 {
+			s.publishErrors--
+			return errors.New("temporal is unreachable")
+		}
+		s.published = append(s.published, event)
+		return nil
+	}).Maybe()
+	s.env.OnActivity(s.a.ExecutionHaltActivity, mock.Anything, mock.Anything).Return(func(_ context.Context, security string) (*Halt, error) {
+		return s.halts[security], nil
+	}).Maybe()
+	s.env.OnActivity(s.n.NotifyOrderActivity, mock.Anything, "order-1", mock.Anything).Return(func(_ context.Context, _ string, event string) error {
+		s.notifications = append(s.notifications, event)
+		return nil
+	}).AfterFn(func() time.Duration { return s.notifyDelay }).Maybe()
+}
+
+func (s *StopLossWorkflowTestSuite) eventTypes() []string {
+	var types []string
+	for _, e := range s.events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func (s *StopLossWorkflowTestSuite) AfterTest(suiteName, testName string) {
+	s.env.AssertExpectations(s.T())
+}
+
+func testOrder() StopLossOrder {
+	return StopLossOrder{
+		ID:        "order-1",
+		Security:  "AAPL",
+		StopPrice: 145.00,
+		Quantity:  10,
+		Status:    OrderStatusPending,
+	}
+}
+
+func (s *StopLossWorkflowTestSuite) signalPrice(security string, price float64, after time.Duration) {
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(PriceUpdateSignalName, PriceUpdateSignalData{Security: security, Price: price})
+	}, after)
+}
+
+func (s *StopLossWorkflowTestSuite) cancel(after time.Duration) {
+	s.env.RegisterDelayedCallback(func() {
+		s.env.UpdateWorkflow(CancelOrderUpdateName, "", &testsuite.TestUpdateCallback{
+			OnAccept: func() {},
+			OnReject: func(err error) { s.Fail("cancel update rejected", err) },
+			OnComplete: func(result interface{}, err error) {
+				s.NoError(err)
+				s.cancelResults = append(s.cancelResults, result.(string))
+			},
+		}, CancelOrderRequest{Source: EventSourceWeb})
+	}, after)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_PriceBelowStop_ExecutesOrder() {
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("ok", nil).Once()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusExecuted).Return(nil).Once()
+
+	s.signalPrice("AAPL", 144.99, time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{OrderEventPriceTriggered, OrderEventExecutionAttempted, OrderEventExecuted}, s.eventTypes())
+	for _, e := range s.events {
+		s.Equal("order-1", e.OrderID)
+		s.Equal(EventSourceWorkflow, e.Source)
+	}
+	s.JSONEq(`{"price": 144.99, "stopPrice": 145}`, string(s.events[0].Payload))
+	s.Equal([]string{OrderEventExecuted}, s.notifications)
+	s.Equal(s.events, s.published)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_Webhooks_PublishingIsRetried() {
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("ok", nil).Once()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusExecuted).Return(nil).Once()
+	// Temporal is briefly unreachable when the trigger is published
+	s.publishErrors = 2
+
+	s.signalPrice("AAPL", 144.99, time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal(s.events, s.published)
+	s.Zero(s.publishErrors)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_Execution_IsTracedFromTheSignalThatTriggeredIt() {
+	recorder := recordSpans(s.T())
+	s.env.SetWorkerOptions(worker.Options{Interceptors: []interceptor.WorkerInterceptor{newTracingInterceptor(), &executionTracingInterceptor{}}})
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("ok", nil).Once()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusExecuted).Return(nil).Once()
+
+	s.signalPrice("AAPL", 150, time.Minute)
+	s.signalPrice("AAPL", 144.99, 2*time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	var signals []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == "HandleSignal:"+PriceUpdateSignalName {
+			signals = append(signals, span)
+		}
+	}
+	s.Require().Len(signals, 2)
+	execution := endedSpan(s.T(), recorder, "StartActivity:ExecuteOrderActivity")
+	s.Equal(signals[1].SpanContext().SpanID(), execution.Parent().SpanID())
+	// other activities stay under the workflow's run
+	status := endedSpan(s.T(), recorder, "StartActivity:UpdateOrderStatusActivity")
+	s.Equal(endedSpan(s.T(), recorder, "RunWorkflow:StopLossWorkflow").SpanContext().SpanID(), status.Parent().SpanID())
+}
+
+func (s *StopLossWorkflowTestSuite) Test_OrderRowAlreadyExists() {
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusCancelled).Return(nil).Once()
+
+	s.cancel(time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.env.AssertActivityNotCalled(s.T(), "CreateOrderActivity", mock.Anything, mock.Anything)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_LegacyRun_CreatesOrderRow() {
+	// runs started before the service wrote the row still create it themselves
+	s.env.OnGetVersion(changeCreateOrderBeforeStart, workflow.DefaultVersion, 1).Return(workflow.DefaultVersion)
+	s.env.OnActivity(s.a.CreateOrderActivity, mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusCancelled).Return(nil).Once()
+
+	s.cancel(time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+}
+
+func (s *StopLossWorkflowTestSuite) Test_PriceAtStop_ExecutesOrder() {
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("ok", nil).Once()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusExecuted).Return(nil).Once()
+
+	s.signalPrice("AAPL", 145.00, time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+}
+
+func (s *StopLossWorkflowTestSuite) Test_PriceAboveStop_KeepsWaiting() {
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusCancelled).Return(nil).Once()
+
+	s.signalPrice("AAPL", 150.00, time.Minute)
+	s.signalPrice("AAPL", 145.01, 2*time.Minute)
+	// the workflow only ends once cancelled, proving the prices above didn't trigger it
+	s.cancel(3 * time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.env.AssertActivityNotCalled(s.T(), "ExecuteOrderActivity", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_WrongSecurity_IsIgnored() {
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusCancelled).Return(nil).Once()
+
+	// well below AAPL's stop, but for a different security
+	s.signalPrice("GOOG", 1.00, time.Minute)
+	s.cancel(2 * time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.env.AssertActivityNotCalled(s.T(), "ExecuteOrderActivity", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_CancelBeforeTrigger() {
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusCancelled).Return(nil).Once()
+
+	s.cancel(time.Minute)
+	// arrives after the workflow has finished, so must never execute
+	s.signalPrice("AAPL", 100.00, 2*time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.env.AssertActivityNotCalled(s.T(), "ExecuteOrderActivity", mock.Anything, mock.Anything, mock.Anything)
+	s.Require().Equal([]string{OrderEventCancelled}, s.eventTypes())
+	// the audit log credits whoever asked for the cancellation
+	s.Equal(EventSourceWeb, s.events[0].Source)
+	s.Equal([]string{CancelResultCancelled}, s.cancelResults)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_CancelWhileExecuting_ExecutionWins() {
+	// the execution takes long enough for the cancel to land mid-flight
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).After(30*time.Second).Return("ok", nil).Once()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusExecuted).Return(nil).Once()
+
+	s.signalPrice("AAPL", 140.00, time.Minute)
+	s.cancel(time.Minute + 10*time.Second)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.env.AssertActivityNotCalled(s.T(), "UpdateOrderStatusActivity", mock.Anything, "order-1", OrderStatusCancelled)
+	s.Equal([]string{CancelResultTooLate}, s.cancelResults)
+	s.NotContains(s.eventTypes(), OrderEventCancelled)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_CancelAfterExecution_AlreadyExecuted() {
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("ok", nil).Once()
+	// slow to write the status, so the workflow is still open when the cancel lands
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusExecuted).After(30 * time.Second).Return(nil).Once()
+
+	s.signalPrice("AAPL", 140.00, time.Minute)
+	s.cancel(time.Minute + 10*time.Second)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{CancelResultAlreadyExecuted}, s.cancelResults)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_CancelTwice_IsIdempotent() {
+	// the status write is slow enough for the second request to arrive first
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusCancelled).After(30 * time.Second).Return(nil).Once()
+
+	s.cancel(time.Minute)
+	s.cancel(time.Minute + 10*time.Second)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.ElementsMatch([]string{CancelResultCancelled, CancelResultCancelled}, s.cancelResults)
+	s.Equal([]string{OrderEventCancelled}, s.eventTypes())
+}
+
+func (s *StopLossWorkflowTestSuite) Test_LegacyCancelSignal() {
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusCancelled).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CancelOrderSignalName, CancelOrderRequest{Source: EventSourceAdmin})
+	}, time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Require().Equal([]string{OrderEventCancelled}, s.eventTypes())
+	s.Equal(EventSourceAdmin, s.events[0].Source)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_LegacyRun_ExecutionFailureStopsWatching() {
+	s.env.OnGetVersion(changeExecutionFailurePolicy, workflow.DefaultVersion, 1).Return(workflow.DefaultVersion)
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("", errors.New("broker unavailable"))
+	// fired without waiting on the result, so it may not get to run before the workflow ends
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusPending).Return(nil).Maybe()
+
+	s.signalPrice("AAPL", 140.00, time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	// retried up to the activity retry policy's MaximumAttempts
+	s.env.AssertActivityNumberOfCalls(s.T(), "ExecuteOrderActivity", 5)
+	s.env.AssertActivityNotCalled(s.T(), "UpdateOrderStatusActivity", mock.Anything, "order-1", OrderStatusExecuted)
+	s.Equal([]string{OrderEventPriceTriggered, OrderEventExecutionAttempted, OrderEventFailed}, s.eventTypes())
+}
+
+func (s *StopLossWorkflowTestSuite) Test_ExecutionFails_Escalates() {
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("", errors.New("broker unavailable"))
+	s.env.OnActivity(s.a.MarkOrderFailedActivity, mock.Anything, "order-1", mock.MatchedBy(func(reason string) bool {
+		return strings.Contains(reason, "broker unavailable")
+	})).Return(nil).Once()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusCancelled).Return(nil).Once()
+
+	s.signalPrice("AAPL", 140.00, time.Minute)
+	// the order stays with its workflow, which ignores prices until it's re-driven
+	s.signalPrice("AAPL", 139.00, 2*time.Hour)
+	var state OrderLiveState
+	s.env.RegisterDelayedCallback(func() { state = s.queryState() }, 3*time.Hour)
+	s.cancel(4 * time.Hour)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{OnFailure: ExecutionFailurePolicy{Action: FailureActionEscalate}})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.env.AssertActivityNumberOfCalls(s.T(), "ExecuteOrderActivity", 5)
+	s.Equal(OrderStatusFailed, state.Status)
+	s.Equal("broker unavailable", state.FailureReason)
+	s.Equal(1, state.ExecutionAttempts)
+	s.Equal([]string{CancelResultCancelled}, s.cancelResults)
+	s.Equal([]string{OrderEventPriceTriggered, OrderEventExecutionAttempted, OrderEventFailed, OrderEventCancelled}, s.eventTypes())
+	s.Equal([]string{OrderEventFailed}, s.notifications)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_ExecutionFails_Rearms() {
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("", errors.New("broker unavailable")).Times(5)
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("ok", nil).Once()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusPending).Return(nil).Once()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusExecuted).Return(nil).Once()
+
+	s.signalPrice("AAPL", 140.00, time.Minute)
+	s.signalPrice("AAPL", 139.00, 2*time.Hour)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{OnFailure: ExecutionFailurePolicy{Action: FailureActionRearm}})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{
+		OrderEventPriceTriggered, OrderEventExecutionAttempted, OrderEventFailed,
+		OrderEventPriceTriggered, OrderEventExecutionAttempted, OrderEventExecuted,
+	}, s.eventTypes())
+	// a re-armed order hasn't failed, so there's nothing to tell
+	s.Equal([]string{OrderEventExecuted}, s.notifications)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_ExecutionFails_RetriesThenEscalates() {
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("", errors.New("broker unavailable"))
+	s.env.OnActivity(s.a.MarkOrderFailedActivity, mock.Anything, "order-1", mock.Anything).Return(nil).Once()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusCancelled).Return(nil).Once()
+
+	s.signalPrice("AAPL", 140.00, time.Minute)
+	var waiting OrderLiveState
+	s.env.RegisterDelayedCallback(func() { waiting = s.queryState() }, 30*time.Minute)
+	s.cancel(3 * time.Hour)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{OnFailure: ExecutionFailurePolicy{Action: FailureActionRetry, RetryDelay: time.Hour, MaxRetries: 1}})
+
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal(OrderStatusExecuting, waiting.Status)
+	s.env.AssertActivityNumberOfCalls(s.T(), "ExecuteOrderActivity", 10)
+	s.Equal([]string{
+		OrderEventPriceTriggered,
+		OrderEventExecutionAttempted, OrderEventFailed,
+		OrderEventExecutionAttempted, OrderEventFailed,
+		OrderEventCancelled,
+	}, s.eventTypes())
+}
+
+func (s *StopLossWorkflowTestSuite) Test_CancelWhileAwaitingRetry() {
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("", errors.New("broker unavailable"))
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusCancelled).Return(nil).Once()
+
+	s.signalPrice("AAPL", 140.00, time.Minute)
+	s.cancel(30 * time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{OnFailure: ExecutionFailurePolicy{Action: FailureActionRetry, RetryDelay: time.Hour, MaxRetries: 3}})
+
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{CancelResultCancelled}, s.cancelResults)
+	s.env.AssertActivityNumberOfCalls(s.T(), "ExecuteOrderActivity", 5)
+}
+
+func (s *StopLossWorkflowTestSuite) redrive(action string, after time.Duration) {
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(RedriveSignalName, RedriveRequest{Action: action, Source: EventSourceAdmin})
+	}, after)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_Redrive_RetriesExecution() {
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("", errors.New("broker unavailable")).Times(5)
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("ok", nil).Once()
+	s.env.OnActivity(s.a.MarkOrderFailedActivity, mock.Anything, "order-1", mock.Anything).Return(nil).Once()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusExecuted).Return(nil).Once()
+
+	s.signalPrice("AAPL", 140.00, time.Minute)
+	s.redrive(FailureActionRetry, time.Hour)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{
+		OrderEventPriceTriggered, OrderEventExecutionAttempted, OrderEventFailed,
+		OrderEventRedriven, OrderEventExecutionAttempted, OrderEventExecuted,
+	}, s.eventTypes())
+	s.Equal(EventSourceAdmin, s.events[3].Source)
+	s.Equal([]string{OrderEventFailed, OrderEventExecuted}, s.notifications)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_SlowNotification_DoesntHoldUpTheOrder() {
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("", errors.New("broker unavailable"))
+	s.env.OnActivity(s.a.MarkOrderFailedActivity, mock.Anything, "order-1", mock.Anything).Return(nil).Once()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusCancelled).Return(nil).Once()
+	s.notifyDelay = 3 * time.Hour
+
+	s.signalPrice("AAPL", 140.00, time.Minute)
+	s.cancel(time.Hour)
+	var state OrderLiveState
+	s.env.RegisterDelayedCallback(func() { state = s.queryState() }, 2*time.Hour)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{OnFailure: ExecutionFailurePolicy{Action: FailureActionEscalate}})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	// cancelled while the notification was still sending, which the run then waited for
+	s.Equal([]string{CancelResultCancelled}, s.cancelResults)
+	s.Equal(OrderStatusCancelled, state.Status)
+	s.Equal([]string{OrderEventFailed}, s.notifications)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_LegacyRun_SendsNoNotifications() {
+	s.env.OnGetVersion(changeNotifications, workflow.DefaultVersion, 1).Return(workflow.DefaultVersion)
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("ok", nil).Once()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusExecuted).Return(nil).Once()
+
+	s.signalPrice("AAPL", 140.00, time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Empty(s.notifications)
+	s.env.AssertActivityNotCalled(s.T(), "NotifyOrderActivity", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_Redrive_Rearms() {
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusPending).Return(nil).Once()
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("ok", nil).Once()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusExecuted).Return(nil).Once()
+
+	// a workflow started for a FAILED order by signal-with-start, the signal
+	// already waiting for it
+	order := testOrder()
+	order.Status, order.FailureReason = OrderStatusFailed, "broker unavailable"
+	s.redrive(FailureActionRearm, 0)
+	s.signalPrice("AAPL", 150.00, time.Minute)
+	s.signalPrice("AAPL", 140.00, 2*time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, order, StopLossRun{})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{OrderEventRedriven, OrderEventPriceTriggered, OrderEventExecutionAttempted, OrderEventExecuted}, s.eventTypes())
+}
+
+func (s *StopLossWorkflowTestSuite) Test_Redrive_IgnoredUnlessFailed() {
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusCancelled).Return(nil).Once()
+
+	s.redrive(FailureActionRetry, time.Minute)
+	s.cancel(2 * time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.NoError(s.env.GetWorkflowError())
+	s.env.AssertActivityNotCalled(s.T(), "ExecuteOrderActivity", mock.Anything, mock.Anything, mock.Anything)
+	s.Equal([]string{OrderEventCancelled}, s.eventTypes())
+}
+
+func (s *StopLossWorkflowTestSuite) queryState() OrderLiveState {
+	value, err := s.env.QueryWorkflow(OrderStateQueryName)
+	s.Require().NoError(err)
+	var state OrderLiveState
+	s.Require().NoError(value.Get(&state))
+	return state
+}
+
+// searchStatuses collects the OrderStatus search attribute every time the
+// workflow sets it.
+func (s *StopLossWorkflowTestSuite) searchStatuses() *[]string {
+	var statuses []string
+	s.env.OnUpsertTypedSearchAttributes(mock.Anything).Run(func(args mock.Arguments) {
+		attributes := args.Get(0).(temporal.SearchAttributes)
+		if status, ok := attributes.GetKeyword(SearchAttributeOrderStatus); ok {
+			statuses = append(statuses, status)
+		}
+	}).Return(nil).Maybe()
+	return &statuses
+}
+
+func (s *StopLossWorkflowTestSuite) Test_SearchAttributes() {
+	var initial temporal.SearchAttributes
+	s.env.OnUpsertTypedSearchAttributes(mock.MatchedBy(func(attributes temporal.SearchAttributes) bool {
+		return attributes.Size() > 1
+	})).Run(func(args mock.Arguments) { initial = args.Get(0).(temporal.SearchAttributes) }).Return(nil).Once()
+	statuses := s.searchStatuses()
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("ok", nil).Once()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusExecuted).Return(nil).Once()
+
+	s.signalPrice("AAPL", 144.00, time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.NoError(s.env.GetWorkflowError())
+	security, _ := initial.GetKeyword(SearchAttributeSecurity)
+	s.Equal("AAPL", security)
+	stopPrice, _ := initial.GetFloat64(SearchAttributeStopPrice)
+	s.Equal(145.00, stopPrice)
+	status, _ := initial.GetKeyword(SearchAttributeOrderStatus)
+	s.Equal(OrderStatusPending, status)
+	s.Equal([]string{OrderStatusExecuting, OrderStatusExecuted}, *statuses)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_SearchAttributes_Cancelled() {
+	statuses := s.searchStatuses()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusCancelled).Return(nil).Once()
+
+	s.cancel(time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{OrderStatusPending, OrderStatusCancelled}, *statuses)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_LegacyRun_SetsNoSearchAttributes() {
+	s.env.OnGetVersion(changeSearchAttributes, workflow.DefaultVersion, 1).Return(workflow.DefaultVersion)
+	statuses := s.searchStatuses()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusCancelled).Return(nil).Once()
+
+	s.cancel(time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.NoError(s.env.GetWorkflowError())
+	s.Empty(*statuses)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_StateQuery() {
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).After(30*time.Second).Return("ok", nil).Once()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusExecuted).Return(nil).Once()
+
+	s.signalPrice("AAPL", 150.00, time.Minute)
+	s.signalPrice("GOOG", 99.00, time.Minute+time.Second) // not counted
+	s.signalPrice("AAPL", 146.50, 2*time.Minute)
+	s.env.RegisterDelayedCallback(func() {
+		state := s.queryState()
+		s.Equal(OrderStatusPending, state.Status)
+		s.Equal(146.50, state.LastPrice)
+		s.Equal(145.00, state.EffectiveStop)
+		s.Equal(2, state.PriceUpdates)
+		s.Equal(0, state.ExecutionAttempts)
+	}, 3*time.Minute)
+
+	s.signalPrice("AAPL", 140.00, 4*time.Minute)
+	s.env.RegisterDelayedCallback(func() {
+		state := s.queryState()
+		s.Equal(OrderStatusExecuting, state.Status)
+		s.Equal(1, state.ExecutionAttempts)
+	}, 4*time.Minute+10*time.Second)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	state := s.queryState()
+	s.Equal(OrderStatusExecuted, state.Status)
+	s.Equal(3, state.PriceUpdates)
+}
+
+// continuedAsNew returns the arguments the workflow handed to its next run.
+func (s *StopLossWorkflowTestSuite) continuedAsNew() (StopLossOrder, StopLossRun) {
+	var canErr *workflow.ContinueAsNewError
+	s.Require().ErrorAs(s.env.GetWorkflowError(), &canErr)
+	var order StopLossOrder
+	var run StopLossRun
+	s.Require().NoError(converter.GetDefaultDataConverter().FromPayloads(canErr.Input, &order, &run))
+	return order, run
+}
+
+func (s *StopLossWorkflowTestSuite) Test_ContinuesAsNewAfterSignals() {
+	policy := ContinueAsNewPolicy{AfterSignals: 3}
+
+	s.signalPrice("AAPL", 150.00, time.Minute)
+	s.signalPrice("AAPL", 151.00, 2*time.Minute)
+	s.signalPrice("AAPL", 152.00, 3*time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{ContinueAsNew: policy})
+
+	s.True(s.env.IsWorkflowCompleted())
+	order, run := s.continuedAsNew()
+	s.Equal("order-1", order.ID)
+	s.Equal(145.00, order.StopPrice)
+	s.Equal(OrderStatusPending, order.Status)
+	s.Equal(policy, run.ContinueAsNew)
+	s.Equal(3, run.PriceUpdates)
+	s.Equal(152.00, run.LastPrice)
+	s.Empty(run.PendingPrices)
+	s.env.AssertActivityNotCalled(s.T(), "ExecuteOrderActivity", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_ContinueAsNew_CarriesUnhandledPrices() {
+	// the test environment hands the workflow one signal at a time, so the
+	// channel is filled by hand here
+	handover := func(ctx workflow.Context) error {
+		prices := workflow.NewBufferedChannel(ctx, 2)
+		prices.SendAsync(PriceUpdateSignalData{Security: "AAPL", Price: 144.00})
+		prices.SendAsync(PriceUpdateSignalData{Security: "AAPL", Price: 143.00})
+		return continueAsNew(ctx, testOrder(), StopLossRun{PriceUpdates: 3}, prices)
+	}
+	s.env.RegisterWorkflowWithOptions(handover, workflow.RegisterOptions{Name: "handover"})
+
+	s.env.ExecuteWorkflow("handover")
+
+	_, run := s.continuedAsNew()
+	s.Equal(3, run.PriceUpdates)
+	s.Equal([]PriceUpdateSignalData{{Security: "AAPL", Price: 144.00}, {Security: "AAPL", Price: 143.00}}, run.PendingPrices)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_ContinuedRun_HandlesPendingPricesFirst() {
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("ok", nil).Once()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusExecuted).Return(nil).Once()
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{
+		PriceUpdates:  3,
+		PendingPrices: []PriceUpdateSignalData{{Security: "AAPL", Price: 144.00}},
+	})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	state := s.queryState()
+	s.Equal(OrderStatusExecuted, state.Status)
+	s.Equal(4, state.PriceUpdates)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_ContinueAsNew_HandlesCancelSignalledDuringHandover() {
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("", errors.New("broker unavailable"))
+	s.env.OnActivity(s.a.MarkOrderFailedActivity, mock.Anything, "order-1", mock.Anything).Return(nil).Once()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusCancelled).Return(nil).Once()
+	s.notifyDelay = 3 * time.Hour
+
+	// the second price makes the run due to continue as new while the
+	// failure notification is still sending, and the cancel comes meanwhile
+	s.signalPrice("AAPL", 140.00, time.Minute)
+	s.signalPrice("AAPL", 150.00, time.Hour)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CancelOrderSignalName, CancelOrderRequest{Source: EventSourceAdmin})
+	}, 2*time.Hour)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{
+		ContinueAsNew: ContinueAsNewPolicy{AfterSignals: 2},
+		OnFailure:     ExecutionFailurePolicy{Action: FailureActionEscalate},
+	})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal(OrderEventCancelled, s.eventTypes()[len(s.events)-1])
+	s.Equal([]string{OrderEventFailed}, s.notifications)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_LegacyRun_NeverContinuesAsNew() {
+	s.env.OnGetVersion(changeContinueAsNew, workflow.DefaultVersion, 1).Return(workflow.DefaultVersion)
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusCancelled).Return(nil).Once()
+
+	s.signalPrice("AAPL", 150.00, time.Minute)
+	s.signalPrice("AAPL", 151.00, 2*time.Minute)
+	s.cancel(3 * time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{ContinueAsNew: ContinueAsNewPolicy{AfterSignals: 1}})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+}
+
+// TestStopLossWorkflowReplaysRecordedHistories replays histories recorded by
+// every earlier version of the workflow against the current code. A failure
+// means the change would break orders already in flight: gate it behind
+// workflow.GetVersion instead.
+func TestStopLossWorkflowReplaysRecordedHistories(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "histories", "*.json"))
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".json"), func(t *testing.T) {
+			replayer := worker.NewWorkflowReplayer()
+			replayer.RegisterWorkflow(StopLossWorkflow)
+			require.NoError(t, replayer.ReplayWorkflowHistoryFromJSONFile(nil, file))
+		})
+	}
+}
+
+func (s *StopLossWorkflowTestSuite) resume(action string, after time.Duration) {
+	s.env.RegisterDelayedCallback(func() {
+		delete(s.halts, "AAPL")
+		s.env.SignalWorkflow(ResumeSignalName, ResumeRequest{Action: action, Source: EventSourceAdmin})
+	}, after)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_Halted_QueuesTriggerUntilResumed() {
+	s.halts["AAPL"] = &Halt{Security: "AAPL", Reason: "bad feed"}
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("ok", nil).Once()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusExecuted).Return(nil).Once()
+
+	s.signalPrice("AAPL", 140.00, time.Minute)
+	// still tracked, but can't trigger a second time
+	s.signalPrice("AAPL", 150.00, 2*time.Minute)
+	s.signalPrice("AAPL", 130.00, 3*time.Minute)
+	var halted OrderLiveState
+	s.env.RegisterDelayedCallback(func() { halted = s.queryState() }, 4*time.Minute)
+	// executes whatever the price is by now
+	s.resume(ResumeActionExecute, 5*time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal(OrderStatusHalted, halted.Status)
+	s.Equal("bad feed", halted.HaltReason)
+	s.Equal(3, halted.PriceUpdates)
+	s.Equal(130.00, halted.LastPrice)
+	s.Equal(0, halted.ExecutionAttempts)
+	s.Equal([]string{
+		OrderEventPriceTriggered, OrderEventHalted, OrderEventResumed, OrderEventExecutionAttempted, OrderEventExecuted,
+	}, s.eventTypes())
+	s.JSONEq(`{"scope": "AAPL", "reason": "bad feed"}`, string(s.events[1].Payload))
+	s.Equal(EventSourceAdmin, s.events[2].Source)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_Halted_ReevaluateRearmsIfPriceRecovered() {
+	s.halts["AAPL"] = &Halt{Reason: "market event"}
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusPending).Return(nil).Once()
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("ok", nil).Once()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusExecuted).Return(nil).Once()
+
+	s.signalPrice("AAPL", 140.00, time.Minute)
+	s.signalPrice("AAPL", 150.00, 2*time.Minute)
+	s.resume(ResumeActionReevaluate, 3*time.Minute)
+	// armed again, so the next price at the stop triggers
+	s.signalPrice("AAPL", 144.00, 4*time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{
+		OrderEventPriceTriggered, OrderEventHalted, OrderEventResumed,
+		OrderEventPriceTriggered, OrderEventExecutionAttempted, OrderEventExecuted,
+	}, s.eventTypes())
+	s.JSONEq(`{"scope": "all securities", "reason": "market event"}`, string(s.events[1].Payload))
+}
+
+func (s *StopLossWorkflowTestSuite) Test_Halted_ReevaluateExecutesIfStillBelowStop() {
+	s.halts["AAPL"] = &Halt{Security: "AAPL"}
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("ok", nil).Once()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusExecuted).Return(nil).Once()
+
+	s.signalPrice("AAPL", 140.00, time.Minute)
+	s.signalPrice("AAPL", 145.00, 2*time.Minute)
+	s.resume(ResumeActionReevaluate, 3*time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{
+		OrderEventPriceTriggered, OrderEventHalted, OrderEventResumed, OrderEventExecutionAttempted, OrderEventExecuted,
+	}, s.eventTypes())
+}
+
+func (s *StopLossWorkflowTestSuite) Test_Halted_ResumedWhileStillHaltedQueuesAgain() {
+	s.halts["AAPL"] = &Halt{Reason: "market event"}
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusCancelled).Return(nil).Once()
+
+	s.signalPrice("AAPL", 140.00, time.Minute)
+	// resumed without lifting the halt, so the next attempt queues again
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(ResumeSignalName, ResumeRequest{Action: ResumeActionExecute, Source: EventSourceAdmin})
+	}, 2*time.Minute)
+	s.cancel(3 * time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.NoError(s.env.GetWorkflowError())
+	s.env.AssertActivityNotCalled(s.T(), "ExecuteOrderActivity", mock.Anything, mock.Anything, mock.Anything)
+	s.Equal([]string{CancelResultCancelled}, s.cancelResults)
+	s.Equal([]string{
+		OrderEventPriceTriggered, OrderEventHalted, OrderEventResumed, OrderEventHalted, OrderEventCancelled,
+	}, s.eventTypes())
+}
+
+func (s *StopLossWorkflowTestSuite) Test_Resume_IgnoredWithNothingQueued() {
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusCancelled).Return(nil).Once()
+
+	s.resume(ResumeActionExecute, time.Minute)
+	s.cancel(2 * time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{OrderEventCancelled}, s.eventTypes())
+}
+
+func (s *StopLossWorkflowTestSuite) Test_LegacyRun_IgnoresHalts() {
+	s.env.OnGetVersion(changeExecutionHalts, workflow.DefaultVersion, 1).Return(workflow.DefaultVersion)
+	s.halts["AAPL"] = &Halt{Security: "AAPL"}
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("ok", nil).Once()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusExecuted).Return(nil).Once()
+
+	s.signalPrice("AAPL", 140.00, time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{})
+
+	s.NoError(s.env.GetWorkflowError())
+	s.env.AssertActivityNotCalled(s.T(), "ExecutionHaltActivity", mock.Anything, mock.Anything)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_ContinueAsNew_CarriesHaltedTrigger() {
+	s.halts["AAPL"] = &Halt{Security: "AAPL", Reason: "bad feed"}
+
+	s.signalPrice("AAPL", 140.00, time.Minute)
+	s.signalPrice("AAPL", 139.00, 2*time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, testOrder(), StopLossRun{ContinueAsNew: ContinueAsNewPolicy{AfterSignals: 2}})
+
+	order, run := s.continuedAsNew()
+	s.Equal(OrderStatusHalted, order.Status)
+	s.Require().NotNil(run.Halted)
+	s.Equal("bad feed", run.Halted.Reason)
+}
+
+func (s *StopLossWorkflowTestSuite) Test_ContinuedRun_ResumesHaltedTrigger() {
+	s.env.OnActivity(ExecuteOrderActivity, mock.Anything, "AAPL", 10).Return("ok", nil).Once()
+	s.env.OnActivity(s.a.UpdateOrderStatusActivity, mock.Anything, "order-1", OrderStatusExecuted).Return(nil).Once()
+
+	order := testOrder()
+	order.Status = OrderStatusHalted
+	var halted OrderLiveState
+	s.env.RegisterDelayedCallback(func() { halted = s.queryState() }, time.Minute)
+	s.resume(ResumeActionExecute, 2*time.Minute)
+
+	s.env.ExecuteWorkflow(StopLossWorkflow, order, StopLossRun{LastPrice: 139.00, Halted: &Halt{Security: "AAPL", Reason: "bad feed"}})
+
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal(OrderStatusHalted, halted.Status)
+	s.Equal([]string{OrderEventResumed, OrderEventExecutionAttempted, OrderEventExecuted}, s.eventTypes())
+}
+
+func TestUpdateOrderStatusActivityAppliesExecution(t *testing.T) {
+	orders, positions := NewOrdersRepoMemory(), NewPositionsRepoMemory()
+	a := NewOrderActivities(orders, NewOrderEventsRepoMemory(), NewHaltsRepoMemory(), positions)
+	_, err := orders.CreateOrder(StopLossOrder{ID: "order-1", AccountID: DefaultAccountID, Security: "AAPL", Quantity: 10, Status: OrderStatusPending})
+	require.NoError(t, err)
+	require.NoError(t, positions.SetPosition(Position{AccountID: DefaultAccountID, Security: "AAPL", Quantity: 100}))
+
+	require.NoError(t, a.UpdateOrderStatusActivity(context.Background(), "order-1", OrderStatusPending))
+	// a retried activity must not sell the shares twice
+	require.NoError(t, a.UpdateOrderStatusActivity(context.Background(), "order-1", OrderStatusExecuted))
+	require.NoError(t, a.UpdateOrderStatusActivity(context.Background(), "order-1", OrderStatusExecuted))
+
+	position, err := positions.GetPosition(DefaultAccountID, "AAPL")
+	require.NoError(t, err)
+	assert.Equal(t, 90, position.Quantity)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+const defaultDBFileName = "/app/data/orders.db"
+
+// Order repo backends, selected with ORDERS_REPO
+const (
+	ordersRepoSQLite   = "sqlite"
+	ordersRepoMemory   = "memory"
+	ordersRepoPostgres = "postgres"
+)
+
+// storeConfig says where orders are kept.
+type storeConfig struct {
+	Kind        string
+	SQLitePath  string
+	PostgresDSN string
+}
+
+func loadStoreConfig() storeConfig {
+	cfg := storeConfig{
+		Kind:        os.Getenv("ORDERS_REPO"),
+		SQLitePath:  os.Getenv("ORDERS_DB_PATH"),
+		PostgresDSN: os.Getenv("ORDERS_POSTGRES_DSN"),
+	}
+
+	// a Postgres DSN picks the Postgres backend unless ORDERS_REPO says otherwise
+	if cfg.Kind == "" {
+		cfg.Kind = ordersRepoSQLite
+		if cfg.PostgresDSN != "" {
+			cfg.Kind = ordersRepoPostgres
+		}
+	}
+	if cfg.SQLitePath == "" {
+		cfg.SQLitePath = defaultDBFileName
+	}
+	return cfg
+}
+
+// openStoreDB opens the database behind a SQL backed store. The memory store
+// has no database, so asking for one is an error.
+func openStoreDB(cfg storeConfig) (*sql.DB, dialect, error) {
+	switch cfg.Kind {
+	case ordersRepoSQLite:
+		db, err := openSQLiteDB(cfg.SQLitePath)
+		return db, dialectSQLite, err
+	case ordersRepoPostgres:
+		if cfg.PostgresDSN == "" {
+			return nil, "", fmt.Errorf("ORDERS_POSTGRES_DSN environment variable is not set")
+		}
+		db, err := openPostgresDB(cfg.PostgresDSN)
+		return db, dialectPostgres, err
+	case ordersRepoMemory:
+		return nil, "", fmt.Errorf("the %s store has no database", ordersRepoMemory)
+	default:
+		return nil, "", fmt.Errorf("unknown ORDERS_REPO %q, expected %q, %q or %q", cfg.Kind, ordersRepoSQLite, ordersRepoPostgres, ordersRepoMemory)
+	}
+}
+
+// newOrdersRepoForDialect wraps an already migrated database.
+func newOrdersRepoForDialect(db *sql.DB, d dialect) OrdersRepo {
+	if d == dialectPostgres {
+		return NewOrdersRepoPostgres(db)
+	}
+	return NewOrdersRepoSQLite(db)
+}
+
+// stores bundles every repository kept in the configured store.
+type stores struct {
+	db            *sql.DB // nil for the memory store
+	orders        OrdersRepo
+	events        OrderEventsRepo
+	halts         HaltsRepo
+	positions     PositionsRepo
+	accounts      AccountsRepo
+	credentials   CredentialsRepo
+	audit         AuditLogRepo
+	webhooks      WebhooksRepo
+	notifications NotificationsRepo
+}
+
+// openStores opens and migrates the configured store.
+func openStores(cfg storeConfig) (*stores, error) {
+	if cfg.Kind == ordersRepoMemory {
+		// nothing survives a restart, while Temporal keeps its workflows
+		slog.Warn("Order repository initialized in memory, orders are lost on restart")
+		return &stores{
+			orders:        NewOrdersRepoMemory(),
+			events:        NewOrderEventsRepoMemory(),
+			halts:         NewHaltsRepoMemory(),
+			positions:     NewPositionsRepoMemory(),
+			accounts:      NewAccountsRepoMemory(),
+			credentials:   NewCredentialsRepoMemory(),
+			audit:         NewAuditLogRepoMemory(),
+			webhooks:      NewWebhooksRepoMemory(),
+			notifications: NewNotificationsRepoMemory(),
+		}, nil
+	}
+
+	db, d, err := openStoreDB(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize %s database: %w", cfg.Kind, err)
+	}
+	if err := migrateDB(db, d); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+	slog.Info("Order repository initialized", "driver", d)
+
+	return &stores{
+		db:            db,
+		orders:        newOrdersRepoForDialect(db, d),
+		events:        NewOrderEventsRepoSQL(db, d),
+		halts:         NewHaltsRepoSQL(db, d),
+		positions:     NewPositionsRepoSQL(db, d),
+		accounts:      NewAccountsRepoSQL(db, d),
+		credentials:   NewCredentialsRepoSQL(db, d),
+		audit:         NewAuditLogRepoSQL(db, d),
+		webhooks:      NewWebhooksRepoSQL(db, d),
+		notifications: NewNotificationsRepoSQL(db, d),
+	}, nil
+}
+
+func (s *stores) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
@@ -1,34 +1,41 @@
 package main
 
 import (
-	"log"
+	"log/slog"
 	"time"
 
 	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/interceptor"
+	tlog "go.temporal.io/sdk/log"
 )
 
+// WaitDialTemporal's client traces its calls, and the workers made from it
+// trace theirs. Both log through slog's default logger, so workflow and
+// activity logs come out as JSON like ours.
 func WaitDialTemporal(hostAddress string, connectionRetryAttempts int) (client.Client, error) {
 	var c client.Client
 	var err error
 
 	for i := 0; i < connectionRetryAttempts; i++ {
 		c, err = client.Dial(client.Options{
-			HostPort: hostAddress,
+			HostPort:     hostAddress,
+			Logger:       tlog.NewStructuredLogger(slog.Default()),
+			Interceptors: []interceptor.ClientInterceptor{newTracingInterceptor()},
 		})
 		if err == nil {
-			log.Println("Successfully connected to Temporal server.")
+			slog.Info("Connected to Temporal", "address", hostAddress)
 			return c, nil
 		}
-		log.Printf("Failed to connect to Temporal (attempt %d/%d): %v. Retrying in %ds...", i+1, connectionRetryAttempts, err, 5)
+		slog.Warn("Failed to connect to Temporal, retrying", "attempt", i+1, "attempts", connectionRetryAttempts, "retryIn", "5s", "error", err)
 		time.Sleep(5 * time.Second)
 	}
 
 	// After all retry attempts, if still failed:
 	if err != nil {
-		log.Printf("Failed to connect to Temporal after %d attempts.", connectionRetryAttempts)
+		slog.Error("Failed to connect to Temporal", "attempts", connectionRetryAttempts)
 		return nil, err // Return the last error
 	}
 
-	log.Fatal("unexpected execution")
+	fatal("Unexpected execution")
 	return c, nil
 }
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/workflow"
+)
+
+// tracer starts spans with whatever provider is installed, a no-op one
+// until setupTracing runs.
+func tracer() trace.Tracer {
+	return otel.Tracer("slurm.software/stop-loss")
+}
+
+// setupTracing exports spans to the OTLP collector in
+// OTEL_EXPORTER_OTLP_ENDPOINT, as JSON lines to the file in TRACES_FILE, or
+// both. With neither, nothing is recorded. The returned func flushes the
+// spans still buffered.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	var options []sdktrace.TracerProviderOption
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+		exporter, err := otlptracegrpc.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+		slog.Info("Exporting traces over OTLP")
+	}
+	var file *os.File
+	if path := os.Getenv("TRACES_FILE"); path != "" {
+		var err error
+		file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open traces file: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to create file trace exporter: %w", err)
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+		slog.Info("Writing traces to a file", "path", path)
+	}
+	if len(options) == 0 {
+		slog.Info("Neither OTEL_EXPORTER_OTLP_ENDPOINT nor TRACES_FILE is set, so traces aren't recorded")
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the name
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "stop-loss")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the service for tracing: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(append(options, sdktrace.WithResource(res))...)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			err = errors.Join(err, file.Close())
+		}
+		return err
+	}, nil
+}
+
+// newTracingInterceptor traces what goes through Temporal: the client's
+// workflow starts and signals, and the worker's workflows, signal handlers
+// and activities, each span carried to the next in Temporal headers.
+func newTracingInterceptor() interceptor.Interceptor {
+	return interceptor.NewTracingInterceptor(temporalTracer{})
+}
+
+// temporalSpanKey holds a workflow's current span in its workflow.Context.
+type temporalSpanKey struct{}
+
+// temporalTracer hands Temporal's tracing interceptor OpenTelemetry spans,
+// carried in headers as W3C trace context.
+type temporalTracer struct {
+	interceptor.BaseTracer
+}
+
+type temporalSpan struct {
+	trace.Span
+}
+
+func (s temporalSpan) Finish(options *interceptor.TracerFinishSpanOptions) {
+	if options.Error != nil {
+		s.RecordError(options.Error)
+		s.SetStatus(codes.Error, options.Error.Error())
+	}
+	s.End()
+}
+
+// temporalSpanRef is a span from a header, started by someone else.
+type temporalSpanRef struct {
+	trace.SpanContext
+}
+
+func (temporalTracer) Options() interceptor.TracerOptions {
+	return interceptor.TracerOptions{
+		SpanContextKey: temporalSpanKey{},
+		HeaderKey:      "_tracer-data",
+		// a header from a tracer we no longer understand shouldn't fail the
+		// workflow that carries it
+		AllowInvalidParentSpans: true,
+	}
+}
+
+func (temporalTracer) UnmarshalSpan(m map[string]string) (interceptor.TracerSpanRef, error) {
+	ctx := propagation.TraceContext{}.Extract(context.Background(), propagation.MapCarrier(m))
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return nil, errors.New("no valid trace context in header")
+	}
+	return temporalSpanRef{SpanContext: spanContext}, nil
+}
+
+func (temporalTracer) MarshalSpan(span interceptor.TracerSpan) (map[string]string, error) {
+	s, ok := span.(temporalSpan)
+	if !ok {
+		return nil, fmt.Errorf("unexpected span type %T", span)
+	}
+	m := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(trace.ContextWithSpan(context.Background(), s.Span), m)
+	return m, nil
+}
+
+func (temporalTracer) SpanFromContext(ctx context.Context) interceptor.TracerSpan {
+	span := trace.SpanFromContext(ctx)
+	if !span.SpanContext().IsValid() {
+		return nil
+	}
+	return temporalSpan{Span: span}
+}
+
+func (temporalTracer) ContextWithSpan(ctx context.Context, span interceptor.TracerSpan) context.Context {
+	return trace.ContextWithSpan(ctx, span.(temporalSpan).Span)
+}
+
+func (t temporalTracer) StartSpan(options *interceptor.TracerStartSpanOptions) (interceptor.TracerSpan, error) {
+	parent := context.Background()
+	switch p := options.Parent.(type) {
+	case nil:
+	case temporalSpan:
+		parent = trace.ContextWithSpan(parent, p.Span)
+	case temporalSpanRef:
+		parent = trace.ContextWithRemoteSpanContext(parent, p.SpanContext)
+	default:
+		return nil, fmt.Errorf("unexpected parent span type %T", p)
+	}
+
+	kind := trace.SpanKindClient
+	if options.Operation == "RunWorkflow" || options.Operation == "RunActivity" {
+		kind = trace.SpanKindServer
+	}
+	attributes := make([]attribute.KeyValue, 0, len(options.Tags))
+	for k, v := range options.Tags {
+		attributes = append(attributes, attribute.String(k, v))
+	}
+	_, span := tracer().Start(parent, t.SpanName(options),
+		trace.WithTimestamp(options.Time),
+		trace.WithSpanKind(kind),
+		trace.WithAttributes(attributes...),
+	)
+	return temporalSpan{Span: span}, nil
+}
+
+// executionTriggers are the signals that can lead an order's workflow to
+// execute it.
+var executionTriggers = map[string]bool{
+	PriceUpdateSignalName: true,
+	RedriveSignalName:     true,
+	ResumeSignalName:      true,
+}
+
+// executionTracingInterceptor puts ExecuteOrderActivity in the trace of the
+// signal that led to it, e.g. the price tick that crossed the stop, rather
+// than under the workflow's run, which began when the order was placed. It
+// has to come after the tracing interceptor, which puts each signal's span
+// in its handler's context.
+type executionTracingInterceptor struct {
+	interceptor.WorkerInterceptorBase
+}
+
+func (*executionTracingInterceptor) InterceptWorkflow(ctx workflow.Context, next interceptor.WorkflowInboundInterceptor) interceptor.WorkflowInboundInterceptor {
+	i := &executionTracingInbound{}
+	i.Next = next
+	return i
+}
+
+type executionTracingInbound struct {
+	interceptor.WorkflowInboundInterceptorBase
+	// trigger is the span of the last signal in executionTriggers
+	trigger interceptor.TracerSpan
+}
+
+func (i *executionTracingInbound) Init(outbound interceptor.WorkflowOutboundInterceptor) error {
+	o := &executionTracingOutbound{inbound: i}
+	o.Next = outbound
+	return i.Next.Init(o)
+}
+
+func (i *executionTracingInbound) HandleSignal(ctx workflow.Context, in *interceptor.HandleSignalInput) error {
+	if span, ok := ctx.Value(temporalSpanKey{}).(interceptor.TracerSpan); ok && executionTriggers[in.SignalName] {
+		i.trigger = span
+	}
+	return i.Next.HandleSignal(ctx, in)
+}
+
+type executionTracingOutbound struct {
+	interceptor.WorkflowOutboundInterceptorBase
+	inbound *executionTracingInbound
+}
+
+func (o *executionTracingOutbound) ExecuteActivity(ctx workflow.Context, activityType string, args ...interface{}) workflow.Future {
+	if activityType == "ExecuteOrderActivity" && o.inbound.trigger != nil {
+		ctx = workflow.WithValue(ctx, temporalSpanKey{}, o.inbound.trigger)
+	}
+	return o.Next.ExecuteActivity(ctx, activityType, args...)
+}
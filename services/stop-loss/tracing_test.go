@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.temporal.io/sdk/interceptor"
+)
+
+// recordSpans installs a tracer provider that keeps every span, until the
+// test ends.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	previous := otel.GetTracerProvider()
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+// endedSpan finds the span called name, failing the test without one.
+func endedSpan(t *testing.T, recorder *tracetest.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			return span
+		}
+	}
+	require.Failf(t, "span not found", "no %s span was ended", name)
+	return nil
+}
+
+func TestTemporalTracerCarriesSpansThroughHeaders(t *testing.T) {
+	recorder := recordSpans(t)
+	var tracer temporalTracer
+
+	ctx, tick := otel.Tracer("test").Start(context.Background(), "PriceTick")
+	parent := tracer.SpanFromContext(ctx)
+	require.NotNil(t, parent)
+
+	header, err := tracer.MarshalSpan(parent)
+	require.NoError(t, err)
+	assert.Contains(t, header, "traceparent")
+	ref, err := tracer.UnmarshalSpan(header)
+	require.NoError(t, err)
+
+	span, err := tracer.StartSpan(&interceptor.TracerStartSpanOptions{
+		Parent:    ref,
+		Operation: "HandleSignal",
+		Name:      PriceUpdateSignalName,
+		Tags:      map[string]string{"temporalWorkflowID": "stop-loss-workflow-order-1"},
+	})
+	require.NoError(t, err)
+	span.Finish(&interceptor.TracerFinishSpanOptions{})
+	tick.End()
+
+	signal := endedSpan(t, recorder, "HandleSignal:priceUpdate")
+	assert.Equal(t, tick.SpanContext().TraceID(), signal.SpanContext().TraceID())
+	assert.Equal(t, tick.SpanContext().SpanID(), signal.Parent().SpanID())
+
+	_, err = tracer.UnmarshalSpan(map[string]string{"traceparent": "junk"})
+	assert.Error(t, err)
+	assert.Nil(t, tracer.SpanFromContext(context.Background()))
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type StopLossOrder struct {
@@ -12,25 +16,314 @@ type StopLossOrder struct {
 	Security   string    `json:"security"`
 	StopPrice  float64   `json:"stopPrice"`
 	Quantity   int       `json:"quantity"`
-	Status     string    `json:"status"` // pending, executed, cancelled - using constants below
+	Status     string    `json:"status"` // pending, executed, cancelled, failed - using constants below
 	PlacedAt   time.Time `json:"placedAt"`
 	WorkflowID string    `json:"workflowID,omitempty"` // Temporal Workflow ID
+	// IdempotencyKey is supplied by the client; resubmitting with the same key
+	// returns the original order instead of placing another one.
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	// FailureReason is why execution failed, set only while the order is FAILED.
+	FailureReason string `json:"failureReason,omitempty"`
+	// AccountID owns the order. Orders placed before accounts existed belong
+	// to DefaultAccountID, though their workflows' input doesn't say so.
+	AccountID string `json:"accountID,omitempty"`
 }
 
 type OrderWorkflowService interface {
-	CreateOrder(ctx context.Context, order StopLossOrder) error
-	CancelOrder(ctx context.Context, orderID string) error
+	// CreateOrder stores the order and starts its workflow. created is false
+	// when the idempotency key matched an earlier order, which is returned
+	// instead.
+	CreateOrder(ctx context.Context, order StopLossOrder, source string) (saved StopLossOrder, created bool, err error)
+	// CancelOrder asks the order's workflow to cancel it and returns its
+	// verdict, one of the CancelResult values.
+	CancelOrder(ctx context.Context, orderID string, source string) (string, error)
+	// LiveState asks the order's workflow for its current state.
+	LiveState(ctx context.Context, orderID string) (OrderLiveState, error)
+	// RedriveOrder asks a FAILED order's workflow, starting a new one if it's
+	// gone, to retry execution or re-arm the stop.
+	RedriveOrder(ctx context.Context, orderID string, action string, source string) error
+	// SearchOrders finds orders through Temporal's visibility store instead
+	// of the orders table.
+	SearchOrders(ctx context.Context, search OrderSearch) ([]OrderSearchResult, error)
+	// HaltExecutions stops orders for security, or for every security if it's
+	// empty, from executing until the halt is lifted.
+	HaltExecutions(ctx context.Context, security string, reason string, source string) (Halt, error)
+	// ResumeExecutions lifts a halt and tells the open orders it covered,
+	// returning how many were told.
+	ResumeExecutions(ctx context.Context, security string, source string) (int, error)
+	ListHalts(ctx context.Context) ([]Halt, error)
+	// Reconcile compares the orders table with Temporal's open workflows,
+	// fixing mismatches if repair is set.
+	Reconcile(ctx context.Context, repair bool) (ReconcileReport, error)
 }
 
 type OrdersRepo interface {
 	CreateOrder(order StopLossOrder) (StopLossOrder, error)
 	GetOrder(orderID string) (StopLossOrder, error)
+	GetOrderByIdempotencyKey(key string) (StopLossOrder, error)
 	CancelOrder(orderID string) error
 	ListOrders() ([]StopLossOrder, error)
+	// UpdateOrderStatus also clears the failure reason of a FAILED order.
 	UpdateOrderStatus(orderID string, status string) error
+	MarkOrderFailed(orderID string, reason string) error
 	AssociateWorkflowID(orderID string, workflowID string) error
 	GetPendingWorkflowIDsForSecurity(security string) ([]string, error)
+	CountPendingOrdersBySecurity() (map[string]int, error)
 	GetOrdersForSecurity(security string) ([]StopLossOrder, error)
+	ListOrdersForAccount(accountID string) ([]StopLossOrder, error)
+}
+
+// riskCheck decides whether an order may be placed, given the account's
+// orders on its security and its position there, nil if it holds none.
+type riskCheck func(stops []StopLossOrder, position *Position) error
+
+// OrderAdmitter stores an order only if a risk check allows it, reading what
+// the check needs and inserting the order in one transaction, so two orders
+// can't both be checked against the same headroom, whichever replicas place
+// them. The SQL orders repos are OrderAdmitters.
+type OrderAdmitter interface {
+	// AdmitOrder returns check's error as it is.
+	AdmitOrder(order StopLossOrder, check riskCheck) (StopLossOrder, error)
+}
+
+// DefaultAccountID is the account everything from before accounts belongs
+// to, along with the built-in admin user.
+const DefaultAccountID = "default"
+
+// Account owns orders and positions.
+type Account struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// User acts for exactly one account, with a Role saying what they may do.
+// Risk managers and admins also see and act on every other account's orders.
+type User struct {
+	Username  string `json:"username"`
+	AccountID string `json:"accountID"`
+	Role      string `json:"role"`
+	// PasswordHash is the user's bcrypt hash, empty for users who can't log
+	// in to the web UI.
+	PasswordHash string    `json:"-"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// Owns says whether user may see and act on order.
+func (u User) Owns(order StopLossOrder) bool {
+	return u.Can(ActionViewAllAccounts) || order.AccountID == u.AccountID
+}
+
+type AccountsRepo interface {
+	CreateAccount(account Account) error
+	GetAccount(id string) (Account, error)
+	ListAccounts() ([]Account, error)
+	// CreateUser returns ErrAccountNotFound unless the user's account exists.
+	CreateUser(user User) error
+	GetUser(username string) (User, error)
+	ListUsers() ([]User, error)
+	// SetPassword replaces the user's password hash.
+	SetPassword(username string, passwordHash string) error
+	SetRole(username string, role string) error
+}
+
+// AuditEntry records an action a user tried. For now only refusals are
+// recorded; what was done to an order is in its OrderEvents.
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	Username   string    `json:"username"`
+	Role       string    `json:"role"`
+	Action     string    `json:"action"`
+	Target     string    `json:"target"` // the request, e.g. "POST /api/halts"
+	Outcome    string    `json:"outcome"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+const AuditOutcomeDenied = "denied"
+
+type AuditLogRepo interface {
+	AppendEntry(entry AuditEntry) error
+	// ListEntries returns the latest entries, newest first.
+	ListEntries(limit int) ([]AuditEntry, error)
+}
+
+// Session is a web UI login. Only a hash of the cookie's token is kept.
+type Session struct {
+	TokenHash string
+	Username  string
+	// CSRFToken must come back with every unsafe request the session makes.
+	CSRFToken string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// APIKey lets a user call the JSON API with a bearer token. Only a hash of
+// the key is kept; the key itself is shown once, when it's made.
+type APIKey struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Name      string    `json:"name"`
+	KeyHash   string    `json:"-"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type CredentialsRepo interface {
+	CreateSession(session Session) error
+	// GetSession returns ErrSessionNotFound for an unknown token hash,
+	// expired or not.
+	GetSession(tokenHash string) (Session, error)
+	DeleteSession(tokenHash string) error
+	CreateAPIKey(key APIKey) error
+	GetAPIKey(keyHash string) (APIKey, error)
+	ListAPIKeys() ([]APIKey, error)
+	DeleteAPIKey(id string) error
+}
+
+// OrderEvent is one entry in an order's append-only audit log.
+type OrderEvent struct {
+	ID         int64           `json:"id"`
+	OrderID    string          `json:"orderID"`
+	Type       string          `json:"type"`
+	Source     string          `json:"source"`
+	Payload    json.RawMessage `json:"payload"`
+	OccurredAt time.Time       `json:"occurredAt"`
+}
+
+type OrderEventsRepo interface {
+	AppendEvent(event OrderEvent) error
+	ListEventsForOrder(orderID string) ([]OrderEvent, error)
+}
+
+// Halt stops orders from executing while their workflows keep tracking
+// prices. Security is empty for a halt on every security.
+type Halt struct {
+	Security string    `json:"security,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	Source   string    `json:"source"`
+	HaltedAt time.Time `json:"haltedAt"`
+}
+
+type HaltsRepo interface {
+	// SetHalt halts the halt's security, replacing any halt already on it.
+	SetHalt(halt Halt) error
+	ClearHalt(security string) error
+	ListHalts() ([]Halt, error)
+	// HaltFor returns the halt that stops security from executing: its own,
+	// or else the one on every security.
+	HaltFor(security string) (Halt, error)
+}
+
+// Position is how many shares of a security an account holds. The account's
+// stop-losses on the security can't sell more than that between them.
+type Position struct {
+	AccountID string    `json:"accountID"`
+	Security  string    `json:"security"`
+	Quantity  int       `json:"quantity"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type PositionsRepo interface {
+	// SetPosition replaces the held quantity, e.g. from an import.
+	SetPosition(position Position) error
+	GetPosition(accountID string, security string) (Position, error)
+	ListPositions() ([]Position, error)
+	// ApplyExecution takes an executed order's quantity off its account's
+	// position in the security, once per order however often it's called.
+	ApplyExecution(order StopLossOrder, executedAt time.Time) error
+}
+
+// Errors returned by every OrdersRepo implementation
+var (
+	ErrOrderNotFound   = errors.New("order not found")
+	ErrOrderNotPending = errors.New("order is not pending and cannot be cancelled")
+	// ErrDuplicateIdempotencyKey is returned by CreateOrder when another order
+	// already holds the key
+	ErrDuplicateIdempotencyKey = errors.New("an order with this idempotency key already exists")
+	// ErrOrderNotLive means Temporal no longer (or never) had a workflow for
+	// the order to answer a query
+	ErrOrderNotLive = errors.New("order has no workflow to query")
+	// ErrOrderNotFailed is returned when re-driving an order that isn't FAILED
+	ErrOrderNotFailed = errors.New("order has not failed")
+	// ErrNotHalted is returned by HaltsRepo when there's no halt
+	ErrNotHalted = errors.New("executions are not halted")
+	// ErrInvalidOrder is returned by OrdersService.CreateOrder for an order
+	// without a security, a positive stop price and a positive quantity
+	ErrInvalidOrder = errors.New("security, a positive stop price and a positive quantity are required")
+	// ErrNoPosition is returned by PositionsRepo for a security with no
+	// position
+	ErrNoPosition = errors.New("no position held")
+	// Errors returned by AccountsRepo
+	ErrAccountNotFound = errors.New("account not found")
+	ErrAccountExists   = errors.New("account already exists")
+	ErrUserNotFound    = errors.New("user not found")
+	ErrUserExists      = errors.New("user already exists")
+	// Errors returned by CredentialsRepo
+	ErrSessionNotFound = errors.New("session not found")
+	ErrAPIKeyNotFound  = errors.New("API key not found")
+	// ErrWebhookNotFound is returned by WebhooksRepo
+	ErrWebhookNotFound = errors.New("webhook not found")
+	// ErrNotificationNotFound is returned by NotificationsRepo
+	ErrNotificationNotFound = errors.New("notification preference not found")
+)
+
+// WebhookSubscription sends an account's order events to URL as signed
+// JSON POSTs.
+type WebhookSubscription struct {
+	ID        string `json:"id"`
+	AccountID string `json:"accountID"`
+	URL       string `json:"url"`
+	// Secret signs every delivery. It's shown once, when the subscription is
+	// made.
+	Secret    string    `json:"-"`
+	Events    []string  `json:"events"` // WebhookEvent values
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WebhookDeadLetter is a delivery that ran out of retries.
+type WebhookDeadLetter struct {
+	ID             int64           `json:"id"`
+	DeliveryID     string          `json:"deliveryID"`
+	SubscriptionID string          `json:"subscriptionID"`
+	AccountID      string          `json:"accountID"`
+	OrderID        string          `json:"orderID"`
+	Event          string          `json:"event"`
+	URL            string          `json:"url"`
+	Payload        json.RawMessage `json:"payload"` // the body that was sent
+	Error          string          `json:"error"`   // from the last attempt
+	FailedAt       time.Time       `json:"failedAt"`
+}
+
+type WebhooksRepo interface {
+	CreateSubscription(subscription WebhookSubscription) error
+	GetSubscription(id string) (WebhookSubscription, error)
+	// ListSubscriptions lists the account's subscriptions, or every
+	// account's if accountID is empty.
+	ListSubscriptions(accountID string) ([]WebhookSubscription, error)
+	DeleteSubscription(id string) error
+	// RecordDeadLetter keeps the first dead letter for each delivery.
+	RecordDeadLetter(deadLetter WebhookDeadLetter) error
+	// ListDeadLetters returns the latest dead letters, newest first.
+	ListDeadLetters(limit int) ([]WebhookDeadLetter, error)
+}
+
+// NotificationPreference tells an account's traders about their orders
+// executing or failing, by email or in a chat channel.
+type NotificationPreference struct {
+	ID        string    `json:"id"`
+	AccountID string    `json:"accountID"`
+	Channel   string    `json:"channel"` // NotificationChannel value
+	Target    string    `json:"target"`  // an email address, or a chat incoming-webhook URL
+	Events    []string  `json:"events"`  // OrderEventExecuted, OrderEventFailed
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type NotificationsRepo interface {
+	CreatePreference(preference NotificationPreference) error
+	GetPreference(id string) (NotificationPreference, error)
+	// ListPreferences lists the account's preferences, or every account's if
+	// accountID is empty.
+	ListPreferences(accountID string) ([]NotificationPreference, error)
+	DeletePreference(id string) error
 }
 
 // PriceIngestionService manages the WebSocket connection and price updates.
@@ -38,12 +331,18 @@ type PriceIngestionService struct {
 	wsURL         string
 	conn          *websocket.Conn
 	pricesChannel chan PriceUpdate // Channel to publish price updates
+	connected     atomic.Bool
+	lastTickAt    atomic.Int64 // unix nanoseconds, 0 until the first tick
+	done          chan struct{}
 }
 
 // PriceUpdate struct to hold price update information
 type PriceUpdate struct {
 	Security string  `json:"security"`
 	Price    float64 `json:"price"`
+	// spanContext is the tick's span, which the dispatcher carries on into
+	// the signals it sends.
+	spanContext trace.SpanContext
 }
 
 // PriceUpdateSignal is the signal type for price updates.
@@ -56,13 +355,110 @@ type PriceUpdateSignalData struct {
 	Price    float64 `json:"price"`
 }
 
-// CancelOrderSignal is the signal type for order cancellation.
-type CancelOrderSignal struct{}
+// CancelOrderRequest is the argument of the cancel update (and of the cancel
+// signal it replaced).
+type CancelOrderRequest struct {
+	Source string `json:"source"` // who asked, recorded in the audit log
+}
 
 // WorkflowSignals to keep signal names as constants
 const (
 	PriceUpdateSignalName = "priceUpdate"
-	CancelOrderSignalName = "cancelOrder"
+	CancelOrderSignalName = "cancelOrder" // superseded by CancelOrderUpdateName
+)
+
+// CancelOrderUpdateName is the update StopLossWorkflow decides cancellation
+// through; its result is one of the CancelResult values.
+const CancelOrderUpdateName = "cancel"
+
+// StopLossRun is StopLossWorkflow's second argument: how often to continue
+// as new, and what the previous run knew when it did.
+type StopLossRun struct {
+	ContinueAsNew ContinueAsNewPolicy    `json:"continueAsNew"`
+	OnFailure     ExecutionFailurePolicy `json:"onFailure"`
+
+	LastPrice         float64                 `json:"lastPrice,omitempty"`
+	LastPriceAt       time.Time               `json:"lastPriceAt,omitempty"`
+	PriceUpdates      int                     `json:"priceUpdates,omitempty"`
+	ExecutionAttempts int                     `json:"executionAttempts,omitempty"`
+	PendingPrices     []PriceUpdateSignalData `json:"pendingPrices,omitempty"` // received during the handover, handled first
+	Halted            *Halt                   `json:"halted,omitempty"`        // the halt a trigger is queued behind
+}
+
+// ContinueAsNewPolicy bounds a StopLossWorkflow run's history. It travels
+// with the workflow rather than being read from config, so changing it never
+// affects replay of runs already in flight. Zero disables a limit.
+type ContinueAsNewPolicy struct {
+	AfterSignals       int `json:"afterSignals"`
+	AfterHistoryEvents int `json:"afterHistoryEvents"`
+}
+
+// ExecutionFailurePolicy decides what StopLossWorkflow does once
+// ExecuteOrderActivity has used up its own retries. Like ContinueAsNewPolicy
+// it travels with the workflow.
+type ExecutionFailurePolicy struct {
+	Action     string        `json:"action"`               // one of the FailureAction values, escalate if empty
+	RetryDelay time.Duration `json:"retryDelay,omitempty"` // retry: wait between attempts
+	MaxRetries int           `json:"maxRetries,omitempty"` // retry: attempts after the first, then escalate
+}
+
+// What to do with an order whose execution failed
+const (
+	FailureActionRetry    = "retry"    // try executing again after a delay
+	FailureActionRearm    = "rearm"    // back to PENDING, the next price at or below the stop triggers again
+	FailureActionEscalate = "escalate" // FAILED with the error, until an admin re-drives or cancels it
+)
+
+// RedriveSignalName re-drives a FAILED order. It's sent with signal-with-start,
+// so it reaches the order even if its workflow is gone.
+const RedriveSignalName = "redrive"
+
+// RedriveRequest is the argument of the redrive signal.
+type RedriveRequest struct {
+	Action string `json:"action"` // FailureActionRetry or FailureActionRearm
+	Source string `json:"source"`
+}
+
+// ResumeSignalName tells an order its halt was lifted. Orders with nothing
+// queued ignore it.
+const ResumeSignalName = "resume"
+
+// ResumeRequest is the argument of the resume signal.
+type ResumeRequest struct {
+	Action string `json:"action"` // one of the ResumeAction values, execute if empty
+	Source string `json:"source"`
+}
+
+// What a halted trigger does once its halt is lifted
+const (
+	ResumeActionExecute    = "execute"    // execute, whatever the price is now
+	ResumeActionReevaluate = "reevaluate" // execute only if the last price is still at or below the stop
+)
+
+// OrderStateQueryName is the query StopLossWorkflow answers with its
+// OrderLiveState.
+const OrderStateQueryName = "orderState"
+
+// OrderLiveState is what the order's workflow currently believes, which the
+// orders table may lag behind.
+type OrderLiveState struct {
+	OrderID           string    `json:"orderID"`
+	Security          string    `json:"security"`
+	Status            string    `json:"status"`        // also EXECUTING while the order is being executed
+	EffectiveStop     float64   `json:"effectiveStop"` // the price that triggers execution
+	LastPrice         float64   `json:"lastPrice"`     // zero until the first price update
+	LastPriceAt       time.Time `json:"lastPriceAt"`
+	PriceUpdates      int       `json:"priceUpdates"`      // updates for this order's security
+	ExecutionAttempts int       `json:"executionAttempts"` // activity retries count as one attempt
+	FailureReason     string    `json:"failureReason,omitempty"`
+	HaltReason        string    `json:"haltReason,omitempty"` // set while a trigger is queued behind a halt
+}
+
+// Outcomes of a cancel request
+const (
+	CancelResultCancelled       = "cancelled"
+	CancelResultTooLate         = "too_late" // the order is executing right now
+	CancelResultAlreadyExecuted = "already_executed"
 )
 
 // Workflow statuses
@@ -70,4 +466,28 @@ const (
 	OrderStatusPending   = "PENDING"
 	OrderStatusExecuted  = "EXECUTED"
 	OrderStatusCancelled = "CANCELLED"
+	OrderStatusFailed    = "FAILED"    // execution failed; the workflow waits for an admin to re-drive it
+	OrderStatusExecuting = "EXECUTING" // only ever reported live, never stored
+	OrderStatusHalted    = "HALTED"    // triggered while executions are halted; live only, like EXECUTING
+)
+
+// Order event types recorded in the audit log
+const (
+	OrderEventCreated            = "created"
+	OrderEventPriceTriggered     = "price-triggered"
+	OrderEventExecutionAttempted = "execution-attempted"
+	OrderEventExecuted           = "executed"
+	OrderEventCancelled          = "cancelled"
+	OrderEventFailed             = "failed"
+	OrderEventRedriven           = "redriven"
+	OrderEventHalted             = "halted"  // the trigger is queued until the halt is lifted
+	OrderEventResumed            = "resumed" // the halt was lifted
+)
+
+// Where an order event came from
+const (
+	EventSourceWeb      = "web"
+	EventSourceWorkflow = "workflow"
+	EventSourceAdmin    = "admin"
+	EventSourceAPI      = "api"
 )
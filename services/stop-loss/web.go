@@ -1,46 +1,93 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.temporal.io/sdk/client"
 )
 
 type WebServer struct {
 	template             *template.Template
+	detailTemplate       *template.Template
+	loginTemplate        *template.Template
 	orderWorkflowService OrderWorkflowService
 	ordersRepo           OrdersRepo
+	eventsRepo           OrderEventsRepo
+	positionsRepo        PositionsRepo
+	accountsRepo         AccountsRepo
+	credentialsRepo      CredentialsRepo
+	auditRepo            AuditLogRepo
+	webhooksRepo         WebhooksRepo
+	notificationsRepo    NotificationsRepo
 }
 
-func NewWebServer(tpl *template.Template, tc client.Client, repo OrdersRepo, orderWorkflowService OrderWorkflowService) *WebServer {
+func NewWebServer(tpl *template.Template, tc client.Client, repo OrdersRepo, eventsRepo OrderEventsRepo, positionsRepo PositionsRepo, accountsRepo AccountsRepo, credentialsRepo CredentialsRepo, auditRepo AuditLogRepo, webhooksRepo WebhooksRepo, notificationsRepo NotificationsRepo, orderWorkflowService OrderWorkflowService) (*WebServer, error) {
+	detailTpl, err := compilePageTemplate(tpl, "./html/pages/order_detail.html")
+	if err != nil {
+		return nil, err
+	}
+	loginTpl, err := compilePageTemplate(tpl, "./html/pages/login.html")
+	if err != nil {
+		return nil, err
+	}
 	return &WebServer{
 		template:             tpl,
+		detailTemplate:       detailTpl,
+		loginTemplate:        loginTpl,
 		ordersRepo:           repo,
+		eventsRepo:           eventsRepo,
+		positionsRepo:        positionsRepo,
+		accountsRepo:         accountsRepo,
+		credentialsRepo:      credentialsRepo,
+		auditRepo:            auditRepo,
+		webhooksRepo:         webhooksRepo,
+		notificationsRepo:    notificationsRepo,
 		orderWorkflowService: orderWorkflowService,
-	}
+	}, nil
 }
 
 func (s *WebServer) SetupRoutes(mux *mux.Router) {
-	mux.HandleFunc("/", s.handleIndex).Methods("GET")
-	mux.HandleFunc("/orders", s.handleCreateOrder).Methods("POST")
-	mux.HandleFunc("/orders", s.handleGetOrders).Methods("GET")
-	mux.HandleFunc("/orders/{id}/cancel", s.handleCancelOrder).Methods("POST")
+	mux.Use(withRequestID, instrumentHTTP, s.authenticate)
+
+	mux.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	mux.HandleFunc("/login", s.handleLoginPage).Methods("GET")
+	mux.HandleFunc("/login", s.handleLogin).Methods("POST")
+	mux.HandleFunc("/logout", s.handleLogout).Methods("POST")
+	mux.HandleFunc("/", s.allow(ActionViewOrders, s.handleIndex)).Methods("GET")
+	mux.HandleFunc("/orders", s.allow(ActionPlaceOrder, s.handleCreateOrder)).Methods("POST")
+	mux.HandleFunc("/orders", s.allow(ActionViewOrders, s.handleGetOrders)).Methods("GET")
+	mux.HandleFunc("/orders/{id}", s.allow(ActionViewOrders, s.handleGetOrder)).Methods("GET")
+	mux.HandleFunc("/orders/{id}/cancel", s.allow(ActionCancelOrder, s.handleCancelOrder)).Methods("POST")
+	mux.HandleFunc("/orders/{id}/redrive", s.allow(ActionRedriveOrder, s.handleRedriveOrder)).Methods("POST")
+	mux.HandleFunc("/orders/{id}/live", s.allow(ActionViewOrders, s.handleOrderLiveState)).Methods("GET")
+	mux.HandleFunc("/halts", s.allow(ActionViewOrders, s.handleGetHalts)).Methods("GET")
+	mux.HandleFunc("/halts", s.allow(ActionHaltExecutions, s.handleHaltExecutions)).Methods("POST")
+	mux.HandleFunc("/halts/resume", s.allow(ActionHaltExecutions, s.handleResumeExecutions)).Methods("POST")
+
+	s.setupAPIRoutes(mux.PathPrefix("/api").Subrouter())
 }
 
 func (s *WebServer) handleIndex(w http.ResponseWriter, r *http.Request) {
-	orders, err := s.ordersRepo.ListOrders()
+	user := userFrom(r)
+	orders, err := s.ordersFor(user)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to load orders: %v", err), http.StatusInternalServerError)
 		return
 	}
-	data := IndexPageData{Orders: orders}
+	idempotencyKey, err := randomHex(16)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to generate idempotency key: %v", err), http.StatusInternalServerError)
+		return
+	}
+	data := IndexPageData{PageData: pageData(r), Orders: orders, IdempotencyKey: idempotencyKey}
 	err = s.template.ExecuteTemplate(w, "layout.html", data)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Template execution error: %v", err), http.StatusInternalServerError)
@@ -68,16 +115,28 @@ func (s *WebServer) handleCreateOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	orderID := fmt.Sprintf("order-%d", time.Now().UnixNano()) // Simple unique ID
 	order := StopLossOrder{
-		ID:        orderID,
-		Security:  security,
-		StopPrice: price,
-		Quantity:  quantity,
-		Status:    OrderStatusPending,
+		Security:       security,
+		StopPrice:      price,
+		Quantity:       quantity,
+		IdempotencyKey: r.FormValue("idempotency_key"), // rendered into the form, so a double submit reuses it
+		AccountID:      userFrom(r).AccountID,
 	}
 
-	err = s.orderWorkflowService.CreateOrder(r.Context(), order)
+	_, _, err = s.orderWorkflowService.CreateOrder(r.Context(), order, EventSourceWeb)
+	if errors.Is(err, ErrInvalidOrder) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if reasons := riskReasons(err); reasons != nil {
+		// shown in the error toast
+		http.Error(w, strings.Join(reasons, "; "), http.StatusUnprocessableEntity)
+		return
+	}
+	if errors.Is(err, ErrDuplicateIdempotencyKey) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to create order: %v", err), http.StatusInternalServerError)
 		return
@@ -85,7 +144,7 @@ func (s *WebServer) handleCreateOrder(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *WebServer) handleGetOrders(w http.ResponseWriter, r *http.Request) {
-	orders, err := s.ordersRepo.ListOrders()
+	orders, err := s.ordersFor(userFrom(r))
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to load orders: %v", err), http.StatusInternalServerError)
 		return
@@ -100,30 +159,164 @@ func (s *WebServer) handleCancelOrder(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	orderID := vars["id"]
 
-	order, err := s.ordersRepo.GetOrder(orderID)
+	err := s.checkOwner(r, ActionCancelOrder, orderID)
+	var result string
+	if err == nil {
+		result, err = s.orderWorkflowService.CancelOrder(r.Context(), orderID, EventSourceWeb)
+	}
+	if errors.Is(err, ErrOrderNotFound) {
+		http.Error(w, fmt.Sprintf("Order not found: %v", err), http.StatusNotFound)
+		return
+	}
 	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to cancel order", "orderID", orderID, "error", err)
+		http.Error(w, "Failed to cancel order.", http.StatusInternalServerError)
+		return
+	}
+
+	// replaces the cancel button, so always a 2xx for htmx to swap in
+	fmt.Fprintf(w, `<span class="cancel-result cancel-%s">%s</span>`, result, template.HTMLEscapeString(cancelResultMessage(result)))
+}
+
+func cancelResultMessage(result string) string {
+	switch result {
+	case CancelResultCancelled:
+		return "Order cancelled."
+	case CancelResultTooLate:
+		return "Too late to cancel: the order is already executing."
+	case CancelResultAlreadyExecuted:
+		return "Too late to cancel: the order has already been executed."
+	}
+	return result
+}
+
+// handleRedriveOrder is the admin action on a FAILED order: retry execution,
+// or re-arm the stop.
+func (s *WebServer) handleRedriveOrder(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+	action := r.FormValue("action")
+	if action != FailureActionRetry && action != FailureActionRearm {
+		http.Error(w, "Invalid action", http.StatusBadRequest)
+		return
+	}
+
+	err := s.checkOwner(r, ActionRedriveOrder, orderID)
+	if err == nil {
+		err = s.orderWorkflowService.RedriveOrder(r.Context(), orderID, action, EventSourceAdmin)
+	}
+	if errors.Is(err, ErrOrderNotFound) {
 		http.Error(w, fmt.Sprintf("Order not found: %v", err), http.StatusNotFound)
 		return
 	}
+	message := "Retrying execution."
+	switch {
+	case errors.Is(err, ErrOrderNotFailed):
+		message = "The order is no longer FAILED."
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Failed to re-drive order", "orderID", orderID, "error", err)
+		http.Error(w, "Failed to re-drive order.", http.StatusInternalServerError)
+		return
+	case action == FailureActionRearm:
+		message = "Stop re-armed."
+	}
+
+	// replaces the re-drive buttons, like the cancel result
+	fmt.Fprintf(w, `<span class="redrive-result">%s</span>`, template.HTMLEscapeString(message))
+}
+
+func (s *WebServer) handleGetHalts(w http.ResponseWriter, r *http.Request) {
+	s.renderHalts(w, r)
+}
 
-	// TODO: probably a race condition here
-	if order.Status != OrderStatusPending {
-		http.Error(w, "Order cannot be cancelled as it is not pending.", http.StatusBadRequest)
+// handleHaltExecutions halts the form's security, or every security if it's
+// empty, and answers with the updated list of halts.
+func (s *WebServer) handleHaltExecutions(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+	_, err := s.orderWorkflowService.HaltExecutions(r.Context(), r.FormValue("security"), r.FormValue("reason"), EventSourceWeb)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to halt executions", "security", haltScope(r.FormValue("security")), "error", err)
+		http.Error(w, "Failed to halt executions.", http.StatusInternalServerError)
 		return
 	}
+	s.renderHalts(w, r)
+}
 
-	workflowID := order.WorkflowID
-	if workflowID == "" {
-		http.Error(w, "Workflow ID not associated with order, cannot cancel.", http.StatusInternalServerError) // Should not happen ideally
+func (s *WebServer) handleResumeExecutions(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+	security := r.FormValue("security")
+	// a halt someone else already lifted needs nothing more than a fresh list
+	_, err := s.orderWorkflowService.ResumeExecutions(r.Context(), security, EventSourceWeb)
+	if err != nil && !errors.Is(err, ErrNotHalted) {
+		slog.ErrorContext(r.Context(), "Failed to resume executions", "security", haltScope(security), "error", err)
+		http.Error(w, "Failed to resume executions.", http.StatusInternalServerError)
 		return
 	}
+	s.renderHalts(w, r)
+}
 
-	err = s.orderWorkflowService.CancelOrder(r.Context(), workflowID)
+func (s *WebServer) renderHalts(w http.ResponseWriter, r *http.Request) {
+	halts, err := s.orderWorkflowService.ListHalts(r.Context())
 	if err != nil {
-		log.Printf("Web: Error signaling workflow %s to cancel: %v", workflowID, err)
-		http.Error(w, "Failed to cancel order.", http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Failed to load halts: %v", err), http.StatusInternalServerError)
+		return
+	}
+	err = s.template.ExecuteTemplate(w, "halts_list.html", halts)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Template execution error: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// handleOrderLiveState reports the order as its workflow sees it, straight
+// from Temporal rather than the orders table.
+func (s *WebServer) handleOrderLiveState(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+
+	err := s.checkOwner(r, ActionViewOrders, orderID)
+	var state OrderLiveState
+	if err == nil {
+		state, err = s.orderWorkflowService.LiveState(r.Context(), orderID)
+	}
+	if errors.Is(err, ErrOrderNotFound) || errors.Is(err, ErrOrderNotLive) {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to query live state", "orderID", orderID, "error", err)
+		writeJSONError(w, http.StatusBadGateway, "failed to query the order's workflow")
+		return
+	}
+	writeJSON(w, http.StatusOK, state)
+}
+
+func (s *WebServer) handleGetOrder(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+
+	order, err := s.orderFor(r, ActionViewOrders, orderID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Order not found: %v", err), http.StatusNotFound)
+		return
+	}
+	events, err := s.eventsRepo.ListEventsForOrder(orderID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load order history: %v", err), http.StatusInternalServerError)
 		return
 	}
+
+	data := OrderDetailPageData{PageData: pageData(r), Order: order, Events: events}
+	err = s.detailTemplate.ExecuteTemplate(w, "layout.html", data)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Template execution error: %v", err), http.StatusInternalServerError)
+	}
 }
 
 func compileTemplates() (*template.Template, error) {
@@ -140,11 +333,49 @@ func compileTemplates() (*template.Template, error) {
 		return nil, fmt.Errorf("template parsing error: %w", err)
 	}
 
-	log.Println(templates.DefinedTemplates())
+	slog.Debug("Parsed templates", "templates", templates.DefinedTemplates())
 
 	return templates, nil
 }
 
+// compilePageTemplate layers a page, which defines its own "content", over
+// the shared templates.
+func compilePageTemplate(base *template.Template, pageFile string) (*template.Template, error) {
+	page, err := base.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("template clone error: %w", err)
+	}
+	page, err = page.ParseFiles(pageFile)
+	if err != nil {
+		return nil, fmt.Errorf("template parsing error: %w", err)
+	}
+	return page, nil
+}
+
+// PageData is what layout.html needs of every page.
+type PageData struct {
+	User      User   // who the page is for; empty on the login page
+	CSRFToken string // htmx sends it back on every request
+}
+
+func pageData(r *http.Request) PageData {
+	session, _ := sessionFrom(r)
+	return PageData{User: userFrom(r), CSRFToken: session.CSRFToken}
+}
+
 type IndexPageData struct {
-	Orders []StopLossOrder // Use StopLossOrder struct
+	PageData
+	Orders         []StopLossOrder // Use StopLossOrder struct
+	IdempotencyKey string          // for the order form; replaced after each successful submit
+}
+
+type OrderDetailPageData struct {
+	PageData
+	Order  StopLossOrder
+	Events []OrderEvent
+}
+
+type LoginPageData struct {
+	PageData
+	Error string
 }
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeOrderWorkflowService records calls instead of talking to Temporal.
+type fakeOrderWorkflowService struct {
+	created   []StopLossOrder
+	sources   []string
+	cancelled []string
+	redriven  []string // orderID:action
+
+	cancelResult  string
+	live          map[string]OrderLiveState
+	searches      []OrderSearch
+	searchResults []OrderSearchResult
+	halts         []Halt
+	reconciles    []bool   // the repair flag of each
+	riskReasons   []string // CreateOrder rejects every order with these, if set
+}
+
+// CreateOrder hands out sequential IDs and replays orders by idempotency key.
+func (f *fakeOrderWorkflowService) CreateOrder(ctx context.Context, order StopLossOrder, source string) (StopLossOrder, bool, error) {
+	if f.riskReasons != nil {
+		return StopLossOrder{}, false, &RiskRejection{Reasons: f.riskReasons}
+	}
+	for _, existing := range f.created {
+		if order.IdempotencyKey != "" && existing.IdempotencyKey == order.IdempotencyKey {
+			return existing, false, nil
+		}
+	}
+	order.ID = fmt.Sprintf("order-%d", len(f.created)+1)
+	order.Status = OrderStatusPending
+	f.created = append(f.created, order)
+	f.sources = append(f.sources, source)
+	return order, true, nil
+}
+
+func (f *fakeOrderWorkflowService) LiveState(ctx context.Context, orderID string) (OrderLiveState, error) {
+	state, ok := f.live[orderID]
+	if !ok {
+		return OrderLiveState{}, ErrOrderNotLive
+	}
+	return state, nil
+}
+
+// RedriveOrder re-drives anything but "nope", which doesn't exist, and
+// "order-ok", which hasn't failed.
+func (f *fakeOrderWorkflowService) RedriveOrder(ctx context.Context, orderID string, action string, source string) error {
+	switch orderID {
+	case "nope":
+		return ErrOrderNotFound
+	case "order-ok":
+		return ErrOrderNotFailed
+	}
+	f.redriven = append(f.redriven, orderID+":"+action)
+	return nil
+}
+
+// SearchOrders returns searchResults, after noting the search it was given.
+func (f *fakeOrderWorkflowService) SearchOrders(ctx context.Context, search OrderSearch) ([]OrderSearchResult, error) {
+	f.searches = append(f.searches, search)
+	return f.searchResults, nil
+}
+
+// HaltExecutions replaces any halt already on the security.
+func (f *fakeOrderWorkflowService) HaltExecutions(ctx context.Context, security string, reason string, source string) (Halt, error) {
+	halt := Halt{Security: security, Reason: reason, Source: source, HaltedAt: time.Date(2025, 2, 1, 14, 30, 0, 0, time.UTC)}
+	for i, existing := range f.halts {
+		if existing.Security == security {
+			f.halts[i] = halt
+			return halt, nil
+		}
+	}
+	f.halts = append(f.halts, halt)
+	return halt, nil
+}
+
+// ResumeExecutions lifts the halt and claims to have resumed two orders.
+func (f *fakeOrderWorkflowService) ResumeExecutions(ctx context.Context, security string, source string) (int, error) {
+	for i, existing := range f.halts {
+		if existing.Security == security {
+			f.halts = append(f.halts[:i], f.halts[i+1:]...)
+			return 2, nil
+		}
+	}
+	return 0, ErrNotHalted
+}
+
+func (f *fakeOrderWorkflowService) ListHalts(ctx context.Context) ([]Halt, error) {
+	return f.halts, nil
+}
+
+// Reconcile finds nothing to fix.
+func (f *fakeOrderWorkflowService) Reconcile(ctx context.Context, repair bool) (ReconcileReport, error) {
+	f.reconciles = append(f.reconciles, repair)
+	return ReconcileReport{Mismatches: []ReconcileMismatch{}}, nil
+}
+
+// CancelOrder answers cancelResult, cancelled unless a test says otherwise.
+func (f *fakeOrderWorkflowService) CancelOrder(ctx context.Context, orderID string, source string) (string, error) {
+	if orderID == "nope" {
+		return "", ErrOrderNotFound
+	}
+	f.cancelled = append(f.cancelled, orderID)
+	if f.cancelResult == "" {
+		return CancelResultCancelled, nil
+	}
+	return f.cancelResult, nil
+}
+
+type testWebServer struct {
+	router        *mux.Router
+	orders        *OrdersRepoMemory
+	events        *OrderEventsRepoMemory
+	positions     *PositionsRepoMemory
+	accounts      *AccountsRepoMemory
+	credentials   *CredentialsRepoMemory
+	audit         *AuditLogRepoMemory
+	webhooks      *WebhooksRepoMemory
+	notifications *NotificationsRepoMemory
+	service       *fakeOrderWorkflowService
+	apiKeys       map[string]string // username to key, made on first use
+}
+
+func newTestWebServer(t *testing.T) *testWebServer {
+	tpl, err := compileTemplates()
+	require.NoError(t, err)
+
+	ts := &testWebServer{
+		router:        mux.NewRouter(),
+		orders:        NewOrdersRepoMemory(),
+		events:        NewOrderEventsRepoMemory(),
+		positions:     NewPositionsRepoMemory(),
+		accounts:      NewAccountsRepoMemory(),
+		credentials:   NewCredentialsRepoMemory(),
+		audit:         NewAuditLogRepoMemory(),
+		webhooks:      NewWebhooksRepoMemory(),
+		notifications: NewNotificationsRepoMemory(),
+		service:       &fakeOrderWorkflowService{},
+		apiKeys:       map[string]string{},
+	}
+	webServer, err := NewWebServer(tpl, nil, ts.orders, ts.events, ts.positions, ts.accounts, ts.credentials, ts.audit, ts.webhooks, ts.notifications, ts.service)
+	require.NoError(t, err)
+	webServer.SetupRoutes(ts.router)
+	return ts
+}
+
+// addUser adds a trader, and their account if it's new.
+func (ts *testWebServer) addUser(t *testing.T, username, accountID string) {
+	ts.addUserWithRole(t, username, accountID, RoleTrader)
+}
+
+func (ts *testWebServer) addUserWithRole(t *testing.T, username, accountID, role string) {
+	err := ts.accounts.CreateAccount(Account{ID: accountID, Name: accountID})
+	if !errors.Is(err, ErrAccountExists) {
+		require.NoError(t, err)
+	}
+	require.NoError(t, ts.accounts.CreateUser(User{Username: username, AccountID: accountID, Role: role}))
+}
+
+// doAs makes the request as username, with an API key.
+func (ts *testWebServer) doAs(username string, req *http.Request) *httptest.ResponseRecorder {
+	secret, ok := ts.apiKeys[username]
+	if !ok {
+		key, s, err := newAPIKey(username, "test", time.Now())
+		if err != nil {
+			panic(err)
+		}
+		if err := ts.credentials.CreateAPIKey(key); err != nil {
+			panic(err)
+		}
+		secret = s
+		ts.apiKeys[username] = secret
+	}
+	req.Header.Set("Authorization", "Bearer "+secret)
+	return ts.send(req)
+}
+
+// do makes the request as the built-in admin.
+func (ts *testWebServer) do(req *http.Request) *httptest.ResponseRecorder {
+	return ts.doAs("admin", req)
+}
+
+// send makes the request with whatever credentials it already has.
+func (ts *testWebServer) send(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	ts.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestWebOrderDetailShowsTimeline(t *testing.T) {
+	ts := newTestWebServer(t)
+	placedAt := time.Date(2025, 2, 1, 14, 30, 0, 0, time.UTC)
+	_, err := ts.orders.CreateOrder(StopLossOrder{ID: "order-1", Security: "AAPL", StopPrice: 145, Quantity: 10, Status: OrderStatusExecuted, PlacedAt: placedAt})
+	require.NoError(t, err)
+	require.NoError(t, ts.events.AppendEvent(newOrderEvent("order-1", OrderEventCreated, EventSourceWeb, nil, placedAt)))
+	require.NoError(t, ts.events.AppendEvent(newOrderEvent("order-1", OrderEventExecuted, EventSourceWorkflow, map[string]string{"result": "filled"}, placedAt.Add(time.Hour))))
+
+	rec := ts.do(httptest.NewRequest("GET", "/orders/order-1", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.Contains(t, body, "Order order-1")
+	assert.Contains(t, body, "2025-02-01 15:30:00")
+	assert.Contains(t, body, "via workflow")
+	assert.Contains(t, body, "filled")
+}
+
+func TestWebOrderDetailNotFound(t *testing.T) {
+	ts := newTestWebServer(t)
+	rec := ts.do(httptest.NewRequest("GET", "/orders/nope", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestWebIndexRendersIdempotencyKey(t *testing.T) {
+	ts := newTestWebServer(t)
+	rec := ts.do(httptest.NewRequest("GET", "/", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Regexp(t, `name="idempotency_key" value="[0-9a-f]{32}"`, rec.Body.String())
+}
+
+func TestWebCreateOrderRejectedByRiskLimits(t *testing.T) {
+	ts := newTestWebServer(t)
+	ts.service.riskReasons = []string{"stops on AAPL would sell 20 shares but 15 are held", "over the notional limit"}
+	req := httptest.NewRequest("POST", "/orders", strings.NewReader(url.Values{"security": {"AAPL"}, "price": {"145.5"}, "quantity": {"10"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rec := ts.do(req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Equal(t, "stops on AAPL would sell 20 shares but 15 are held; over the notional limit\n", rec.Body.String())
+}
+
+func TestWebCreateOrderIsIdempotent(t *testing.T) {
+	ts := newTestWebServer(t)
+	form := url.Values{"security": {"AAPL"}, "price": {"145.5"}, "quantity": {"10"}, "idempotency_key": {"key-1"}}
+
+	// a double click submits the same form twice
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/orders", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := ts.do(req)
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	require.Len(t, ts.service.created, 1)
+	assert.Equal(t, "key-1", ts.service.created[0].IdempotencyKey)
+	assert.Equal(t, []string{EventSourceWeb}, ts.service.sources)
+}
+
+func TestWebCancelOrderReportsOutcome(t *testing.T) {
+	for result, message := range map[string]string{
+		CancelResultCancelled:       "Order cancelled.",
+		CancelResultTooLate:         "already executing",
+		CancelResultAlreadyExecuted: "already been executed",
+	} {
+		ts := newTestWebServer(t)
+		ts.service.cancelResult = result
+
+		rec := ts.do(httptest.NewRequest("POST", "/orders/order-1/cancel", nil))
+
+		require.Equal(t, http.StatusOK, rec.Code, result)
+		assert.Contains(t, rec.Body.String(), "cancel-"+result)
+		assert.Contains(t, rec.Body.String(), message)
+		assert.Equal(t, []string{"order-1"}, ts.service.cancelled)
+	}
+
+	ts := newTestWebServer(t)
+	rec := ts.do(httptest.NewRequest("POST", "/orders/nope/cancel", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestWebOrderLiveState(t *testing.T) {
+	ts := newTestWebServer(t)
+	ts.service.live = map[string]OrderLiveState{
+		"order-1": {OrderID: "order-1", Security: "AAPL", Status: OrderStatusPending, EffectiveStop: 145, LastPrice: 150.25, PriceUpdates: 3},
+	}
+
+	rec := ts.do(httptest.NewRequest("GET", "/orders/order-1/live", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), `"lastPrice":150.25`)
+	assert.Contains(t, rec.Body.String(), `"priceUpdates":3`)
+
+	rec = ts.do(httptest.NewRequest("GET", "/orders/order-2/live", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestWebOrderListShowsFailedOrder(t *testing.T) {
+	ts := newTestWebServer(t)
+	_, err := ts.orders.CreateOrder(StopLossOrder{ID: "order-1", Security: "AAPL", StopPrice: 145, Quantity: 10, Status: OrderStatusPending})
+	require.NoError(t, err)
+	require.NoError(t, ts.orders.MarkOrderFailed("order-1", "broker unavailable"))
+
+	rec := ts.do(httptest.NewRequest("GET", "/orders", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.Contains(t, body, "broker unavailable")
+	assert.Contains(t, body, `hx-post="/orders/order-1/redrive"`)
+}
+
+func TestWebRedriveOrder(t *testing.T) {
+	for action, message := range map[string]string{
+		FailureActionRetry: "Retrying execution.",
+		FailureActionRearm: "Stop re-armed.",
+	} {
+		ts := newTestWebServer(t)
+		req := httptest.NewRequest("POST", "/orders/order-1/redrive", strings.NewReader(url.Values{"action": {action}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rec := ts.do(req)
+
+		require.Equal(t, http.StatusOK, rec.Code, action)
+		assert.Contains(t, rec.Body.String(), message)
+		assert.Equal(t, []string{"order-1:" + action}, ts.service.redriven)
+	}
+
+	ts := newTestWebServer(t)
+	for orderID, status := range map[string]int{"order-ok": http.StatusOK, "nope": http.StatusNotFound} {
+		req := httptest.NewRequest("POST", "/orders/"+orderID+"/redrive", strings.NewReader("action=retry"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		assert.Equal(t, status, ts.do(req).Code, orderID)
+	}
+	req := httptest.NewRequest("POST", "/orders/order-1/redrive", strings.NewReader("action=escalate"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	assert.Equal(t, http.StatusBadRequest, ts.do(req).Code)
+	assert.Empty(t, ts.service.redriven)
+}
+
+func TestWebHalts(t *testing.T) {
+	ts := newTestWebServer(t)
+
+	rec := ts.do(httptest.NewRequest("GET", "/halts", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Orders are executing normally.")
+
+	req := httptest.NewRequest("POST", "/halts", strings.NewReader(url.Values{"security": {""}, "reason": {"market event"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = ts.do(req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.Contains(t, body, "All securities")
+	assert.Contains(t, body, "market event")
+	assert.Contains(t, body, `hx-post="/halts/resume"`)
+	require.Len(t, ts.service.halts, 1)
+	assert.Equal(t, EventSourceWeb, ts.service.halts[0].Source)
+
+	req = httptest.NewRequest("POST", "/halts/resume", strings.NewReader("security="))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = ts.do(req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Orders are executing normally.")
+	assert.Empty(t, ts.service.halts)
+	// already lifted, e.g. from another tab
+	req = httptest.NewRequest("POST", "/halts/resume", strings.NewReader("security="))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	assert.Equal(t, http.StatusOK, ts.do(req).Code)
+}
+
+func TestWebScopesOrdersToAccount(t *testing.T) {
+	ts := newTestWebServer(t)
+	ts.addUser(t, "alice", "acct-1")
+	for _, order := range []StopLossOrder{
+		{ID: "order-1", AccountID: "acct-1", Security: "AAPL", StopPrice: 145, Quantity: 10, Status: OrderStatusPending},
+		{ID: "order-2", AccountID: "acct-2", Security: "GOOG", StopPrice: 95, Quantity: 5, Status: OrderStatusPending},
+	} {
+		_, err := ts.orders.CreateOrder(order)
+		require.NoError(t, err)
+	}
+
+	rec := ts.doAs("alice", httptest.NewRequest("GET", "/orders", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "/orders/order-1")
+	assert.NotContains(t, rec.Body.String(), "/orders/order-2")
+
+	assert.Equal(t, http.StatusOK, ts.doAs("alice", httptest.NewRequest("GET", "/orders/order-1", nil)).Code)
+	assert.Equal(t, http.StatusNotFound, ts.doAs("alice", httptest.NewRequest("GET", "/orders/order-2", nil)).Code)
+	assert.Equal(t, http.StatusNotFound, ts.doAs("alice", httptest.NewRequest("POST", "/orders/order-2/cancel", nil)).Code)
+	assert.Empty(t, ts.service.cancelled)
+
+	// the default user is the admin, who sees everything
+	rec = ts.do(httptest.NewRequest("GET", "/orders", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "/orders/order-2")
+
+	req := httptest.NewRequest("POST", "/orders", strings.NewReader(url.Values{"security": {"AAPL"}, "price": {"140"}, "quantity": {"5"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ts.doAs("alice", req)
+	require.Len(t, ts.service.created, 1)
+	assert.Equal(t, "acct-1", ts.service.created[0].AccountID)
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// Webhook events, the order events a subscription can ask for.
+const (
+	WebhookEventCreated   = "created"
+	WebhookEventTriggered = "triggered"
+	WebhookEventExecuted  = "executed"
+	WebhookEventCancelled = "cancelled"
+	WebhookEventFailed    = "failed"
+	// WebhookEventExpired can be subscribed to but never fires yet: orders
+	// don't expire until they carry a time in force, and no order event
+	// maps to it in webhookEventFor.
+	WebhookEventExpired = "expired"
+)
+
+var webhookEvents = []string{WebhookEventCreated, WebhookEventTriggered, WebhookEventExecuted, WebhookEventCancelled, WebhookEventFailed, WebhookEventExpired}
+
+// webhookEventFor names the webhook event an order event is sent as, if
+// it's sent at all.
+var webhookEventFor = map[string]string{
+	OrderEventCreated:        WebhookEventCreated,
+	OrderEventPriceTriggered: WebhookEventTriggered,
+	OrderEventExecuted:       WebhookEventExecuted,
+	OrderEventCancelled:      WebhookEventCancelled,
+	OrderEventFailed:         WebhookEventFailed,
+}
+
+// webhookOrderStatus is the status an order has once an event is recorded.
+// Events are recorded around the status write rather than with it, so the
+// row a delivery loads can still have the one before.
+var webhookOrderStatus = map[string]string{
+	OrderEventCreated:   OrderStatusPending,
+	OrderEventExecuted:  OrderStatusExecuted,
+	OrderEventCancelled: OrderStatusCancelled,
+	OrderEventFailed:    OrderStatusFailed,
+}
+
+// Headers sent with every delivery. The signature is the hex HMAC-SHA256 of
+// the body under the subscription's secret, as "sha256=<hex>".
+const (
+	WebhookSignatureHeader = "X-Stop-Loss-Signature"
+	WebhookEventHeader     = "X-Stop-Loss-Event"
+	WebhookDeliveryHeader  = "X-Stop-Loss-Delivery" // the same on every retry
+)
+
+const webhookSecretPrefix = "whsec_"
+
+// webhookDeliveryAttempts is how often a delivery is tried before it's dead
+// lettered. Each delivery keeps the value it started with.
+var webhookDeliveryAttempts = 8
+
+// webhookTimeout bounds a single attempt.
+const webhookTimeout = 10 * time.Second
+
+// WebhookPayload is the JSON body of a delivery.
+type WebhookPayload struct {
+	DeliveryID string          `json:"deliveryID"`
+	Event      string          `json:"event"`
+	OrderID    string          `json:"orderID"`
+	AccountID  string          `json:"accountID"`
+	OccurredAt time.Time       `json:"occurredAt"`
+	Source     string          `json:"source"`
+	Data       json.RawMessage `json:"data"`  // the order event's payload
+	Order      StopLossOrder   `json:"order"` // as of the event, e.g. EXECUTED for executed
+}
+
+// WebhookDelivery is one event on its way to one subscription. It holds
+// the body but not the secret, which stays out of workflow histories.
+type WebhookDelivery struct {
+	ID             string
+	SubscriptionID string
+	AccountID      string
+	OrderID        string
+	Event          string
+	URL            string
+	Payload        json.RawMessage
+	MaxAttempts    int
+}
+
+func newWebhookSubscription(accountID, url string, events []string, now time.Time) (WebhookSubscription, error) {
+	id, err := randomHex(6)
+	if err != nil {
+		return WebhookSubscription{}, err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return WebhookSubscription{}, err
+	}
+	return WebhookSubscription{ID: "wh-" + id, AccountID: accountID, URL: url, Secret: webhookSecretPrefix + secret, Events: events, CreatedAt: now}, nil
+}
+
+func (s WebhookSubscription) wants(event string) bool {
+	for _, e := range s.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func validWebhookEvent(event string) bool {
+	for _, e := range webhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// absoluteHTTPURL says whether raw is somewhere a webhook or chat
+// notification can be POSTed.
+func absoluteHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookDeliveryID is the same for the same event and subscription, so an
+// event published twice is still delivered once.
+func webhookDeliveryID(subscriptionID string, event OrderEvent) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s|%d", subscriptionID, event.OrderID, event.Type, event.OccurredAt.UnixNano())))
+	return hex.EncodeToString(sum[:12])
+}
+
+// WebhookPublisher starts a WebhookDeliveryWorkflow for every subscription
+// that wants an order event. Deliveries retry on their own, so nothing
+// waits on a slow receiver.
+type WebhookPublisher struct {
+	temporalClient client.Client
+	ordersRepo     OrdersRepo
+	webhooksRepo   WebhooksRepo
+}
+
+func NewWebhookPublisher(temporalClient client.Client, ordersRepo OrdersRepo, webhooksRepo WebhooksRepo) *WebhookPublisher {
+	return &WebhookPublisher{
+		temporalClient: temporalClient,
+		ordersRepo:     ordersRepo,
+		webhooksRepo:   webhooksRepo,
+	}
+}
+
+// Publish starts every delivery the event makes, failing if any of them
+// couldn't be started. It's safe to call again with the same event: a
+// delivery that already started isn't started twice.
+func (p *WebhookPublisher) Publish(ctx context.Context, event OrderEvent) error {
+	deliveries, err := p.deliveriesFor(event)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, delivery := range deliveries {
+		if err := p.start(ctx, delivery); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PublishOrDeadLetter is Publish for callers outside a workflow, which have
+// nothing to retry it: a delivery that can't be started is kept as a dead
+// letter instead. It only fails if the event's deliveries can't be worked
+// out, or a dead letter can't be kept.
+func (p *WebhookPublisher) PublishOrDeadLetter(ctx context.Context, event OrderEvent) error {
+	deliveries, err := p.deliveriesFor(event)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, delivery := range deliveries {
+		startErr := p.start(ctx, delivery)
+		if startErr == nil {
+			continue
+		}
+		slog.ErrorContext(ctx, "Failed to start webhook delivery, dead lettering it", "deliveryID", delivery.ID, "orderID", delivery.OrderID, "event", delivery.Event, "error", startErr)
+		err := p.webhooksRepo.RecordDeadLetter(WebhookDeadLetter{
+			DeliveryID:     delivery.ID,
+			SubscriptionID: delivery.SubscriptionID,
+			AccountID:      delivery.AccountID,
+			OrderID:        delivery.OrderID,
+			Event:          delivery.Event,
+			URL:            delivery.URL,
+			Payload:        delivery.Payload,
+			Error:          startErr.Error(),
+			FailedAt:       time.Now().UTC(),
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w, and failed to dead letter it: %w", startErr, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// deliveriesFor builds a delivery for every subscription that wants event.
+func (p *WebhookPublisher) deliveriesFor(event OrderEvent) ([]WebhookDelivery, error) {
+	name, ok := webhookEventFor[event.Type]
+	if !ok {
+		return nil, nil
+	}
+	order, err := p.ordersRepo.GetOrder(event.OrderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load order %s: %w", event.OrderID, err)
+	}
+	if status, ok := webhookOrderStatus[event.Type]; ok {
+		order.Status = status
+	}
+	subscriptions, err := p.webhooksRepo.ListSubscriptions(order.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	var deliveries []WebhookDelivery
+	for _, subscription := range subscriptions {
+		if !subscription.wants(name) {
+			continue
+		}
+		delivery := WebhookDelivery{
+			ID:             webhookDeliveryID(subscription.ID, event),
+			SubscriptionID: subscription.ID,
+			AccountID:      order.AccountID,
+			OrderID:        order.ID,
+			Event:          name,
+			URL:            subscription.URL,
+			MaxAttempts:    webhookDeliveryAttempts,
+		}
+		delivery.Payload, err = json.Marshal(WebhookPayload{
+			DeliveryID: delivery.ID,
+			Event:      name,
+			OrderID:    order.ID,
+			AccountID:  order.AccountID,
+			OccurredAt: event.OccurredAt.UTC(),
+			Source:     event.Source,
+			Data:       event.Payload,
+			Order:      order,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal %s webhook for order %s: %w", name, order.ID, err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+func (p *WebhookPublisher) start(ctx context.Context, delivery WebhookDelivery) error {
+	_, err := p.temporalClient.ExecuteWorkflow(ctx, client.StartWorkflowOptions{
+		ID:                    "webhook-" + delivery.ID,
+		TaskQueue:             "stop-loss-task-queue",
+		WorkflowIDReusePolicy: enums.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE,
+	}, WebhookDeliveryWorkflow, delivery)
+	if err != nil && !isWorkflowAlreadyStarted(err) {
+		return fmt.Errorf("failed to start %s webhook delivery for order %s: %w", delivery.Event, delivery.OrderID, err)
+	}
+	return nil
+}
+
+// WebhookDeliveryWorkflow POSTs one event to one subscription, retrying
+// with backoff, and leaves a dead letter if every attempt fails.
+func WebhookDeliveryWorkflow(ctx workflow.Context, delivery WebhookDelivery) error {
+	logger := workflow.GetLogger(ctx)
+	var a *WebhookActivities
+
+	deliverCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 2 * webhookTimeout,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    5 * time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumInterval:    10 * time.Minute,
+			MaximumAttempts:    int32(delivery.MaxAttempts),
+		},
+	})
+	err := workflow.ExecuteActivity(deliverCtx, a.DeliverWebhookActivity, delivery).Get(ctx, nil)
+	if err == nil {
+		return nil
+	}
+
+	logger.Warn("Webhook delivery failed, dead lettering it", "deliveryID", delivery.ID, "orderID", delivery.OrderID, "url", delivery.URL, "error", err)
+	recordCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{StartToCloseTimeout: 30 * time.Second})
+	deadLetter := WebhookDeadLetter{
+		DeliveryID:     delivery.ID,
+		SubscriptionID: delivery.SubscriptionID,
+		AccountID:      delivery.AccountID,
+		OrderID:        delivery.OrderID,
+		Event:          delivery.Event,
+		URL:            delivery.URL,
+		Payload:        delivery.Payload,
+		Error:          executionFailureReason(err),
+		FailedAt:       workflow.Now(ctx),
+	}
+	if err := workflow.ExecuteActivity(recordCtx, a.RecordWebhookDeadLetterActivity, deadLetter).Get(ctx, nil); err != nil {
+		return fmt.Errorf("failed to record dead letter for webhook delivery %s: %w", delivery.ID, err)
+	}
+	return fmt.Errorf("webhook delivery %s gave up after %d attempts: %w", delivery.ID, delivery.MaxAttempts, err)
+}
+
+// WebhookActivities publishes order events, sends the deliveries they make
+// and keeps the ones that gave up.
+type WebhookActivities struct {
+	webhooksRepo WebhooksRepo
+	publisher    *WebhookPublisher
+	httpClient   *http.Client
+}
+
+func NewWebhookActivities(webhooksRepo WebhooksRepo, publisher *WebhookPublisher) *WebhookActivities {
+	return &WebhookActivities{
+		webhooksRepo: webhooksRepo,
+		publisher:    publisher,
+		httpClient:   &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+// PublishWebhooksActivity starts the deliveries for an event StopLossWorkflow
+// recorded. It fails, to be retried, until every one of them has started;
+// an order that's gone has nothing to send.
+func (a *WebhookActivities) PublishWebhooksActivity(ctx context.Context, event OrderEvent) error {
+	err := a.publisher.Publish(ctx, event)
+	if errors.Is(err, ErrOrderNotFound) {
+		return temporal.NewNonRetryableApplicationError(fmt.Sprintf("order %s not found", event.OrderID), "OrderNotFound", err)
+	}
+	return err
+}
+
+// DeliverWebhookActivity signs the delivery with its subscription's current
+// secret. A subscription deleted since the event isn't sent anything.
+func (a *WebhookActivities) DeliverWebhookActivity(ctx context.Context, delivery WebhookDelivery) error {
+	subscription, err := a.webhooksRepo.GetSubscription(delivery.SubscriptionID)
+	if errors.Is(err, ErrWebhookNotFound) {
+		activityLogger(ctx).Info("Dropping delivery for a deleted webhook", "deliveryID", delivery.ID, "webhookID", delivery.SubscriptionID, "orderID", delivery.OrderID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return temporal.NewNonRetryableApplicationError(fmt.Sprintf("invalid webhook URL %s", subscription.URL), "InvalidURL", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookSignatureHeader, signWebhook(subscription.Secret, delivery.Payload))
+	req.Header.Set(WebhookEventHeader, delivery.Event)
+	req.Header.Set(WebhookDeliveryHeader, delivery.ID)
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to POST webhook to %s: %w", subscription.URL, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s answered %s", subscription.URL, resp.Status)
+	}
+	return nil
+}
+
+func (a *WebhookActivities) RecordWebhookDeadLetterActivity(ctx context.Context, deadLetter WebhookDeadLetter) error {
+	return a.webhooksRepo.RecordDeadLetter(deadLetter)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// WebhooksRepoSQL keeps webhook subscriptions and dead letters in the
+// webhook_subscriptions and webhook_dead_letters tables of either SQL
+// backend.
+type WebhooksRepoSQL struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func NewWebhooksRepoSQL(db *sql.DB, d dialect) *WebhooksRepoSQL {
+	return &WebhooksRepoSQL{
+		db:      db,
+		dialect: d,
+	}
+}
+
+func (r *WebhooksRepoSQL) CreateSubscription(subscription WebhookSubscription) error {
+	_, err := r.db.Exec(r.dialect.rebind(`
+		INSERT INTO webhook_subscriptions (id, account_id, url, secret, events, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`), subscription.ID, subscription.AccountID, subscription.URL, subscription.Secret, strings.Join(subscription.Events, ","), subscription.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to create webhook for account %s: %w", subscription.AccountID, err)
+	}
+	return nil
+}
+
+const webhookSubscriptionColumns = `id, account_id, url, secret, events, created_at`
+
+func scanWebhookSubscription(row interface{ Scan(...any) error }) (WebhookSubscription, error) {
+	var subscription WebhookSubscription
+	var events string
+	err := row.Scan(&subscription.ID, &subscription.AccountID, &subscription.URL, &subscription.Secret, &events, &subscription.CreatedAt)
+	if events != "" {
+		subscription.Events = strings.Split(events, ",")
+	}
+	return subscription, err
+}
+
+func (r *WebhooksRepoSQL) GetSubscription(id string) (WebhookSubscription, error) {
+	row := r.db.QueryRow(r.dialect.rebind(`SELECT `+webhookSubscriptionColumns+` FROM webhook_subscriptions WHERE id = ?`), id)
+	subscription, err := scanWebhookSubscription(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return WebhookSubscription{}, ErrWebhookNotFound
+	}
+	if err != nil {
+		return WebhookSubscription{}, fmt.Errorf("failed to get webhook %s: %w", id, err)
+	}
+	return subscription, nil
+}
+
+func (r *WebhooksRepoSQL) ListSubscriptions(accountID string) ([]WebhookSubscription, error) {
+	query, args := `SELECT `+webhookSubscriptionColumns+` FROM webhook_subscriptions ORDER BY account_id, created_at, id`, []any{}
+	if accountID != "" {
+		query, args = `SELECT `+webhookSubscriptionColumns+` FROM webhook_subscriptions WHERE account_id = ? ORDER BY created_at, id`, []any{accountID}
+	}
+	rows, err := r.db.Query(r.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var subscriptions []WebhookSubscription
+	for rows.Next() {
+		subscription, err := scanWebhookSubscription(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook: %w", err)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webhook rows: %w", err)
+	}
+	return subscriptions, nil
+}
+
+func (r *WebhooksRepoSQL) DeleteSubscription(id string) error {
+	result, err := r.db.Exec(r.dialect.rebind(`DELETE FROM webhook_subscriptions WHERE id = ?`), id)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook %s: %w", id, err)
+	}
+	return affectedOr(result, ErrWebhookNotFound)
+}
+
+func (r *WebhooksRepoSQL) RecordDeadLetter(deadLetter WebhookDeadLetter) error {
+	_, err := r.db.Exec(r.dialect.rebind(`
+		INSERT INTO webhook_dead_letters (delivery_id, subscription_id, account_id, order_id, event, url, payload, error, failed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (delivery_id) DO NOTHING
+	`), deadLetter.DeliveryID, deadLetter.SubscriptionID, deadLetter.AccountID, deadLetter.OrderID, deadLetter.Event, deadLetter.URL,
+		string(deadLetter.Payload), deadLetter.Error, deadLetter.FailedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record dead letter for webhook delivery %s: %w", deadLetter.DeliveryID, err)
+	}
+	return nil
+}
+
+func (r *WebhooksRepoSQL) ListDeadLetters(limit int) ([]WebhookDeadLetter, error) {
+	rows, err := r.db.Query(r.dialect.rebind(`
+		SELECT id, delivery_id, subscription_id, account_id, order_id, event, url, payload, error, failed_at
+		FROM webhook_dead_letters ORDER BY id DESC LIMIT ?
+	`), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook dead letters: %w", err)
+	}
+	defer rows.Close()
+
+	var deadLetters []WebhookDeadLetter
+	for rows.Next() {
+		var deadLetter WebhookDeadLetter
+		var payload string
+		if err := rows.Scan(&deadLetter.ID, &deadLetter.DeliveryID, &deadLetter.SubscriptionID, &deadLetter.AccountID, &deadLetter.OrderID,
+			&deadLetter.Event, &deadLetter.URL, &payload, &deadLetter.Error, &deadLetter.FailedAt); err != nil {
+			slog.Error("Failed to scan webhook dead letter row", "error", err)
+			continue
+		}
+		deadLetter.Payload = json.RawMessage(payload)
+		deadLetters = append(deadLetters, deadLetter)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webhook dead letter rows: %w", err)
+	}
+	return deadLetters, nil
+}
+
+// Ensure WebhooksRepoSQL implements WebhooksRepo
+var _ WebhooksRepo = (*WebhooksRepoSQL)(nil)
+
+// WebhooksRepoMemory keeps webhooks for the in-memory store.
+type WebhooksRepoMemory struct {
+	mu            sync.RWMutex
+	subscriptions map[string]WebhookSubscription
+	deadLetters   []WebhookDeadLetter
+}
+
+func NewWebhooksRepoMemory() *WebhooksRepoMemory {
+	return &WebhooksRepoMemory{
+		subscriptions: make(map[string]WebhookSubscription),
+	}
+}
+
+func (m *WebhooksRepoMemory) CreateSubscription(subscription WebhookSubscription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.subscriptions[subscription.ID] = subscription
+	return nil
+}
+
+func (m *WebhooksRepoMemory) GetSubscription(id string) (WebhookSubscription, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	subscription, ok := m.subscriptions[id]
+	if !ok {
+		return WebhookSubscription{}, ErrWebhookNotFound
+	}
+	return subscription, nil
+}
+
+func (m *WebhooksRepoMemory) ListSubscriptions(accountID string) ([]WebhookSubscription, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var subscriptions []WebhookSubscription
+	for _, subscription := range m.subscriptions {
+		if accountID == "" || subscription.AccountID == accountID {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		a, b := subscriptions[i], subscriptions[j]
+		if a.AccountID != b.AccountID {
+			return a.AccountID < b.AccountID
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+	return subscriptions, nil
+}
+
+func (m *WebhooksRepoMemory) DeleteSubscription(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.subscriptions[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(m.subscriptions, id)
+	return nil
+}
+
+func (m *WebhooksRepoMemory) RecordDeadLetter(deadLetter WebhookDeadLetter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.deadLetters {
+		if existing.DeliveryID == deadLetter.DeliveryID {
+			return nil
+		}
+	}
+	deadLetter.ID = int64(len(m.deadLetters) + 1)
+	m.deadLetters = append(m.deadLetters, deadLetter)
+	return nil
+}
+
+func (m *WebhooksRepoMemory) ListDeadLetters(limit int) ([]WebhookDeadLetter, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var deadLetters []WebhookDeadLetter
+	for i := len(m.deadLetters) - 1; i >= 0 && len(deadLetters) < limit; i-- {
+		deadLetters = append(deadLetters, m.deadLetters[i])
+	}
+	return deadLetters, nil
+}
+
+// Ensure WebhooksRepoMemory implements WebhooksRepo
+var _ WebhooksRepo = (*WebhooksRepoMemory)(nil)
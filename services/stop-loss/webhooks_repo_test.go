@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testWebhooksRepoContract(t *testing.T, repo WebhooksRepo) {
+	at := time.Date(2025, 2, 1, 14, 30, 0, 0, time.UTC)
+
+	_, err := repo.GetSubscription("wh-1")
+	assert.ErrorIs(t, err, ErrWebhookNotFound)
+
+	require.NoError(t, repo.CreateSubscription(WebhookSubscription{ID: "wh-1", AccountID: "acct-1", URL: "https://books.example/hook", Secret: "whsec_1", Events: []string{WebhookEventExecuted, WebhookEventFailed}, CreatedAt: at}))
+	require.NoError(t, repo.CreateSubscription(WebhookSubscription{ID: "wh-2", AccountID: "acct-2", URL: "https://other.example/hook", Secret: "whsec_2", Events: webhookEvents, CreatedAt: at}))
+	require.NoError(t, repo.CreateSubscription(WebhookSubscription{ID: "wh-3", AccountID: "acct-1", URL: "https://books.example/audit", Secret: "whsec_3", Events: []string{WebhookEventCreated}, CreatedAt: at.Add(time.Minute)}))
+
+	subscription, err := repo.GetSubscription("wh-1")
+	require.NoError(t, err)
+	assert.Equal(t, "https://books.example/hook", subscription.URL)
+	assert.Equal(t, "whsec_1", subscription.Secret)
+	assert.Equal(t, []string{WebhookEventExecuted, WebhookEventFailed}, subscription.Events)
+	assert.True(t, at.Equal(subscription.CreatedAt))
+
+	subscriptions, err := repo.ListSubscriptions("acct-1")
+	require.NoError(t, err)
+	require.Len(t, subscriptions, 2)
+	assert.Equal(t, "wh-1", subscriptions[0].ID)
+	assert.Equal(t, "wh-3", subscriptions[1].ID)
+	subscriptions, err = repo.ListSubscriptions("")
+	require.NoError(t, err)
+	assert.Len(t, subscriptions, 3)
+
+	require.NoError(t, repo.DeleteSubscription("wh-3"))
+	assert.ErrorIs(t, repo.DeleteSubscription("wh-3"), ErrWebhookNotFound)
+	subscriptions, err = repo.ListSubscriptions("acct-1")
+	require.NoError(t, err)
+	assert.Len(t, subscriptions, 1)
+
+	// one dead letter per delivery, newest first
+	for i, deliveryID := range []string{"delivery-1", "delivery-2", "delivery-1"} {
+		require.NoError(t, repo.RecordDeadLetter(WebhookDeadLetter{
+			DeliveryID:     deliveryID,
+			SubscriptionID: "wh-1",
+			AccountID:      "acct-1",
+			OrderID:        "order-1",
+			Event:          WebhookEventExecuted,
+			URL:            "https://books.example/hook",
+			Payload:        json.RawMessage(`{"event":"executed"}`),
+			Error:          "webhook answered 503 Service Unavailable",
+			FailedAt:       at.Add(time.Duration(i) * time.Minute),
+		}))
+	}
+	deadLetters, err := repo.ListDeadLetters(10)
+	require.NoError(t, err)
+	require.Len(t, deadLetters, 2)
+	assert.Equal(t, "delivery-2", deadLetters[0].DeliveryID)
+	assert.Equal(t, "delivery-1", deadLetters[1].DeliveryID)
+	assert.True(t, at.Equal(deadLetters[1].FailedAt))
+	assert.JSONEq(t, `{"event":"executed"}`, string(deadLetters[1].Payload))
+	assert.Equal(t, "webhook answered 503 Service Unavailable", deadLetters[1].Error)
+	deadLetters, err = repo.ListDeadLetters(1)
+	require.NoError(t, err)
+	assert.Len(t, deadLetters, 1)
+}
+
+func TestWebhooksRepoMemory(t *testing.T) {
+	testWebhooksRepoContract(t, NewWebhooksRepoMemory())
+}
+
+func TestWebhooksRepoSQLite(t *testing.T) {
+	db := newTestSQLiteDB(t)
+	require.NoError(t, migrateDB(db, dialectSQLite))
+
+	testWebhooksRepoContract(t, NewWebhooksRepoSQL(db, dialectSQLite))
+}
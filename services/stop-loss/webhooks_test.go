@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/mocks"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+)
+
+func TestSignWebhook(t *testing.T) {
+	// echo -n '{"event":"executed"}' | openssl dgst -sha256 -hmac whsec_test
+	assert.Equal(t, "sha256=11322e7e939dc9446dc93c2aca48122ba908dc83df4fecb0f157ec4d4becbf3a", signWebhook("whsec_test", []byte(`{"event":"executed"}`)))
+}
+
+func TestWebhookPublisherStartsDeliveries(t *testing.T) {
+	at := time.Date(2025, 2, 1, 14, 30, 0, 0, time.UTC)
+	temporalClient := mocks.NewClient(t)
+	orders := NewOrdersRepoMemory()
+	webhooks := NewWebhooksRepoMemory()
+	publisher := NewWebhookPublisher(temporalClient, orders, webhooks)
+
+	// the execution is published before its status is written
+	_, err := orders.CreateOrder(StopLossOrder{ID: "order-1", AccountID: "acct-1", Security: "AAPL", StopPrice: 145, Quantity: 10, Status: OrderStatusPending})
+	require.NoError(t, err)
+	require.NoError(t, webhooks.CreateSubscription(WebhookSubscription{ID: "wh-1", AccountID: "acct-1", URL: "https://books.example/hook", Secret: "whsec_1", Events: []string{WebhookEventExecuted}}))
+	require.NoError(t, webhooks.CreateSubscription(WebhookSubscription{ID: "wh-2", AccountID: "acct-1", URL: "https://books.example/created", Secret: "whsec_2", Events: []string{WebhookEventCreated}}))
+	require.NoError(t, webhooks.CreateSubscription(WebhookSubscription{ID: "wh-3", AccountID: "acct-2", URL: "https://other.example/hook", Secret: "whsec_3", Events: webhookEvents}))
+
+	event := newOrderEvent("order-1", OrderEventExecuted, EventSourceWorkflow, map[string]string{"result": "filled"}, at)
+	var delivery WebhookDelivery
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			delivery = args.Get(3).(WebhookDelivery)
+			assert.Equal(t, "webhook-"+delivery.ID, args.Get(1).(client.StartWorkflowOptions).ID)
+		}).
+		Return(mockWorkflowRun(t), nil).Once()
+	require.NoError(t, publisher.Publish(context.Background(), event))
+
+	// only the account's subscription to executed gets it
+	assert.Equal(t, "wh-1", delivery.SubscriptionID)
+	assert.Equal(t, webhookDeliveryID("wh-1", event), delivery.ID)
+	assert.Equal(t, WebhookEventExecuted, delivery.Event)
+	assert.Equal(t, webhookDeliveryAttempts, delivery.MaxAttempts)
+	var payload WebhookPayload
+	require.NoError(t, json.Unmarshal(delivery.Payload, &payload))
+	assert.Equal(t, delivery.ID, payload.DeliveryID)
+	assert.Equal(t, "acct-1", payload.AccountID)
+	assert.Equal(t, "AAPL", payload.Order.Security)
+	assert.Equal(t, OrderStatusExecuted, payload.Order.Status)
+	assert.True(t, at.Equal(payload.OccurredAt))
+	assert.JSONEq(t, `{"result": "filled"}`, string(payload.Data))
+
+	// publishing the same event again is fine, and events webhooks don't
+	// carry start nothing
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil, serviceerror.NewWorkflowExecutionAlreadyStarted("started", "", "")).Once()
+	require.NoError(t, publisher.Publish(context.Background(), event))
+	require.NoError(t, publisher.Publish(context.Background(), newOrderEvent("order-1", OrderEventExecutionAttempted, EventSourceWorkflow, nil, at)))
+}
+
+func TestWebhookPublisherFailures(t *testing.T) {
+	at := time.Date(2025, 2, 1, 14, 30, 0, 0, time.UTC)
+	temporalClient := mocks.NewClient(t)
+	orders := NewOrdersRepoMemory()
+	webhooks := NewWebhooksRepoMemory()
+	publisher := NewWebhookPublisher(temporalClient, orders, webhooks)
+
+	_, err := orders.CreateOrder(StopLossOrder{ID: "order-1", AccountID: "acct-1", Security: "AAPL", StopPrice: 145, Quantity: 10, Status: OrderStatusPending})
+	require.NoError(t, err)
+	require.NoError(t, webhooks.CreateSubscription(WebhookSubscription{ID: "wh-1", AccountID: "acct-1", URL: "https://books.example/hook", Secret: "whsec_1", Events: webhookEvents}))
+	event := newOrderEvent("order-1", OrderEventCancelled, EventSourceAdmin, nil, at)
+
+	// inside a workflow the activity retries
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("unavailable")).Once()
+	assert.ErrorContains(t, publisher.Publish(context.Background(), event), "unavailable")
+
+	// outside one the delivery is dead lettered
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("unavailable")).Once()
+	require.NoError(t, publisher.PublishOrDeadLetter(context.Background(), event))
+	deadLetters, err := webhooks.ListDeadLetters(10)
+	require.NoError(t, err)
+	require.Len(t, deadLetters, 1)
+	assert.Equal(t, webhookDeliveryID("wh-1", event), deadLetters[0].DeliveryID)
+	assert.Equal(t, WebhookEventCancelled, deadLetters[0].Event)
+	assert.Contains(t, deadLetters[0].Error, "unavailable")
+	assert.Contains(t, string(deadLetters[0].Payload), `"status":"CANCELLED"`)
+
+	// there's nothing to send for an order that's gone
+	activities := NewWebhookActivities(webhooks, publisher)
+	err = activities.PublishWebhooksActivity(context.Background(), newOrderEvent("nope", OrderEventCancelled, EventSourceAdmin, nil, at))
+	var appErr *temporal.ApplicationError
+	require.ErrorAs(t, err, &appErr)
+	assert.True(t, appErr.NonRetryable())
+}
+
+func TestDeliverWebhookActivity(t *testing.T) {
+	status := http.StatusOK
+	var received *http.Request
+	var body []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+
+	webhooks := NewWebhooksRepoMemory()
+	require.NoError(t, webhooks.CreateSubscription(WebhookSubscription{ID: "wh-1", AccountID: "acct-1", URL: receiver.URL, Secret: "whsec_1", Events: webhookEvents}))
+	activities := NewWebhookActivities(webhooks, nil)
+	delivery := WebhookDelivery{ID: "delivery-1", SubscriptionID: "wh-1", Event: WebhookEventExecuted, Payload: json.RawMessage(`{"event":"executed"}`)}
+
+	require.NoError(t, activities.DeliverWebhookActivity(context.Background(), delivery))
+	assert.JSONEq(t, `{"event":"executed"}`, string(body))
+	assert.Equal(t, signWebhook("whsec_1", body), received.Header.Get(WebhookSignatureHeader))
+	assert.Equal(t, WebhookEventExecuted, received.Header.Get(WebhookEventHeader))
+	assert.Equal(t, "delivery-1", received.Header.Get(WebhookDeliveryHeader))
+
+	status = http.StatusServiceUnavailable
+	assert.ErrorContains(t, activities.DeliverWebhookActivity(context.Background(), delivery), "503")
+
+	// nothing goes to a webhook that's since been deleted
+	require.NoError(t, webhooks.DeleteSubscription("wh-1"))
+	received = nil
+	require.NoError(t, activities.DeliverWebhookActivity(context.Background(), delivery))
+	assert.Nil(t, received)
+}
+
+func TestWebhookDeliveryWorkflowDeadLetters(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	webhooks := NewWebhooksRepoMemory()
+	activities := NewWebhookActivities(webhooks, nil)
+	env.RegisterActivity(activities)
+
+	delivery := WebhookDelivery{ID: "delivery-1", SubscriptionID: "wh-1", AccountID: "acct-1", OrderID: "order-1", Event: WebhookEventExecuted, URL: "https://books.example/hook", Payload: json.RawMessage(`{}`), MaxAttempts: 3}
+	env.OnActivity(activities.DeliverWebhookActivity, mock.Anything, delivery).Return(errors.New("webhook answered 503 Service Unavailable")).Times(3)
+	env.ExecuteWorkflow(WebhookDeliveryWorkflow, delivery)
+
+	require.True(t, env.IsWorkflowCompleted())
+	assert.ErrorContains(t, env.GetWorkflowError(), "gave up after 3 attempts")
+	env.AssertExpectations(t)
+	deadLetters, err := webhooks.ListDeadLetters(10)
+	require.NoError(t, err)
+	require.Len(t, deadLetters, 1)
+	assert.Equal(t, "delivery-1", deadLetters[0].DeliveryID)
+	assert.Equal(t, "order-1", deadLetters[0].OrderID)
+	assert.Contains(t, deadLetters[0].Error, "503")
+}
+
+func TestWebhookDeliveryWorkflowDelivers(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	webhooks := NewWebhooksRepoMemory()
+	activities := NewWebhookActivities(webhooks, nil)
+	env.RegisterActivity(activities)
+
+	delivery := WebhookDelivery{ID: "delivery-1", SubscriptionID: "wh-1", Payload: json.RawMessage(`{}`), MaxAttempts: 3}
+	env.OnActivity(activities.DeliverWebhookActivity, mock.Anything, delivery).Return(errors.New("connection refused")).Once()
+	env.OnActivity(activities.DeliverWebhookActivity, mock.Anything, delivery).Return(nil).Once()
+	env.ExecuteWorkflow(WebhookDeliveryWorkflow, delivery)
+
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+	deadLetters, err := webhooks.ListDeadLetters(10)
+	require.NoError(t, err)
+	assert.Empty(t, deadLetters)
+}